@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ArtifactUpload controls uploading written attestations as artifacts of
+// the current Buildkite job with `buildkite-agent artifact upload`, for
+// generators running directly on an agent rather than in the plugin's
+// container.
+type ArtifactUpload struct {
+	Enabled bool
+	// Destination is passed to the agent as the upload destination, e.g.
+	// "s3://bucket/prefix" for an artifact bucket of your own. Empty uses
+	// the agent's default artifact storage.
+	Destination string
+}
+
+// addArtifactUploadFlags registers the artifact upload flags on "fs".
+func addArtifactUploadFlags(fs *flag.FlagSet) *ArtifactUpload {
+	a := &ArtifactUpload{}
+	fs.BoolVar(&a.Enabled, "artifact_upload", false, "Upload the written file as an artifact of the current job with 'buildkite-agent artifact upload'.")
+	fs.StringVar(&a.Destination, "artifact_upload_destination", "", "The destination passed to 'buildkite-agent artifact upload', e.g. 's3://bucket/prefix'.")
+	return a
+}
+
+// upload uploads the file at "path", keeping its path relative to the
+// working directory as the artifact path.
+func (a *ArtifactUpload) upload(path string) error {
+	if filepath.IsAbs(path) {
+		// The agent names artifacts after the path it is given; upload
+		// from the file's directory so the name stays relative.
+		return a.run(filepath.Dir(path), filepath.Base(path))
+	}
+	return a.run("", path)
+}
+
+func (a *ArtifactUpload) run(dir, path string) error {
+	args := []string{"artifact", "upload", path}
+	if a.Destination != "" {
+		args = append(args, a.Destination)
+	}
+	cmd := exec.Command("buildkite-agent", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("buildkite-agent artifact upload: %s: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/sign"
+)
+
+// APIService is the fully qualified name of the service defined in
+// proto/provenance/v1/generator.proto.
+const APIService = "/provenance.v1.GeneratorService/"
+
+// The request and response types below follow the messages of
+// proto/provenance/v1/generator.proto, in both their proto3 JSON mapping
+// (for Connect) and their binary encoding (for gRPC).
+
+type GenerateStatementRequest struct {
+	Subjects []provenance.Subject `json:"subjects"`
+	Build    APIBuildContext      `json:"build"`
+	Agent    APIAgentContext      `json:"agent"`
+}
+
+type APIBuildContext struct {
+	Repository string `json:"repository"`
+	BuildURL   string `json:"buildUrl"`
+	Commit     string `json:"commit"`
+	StepID     string `json:"stepId"`
+	Command    string `json:"command"`
+	Ref        string `json:"ref"`
+}
+
+type APIAgentContext struct {
+	Name         string            `json:"agentName"`
+	ID           string            `json:"agentId"`
+	Organization string            `json:"agentOrganization"`
+	OS           string            `json:"agentOs"`
+	Arch         string            `json:"agentArch"`
+	Queue        string            `json:"agentQueue"`
+	Tags         map[string]string `json:"agentTags"`
+}
+
+func (m *GenerateStatementRequest) marshalProto() []byte {
+	var b []byte
+	for _, subject := range m.Subjects {
+		var encoded []byte
+		encoded = appendStringField(encoded, 1, subject.Name)
+		encoded = appendMapField(encoded, 2, subject.Digest)
+		b = appendMessageField(b, 1, encoded)
+	}
+	build := m.Build
+	var encoded []byte
+	for i, value := range []string{build.Repository, build.BuildURL, build.Commit, build.StepID, build.Command, build.Ref} {
+		encoded = appendStringField(encoded, i+1, value)
+	}
+	b = appendMessageField(b, 2, encoded)
+	agent := m.Agent
+	encoded = nil
+	for i, value := range []string{agent.Name, agent.ID, agent.Organization, agent.OS, agent.Arch, agent.Queue} {
+		encoded = appendStringField(encoded, i+1, value)
+	}
+	encoded = appendMapField(encoded, 7, agent.Tags)
+	return appendMessageField(b, 3, encoded)
+}
+
+func (m *GenerateStatementRequest) unmarshalProto(b []byte) error {
+	return rangeFields(b, func(field int, value []byte) error {
+		switch field {
+		case 1:
+			subject := provenance.Subject{Digest: provenance.DigestSet{}}
+			err := rangeFields(value, func(field int, value []byte) error {
+				switch field {
+				case 1:
+					subject.Name = string(value)
+				case 2:
+					return addMapEntry(subject.Digest, value)
+				}
+				return nil
+			})
+			m.Subjects = append(m.Subjects, subject)
+			return err
+		case 2:
+			build := []*string{&m.Build.Repository, &m.Build.BuildURL, &m.Build.Commit, &m.Build.StepID, &m.Build.Command, &m.Build.Ref}
+			return rangeFields(value, func(field int, value []byte) error {
+				if field >= 1 && field <= len(build) {
+					*build[field-1] = string(value)
+				}
+				return nil
+			})
+		case 3:
+			agent := []*string{&m.Agent.Name, &m.Agent.ID, &m.Agent.Organization, &m.Agent.OS, &m.Agent.Arch, &m.Agent.Queue}
+			return rangeFields(value, func(field int, value []byte) error {
+				if field >= 1 && field <= len(agent) {
+					*agent[field-1] = string(value)
+				} else if field == 7 {
+					if m.Agent.Tags == nil {
+						m.Agent.Tags = map[string]string{}
+					}
+					return addMapEntry(m.Agent.Tags, value)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+type GenerateStatementResponse struct {
+	Statement []byte `json:"statement"`
+}
+
+func (m *GenerateStatementResponse) marshalProto() []byte {
+	return appendBytesField(nil, 1, m.Statement)
+}
+
+func (m *GenerateStatementResponse) unmarshalProto(b []byte) error {
+	return rangeFields(b, func(field int, value []byte) error {
+		if field == 1 {
+			m.Statement = append([]byte(nil), value...)
+		}
+		return nil
+	})
+}
+
+type SignStatementRequest struct {
+	Statement []byte `json:"statement"`
+}
+
+func (m *SignStatementRequest) marshalProto() []byte {
+	return appendBytesField(nil, 1, m.Statement)
+}
+
+func (m *SignStatementRequest) unmarshalProto(b []byte) error {
+	return rangeFields(b, func(field int, value []byte) error {
+		if field == 1 {
+			m.Statement = append([]byte(nil), value...)
+		}
+		return nil
+	})
+}
+
+type SignStatementResponse struct {
+	Envelope []byte `json:"envelope"`
+}
+
+func (m *SignStatementResponse) marshalProto() []byte {
+	return appendBytesField(nil, 1, m.Envelope)
+}
+
+func (m *SignStatementResponse) unmarshalProto(b []byte) error {
+	return rangeFields(b, func(field int, value []byte) error {
+		if field == 1 {
+			m.Envelope = append([]byte(nil), value...)
+		}
+		return nil
+	})
+}
+
+type VerifySignatureRequest struct {
+	Envelope  []byte `json:"envelope"`
+	PublicKey []byte `json:"publicKey"`
+}
+
+func (m *VerifySignatureRequest) marshalProto() []byte {
+	return appendBytesField(appendBytesField(nil, 1, m.Envelope), 2, m.PublicKey)
+}
+
+func (m *VerifySignatureRequest) unmarshalProto(b []byte) error {
+	return rangeFields(b, func(field int, value []byte) error {
+		switch field {
+		case 1:
+			m.Envelope = append([]byte(nil), value...)
+		case 2:
+			m.PublicKey = append([]byte(nil), value...)
+		}
+		return nil
+	})
+}
+
+type VerifySignatureResponse struct {
+	KeyID string `json:"keyId"`
+}
+
+func (m *VerifySignatureResponse) marshalProto() []byte {
+	return appendStringField(nil, 1, m.KeyID)
+}
+
+func (m *VerifySignatureResponse) unmarshalProto(b []byte) error {
+	return rangeFields(b, func(field int, value []byte) error {
+		if field == 1 {
+			m.KeyID = string(value)
+		}
+		return nil
+	})
+}
+
+// apiError is a Connect protocol error; gRPC calls get the matching status
+// code from grpcCodes.
+type apiError struct {
+	status  int
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func invalidArgument(err error) *apiError {
+	return &apiError{http.StatusBadRequest, "invalid_argument", err.Error()}
+}
+
+// apiMaxRequestBytes bounds the size of request bodies.
+const apiMaxRequestBytes = 16 << 20
+
+// apiServer serves the GeneratorService, signing with the keys it was
+// started with.
+type apiServer struct {
+	signers []sign.Signer
+	tsaURL  string
+	// token, when set, is the bearer token callers must present; otherwise
+	// callers are authenticated by their TLS client certificates.
+	token string
+}
+
+// unary adapts "method" into an HTTP handler serving it both over gRPC
+// (for requests with an application/grpc content type, over HTTP/2) and
+// with the Connect protocol's unary JSON encoding, which Connect clients
+// (buf curl, connect-go, connect-es) and plain HTTP clients can call.
+func (s *apiServer) unary(newRequest func() protoMessage, method func(req protoMessage) (protoMessage, *apiError)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, apiMaxRequestBytes)
+		call := func(decode func(r *http.Request, req protoMessage) *apiError) (protoMessage, *apiError) {
+			if s.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.token)) != 1 {
+				return nil, &apiError{http.StatusUnauthorized, "unauthenticated", "invalid bearer token"}
+			}
+			req := newRequest()
+			if apiErr := decode(r, req); apiErr != nil {
+				return nil, apiErr
+			}
+			return method(req)
+		}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			resp, apiErr := call(decodeGRPC)
+			writeGRPC(w, resp, apiErr)
+			return
+		}
+		resp, apiErr := call(decodeJSON)
+		w.Header().Set("Content-Type", "application/json")
+		if apiErr != nil {
+			w.WriteHeader(apiErr.status)
+			json.NewEncoder(w).Encode(apiErr)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func decodeJSON(r *http.Request, req protoMessage) *apiError {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidArgument(err)
+	}
+	return nil
+}
+
+func generateStatementRPC(r protoMessage) (protoMessage, *apiError) {
+	req := r.(*GenerateStatementRequest)
+	stmt, err := provenance.NewStatement(req.Subjects, provenance.AnyContext{
+		BuildContext: provenance.BuildContext(req.Build),
+		AgentContext: provenance.AgentContext(req.Agent),
+	}, now())
+	if err != nil {
+		return nil, invalidArgument(err)
+	}
+	statement, _ := provenance.EscapedMarshal(stmt)
+	return &GenerateStatementResponse{Statement: statement}, nil
+}
+
+func (s *apiServer) signStatementRPC(r protoMessage) (protoMessage, *apiError) {
+	req := r.(*SignStatementRequest)
+	if len(s.signers) == 0 {
+		return nil, &apiError{http.StatusPreconditionFailed, "failed_precondition", "the server has no signing keys"}
+	}
+	var stmt interface{}
+	if err := json.Unmarshal(req.Statement, &stmt); err != nil {
+		return nil, invalidArgument(err)
+	}
+	statement, err := provenance.CanonicalMarshal(stmt)
+	if err != nil {
+		return nil, invalidArgument(err)
+	}
+	envelope, err := sign.SignEnvelope(statement, s.signers...)
+	if err != nil {
+		return nil, &apiError{http.StatusInternalServerError, "internal", err.Error()}
+	}
+	if s.tsaURL != "" {
+		if err := sign.TimestampEnvelope(envelope, s.tsaURL); err != nil {
+			return nil, &apiError{http.StatusServiceUnavailable, "unavailable", err.Error()}
+		}
+	}
+	encoded, _ := provenance.EscapedMarshal(envelope)
+	return &SignStatementResponse{Envelope: encoded}, nil
+}
+
+func verifySignatureRPC(r protoMessage) (protoMessage, *apiError) {
+	req := r.(*VerifySignatureRequest)
+	var envelope sign.Envelope
+	if err := json.Unmarshal(req.Envelope, &envelope); err != nil {
+		return nil, invalidArgument(err)
+	}
+	if envelope.PayloadType != sign.PayloadContentType {
+		return nil, invalidArgument(fmt.Errorf("unexpected payload type %q", envelope.PayloadType))
+	}
+	verifier, err := sign.LoadVerifier(req.PublicKey)
+	if err != nil {
+		return nil, invalidArgument(err)
+	}
+	keyID, err := sign.VerifyEnvelope(&envelope, verifier)
+	if err != nil {
+		return nil, &apiError{http.StatusPreconditionFailed, "failed_precondition", err.Error()}
+	}
+	return &VerifySignatureResponse{KeyID: keyID}, nil
+}
+
+// apiCommand serves the GeneratorService API over TLS to gRPC and Connect
+// clients. Callers must present the bearer token in PROVENANCE_API_TOKEN or
+// a client certificate issued by --tls_client_ca, and statements are only
+// signed with the --sign_key keys.
+func apiCommand(args []string) {
+	fs := flag.NewFlagSet("http-api", flag.ExitOnError)
+	listen := fs.String("listen", ":8081", "The address the API server listens on.")
+	certFile := fs.String("tls_cert", "", "The path of the server's PEM certificate; required, as the API is only served over TLS.")
+	keyFile := fs.String("tls_key", "", "The path of the server's PEM private key.")
+	clientCA := fs.String("tls_client_ca", "", "The path of the PEM certificates client certificates must be issued by.")
+	var keys arrayFlags
+	fs.Var(&keys, "sign_key", "A key SignStatement signs with, prefixed with its backend (e.g. 'pkcs11:<label>' or 'ssh:<path>'); may be repeated.")
+	tsa := fs.String("tsa_url", "", "The URL of an RFC 3161 timestamp authority used to timestamp signatures.")
+	fs.Parse(args)
+
+	token := os.Getenv("PROVENANCE_API_TOKEN")
+	if token == "" && *clientCA == "" {
+		fmt.Println("No client authentication: set PROVENANCE_API_TOKEN or --tls_client_ca")
+		fs.Usage()
+		os.Exit(1)
+	}
+	// Bearer tokens must not cross the network in the clear, and client
+	// certificates need TLS anyway.
+	if *certFile == "" || *keyFile == "" {
+		fmt.Println("No value found for required flags: --tls_cert and --tls_key")
+		fs.Usage()
+		os.Exit(1)
+	}
+	signers, err := sign.NewSigners(keys)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to load signing keys: %s", err))
+		os.Exit(1)
+	}
+	s := &apiServer{signers: signers, tsaURL: *tsa, token: token}
+
+	mux := http.NewServeMux()
+	mux.Handle(APIService+"GenerateStatement", s.unary(func() protoMessage { return &GenerateStatementRequest{} }, generateStatementRPC))
+	mux.Handle(APIService+"SignStatement", s.unary(func() protoMessage { return &SignStatementRequest{} }, s.signStatementRPC))
+	mux.Handle(APIService+"VerifySignature", s.unary(func() protoMessage { return &VerifySignatureRequest{} }, verifySignatureRPC))
+	server := &http.Server{Addr: *listen, Handler: mux}
+	if *clientCA != "" {
+		pem, err := ioutil.ReadFile(*clientCA)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to read client CA: %s", err))
+			os.Exit(1)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			fmt.Println(fmt.Sprintf("No certificates found in %s", *clientCA))
+			os.Exit(1)
+		}
+		server.TLSConfig = &tls.Config{ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	}
+
+	log.Printf("Serving %s on %s", strings.Trim(APIService, "/"), *listen)
+	log.Fatal(server.ListenAndServeTLS(*certFile, *keyFile))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/sign"
+)
+
+// storeInArchivista uploads "envelope" to the Archivista attestation store
+// at "baseURL" and returns the gitoid it is stored under. Servers predating
+// the versioned API are tried at "/upload".
+func storeInArchivista(baseURL string, envelope *sign.Envelope) (string, error) {
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return "", err
+	}
+	baseURL = strings.TrimRight(baseURL, "/")
+	contents, status, err := archivistaDo("POST", baseURL+"/v1/store", body)
+	if err == nil && status == http.StatusNotFound {
+		contents, status, err = archivistaDo("POST", baseURL+"/upload", body)
+	}
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("POST %s: %d %s", baseURL, status, strings.TrimSpace(string(contents)))
+	}
+	var stored struct {
+		Gitoid string `json:"gitoid"`
+	}
+	if err := json.Unmarshal(contents, &stored); err != nil || stored.Gitoid == "" {
+		return "", fmt.Errorf("unexpected Archivista response: %s", strings.TrimSpace(string(contents)))
+	}
+	return stored.Gitoid, nil
+}
+
+// fetchFromArchivista downloads the envelope stored under "gitoid".
+func fetchFromArchivista(baseURL, gitoid string) ([]byte, error) {
+	baseURL = strings.TrimRight(baseURL, "/")
+	contents, status, err := archivistaDo("GET", baseURL+"/v1/download/"+gitoid, nil)
+	if err == nil && status == http.StatusNotFound {
+		contents, status, err = archivistaDo("GET", baseURL+"/download/"+gitoid, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %d %s", gitoid, status, strings.TrimSpace(string(contents)))
+	}
+	return contents, nil
+}
+
+func archivistaDo(method, url string, body []byte) ([]byte, int, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	return contents, resp.StatusCode, err
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// artifactoryPut deploys "body" to "key" (the repository path) on the
+// Artifactory server "host" with "properties" and the upload metadata as
+// item properties, so the attestation is found by AQL, e.g.
+// items.find({"@provenance.subject.sha256": "<digest>"}).
+func (u *Upload) artifactoryPut(host, key string, body []byte, properties map[string][]string) error {
+	all := map[string][]string{}
+	for name, values := range properties {
+		all[name] = values
+	}
+	for name, value := range u.metadata() {
+		all[name] = append(all[name], value)
+	}
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var matrix strings.Builder
+	for _, name := range names {
+		var values []string
+		for _, value := range all[name] {
+			values = append(values, url.PathEscape(artifactoryEscape(value)))
+		}
+		fmt.Fprintf(&matrix, ";%s=%s", url.PathEscape(artifactoryEscape(name)), strings.Join(values, ","))
+	}
+
+	req, err := http.NewRequest("PUT", u.artifactoryURL(host, key)+matrix.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	sha1sum := sha1.Sum(body)
+	sha256sum := sha256.Sum256(body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Checksum-Sha1", hex.EncodeToString(sha1sum[:]))
+	req.Header.Set("X-Checksum-Sha256", hex.EncodeToString(sha256sum[:]))
+	_, err = artifactorySend(req, http.StatusCreated)
+	return err
+}
+
+// artifactoryGet downloads the item "key" from the server "host".
+func (u *Upload) artifactoryGet(host, key string) ([]byte, error) {
+	req, err := http.NewRequest("GET", u.artifactoryURL(host, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	return artifactorySend(req, http.StatusOK)
+}
+
+func (u *Upload) artifactoryURL(host, key string) string {
+	if u.Endpoint != "" {
+		return strings.TrimRight(u.Endpoint, "/") + "/" + s3EscapePath(key)
+	}
+	return "https://" + host + "/" + s3EscapePath(key)
+}
+
+// artifactorySend authenticates "req" with $ARTIFACTORY_ACCESS_TOKEN or
+// $ARTIFACTORY_USER and $ARTIFACTORY_PASSWORD (a password or API key) and
+// returns the response body.
+func artifactorySend(req *http.Request, status int) ([]byte, error) {
+	if token := os.Getenv("ARTIFACTORY_ACCESS_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if user := os.Getenv("ARTIFACTORY_USER"); user != "" {
+		req.SetBasicAuth(user, os.Getenv("ARTIFACTORY_PASSWORD"))
+	} else {
+		return nil, fmt.Errorf("no Artifactory credentials: set ARTIFACTORY_ACCESS_TOKEN or ARTIFACTORY_USER and ARTIFACTORY_PASSWORD")
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != status {
+		return nil, fmt.Errorf("%s %s: %s %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(contents)))
+	}
+	return contents, nil
+}
+
+// artifactoryEscape escapes the characters that separate matrix
+// parameters and their values.
+func artifactoryEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `,`, `\,`, `|`, `\|`, `=`, `\=`, `;`, `\;`).Replace(s)
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/sign"
+)
+
+const (
+	OCIConfigMediaType = "application/vnd.oci.image.config.v1+json"
+	OCIEmptyMediaType  = "application/vnd.oci.empty.v1+json"
+	DSSELayerMediaType = "application/vnd.dsse.envelope.v1+json"
+)
+
+// Attach modes select how attestations are stored next to an image.
+const (
+	// AttachModeTag appends to the cosign "sha256-<hex>.att" tag.
+	AttachModeTag = "tag"
+	// AttachModeReferrers pushes an OCI 1.1 artifact whose subject is the
+	// image, listed by the registry's referrers API.
+	AttachModeReferrers = "referrers"
+	// AttachModeBoth does both, for verifiers of either kind.
+	AttachModeBoth = "both"
+)
+
+// OCIManifest is an OCI image manifest.
+type OCIManifest struct {
+	SchemaVersion int                        `json:"schemaVersion"`
+	MediaType     string                     `json:"mediaType"`
+	ArtifactType  string                     `json:"artifactType,omitempty"`
+	Config        provenance.OCIDescriptor   `json:"config"`
+	Layers        []provenance.OCIDescriptor `json:"layers"`
+	Subject       *provenance.OCIDescriptor  `json:"subject,omitempty"`
+	Annotations   map[string]string          `json:"annotations,omitempty"`
+}
+
+// OCIIndex is an OCI image index, as used for the referrers tag fallback.
+type OCIIndex struct {
+	SchemaVersion int                        `json:"schemaVersion"`
+	MediaType     string                     `json:"mediaType"`
+	Manifests     []provenance.OCIDescriptor `json:"manifests"`
+}
+
+// attestationTag returns the tag cosign stores the attestations of the
+// image with manifest "digest" under, e.g. "sha256-<hex>.att".
+func attestationTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".att"
+}
+
+func blobDigest(blob []byte) string {
+	sum := sha256.Sum256(blob)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// attestationLayer returns the envelope as a blob and its layer
+// descriptor, annotated with the statement's predicate type.
+func attestationLayer(envelope *sign.Envelope) ([]byte, provenance.OCIDescriptor, error) {
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return nil, provenance.OCIDescriptor{}, err
+	}
+	var stmt struct {
+		PredicateType string `json:"predicateType"`
+	}
+	if err := json.Unmarshal(payload, &stmt); err != nil {
+		return nil, provenance.OCIDescriptor{}, err
+	}
+	layer, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, provenance.OCIDescriptor{}, err
+	}
+	return layer, provenance.OCIDescriptor{
+		MediaType: DSSELayerMediaType,
+		Digest:    blobDigest(layer),
+		Size:      len(layer),
+		Annotations: map[string]string{
+			"dev.cosignproject.cosign/signature": "",
+			"predicateType":                      stmt.PredicateType,
+		},
+	}, nil
+}
+
+// attachAttestation uploads "envelope" to the registry of "image", which
+// must be pinned by digest, in the given attach mode and returns the
+// references of the manifests it pushed.
+func attachAttestation(image string, envelope *sign.Envelope, mode string) ([]string, error) {
+	ref, err := ParseImageRef(image)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Digest == "" {
+		return nil, fmt.Errorf("image %s must be referenced by digest (image@sha256:...)", image)
+	}
+	layer, descriptor, err := attestationLayer(envelope)
+	if err != nil {
+		return nil, err
+	}
+	r := newRegistryResolver(1)
+	var attached []string
+	if mode == AttachModeTag || mode == AttachModeBoth {
+		reference, err := attachToTag(r, ref, layer, descriptor)
+		if err != nil {
+			return nil, err
+		}
+		attached = append(attached, reference)
+	}
+	if mode == AttachModeReferrers || mode == AttachModeBoth {
+		reference, err := attachAsReferrer(r, ref, layer, descriptor)
+		if err != nil {
+			return nil, err
+		}
+		attached = append(attached, reference)
+	}
+	if len(attached) == 0 {
+		return nil, fmt.Errorf("unknown attach mode %q", mode)
+	}
+	return attached, nil
+}
+
+// attachToTag follows cosign's attestation conventions: each envelope is
+// a layer of the manifest tagged "sha256-<hex>.att", so
+// `cosign verify-attestation` finds it next to the image. Attestations
+// already attached to the image are kept.
+func attachToTag(r *registryResolver, ref ImageRef, layer []byte, descriptor provenance.OCIDescriptor) (string, error) {
+	base := fmt.Sprintf("%s://%s/v2/%s", ref.scheme(), ref.endpoint(), ref.Repository)
+	tag := attestationTag(ref.Digest)
+
+	manifest := OCIManifest{SchemaVersion: 2, MediaType: provenance.OCIManifestMediaType}
+	resp, err := r.do("GET", base+"/manifests/"+tag, ref)
+	if err == nil {
+		err = json.NewDecoder(resp.Body).Decode(&manifest)
+		resp.Body.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to parse existing attestations: %s", err)
+		}
+		manifest.MediaType = provenance.OCIManifestMediaType
+	} else if !isNotFound(err) {
+		return "", err
+	}
+
+	attached := false
+	for _, l := range manifest.Layers {
+		attached = attached || l.Digest == descriptor.Digest
+	}
+	if !attached {
+		if err := uploadBlob(r, ref, base, layer); err != nil {
+			return "", err
+		}
+		manifest.Layers = append(manifest.Layers, descriptor)
+	}
+
+	config := map[string]interface{}{
+		"architecture": "",
+		"os":           "",
+		"config":       map[string]interface{}{},
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": diffIDs(manifest.Layers)},
+	}
+	configBlob, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	if err := uploadBlob(r, ref, base, configBlob); err != nil {
+		return "", err
+	}
+	manifest.Config = provenance.OCIDescriptor{MediaType: OCIConfigMediaType, Digest: blobDigest(configBlob), Size: len(configBlob)}
+
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	resp, err = r.send("PUT", base+"/manifests/"+tag, ref, provenance.OCIManifestMediaType, body)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return ref.Registry + "/" + ref.Repository + ":" + tag, nil
+}
+
+// attachAsReferrer pushes the envelope as an OCI 1.1 artifact whose
+// subject is the image, so registries list it through the referrers API.
+// Registries without referrers support are updated through the
+// "sha256-<hex>" referrers tag schema instead.
+func attachAsReferrer(r *registryResolver, ref ImageRef, layer []byte, descriptor provenance.OCIDescriptor) (string, error) {
+	base := fmt.Sprintf("%s://%s/v2/%s", ref.scheme(), ref.endpoint(), ref.Repository)
+
+	resp, err := r.do("HEAD", base+"/manifests/"+ref.Digest, ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up image manifest: %s", err)
+	}
+	resp.Body.Close()
+	subject := &provenance.OCIDescriptor{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    ref.Digest,
+		Size:      int(resp.ContentLength),
+	}
+	if subject.MediaType == "" || subject.Size < 0 {
+		return "", fmt.Errorf("registry did not return the media type and size of %s", ref.Digest)
+	}
+
+	empty := []byte("{}")
+	if err := uploadBlob(r, ref, base, empty); err != nil {
+		return "", err
+	}
+	if err := uploadBlob(r, ref, base, layer); err != nil {
+		return "", err
+	}
+	manifest := OCIManifest{
+		SchemaVersion: 2,
+		MediaType:     provenance.OCIManifestMediaType,
+		ArtifactType:  DSSELayerMediaType,
+		Config:        provenance.OCIDescriptor{MediaType: OCIEmptyMediaType, Digest: blobDigest(empty), Size: len(empty)},
+		Layers:        []provenance.OCIDescriptor{descriptor},
+		Subject:       subject,
+		Annotations:   map[string]string{"predicateType": descriptor.Annotations["predicateType"]},
+	}
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	digest := blobDigest(body)
+	resp, err = r.send("PUT", base+"/manifests/"+digest, ref, provenance.OCIManifestMediaType, body)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	reference := ref.Registry + "/" + ref.Repository + "@" + digest
+	if resp.Header.Get("OCI-Subject") != "" {
+		return reference, nil
+	}
+
+	// The registry does not index referrers itself, so maintain the
+	// fallback index tagged with the subject digest.
+	tag := strings.Replace(ref.Digest, ":", "-", 1)
+	index := OCIIndex{SchemaVersion: 2, MediaType: provenance.OCIIndexMediaType}
+	resp, err = r.do("GET", base+"/manifests/"+tag, ref)
+	if err == nil {
+		err = json.NewDecoder(resp.Body).Decode(&index)
+		resp.Body.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to parse referrers index: %s", err)
+		}
+	} else if !isNotFound(err) {
+		return "", err
+	}
+	for _, m := range index.Manifests {
+		if m.Digest == digest {
+			return reference, nil
+		}
+	}
+	index.Manifests = append(index.Manifests, provenance.OCIDescriptor{
+		MediaType:    provenance.OCIManifestMediaType,
+		ArtifactType: manifest.ArtifactType,
+		Digest:       digest,
+		Size:         len(body),
+		Annotations:  manifest.Annotations,
+	})
+	body, err = json.Marshal(index)
+	if err != nil {
+		return "", err
+	}
+	resp, err = r.send("PUT", base+"/manifests/"+tag, ref, provenance.OCIIndexMediaType, body)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return reference, nil
+}
+
+func diffIDs(layers []provenance.OCIDescriptor) []string {
+	ids := []string{}
+	for _, l := range layers {
+		ids = append(ids, l.Digest)
+	}
+	return ids
+}
+
+// uploadBlob pushes "blob" to the repository unless it already exists,
+// using a monolithic upload.
+func uploadBlob(r *registryResolver, ref ImageRef, base string, blob []byte) error {
+	digest := blobDigest(blob)
+	resp, err := r.do("HEAD", base+"/blobs/"+digest, ref)
+	if err == nil {
+		resp.Body.Close()
+		return nil
+	} else if !isNotFound(err) {
+		return err
+	}
+	resp, err = r.send("POST", base+"/blobs/uploads/", ref, "", nil)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return err
+	}
+	q := location.Query()
+	q.Set("digest", digest)
+	location.RawQuery = q.Encode()
+	resp, err = r.send("PUT", location.String(), ref, "application/octet-stream", blob)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AzureStorageVersion is the Blob Storage REST API version requests use.
+const AzureStorageVersion = "2021-08-06"
+
+// azureCredentials authorize Blob Storage requests with either a shared
+// key, a SAS token or a Microsoft Entra ID (managed identity) token.
+type azureCredentials struct {
+	endpoint   string
+	accountKey []byte
+	sas        string
+	token      string
+}
+
+// azurePut uploads "body" as a block blob; "key" starts with the container.
+func (u *Upload) azurePut(account, key string, body []byte) error {
+	req, creds, err := u.azureRequest("PUT", account, key, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+	for name, value := range u.metadata() {
+		req.Header.Set("X-Ms-Meta-"+name, value)
+	}
+	if u.KMSKey != "" {
+		req.Header.Set("X-Ms-Encryption-Scope", u.KMSKey)
+	}
+	_, err = azureSend(req, creds, account, http.StatusCreated)
+	return err
+}
+
+// azureGet downloads the blob "key"; "key" starts with the container.
+func (u *Upload) azureGet(account, key string) ([]byte, error) {
+	req, creds, err := u.azureRequest("GET", account, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return azureSend(req, creds, account, http.StatusOK)
+}
+
+func (u *Upload) azureRequest(method, account, key string, body []byte) (*http.Request, azureCredentials, error) {
+	creds, err := azureCredentialsFor(account)
+	if err != nil {
+		return nil, creds, fmt.Errorf("failed to get Azure credentials: %s", err)
+	}
+	endpoint := creds.endpoint
+	if u.Endpoint != "" {
+		endpoint = strings.TrimRight(u.Endpoint, "/")
+	}
+	target := endpoint + "/" + s3EscapePath(key)
+	if creds.sas != "" {
+		target += "?" + strings.TrimPrefix(creds.sas, "?")
+	}
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	return req, creds, err
+}
+
+// azureSend authorizes and performs "req" and returns the response body.
+func azureSend(req *http.Request, creds azureCredentials, account string, status int) ([]byte, error) {
+	req.Header.Set("X-Ms-Version", AzureStorageVersion)
+	req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+	switch {
+	case creds.token != "":
+		req.Header.Set("Authorization", "Bearer "+creds.token)
+	case creds.accountKey != nil:
+		req.Header.Set("Authorization", "SharedKey "+account+":"+azureSharedKeySignature(req, account, creds.accountKey))
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != status {
+		return nil, fmt.Errorf("%s %s: %s %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(contents)))
+	}
+	return contents, nil
+}
+
+// azureCredentialsFor returns credentials for "account" from the
+// AZURE_STORAGE_CONNECTION_STRING environment variable when it names the
+// account, else from the managed identity of the agent.
+func azureCredentialsFor(account string) (azureCredentials, error) {
+	creds := azureCredentials{endpoint: "https://" + account + ".blob.core.windows.net"}
+	if connection := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); connection != "" {
+		settings := map[string]string{}
+		for _, part := range strings.Split(connection, ";") {
+			if kv := strings.SplitN(part, "=", 2); len(kv) == 2 {
+				settings[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+			}
+		}
+		if name := settings["accountname"]; name == "" || name == account {
+			if settings["blobendpoint"] != "" {
+				creds.endpoint = strings.TrimRight(settings["blobendpoint"], "/")
+			} else if suffix := settings["endpointsuffix"]; suffix != "" {
+				protocol := settings["defaultendpointsprotocol"]
+				if protocol == "" {
+					protocol = "https"
+				}
+				creds.endpoint = protocol + "://" + account + ".blob." + suffix
+			}
+			if key := settings["accountkey"]; key != "" {
+				decoded, err := base64.StdEncoding.DecodeString(key)
+				if err != nil {
+					return creds, fmt.Errorf("invalid AccountKey in connection string: %s", err)
+				}
+				creds.accountKey = decoded
+				return creds, nil
+			}
+			if sas := settings["sharedaccesssignature"]; sas != "" {
+				creds.sas = sas
+				return creds, nil
+			}
+		}
+	}
+	token, err := azureManagedIdentityToken()
+	if err != nil {
+		return creds, err
+	}
+	creds.token = token
+	return creds, nil
+}
+
+// azureManagedIdentityToken gets a Blob Storage token for the managed
+// identity of the VM, or the user-assigned identity $AZURE_CLIENT_ID.
+func azureManagedIdentityToken() (string, error) {
+	query := url.Values{"api-version": {"2018-02-01"}, "resource": {"https://storage.azure.com/"}}
+	if id := os.Getenv("AZURE_CLIENT_ID"); id != "" {
+		query.Set("client_id", id)
+	}
+	req, err := http.NewRequest("GET", "http://169.254.169.254/metadata/identity/oauth2/token?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("no connection string and no managed identity endpoint: %s", err)
+	}
+	defer resp.Body.Close()
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil || resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return "", fmt.Errorf("managed identity token request failed: %s %s", resp.Status, token.ErrorDescription)
+	}
+	return token.AccessToken, nil
+}
+
+// azureSharedKeySignature signs "req" with the storage account key
+// following the Shared Key authorization scheme.
+func azureSharedKeySignature(req *http.Request, account string, key []byte) string {
+	length := ""
+	if req.ContentLength > 0 {
+		length = strconv.FormatInt(req.ContentLength, 10)
+	}
+	var headers []string
+	for name, values := range req.Header {
+		if name := strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			headers = append(headers, name+":"+strings.TrimSpace(strings.Join(values, ",")))
+		}
+	}
+	sort.Strings(headers)
+
+	resource := "/" + account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := query[name]
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		length,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, superseded by x-ms-date
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		strings.Join(headers, "\n"),
+		resource,
+	}, "\n")
+	return base64.StdEncoding.EncodeToString(hmacSHA256(key, stringToSign))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// GeneratorComponent names this plugin in the builder version.
+const GeneratorComponent = "provenance-generator"
+
+// builderVersion sets the version and dependencies of "builder", named as
+// in SLSA v1: the version of the agent, "agentVersion", and of this plugin,
+// found in $BUILDKITE_PLUGINS of "env" by the name of its checkout
+// directory "pluginID" and recorded as a dependency with the commit
+// checked out in "pluginDir".
+func builderVersion(builder *provenance.Builder, env map[string]string, agentVersion, pluginID, pluginDir string) error {
+	version := map[string]string{}
+	if agentVersion != "" {
+		version["buildkite-agent"] = agentVersion
+	}
+	if pluginID != "" && env["BUILDKITE_PLUGINS"] != "" {
+		labels, err := pluginLabels(env["BUILDKITE_PLUGINS"])
+		if err != nil {
+			return err
+		}
+		for _, label := range labels {
+			if pluginDirName(label) != pluginID {
+				continue
+			}
+			material, err := pluginMaterial(label, pluginDir)
+			if err != nil {
+				return err
+			}
+			if i := strings.LastIndex(label, "#"); i >= 0 {
+				version[GeneratorComponent] = label[i+1:]
+			} else if commit := material.Digest["sha1"]; commit != "" {
+				version[GeneratorComponent] = commit
+			}
+			builder.Dependencies = append(builder.Dependencies, material)
+			break
+		}
+	}
+	if len(version) > 0 {
+		builder.Version = version
+	}
+	return nil
+}
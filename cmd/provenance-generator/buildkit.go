@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// buildkitPredicate holds the parts of a BuildKit SLSA provenance predicate
+// merged into our statement. BuildKit writes SLSA v0.2 predicates by
+// default and v1 predicates with "mode=max,version=v1".
+type buildkitPredicate struct {
+	// SLSA v0.2
+	Materials   []provenance.Item `json:"materials"`
+	BuildConfig json.RawMessage   `json:"buildConfig"`
+	// SLSA v1
+	BuildDefinition *struct {
+		ResolvedDependencies []provenance.Item `json:"resolvedDependencies"`
+		InternalParameters   struct {
+			BuildConfig json.RawMessage `json:"buildConfig"`
+		} `json:"internalParameters"`
+	} `json:"buildDefinition"`
+}
+
+func (p buildkitPredicate) materials() []provenance.Item {
+	if p.BuildDefinition != nil {
+		return p.BuildDefinition.ResolvedDependencies
+	}
+	return p.Materials
+}
+
+func (p buildkitPredicate) buildConfig() json.RawMessage {
+	if p.BuildDefinition != nil {
+		return p.BuildDefinition.InternalParameters.BuildConfig
+	}
+	return p.BuildConfig
+}
+
+// readBuildkitProvenance reads the BuildKit provenance predicates in
+// "path", keyed by platform, or by "" when the file is not per platform.
+// It accepts an in-toto statement, a bare predicate, the
+// "buildx.build.provenance" entry of a `docker buildx build --metadata-file`
+// and the output of `docker buildx imagetools inspect --format
+// '{{json .Provenance}}'`, which wraps predicates in {"SLSA": ...} and, for
+// multi-platform images, keys them by platform.
+func readBuildkitProvenance(path string) (map[string]buildkitPredicate, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(contents, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	predicates := map[string]buildkitPredicate{}
+	if err := collectBuildkitPredicates(document, "", predicates); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	if len(predicates) == 0 {
+		return nil, fmt.Errorf("no BuildKit provenance found in %s", path)
+	}
+	return predicates, nil
+}
+
+func collectBuildkitPredicates(document map[string]json.RawMessage, platform string, predicates map[string]buildkitPredicate) error {
+	for _, key := range []string{"buildx.build.provenance", "predicate", "SLSA"} {
+		if raw, ok := document[key]; ok {
+			var inner map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &inner); err != nil {
+				return err
+			}
+			return collectBuildkitPredicates(inner, platform, predicates)
+		}
+	}
+	_, v02 := document["buildConfig"]
+	_, materials := document["materials"]
+	_, v1 := document["buildDefinition"]
+	if v02 || materials || v1 {
+		encoded, err := json.Marshal(document)
+		if err != nil {
+			return err
+		}
+		var predicate buildkitPredicate
+		if err := json.Unmarshal(encoded, &predicate); err != nil {
+			return err
+		}
+		predicates[platform] = predicate
+		return nil
+	}
+	if platform != "" {
+		return nil
+	}
+	for key, raw := range document {
+		var inner map[string]json.RawMessage
+		if json.Unmarshal(raw, &inner) != nil {
+			continue
+		}
+		if err := collectBuildkitPredicates(inner, key, predicates); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeBuildkitProvenance adds the materials of the BuildKit "predicates"
+// that the statement does not record yet and sets its buildConfig: the
+// BuildKit buildConfig itself, or a map of them by platform when the
+// provenance covers several platforms.
+func mergeBuildkitProvenance(stmt *provenance.Statement, predicates map[string]buildkitPredicate) error {
+	platforms := make([]string, 0, len(predicates))
+	for platform := range predicates {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+
+	seen := map[string]bool{}
+	key := func(item provenance.Item) string {
+		encoded, _ := json.Marshal(item)
+		return string(encoded)
+	}
+	for _, item := range stmt.Predicate.Materials {
+		seen[key(item)] = true
+	}
+	configs := map[string]json.RawMessage{}
+	for _, platform := range platforms {
+		for _, item := range predicates[platform].materials() {
+			if item.URI == "" {
+				return fmt.Errorf("BuildKit material without a uri")
+			}
+			if !seen[key(item)] {
+				seen[key(item)] = true
+				stmt.Predicate.Materials = append(stmt.Predicate.Materials, item)
+			}
+		}
+		if config := predicates[platform].buildConfig(); len(config) > 0 {
+			configs[platform] = config
+		}
+	}
+
+	if len(configs) == 1 && len(platforms) == 1 {
+		stmt.Predicate.BuildConfig = configs[platforms[0]]
+	} else if len(configs) > 0 {
+		encoded, err := json.Marshal(configs)
+		if err != nil {
+			return err
+		}
+		stmt.Predicate.BuildConfig = encoded
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// BuildkiteAPI is the base URL of the Buildkite REST API.
+const BuildkiteAPI = "https://api.buildkite.com/v2"
+
+// linkNext extracts the "next" URL from a paginated response's Link header.
+var linkNext = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// buildkiteClient is a minimal client for the Buildkite REST API.
+type buildkiteClient struct {
+	token string
+	http  *http.Client
+}
+
+// newBuildkiteClient returns a client authenticating with "token". It bounds
+// connecting and waiting for response headers rather than whole requests,
+// which would cut off downloads of large artifacts.
+func newBuildkiteClient(token string) *buildkiteClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.ResponseHeaderTimeout = 30 * time.Second
+	return &buildkiteClient{token: token, http: &http.Client{Transport: transport}}
+}
+
+// get decodes the JSON response for "url" into "v" and returns the URL of
+// the next page, if any.
+func (c *buildkiteClient) get(url string, v interface{}) (string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", err
+	}
+	next := ""
+	if m := linkNext.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+		next = m[1]
+	}
+	return next, nil
+}
+
+// post sends "body" as JSON to "url" and decodes the response into "v"
+// unless it is nil.
+func (c *buildkiteClient) post(url string, body, v interface{}) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("POST %s: %s", url, resp.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type APIBuild struct {
+	WebURL    string   `json:"web_url"`
+	Number    int      `json:"number"`
+	State     string   `json:"state"`
+	Commit    string   `json:"commit"`
+	Branch    string   `json:"branch"`
+	CreatedAt string   `json:"created_at"`
+	StartedAt string   `json:"started_at"`
+	Jobs      []APIJob `json:"jobs"`
+	Creator   struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	} `json:"creator"`
+}
+
+type APIJob struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	StepKey string `json:"step_key"`
+	State   string `json:"state"`
+	WebURL  string `json:"web_url"`
+}
+
+type APIArtifact struct {
+	JobID       string `json:"job_id"`
+	Path        string `json:"path"`
+	SHA256      string `json:"sha256sum"`
+	DownloadURL string `json:"download_url"`
+}
+
+func buildURL(org, pipeline, number string) string {
+	return fmt.Sprintf("%s/organizations/%s/pipelines/%s/builds/%s", BuildkiteAPI, org, pipeline, number)
+}
+
+// build fetches a build including its jobs.
+func (c *buildkiteClient) build(org, pipeline, number string) (*APIBuild, error) {
+	var b APIBuild
+	_, err := c.get(buildURL(org, pipeline, number), &b)
+	return &b, err
+}
+
+// buildMetadata fetches when the build of the job whose environment is
+// "env" started and what else the Buildkite REST API knows about it.
+func buildMetadata(client *buildkiteClient, env map[string]string) (string, *provenance.BuildkiteMetadata, error) {
+	org, pipeline, number := env["BUILDKITE_ORGANIZATION_SLUG"], env["BUILDKITE_PIPELINE_SLUG"], env["BUILDKITE_BUILD_NUMBER"]
+	if org == "" || pipeline == "" || number == "" {
+		return "", nil, fmt.Errorf("no value found for required environment variables: BUILDKITE_ORGANIZATION_SLUG, BUILDKITE_PIPELINE_SLUG and BUILDKITE_BUILD_NUMBER")
+	}
+	build, err := client.build(org, pipeline, number)
+	if err != nil {
+		return "", nil, err
+	}
+	metadata := &provenance.BuildkiteMetadata{Pipeline: pipeline, BuildNumber: build.Number, Creator: build.Creator.Email}
+	if metadata.Creator == "" {
+		metadata.Creator = build.Creator.Name
+	}
+	for _, job := range build.Jobs {
+		if job.ID == env["BUILDKITE_JOB_ID"] {
+			metadata.JobState = job.State
+		}
+	}
+	started := ""
+	if t, err := time.Parse(time.RFC3339, build.StartedAt); err == nil {
+		started = t.UTC().Format(time.RFC3339)
+	}
+	return started, metadata, nil
+}
+
+// annotate creates or replaces the annotation of a build with "context".
+func (c *buildkiteClient) annotate(org, pipeline, number, context, style, body string) error {
+	return c.post(buildURL(org, pipeline, number)+"/annotations", map[string]interface{}{
+		"context": context,
+		"style":   style,
+		"body":    body,
+		"append":  false,
+	}, nil)
+}
+
+// builds fetches the builds of a pipeline created within [from, to].
+func (c *buildkiteClient) builds(org, pipeline string, from, to time.Time) ([]APIBuild, error) {
+	var all []APIBuild
+	next := fmt.Sprintf("%s/organizations/%s/pipelines/%s/builds?created_from=%s&created_to=%s&per_page=100",
+		BuildkiteAPI, org, pipeline, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+	for next != "" {
+		var page []APIBuild
+		var err error
+		if next, err = c.get(next, &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+	}
+	return all, nil
+}
+
+// artifacts fetches all artifacts uploaded by the jobs of a build.
+func (c *buildkiteClient) artifacts(org, pipeline, number string) ([]APIArtifact, error) {
+	var all []APIArtifact
+	next := buildURL(org, pipeline, number) + "/artifacts?per_page=100"
+	for next != "" {
+		var page []APIArtifact
+		var err error
+		if next, err = c.get(next, &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+	}
+	return all, nil
+}
+
+// open issues an authenticated GET for "url" and returns the response body.
+func (c *buildkiteClient) open(url string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// sha256 downloads the content at "url" and returns its sha256 digest.
+func (c *buildkiteClient) sha256(url string) (string, error) {
+	body, err := c.open(url)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// download writes the content at "url" to the file "dest".
+func (c *buildkiteClient) download(url, dest string) error {
+	body, err := c.open(url)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
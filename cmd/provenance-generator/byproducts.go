@@ -0,0 +1,33 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// fileByproduct returns the byproduct recording the file at "path", named
+// after its path relative to "checkoutDir" when it is in the checkout and
+// as given otherwise, with its digests and media type.
+func fileByproduct(path, checkoutDir string, algorithms []string) (provenance.ResourceDescriptor, error) {
+	digest, err := provenance.DigestFile(path, algorithms)
+	if err != nil {
+		return provenance.ResourceDescriptor{}, err
+	}
+	mediaType, _, err := provenance.DescribeFile(path)
+	if err != nil {
+		return provenance.ResourceDescriptor{}, err
+	}
+	name := filepath.ToSlash(path)
+	abspath, err := filepath.Abs(path)
+	if err != nil {
+		return provenance.ResourceDescriptor{}, err
+	}
+	if root, err := filepath.Abs(checkoutDir); err == nil {
+		if rel, err := filepath.Rel(root, abspath); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			name = filepath.ToSlash(rel)
+		}
+	}
+	return provenance.ResourceDescriptor{Name: name, Digest: digest, MediaType: mediaType}, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// checksumAlgorithms name the algorithm of a coreutils checksum line by the
+// length of its hex digest.
+var checksumAlgorithms = map[int]string{64: "sha256", 96: "sha384", 128: "sha512"}
+
+// taggedChecksum matches the BSD-style lines of `sha256sum --tag`, e.g.
+// "SHA256 (app.tar.gz) = <hex>".
+var taggedChecksum = regexp.MustCompile(`^(SHA256|SHA384|SHA512) \((.*)\) = ([0-9a-fA-F]+)$`)
+
+// readChecksumFiles turns the entries of checksum files such as SHA256SUMS,
+// in the format of sha256sum and friends, into subjects in the order they
+// are listed. Digests of the same name from several files, such as
+// SHA256SUMS and SHA512SUMS, are merged into one subject.
+func readChecksumFiles(paths []string) ([]provenance.Subject, error) {
+	var subjects []provenance.Subject
+	index := map[string]int{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		line := 0
+		for scanner.Scan() {
+			line++
+			text := strings.TrimRight(scanner.Text(), "\r")
+			if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			name, algorithm, digest, err := parseChecksumLine(text)
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s:%d: %s", path, line, err)
+			}
+			i, ok := index[name]
+			if !ok {
+				i = len(subjects)
+				index[name] = i
+				subjects = append(subjects, provenance.Subject{Name: name, Digest: provenance.DigestSet{}})
+			}
+			if existing := subjects[i].Digest[algorithm]; existing != "" && existing != digest {
+				f.Close()
+				return nil, fmt.Errorf("%s:%d: conflicting %s digests for %s", path, line, algorithm, name)
+			}
+			subjects[i].Digest[algorithm] = digest
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, subject := range subjects {
+		if subject.Digest["sha256"] == "" {
+			return nil, fmt.Errorf("no sha256 digest listed for %s", subject.Name)
+		}
+	}
+	return subjects, nil
+}
+
+// parseChecksumLine parses "<hex>  <name>", "<hex> *<name>" (binary mode)
+// or the tagged format. Names with a backslash or newline are escaped and
+// the line prefixed with a backslash, as coreutils does.
+func parseChecksumLine(line string) (name, algorithm, digest string, err error) {
+	escaped := strings.HasPrefix(line, "\\")
+	if escaped {
+		line = line[1:]
+	}
+	if m := taggedChecksum.FindStringSubmatch(line); m != nil {
+		name, algorithm, digest = m[2], strings.ToLower(m[1]), strings.ToLower(m[3])
+	} else {
+		i := strings.Index(line, " ")
+		if i < 0 || len(line) < i+2 || (line[i+1] != ' ' && line[i+1] != '*') {
+			return "", "", "", fmt.Errorf("not a checksum line")
+		}
+		digest, name = strings.ToLower(line[:i]), line[i+2:]
+		var ok bool
+		if algorithm, ok = checksumAlgorithms[len(digest)]; !ok {
+			return "", "", "", fmt.Errorf("digest of unknown length %d", len(digest))
+		}
+	}
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != 2*provenance.DigestSize(algorithm) {
+		return "", "", "", fmt.Errorf("invalid %s digest %q", algorithm, digest)
+	}
+	if escaped {
+		name = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r").Replace(name)
+	}
+	name = strings.TrimPrefix(name, "./")
+	if name == "" {
+		return "", "", "", fmt.Errorf("no file name")
+	}
+	return name, algorithm, digest, nil
+}
+
+// writeChecksums writes the sha256 digests of "subjects" to "path" in the
+// format of sha256sum, so `sha256sum -c` verifies the files. Directory
+// digests and archive entries are left out.
+func writeChecksums(path string, subjects []provenance.Subject) error {
+	var b strings.Builder
+	for _, subject := range subjects {
+		digest := subject.Digest["sha256"]
+		if digest == "" || subject.Digest[provenance.DirHashAlgorithm] != "" || subject.Archive != "" {
+			continue
+		}
+		name := subject.Name
+		if strings.ContainsAny(name, "\\\n\r") {
+			name = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`).Replace(name)
+			b.WriteString(`\`)
+		}
+		fmt.Fprintf(&b, "%s  %s\n", digest, name)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(b.String()), 0644)
+}
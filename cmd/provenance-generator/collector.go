@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// organizationSyntax extracts the organization slug from a REST API URL.
+var organizationSyntax = regexp.MustCompile(`/organizations/([^/]+)/`)
+
+// slugSyntax matches the organization and pipeline slugs and job IDs the
+// output path is made of, so that webhooks cannot point it elsewhere.
+var slugSyntax = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// webhookMaxBytes bounds the size of webhook payloads.
+const webhookMaxBytes = 4 << 20
+
+// collectorQueueSize is the number of jobs that may wait for a worker
+// before webhooks are refused.
+const collectorQueueSize = 100
+
+// JobWebhook is the subset of a Buildkite "job.finished" webhook payload
+// needed to generate provenance.
+type JobWebhook struct {
+	Event string `json:"event"`
+	Job   struct {
+		ID      string `json:"id"`
+		Type    string `json:"type"`
+		State   string `json:"state"`
+		Command string `json:"command"`
+		Step    struct {
+			ID string `json:"id"`
+		} `json:"step"`
+		Agent struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"agent"`
+	} `json:"job"`
+	Build struct {
+		URL    string `json:"url"`
+		WebURL string `json:"web_url"`
+		Number int    `json:"number"`
+		Commit string `json:"commit"`
+		Branch string `json:"branch"`
+		Tag    string `json:"tag"`
+	} `json:"build"`
+	Pipeline struct {
+		Slug       string `json:"slug"`
+		Repository string `json:"repository"`
+	} `json:"pipeline"`
+}
+
+// collector generates provenance for jobs reported by Buildkite webhooks.
+type collector struct {
+	client    *buildkiteClient
+	token     string
+	outputDir string
+	output    outputOptions
+	queue     chan JobWebhook
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Buildkite-Token")), []byte(c.token)) != 1 {
+		http.Error(w, "invalid webhook token", http.StatusUnauthorized)
+		return
+	}
+	var event JobWebhook
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, webhookMaxBytes)).Decode(&event); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if event.Event != "job.finished" || event.Job.Type != "script" || event.Job.State != "passed" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	select {
+	case c.queue <- event:
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "too many jobs waiting for provenance", http.StatusServiceUnavailable)
+	}
+}
+
+// work generates provenance for the jobs of the queue until it is closed.
+func (c *collector) work() {
+	for event := range c.queue {
+		path, err := c.collect(event)
+		if err != nil {
+			log.Printf("Failed to generate provenance for job %s: %s", event.Job.ID, err)
+		} else if path != "" {
+			log.Printf("Wrote provenance for job %s to %s", event.Job.ID, path)
+		}
+	}
+}
+
+// collect downloads the artifacts of the job described by "event" and
+// writes provenance for them. It returns "" when the job has no artifacts.
+func (c *collector) collect(event JobWebhook) (string, error) {
+	m := organizationSyntax.FindStringSubmatch(event.Build.URL)
+	if m == nil {
+		return "", fmt.Errorf("no organization in build URL %q", event.Build.URL)
+	}
+	org := m[1]
+	for _, segment := range []string{org, event.Pipeline.Slug, event.Job.ID} {
+		if !slugSyntax.MatchString(segment) {
+			return "", fmt.Errorf("invalid organization, pipeline or job %q", segment)
+		}
+	}
+	if event.Build.Number < 1 {
+		return "", fmt.Errorf("invalid build number %d", event.Build.Number)
+	}
+	// The API token is only sent to URLs built from the validated slugs,
+	// never to the build URL of the payload.
+	artifacts, err := c.client.artifacts(org, event.Pipeline.Slug, strconv.Itoa(event.Build.Number))
+	if err != nil {
+		return "", err
+	}
+	dir, err := ioutil.TempDir("", "collector")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	downloaded := 0
+	for _, artifact := range artifacts {
+		if artifact.JobID != event.Job.ID {
+			continue
+		}
+		dest := filepath.Join(dir, filepath.FromSlash(artifact.Path))
+		if rel, err := filepath.Rel(dir, dest); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("artifact path %q is outside the job's artifacts", artifact.Path)
+		}
+		if err := c.client.download(artifact.DownloadURL, dest); err != nil {
+			return "", err
+		}
+		downloaded++
+	}
+	if downloaded == 0 {
+		return "", nil
+	}
+	s, err := walker.Subjects(dir)
+	if err != nil {
+		return "", err
+	}
+	if err := provenance.NormalizeSubjectNames(s, provenance.NamePolicyNFC); err != nil {
+		return "", err
+	}
+	stmt, err := provenance.NewStatement(s, provenance.AnyContext{
+		BuildContext: provenance.BuildContext{
+			Repository: event.Pipeline.Repository,
+			BuildURL:   event.Build.WebURL,
+			Commit:     event.Build.Commit,
+			StepID:     event.Job.Step.ID,
+			Command:    event.Job.Command,
+			Ref:        gitRef(event.Build.Branch, event.Build.Tag),
+		},
+		AgentContext: provenance.AgentContext{
+			Name:         event.Job.Agent.Name,
+			ID:           event.Job.Agent.ID,
+			Organization: org,
+		},
+	}, now())
+	if err != nil {
+		return "", err
+	}
+	opts := c.output
+	opts.path = filepath.Join(c.outputDir, org, event.Pipeline.Slug, strconv.Itoa(event.Build.Number), event.Job.ID+".provenance.json")
+	opts.pathSet = true
+	return writeAttestation(stmt, stmt.Subject, opts)
+}
+
+// collectorCommand runs "collector serve", an HTTP server receiving
+// Buildkite job webhooks and generating provenance for pipelines that
+// cannot run the plugin.
+func collectorCommand(args []string) {
+	if len(args) < 1 || args[0] != "serve" {
+		fmt.Println("Usage: collector serve [flags]")
+		os.Exit(1)
+	}
+	fs := flag.NewFlagSet("collector serve", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "The address the webhook server listens on.")
+	outputDir := fs.String("output_dir", "provenance", "The directory provenance is written to, organized by organization, pipeline and build.")
+	preset := fs.String("preset", "", "The output convention to follow.")
+	tsa := fs.String("tsa_url", "", "The URL of an RFC 3161 timestamp authority used to timestamp signatures.")
+	var keys arrayFlags
+	fs.Var(&keys, "sign_key", "A key used to sign provenance envelopes; may be repeated.")
+	workers := fs.Int("workers", 4, "The number of jobs provenance is generated for at once.")
+	fs.Parse(args[1:])
+	if *workers < 1 {
+		fmt.Println("--workers must be at least 1")
+		os.Exit(1)
+	}
+
+	token := os.Getenv("BUILDKITE_API_TOKEN")
+	if token == "" {
+		fmt.Println("No value found for required environment variable: BUILDKITE_API_TOKEN")
+		os.Exit(1)
+	}
+	webhookToken := os.Getenv("BUILDKITE_WEBHOOK_TOKEN")
+	if webhookToken == "" {
+		fmt.Println("No value found for required environment variable: BUILDKITE_WEBHOOK_TOKEN")
+		os.Exit(1)
+	}
+	c := &collector{
+		client:    newBuildkiteClient(token),
+		token:     webhookToken,
+		outputDir: *outputDir,
+		output:    outputOptions{preset: *preset, signKeys: keys, tsaURL: *tsa},
+		queue:     make(chan JobWebhook, collectorQueueSize),
+	}
+	for i := 0; i < *workers; i++ {
+		go c.work()
+	}
+	log.Printf("Listening for Buildkite webhooks on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, c))
+}
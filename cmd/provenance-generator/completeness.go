@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// parseCompleteness returns the completeness claimed by "value", a
+// comma-separated list of "arguments", "environment" and "materials", or
+// "none".
+func parseCompleteness(value string) (provenance.Completeness, error) {
+	var c provenance.Completeness
+	for _, name := range strings.Split(value, ",") {
+		switch strings.TrimSpace(name) {
+		case "arguments":
+			c.Arguments = true
+		case "environment":
+			c.Environment = true
+		case "materials":
+			c.Materials = true
+		case "none", "":
+		default:
+			return c, fmt.Errorf("unknown completeness %q, expected 'arguments', 'environment', 'materials' or 'none'", name)
+		}
+	}
+	return c, nil
+}
+
+// checkCompleteness returns an error when the metadata of "p" claims more
+// than was recorded: complete arguments need the command, a complete
+// environment the variables captured with --capture_env, and complete
+// materials the source at its commit and none of the "unrecorded" inputs
+// known to the generator. Reproducible builds need both, and hermetic
+// builds complete materials.
+func checkCompleteness(p provenance.Predicate, unrecorded []string) error {
+	c := p.Metadata.Completeness
+	if c.Arguments && p.Recipe.EntryPoint == "" {
+		return fmt.Errorf("complete arguments require the command of the step")
+	}
+	if c.Environment && (p.Recipe.Environment == nil || p.Recipe.Environment.Variables == nil) {
+		return fmt.Errorf("a complete environment requires --capture_env")
+	}
+	if c.Materials {
+		if source, ok := definedInMaterial(p); !ok || source.Digest["sha1"] == "" {
+			return fmt.Errorf("complete materials require a source material with a commit")
+		}
+		if len(unrecorded) > 0 {
+			return fmt.Errorf("complete materials require %s", strings.Join(unrecorded, " and "))
+		}
+	}
+	if p.Metadata.Reproducible && !(c.Environment && c.Materials) {
+		return fmt.Errorf("a reproducible build requires a complete environment and materials")
+	}
+	if p.Metadata.Hermetic && !c.Materials {
+		return fmt.Errorf("a hermetic build requires complete materials")
+	}
+	return nil
+}
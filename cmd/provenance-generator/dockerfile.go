@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// dockerfileVariable matches the $NAME, ${NAME} and ${NAME:-default}
+// references substituted in FROM instructions.
+var dockerfileVariable = regexp.MustCompile(`\$(?:\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}|([A-Za-z_][A-Za-z0-9_]*))`)
+
+// dockerfileBaseImages returns the images the stages of the Dockerfile at
+// "path" are built FROM, with the ARGs declared before the first FROM
+// substituted from "buildArgs" or their defaults. Stages built from earlier
+// stages and "scratch" have no base image.
+func dockerfileBaseImages(path string, buildArgs map[string]string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var instructions []string
+	var continued string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasSuffix(line, `\`) {
+			continued += strings.TrimSuffix(line, `\`) + " "
+			continue
+		}
+		if line = strings.TrimSpace(continued + line); line != "" {
+			instructions = append(instructions, line)
+		}
+		continued = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	args := map[string]string{}
+	stages := map[string]bool{}
+	var images []string
+	seenFrom := false
+	for _, instruction := range instructions {
+		fields := strings.Fields(instruction)
+		switch strings.ToUpper(fields[0]) {
+		case "ARG":
+			if seenFrom {
+				continue
+			}
+			for _, arg := range fields[1:] {
+				name, value := arg, ""
+				if i := strings.Index(arg, "="); i >= 0 {
+					name, value = arg[:i], strings.Trim(arg[i+1:], `"'`)
+				}
+				if v, ok := buildArgs[name]; ok {
+					value = v
+				}
+				args[name] = value
+			}
+		case "FROM":
+			seenFrom = true
+			fields = fields[1:]
+			for len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+				fields = fields[1:]
+			}
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("%s: invalid instruction: %s", path, instruction)
+			}
+			var missing []string
+			image := dockerfileVariable.ReplaceAllStringFunc(fields[0], func(ref string) string {
+				m := dockerfileVariable.FindStringSubmatch(ref)
+				name := m[1] + m[3]
+				if value := args[name]; value != "" {
+					return value
+				}
+				if m[2] == "" {
+					missing = append(missing, name)
+				}
+				return m[2]
+			})
+			if len(missing) > 0 {
+				return nil, fmt.Errorf("%s: no value for %s in: %s", path, strings.Join(missing, ", "), instruction)
+			}
+			if image != "scratch" && !stages[strings.ToLower(image)] {
+				images = append(images, image)
+			}
+			if len(fields) == 3 && strings.EqualFold(fields[1], "AS") {
+				stages[strings.ToLower(fields[2])] = true
+			}
+		}
+	}
+	return images, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// loadEnvironment reads the job environment from "path", which holds the
+// NUL-separated output of `env -0`. When "path" is empty the environment of
+// the current process is used instead.
+func loadEnvironment(path string) (map[string]string, error) {
+	var entries []string
+	if path == "" {
+		entries = os.Environ()
+	} else {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range bytes.Split(contents, []byte{0}) {
+			entries = append(entries, string(entry))
+		}
+	}
+	env := map[string]string{}
+	for _, entry := range entries {
+		i := strings.Index(entry, "=")
+		if i <= 0 {
+			continue
+		}
+		env[entry[:i]] = entry[i+1:]
+	}
+	return env, nil
+}
+
+// contextVariables are the job environment variables the build and agent
+// contexts are read from with --from_env.
+var contextVariables = []string{
+	"BUILDKITE_REPO",
+	"BUILDKITE_BUILD_URL",
+	"BUILDKITE_COMMIT",
+	"BUILDKITE_STEP_ID",
+	"BUILDKITE_AGENT_ID",
+	"BUILDKITE_AGENT_NAME",
+	"BUILDKITE_ORGANIZATION_SLUG",
+}
+
+// gitRef returns the Git ref a build of "branch" or "tag" is of: the tag
+// when the build is of one, as release policies are enforced on tags.
+func gitRef(branch, tag string) string {
+	switch {
+	case tag != "":
+		return "refs/tags/" + tag
+	case branch != "":
+		return "refs/heads/" + branch
+	}
+	return ""
+}
+
+// contextFromEnvironment returns the build and agent contexts of the job
+// whose environment is "env". The command is recorded as written.
+func contextFromEnvironment(env map[string]string) (provenance.AnyContext, error) {
+	for _, name := range contextVariables {
+		if env[name] == "" {
+			return provenance.AnyContext{}, fmt.Errorf("no value found for required environment variable: %s", name)
+		}
+	}
+	return provenance.AnyContext{
+		BuildContext: provenance.BuildContext{
+			Repository: env["BUILDKITE_REPO"],
+			BuildURL:   env["BUILDKITE_BUILD_URL"],
+			Commit:     env["BUILDKITE_COMMIT"],
+			StepID:     env["BUILDKITE_STEP_ID"],
+			Command:    env["BUILDKITE_COMMAND"],
+			Ref:        gitRef(env["BUILDKITE_BRANCH"], env["BUILDKITE_TAG"]),
+		},
+		AgentContext: provenance.AgentContext{
+			Name:         env["BUILDKITE_AGENT_NAME"],
+			ID:           env["BUILDKITE_AGENT_ID"],
+			Organization: env["BUILDKITE_ORGANIZATION_SLUG"],
+		},
+	}, nil
+}
+
+// agentHost fills in the host and pool of the agent in "agent" when not
+// given in its context: "hostOS" and "hostArch", and the queue and tags of
+// the agent from "env".
+func agentHost(agent *provenance.AgentContext, env map[string]string, hostOS, hostArch string) {
+	if agent.OS == "" {
+		agent.OS = hostOS
+	}
+	if agent.Arch == "" {
+		agent.Arch = hostArch
+	}
+	if agent.Tags == nil {
+		if tags := agentTags(env); len(tags) > 0 {
+			agent.Tags = tags
+		}
+	}
+	if agent.Queue == "" {
+		agent.Queue = agent.Tags["queue"]
+	}
+}
+
+// jobStartedOn returns the build start time to record: "flagValue" when
+// set, otherwise when the job whose environment is "env" started, as
+// exported in $BUILDKITE_JOB_STARTED_AT by the plugin's environment hook.
+// Reproducible provenance does not take it from the environment, which
+// differs between runs.
+func jobStartedOn(flagValue string, env map[string]string, reproducible bool) (string, error) {
+	value := flagValue
+	if value == "" && !reproducible {
+		value = env["BUILDKITE_JOB_STARTED_AT"]
+	}
+	if value == "" {
+		return "", nil
+	}
+	started, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return "", fmt.Errorf("[provided=%s] %s", value, err)
+	}
+	return started.UTC().Format(time.RFC3339), nil
+}
+
+// loadBaseline reads a baseline file listing one variable name per line.
+// Blank lines and lines starting with "#" are ignored, and a trailing "*"
+// matches any variable with the given prefix.
+func loadBaseline(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// matchName reports whether the variable "name" is matched by "pattern".
+func matchName(pattern, name string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(name, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == name
+}
+
+// diffEnvironment compares the variable names in "env" against the baseline
+// patterns. Patterns without a wildcard that match no variable are reported
+// as missing.
+func diffEnvironment(baseline string, patterns []string, env map[string]string) *provenance.EnvironmentDiff {
+	diff := &provenance.EnvironmentDiff{Baseline: baseline, Unexpected: []string{}, Missing: []string{}}
+	for name := range env {
+		expected := false
+		for _, pattern := range patterns {
+			if matchName(pattern, name) {
+				expected = true
+				break
+			}
+		}
+		if !expected {
+			diff.Unexpected = append(diff.Unexpected, name)
+		}
+	}
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") {
+			continue
+		}
+		if _, ok := env[pattern]; !ok {
+			diff.Missing = append(diff.Missing, pattern)
+		}
+	}
+	sort.Strings(diff.Unexpected)
+	sort.Strings(diff.Missing)
+	return diff
+}
+
+// DefaultCaptureInclude selects the variables recorded by --capture_env
+// when no include patterns are given.
+const DefaultCaptureInclude = "BUILDKITE_*"
+
+// secretVariable matches the names of variables that likely hold secrets,
+// which are never recorded.
+var secretVariable = regexp.MustCompile(`(?i)TOKEN|SECRET|KEY|PASSWORD|PASSWD|CREDENTIAL|PRIVATE`)
+
+// captureEnvironment returns the variables of "env" whose names match one
+// of the "include" patterns (e.g. "BUILDKITE_*"), without those matching
+// one of the "exclude" regular expressions or secretVariable.
+func captureEnvironment(env map[string]string, include, exclude []string) (map[string]string, error) {
+	if len(include) == 0 {
+		include = []string{DefaultCaptureInclude}
+	}
+	denied := []*regexp.Regexp{secretVariable}
+	for _, pattern := range exclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %s", pattern, err)
+		}
+		denied = append(denied, re)
+	}
+	captured := map[string]string{}
+	for name, value := range env {
+		included := false
+		for _, pattern := range include {
+			if matchName(pattern, name) {
+				included = true
+				break
+			}
+		}
+		for _, re := range denied {
+			if included && re.MatchString(name) {
+				included = false
+			}
+		}
+		if included {
+			captured[name] = value
+		}
+	}
+	return captured, nil
+}
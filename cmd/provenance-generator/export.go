@@ -0,0 +1,281 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+const ExportPredicateType = "https://buildkite.com/Attestations/AttestationExport@v1"
+
+// ExportIndexPath is the path of the signed index within an export archive.
+const ExportIndexPath = "index.json"
+
+// ExportStatement is an in-toto Statement whose subjects are the files of
+// an export archive, so the signed index makes the archive tamper-evident.
+type ExportStatement struct {
+	Type          string               `json:"_type"`
+	Subject       []provenance.Subject `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     AttestationExport    `json:"predicate"`
+}
+
+type AttestationExport struct {
+	Organization string                `json:"organization"`
+	Pipeline     string                `json:"pipeline"`
+	From         string                `json:"from"`
+	To           string                `json:"to"`
+	Source       string                `json:"source"`
+	ExportedAt   string                `json:"exportedAt"`
+	Attestations []ExportedAttestation `json:"attestations"`
+}
+
+// ExportedAttestation records where an archived attestation came from.
+type ExportedAttestation struct {
+	Path      string `json:"path"`
+	Build     int    `json:"build"`
+	BuildURL  string `json:"buildUrl,omitempty"`
+	JobID     string `json:"jobId,omitempty"`
+	CreatedAt string `json:"createdAt"`
+	Origin    string `json:"origin"`
+}
+
+// exportedFile is an attestation to be archived.
+type exportedFile struct {
+	ExportedAttestation
+	contents []byte
+}
+
+// parseExportTime accepts RFC 3339 timestamps and plain dates.
+func parseExportTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// collectBuildkiteAttestations downloads the attestation artifacts of the
+// builds of a pipeline created within the time range.
+func collectBuildkiteAttestations(client *buildkiteClient, org, pipeline string, from, to time.Time, patterns []string) ([]exportedFile, error) {
+	builds, err := client.builds(org, pipeline, from, to)
+	if err != nil {
+		return nil, err
+	}
+	var files []exportedFile
+	for _, build := range builds {
+		artifacts, err := client.artifacts(org, pipeline, strconv.Itoa(build.Number))
+		if err != nil {
+			return nil, err
+		}
+		for _, artifact := range artifacts {
+			if !matchAny(patterns, path.Base(artifact.Path)) {
+				continue
+			}
+			body, err := client.open(artifact.DownloadURL)
+			if err != nil {
+				return nil, err
+			}
+			contents, err := ioutil.ReadAll(body)
+			body.Close()
+			if err != nil {
+				return nil, err
+			}
+			sum := sha256.Sum256(contents)
+			if artifact.SHA256 != "" && artifact.SHA256 != hex.EncodeToString(sum[:]) {
+				return nil, fmt.Errorf("digest mismatch for artifact %s of build %d", artifact.Path, build.Number)
+			}
+			files = append(files, exportedFile{
+				ExportedAttestation: ExportedAttestation{
+					Path:      path.Join("attestations", strconv.Itoa(build.Number), artifact.JobID, artifact.Path),
+					Build:     build.Number,
+					BuildURL:  build.WebURL,
+					JobID:     artifact.JobID,
+					CreatedAt: build.CreatedAt,
+					Origin:    artifact.DownloadURL,
+				},
+				contents: contents,
+			})
+		}
+	}
+	return files, nil
+}
+
+// collectStoredAttestations reads the attestations written by the
+// collector server to "dir" that were modified within the time range.
+func collectStoredAttestations(dir, org, pipeline string, from, to time.Time) ([]exportedFile, error) {
+	root := filepath.Join(dir, org, pipeline)
+	var files []exportedFile
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if info.ModTime().Before(from) || info.ModTime().After(to) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		build, _ := strconv.Atoi(strings.SplitN(rel, "/", 2)[0])
+		contents, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files = append(files, exportedFile{
+			ExportedAttestation: ExportedAttestation{
+				Path:      path.Join("attestations", rel),
+				Build:     build,
+				JobID:     strings.TrimSuffix(path.Base(rel), ".provenance.json"),
+				CreatedAt: info.ModTime().UTC().Format(time.RFC3339),
+				Origin:    p,
+			},
+			contents: contents,
+		})
+		return nil
+	})
+	return files, err
+}
+
+// writeExportArchive writes the attestations and the index to a gzipped
+// tar archive at "dest" in a stable order.
+func writeExportArchive(dest string, files []exportedFile, index []byte, mtime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	files = append([]exportedFile{{ExportedAttestation: ExportedAttestation{Path: ExportIndexPath}, contents: index}}, files...)
+	for _, file := range files {
+		header := &tar.Header{Name: file.Path, Mode: 0644, Size: int64(len(file.contents)), ModTime: mtime, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(file.contents); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// exportCommand packages the attestations of a pipeline within a time
+// range into an archive with a signed index, for legal hold and audits.
+func exportCommand(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	org := fs.String("organization", os.Getenv("BUILDKITE_ORGANIZATION_SLUG"), "The slug of the Buildkite organization.")
+	pipeline := fs.String("pipeline", "", "The slug of the pipeline whose attestations are exported.")
+	fromFlag := fs.String("from", "", "The start of the time range, as an RFC 3339 timestamp or a date.")
+	toFlag := fs.String("to", "", "The end of the time range, as an RFC 3339 timestamp or a date; defaults to now.")
+	source := fs.String("source", "buildkite", "Where attestations are stored: 'buildkite' (build artifacts) or the output directory of a collector server.")
+	patterns := fs.String("attestation_patterns", "*.intoto.jsonl,*.att,*.attestation.json,provenance*.json", "Comma-separated artifact name patterns that identify attestations.")
+	output := fs.String("output_path", "attestation-export.tar.gz", "The path to which the archive is written.")
+	var keys arrayFlags
+	fs.Var(&keys, "sign_key", "A key used to sign the archive index; may be repeated.")
+	tsa := fs.String("tsa_url", "", "The URL of an RFC 3161 timestamp authority used to timestamp signatures.")
+	fs.Parse(args)
+	if *org == "" || *pipeline == "" || *fromFlag == "" {
+		fmt.Println("No value found for required flags: --organization, --pipeline and --from")
+		fs.Usage()
+		os.Exit(1)
+	}
+	from, err := parseExportTime(*fromFlag)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Invalid start of time range: [provided=%s]", *fromFlag))
+		os.Exit(1)
+	}
+	to := now()
+	if *toFlag != "" {
+		if to, err = parseExportTime(*toFlag); err != nil {
+			fmt.Println(fmt.Sprintf("Invalid end of time range: [provided=%s]", *toFlag))
+			os.Exit(1)
+		}
+	}
+
+	var files []exportedFile
+	if *source == "buildkite" {
+		token := os.Getenv("BUILDKITE_API_TOKEN")
+		if token == "" {
+			fmt.Println("No value found for required environment variable: BUILDKITE_API_TOKEN")
+			os.Exit(1)
+		}
+		files, err = collectBuildkiteAttestations(newBuildkiteClient(token), *org, *pipeline, from, to, strings.Split(*patterns, ","))
+	} else {
+		files, err = collectStoredAttestations(*source, *org, *pipeline, from, to)
+	}
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to collect attestations: %s", err))
+		os.Exit(1)
+	}
+	if len(files) == 0 {
+		fmt.Println("No attestations found in the time range")
+		os.Exit(1)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	exportedAt := now()
+	stmt := ExportStatement{
+		Type:          "https://in-toto.io/Statement/v0.1",
+		PredicateType: ExportPredicateType,
+		Predicate: AttestationExport{
+			Organization: *org,
+			Pipeline:     *pipeline,
+			From:         from.UTC().Format(time.RFC3339),
+			To:           to.UTC().Format(time.RFC3339),
+			Source:       *source,
+			ExportedAt:   exportedAt.UTC().Format(time.RFC3339),
+		},
+	}
+	for _, file := range files {
+		sum := sha256.Sum256(file.contents)
+		stmt.Subject = append(stmt.Subject, provenance.Subject{Name: file.Path, Digest: provenance.DigestSet{"sha256": hex.EncodeToString(sum[:])}})
+		stmt.Predicate.Attestations = append(stmt.Predicate.Attestations, file.ExportedAttestation)
+	}
+
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	indexPath, err := writeAttestation(stmt, stmt.Subject, outputOptions{
+		path:     filepath.Join(dir, ExportIndexPath),
+		signKeys: keys,
+		tsaURL:   *tsa,
+	})
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to write export index: %s", err))
+		os.Exit(1)
+	}
+	index, err := ioutil.ReadFile(indexPath)
+	if err != nil {
+		panic(err)
+	}
+	if err := writeExportArchive(*output, files, index, exportedAt); err != nil {
+		fmt.Println(fmt.Sprintf("Failed to write archive: %s", err))
+		os.Exit(1)
+	}
+	fmt.Println(fmt.Sprintf("Exported %d attestations to %s", len(files), *output))
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultGCSEndpoint = "https://storage.googleapis.com"
+	googleTokenURL     = "https://oauth2.googleapis.com/token"
+	googleMetadataURL  = "http://metadata.google.internal/computeMetadata/v1"
+	gcsScope           = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+// gcsPut uploads "body" to the object "key" in "bucket" with a multipart
+// upload carrying the object's metadata.
+func (u *Upload) gcsPut(bucket, key string, body []byte) error {
+	object := map[string]interface{}{"name": key, "contentType": "application/json"}
+	if metadata := u.metadata(); len(metadata) > 0 {
+		object["metadata"] = metadata
+	}
+	encoded, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, part := range []struct {
+		contentType string
+		body        []byte
+	}{{"application/json; charset=UTF-8", encoded}, {"application/json", body}} {
+		pw, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return err
+		}
+		pw.Write(part.body)
+	}
+	w.Close()
+
+	query := url.Values{"uploadType": {"multipart"}}
+	if u.KMSKey != "" {
+		query.Set("kmsKeyName", u.KMSKey)
+	}
+	endpoint := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", u.gcsEndpoint(), url.PathEscape(bucket), query.Encode())
+	req, err := http.NewRequest("POST", endpoint, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "multipart/related; boundary="+w.Boundary())
+	_, err = gcsSend(req)
+	return err
+}
+
+// gcsGet downloads the object "key" in "bucket".
+func (u *Upload) gcsGet(bucket, key string) ([]byte, error) {
+	endpoint := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", u.gcsEndpoint(), url.PathEscape(bucket), url.PathEscape(key))
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	return gcsSend(req)
+}
+
+func (u *Upload) gcsEndpoint() string {
+	if u.Endpoint != "" {
+		return strings.TrimRight(u.Endpoint, "/")
+	}
+	return DefaultGCSEndpoint
+}
+
+// gcsSend authorizes and performs "req" and returns the response body.
+func gcsSend(req *http.Request) ([]byte, error) {
+	token, err := googleAccessToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Google credentials: %s", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(contents)))
+	}
+	return contents, nil
+}
+
+// googleCredentialsFile is a credentials file as found by Application
+// Default Credentials.
+type googleCredentialsFile struct {
+	Type string `json:"type"`
+	// service_account
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+	// authorized_user
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// googleAccessToken returns an OAuth2 access token following Application
+// Default Credentials: the file named by $GOOGLE_APPLICATION_CREDENTIALS,
+// the gcloud user credentials file, then the metadata server of GCE and
+// GKE agents.
+func googleAccessToken() (string, error) {
+	file := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if file == "" {
+		config := os.Getenv("CLOUDSDK_CONFIG")
+		if config == "" {
+			home, _ := os.UserHomeDir()
+			config = filepath.Join(home, ".config", "gcloud")
+		}
+		if path := filepath.Join(config, "application_default_credentials.json"); fileExists(path) {
+			file = path
+		}
+	}
+	if file == "" {
+		return metadataAccessToken()
+	}
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	var creds googleCredentialsFile
+	if err := json.Unmarshal(contents, &creds); err != nil {
+		return "", fmt.Errorf("failed to parse %s: %s", file, err)
+	}
+	switch creds.Type {
+	case "service_account":
+		assertion, err := serviceAccountAssertion(creds)
+		if err != nil {
+			return "", err
+		}
+		return exchangeToken(creds.TokenURI, url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		})
+	case "authorized_user":
+		return exchangeToken(googleTokenURL, url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {creds.ClientID},
+			"client_secret": {creds.ClientSecret},
+			"refresh_token": {creds.RefreshToken},
+		})
+	}
+	return "", fmt.Errorf("unsupported credentials type %q in %s", creds.Type, file)
+}
+
+// serviceAccountAssertion returns a signed JWT asserting the service
+// account's identity, exchanged for an access token (RFC 7523).
+func serviceAccountAssertion(creds googleCredentialsFile) (string, error) {
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("service account private key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return "", err
+		}
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("service account private key is not an RSA key")
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = googleTokenURL
+	}
+	issued := time.Now()
+	segments := []interface{}{
+		map[string]string{"alg": "RS256", "typ": "JWT", "kid": creds.PrivateKeyID},
+		map[string]interface{}{
+			"iss":   creds.ClientEmail,
+			"scope": gcsScope,
+			"aud":   creds.TokenURI,
+			"iat":   issued.Unix(),
+			"exp":   issued.Add(time.Hour).Unix(),
+		},
+	}
+	var parts []string
+	for _, segment := range segments {
+		encoded, err := json.Marshal(segment)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, base64.RawURLEncoding.EncodeToString(encoded))
+	}
+	digest := sha256.Sum256([]byte(strings.Join(parts, ".")))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(append(parts, base64.RawURLEncoding.EncodeToString(signature)), "."), nil
+}
+
+// exchangeToken posts an OAuth2 token request and returns the access token.
+func exchangeToken(tokenURL string, form url.Values) (string, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.PostForm(tokenURL, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	return decodeAccessToken(resp)
+}
+
+// metadataAccessToken gets a token for the instance's service account.
+func metadataAccessToken() (string, error) {
+	req, err := http.NewRequest("GET", googleMetadataURL+"/instance/service-accounts/default/token?scopes="+url.QueryEscape(gcsScope), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("no credentials file and no metadata server: %s", err)
+	}
+	defer resp.Body.Close()
+	return decodeAccessToken(resp)
+}
+
+func decodeAccessToken(resp *http.Response) (string, error) {
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("%s: %s", resp.Request.URL, resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return "", fmt.Errorf("%s: %s %s %s", resp.Request.URL, resp.Status, token.Error, token.ErrorDescription)
+	}
+	return token.AccessToken, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
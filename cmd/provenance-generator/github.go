@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// GitHubAPI is the base URL of the GitHub REST API, replaced by
+// $GITHUB_API_URL for GitHub Enterprise Server.
+const GitHubAPI = "https://api.github.com"
+
+// gitHubAttestation is an attestation as listed by the GitHub Artifact
+// Attestations API.
+type gitHubAttestation struct {
+	Bundle SigstoreBundle `json:"bundle"`
+}
+
+// publishToGitHub submits "bundle" to the artifact attestations of the
+// GitHub repository "repo" ("owner/name") and returns the attestation ID.
+func publishToGitHub(repo string, bundle *SigstoreBundle) (int64, error) {
+	body, err := json.Marshal(map[string]interface{}{"bundle": bundle})
+	if err != nil {
+		return 0, err
+	}
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if err := gitHubDo("POST", "/repos/"+repo+"/attestations", body, http.StatusCreated, &created); err != nil {
+		return 0, err
+	}
+	return created.ID, nil
+}
+
+// gitHubAttestations lists the attestations of "repo" about the subject
+// with digest "digest" (e.g. "sha256:...").
+func gitHubAttestations(repo, digest string) ([]gitHubAttestation, error) {
+	var list struct {
+		Attestations []gitHubAttestation `json:"attestations"`
+	}
+	err := gitHubDo("GET", "/repos/"+repo+"/attestations/"+digest+"?per_page=100", nil, http.StatusOK, &list)
+	return list.Attestations, err
+}
+
+// gitHubError is a response of the GitHub API with an unexpected status.
+type gitHubError struct {
+	StatusCode int
+	message    string
+}
+
+func (e *gitHubError) Error() string {
+	return e.message
+}
+
+func gitHubDo(method, path string, body []byte, status int, v interface{}) error {
+	token := os.Getenv("GITHUB_TOKEN")
+	if token == "" {
+		token = os.Getenv("GH_TOKEN")
+	}
+	if token == "" {
+		return fmt.Errorf("no GitHub token: set GITHUB_TOKEN or GH_TOKEN")
+	}
+	base := GitHubAPI
+	if api := os.Getenv("GITHUB_API_URL"); api != "" {
+		base = strings.TrimRight(api, "/")
+	}
+	req, err := http.NewRequest(method, base+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != status {
+		return &gitHubError{StatusCode: resp.StatusCode, message: fmt.Sprintf("%s %s: %s %s", method, path, resp.Status, strings.TrimSpace(string(contents)))}
+	}
+	return json.Unmarshal(contents, v)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// BuildkiteGraphQLAPI is the endpoint of the Buildkite GraphQL API.
+const BuildkiteGraphQLAPI = "https://graphql.buildkite.com/v1"
+
+// jobQuery fetches the step configuration and timing of a command job.
+const jobQuery = `query($uuid: ID!) {
+  job(uuid: $uuid) {
+    ... on JobTypeCommand {
+      label
+      command
+      agentQueryRules
+      scheduledAt
+      runnableAt
+      startedAt
+    }
+  }
+}`
+
+// StepArguments identify the step a job ran and its commands, recorded as
+// the arguments of the recipe.
+type StepArguments struct {
+	Key   string `json:"key,omitempty"`
+	Label string `json:"label,omitempty"`
+	// Commands are the lines of the script the agent runs, in order.
+	Commands []string `json:"commands"`
+}
+
+// stepArguments returns the arguments of the step whose job environment
+// is "env" and that runs "command".
+func stepArguments(command string, env map[string]string) StepArguments {
+	commands := []string{}
+	for _, line := range strings.Split(strings.Replace(command, "\r\n", "\n", -1), "\n") {
+		if strings.TrimSpace(line) != "" {
+			commands = append(commands, line)
+		}
+	}
+	return StepArguments{Key: env["BUILDKITE_STEP_KEY"], Label: env["BUILDKITE_LABEL"], Commands: commands}
+}
+
+// JobDetails is the configuration of the step a job ran and when it ran,
+// recorded as the arguments of the recipe.
+type JobDetails struct {
+	StepArguments
+	Command string   `json:"command"`
+	Agents  []string `json:"agents"`
+	// Plugins are the plugins of the step and their configuration, as in
+	// $BUILDKITE_PLUGINS.
+	Plugins     json.RawMessage `json:"plugins,omitempty"`
+	ScheduledAt string          `json:"scheduledAt,omitempty"`
+	RunnableAt  string          `json:"runnableAt,omitempty"`
+	StartedAt   string          `json:"startedAt,omitempty"`
+}
+
+// graphql runs "query" with "variables" against the GraphQL API and decodes
+// the data of the response into "v".
+func (c *buildkiteClient) graphql(query string, variables map[string]interface{}, v interface{}) error {
+	var response struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := c.post(BuildkiteGraphQLAPI, map[string]interface{}{"query": query, "variables": variables}, &response); err != nil {
+		return err
+	}
+	if len(response.Errors) > 0 {
+		var messages []string
+		for _, e := range response.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("GraphQL query failed: %s", strings.Join(messages, "; "))
+	}
+	return json.Unmarshal(response.Data, v)
+}
+
+// jobDetails fetches the step configuration and timing of the job whose
+// environment is "env".
+func jobDetails(client *buildkiteClient, env map[string]string) (*JobDetails, error) {
+	id := env["BUILDKITE_JOB_ID"]
+	if id == "" {
+		return nil, fmt.Errorf("no value found for required environment variable: BUILDKITE_JOB_ID")
+	}
+	var data struct {
+		Job *struct {
+			Label           string   `json:"label"`
+			Command         string   `json:"command"`
+			AgentQueryRules []string `json:"agentQueryRules"`
+			ScheduledAt     string   `json:"scheduledAt"`
+			RunnableAt      string   `json:"runnableAt"`
+			StartedAt       string   `json:"startedAt"`
+		} `json:"job"`
+	}
+	if err := client.graphql(jobQuery, map[string]interface{}{"uuid": id}, &data); err != nil {
+		return nil, err
+	}
+	job := data.Job
+	if job == nil || job.Command == "" && job.Label == "" {
+		return nil, fmt.Errorf("no command job %s found", id)
+	}
+	details := &JobDetails{
+		StepArguments: stepArguments(job.Command, env),
+		Command:       job.Command,
+		Agents:        job.AgentQueryRules,
+		ScheduledAt:   job.ScheduledAt,
+		RunnableAt:    job.RunnableAt,
+		StartedAt:     job.StartedAt,
+	}
+	if job.Label != "" {
+		details.Label = job.Label
+	}
+	if details.Agents == nil {
+		details.Agents = []string{}
+	}
+	if plugins := env["BUILDKITE_PLUGINS"]; plugins != "" && json.Valid([]byte(plugins)) {
+		details.Plugins = json.RawMessage(plugins)
+	}
+	return details, nil
+}
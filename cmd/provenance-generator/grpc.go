@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// The protobuf wire format and gRPC framing, limited to what the messages
+// of proto/provenance/v1/generator.proto need: strings, bytes, string maps
+// and embedded messages.
+
+const (
+	wireVarint = 0
+	wire64     = 1
+	wireBytes  = 2
+	wire32     = 5
+)
+
+// protoMessage is a message of generator.proto in its binary encoding.
+type protoMessage interface {
+	marshalProto() []byte
+	unmarshalProto(b []byte) error
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// appendBytesField appends a length-delimited field, leaving out empty
+// values as proto3 does.
+func appendBytesField(b []byte, field int, value []byte) []byte {
+	if len(value) == 0 {
+		return b
+	}
+	b = appendVarint(b, uint64(field)<<3|wireBytes)
+	b = appendVarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func appendStringField(b []byte, field int, value string) []byte {
+	return appendBytesField(b, field, []byte(value))
+}
+
+// appendMessageField appends an embedded message, which unlike a string is
+// kept when empty so that it is present on the wire.
+func appendMessageField(b []byte, field int, value []byte) []byte {
+	b = appendVarint(b, uint64(field)<<3|wireBytes)
+	b = appendVarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// appendMapField appends a map<string, string> as repeated entry messages.
+func appendMapField(b []byte, field int, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var entry []byte
+		entry = appendStringField(entry, 1, key)
+		entry = appendStringField(entry, 2, m[key])
+		b = appendMessageField(b, field, entry)
+	}
+	return b
+}
+
+func consumeVarint(b []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * uint(i))
+		if b[i] < 0x80 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid protobuf varint")
+}
+
+// rangeFields calls "fn" with the number and value of each length-delimited
+// field of the message "b", skipping fields of other wire types.
+func rangeFields(b []byte, fn func(field int, value []byte) error) error {
+	for len(b) > 0 {
+		tag, n, err := consumeVarint(b)
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+		field, wireType := int(tag>>3), tag&7
+		switch wireType {
+		case wireVarint:
+			if _, n, err = consumeVarint(b); err != nil {
+				return err
+			}
+		case wire64:
+			n = 8
+		case wire32:
+			n = 4
+		case wireBytes:
+			size, m, err := consumeVarint(b)
+			if err != nil {
+				return err
+			}
+			if size > uint64(len(b)-m) {
+				return fmt.Errorf("truncated protobuf field %d", field)
+			}
+			if err := fn(field, b[m:m+int(size)]); err != nil {
+				return err
+			}
+			n = m + int(size)
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", wireType)
+		}
+		if n > len(b) {
+			return fmt.Errorf("truncated protobuf field %d", field)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// addMapEntry decodes a map<string, string> entry into "m".
+func addMapEntry(m map[string]string, entry []byte) error {
+	var key, value string
+	err := rangeFields(entry, func(field int, v []byte) error {
+		switch field {
+		case 1:
+			key = string(v)
+		case 2:
+			value = string(v)
+		}
+		return nil
+	})
+	m[key] = value
+	return err
+}
+
+// grpcCodes maps the Connect error codes of apiError to gRPC status codes.
+var grpcCodes = map[string]int{
+	"invalid_argument":    3,
+	"failed_precondition": 9,
+	"unimplemented":       12,
+	"internal":            13,
+	"unavailable":         14,
+	"unauthenticated":     16,
+}
+
+// decodeGRPC reads the single length-prefixed protobuf message of a unary
+// gRPC call into "req".
+func decodeGRPC(r *http.Request, req protoMessage) *apiError {
+	if r.ProtoMajor != 2 {
+		return &apiError{Code: "unimplemented", Message: "gRPC requires HTTP/2"}
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return invalidArgument(err)
+	}
+	if len(body) < 5 || binary.BigEndian.Uint32(body[1:5]) != uint32(len(body)-5) {
+		return invalidArgument(fmt.Errorf("the request must hold exactly one gRPC message"))
+	}
+	if body[0] != 0 {
+		return &apiError{Code: "unimplemented", Message: "compressed messages are not supported"}
+	}
+	if err := req.unmarshalProto(body[5:]); err != nil {
+		return invalidArgument(err)
+	}
+	return nil
+}
+
+// writeGRPC writes the response of a unary gRPC call, with its status in
+// the grpc-status and grpc-message trailers.
+func writeGRPC(w http.ResponseWriter, resp protoMessage, apiErr *apiError) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+	if apiErr != nil {
+		w.Header().Set("Grpc-Status", strconv.Itoa(grpcCodes[apiErr.Code]))
+		w.Header().Set("Grpc-Message", url.PathEscape(apiErr.Message))
+		return
+	}
+	message := resp.marshalProto()
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	w.Write(append(frame, message...))
+	w.Header().Set("Grpc-Status", "0")
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// HelmChartMediaType is the media type of packaged Helm charts, as of their
+// layer in OCI registries.
+const HelmChartMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+
+// helmChart is a packaged Helm chart and, once pushed to an OCI registry,
+// the manifest it was pushed as.
+type helmChart struct {
+	file    string
+	name    string
+	version string
+	ref     *ImageRef
+	digest  string
+}
+
+// readHelmChart reads the name and version of the packaged chart at "file"
+// from the Chart.yaml at the root of the chart.
+func readHelmChart(file string) (helmChart, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return helmChart{}, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return helmChart{}, fmt.Errorf("%s is not a packaged chart: %s", file, err)
+	}
+	r := tar.NewReader(gz)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return helmChart{}, fmt.Errorf("%s is not a packaged chart: no Chart.yaml", file)
+		} else if err != nil {
+			return helmChart{}, fmt.Errorf("%s is not a packaged chart: %s", file, err)
+		}
+		name := strings.TrimPrefix(header.Name, "./")
+		if strings.Count(name, "/") != 1 || path.Base(name) != "Chart.yaml" {
+			continue
+		}
+		contents, err := ioutil.ReadAll(io.LimitReader(r, maxLicenseScan))
+		if err != nil {
+			return helmChart{}, err
+		}
+		chart := helmChart{file: file, name: chartYAMLValue(contents, "name"), version: chartYAMLValue(contents, "version")}
+		if chart.name == "" || chart.version == "" {
+			return helmChart{}, fmt.Errorf("the Chart.yaml of %s has no name or version", file)
+		}
+		return chart, nil
+	}
+}
+
+// chartYAMLValue returns the top-level scalar "key" of the Chart.yaml in
+// "contents", unquoted. Charts keep their name and version on one line, so
+// this avoids a YAML parser.
+func chartYAMLValue(contents []byte, key string) string {
+	for _, line := range strings.Split(string(contents), "\n") {
+		if !strings.HasPrefix(line, key+":") {
+			continue
+		}
+		value := strings.TrimSpace(strings.TrimPrefix(line, key+":"))
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		return strings.Trim(value, `"'`)
+	}
+	return ""
+}
+
+// subject returns the subject of the packaged chart, named as `helm
+// package` names it (e.g. "app-1.2.0.tgz") and annotated with the name and
+// version of the chart.
+func (c helmChart) subject(algorithms []string) (provenance.Subject, error) {
+	digest, err := provenance.DigestFile(c.file, algorithms)
+	if err != nil {
+		return provenance.Subject{}, err
+	}
+	return provenance.Subject{
+		Name:        c.name + "-" + c.version + ".tgz",
+		Digest:      digest,
+		MediaType:   HelmChartMediaType,
+		Annotations: c.annotations(),
+		File:        c.file,
+	}, nil
+}
+
+func (c helmChart) annotations() map[string]string {
+	return map[string]string{"chart": c.name, "version": c.version}
+}
+
+// ociRef returns the reference `helm push` pushes the chart to under
+// "registry" (e.g. "oci://ghcr.io/acme/charts"). OCI tags cannot hold "+",
+// so helm replaces it with "_" in versions.
+func (c helmChart) ociRef(registry string) (ImageRef, error) {
+	base := strings.TrimSuffix(strings.TrimPrefix(registry, "oci://"), "/")
+	return ParseImageRef(base + "/" + c.name + ":" + strings.Replace(c.version, "+", "_", -1))
+}
+
+// annotateHelmChart marks the subject of "subjects" digested from the file
+// of "chart", if any, as the chart and reports whether there was one.
+func annotateHelmChart(subjects []provenance.Subject, chart helmChart) bool {
+	abs, _ := filepath.Abs(chart.file)
+	for i := range subjects {
+		if subjects[i].File == "" || subjects[i].Archive != "" {
+			continue
+		}
+		if file, _ := filepath.Abs(subjects[i].File); file == abs {
+			subjects[i].MediaType = HelmChartMediaType
+			if subjects[i].Annotations == nil {
+				subjects[i].Annotations = map[string]string{}
+			}
+			for key, value := range chart.annotations() {
+				subjects[i].Annotations[key] = value
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// subjects returns the subjects of "all" that are the chart: its package
+// and the manifest it was pushed as.
+func (c helmChart) subjects(all []provenance.Subject) []provenance.Subject {
+	abs, _ := filepath.Abs(c.file)
+	var subjects []provenance.Subject
+	for _, subject := range all {
+		if subject.Image != "" && c.ref != nil {
+			// Image was made with ImageRef.String, so it parses.
+			image, _ := ParseImageRef(subject.Image)
+			if image.Registry == c.ref.Registry && image.Repository == c.ref.Repository && "sha256:"+subject.Digest["sha256"] == c.digest {
+				subjects = append(subjects, subject)
+			}
+			continue
+		}
+		if file, _ := filepath.Abs(subject.File); subject.File != "" && subject.Archive == "" && file == abs {
+			subjects = append(subjects, subject)
+		}
+	}
+	return subjects
+}
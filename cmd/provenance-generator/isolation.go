@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// DockerPluginRepository is the repository of the docker plugin, as in the
+// URIs of plugin materials.
+const DockerPluginRepository = "git+https://github.com/buildkite-plugins/docker-buildkite-plugin"
+
+// detectIsolation returns whether the job whose environment is "env" was
+// hermetic, as its command ran under the docker plugin without a network,
+// and isolated from other jobs, as it ran on an ephemeral agent: one that
+// disconnects after the job or is tagged "ephemeral=true". "reasons"
+// explain what was detected.
+func detectIsolation(env map[string]string) (hermetic, isolated bool, reasons []string) {
+	var plugins []map[string]json.RawMessage
+	json.Unmarshal([]byte(env["BUILDKITE_PLUGINS"]), &plugins)
+	for _, plugin := range plugins {
+		for label, config := range plugin {
+			material, err := pluginMaterial(label, "")
+			if err != nil || strings.SplitN(material.URI, "@", 2)[0] != DockerPluginRepository {
+				continue
+			}
+			var options struct {
+				Network string `json:"network"`
+			}
+			if json.Unmarshal(config, &options) == nil && options.Network == "none" {
+				hermetic = true
+				reasons = append(reasons, "the command ran under the docker plugin with network: none")
+			}
+		}
+	}
+	if env["BUILDKITE_AGENT_DISCONNECT_AFTER_JOB"] == "true" {
+		isolated = true
+		reasons = append(reasons, "the agent disconnects after the job")
+	} else if agentTags(env)["ephemeral"] == "true" {
+		isolated = true
+		reasons = append(reasons, "the agent is tagged ephemeral=true")
+	}
+	return hermetic, isolated, reasons
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// jobLogURI returns the URI recording the log of the job whose environment
+// is "env": its anchor on the page of the build.
+func jobLogURI(env map[string]string) (string, error) {
+	if env["BUILDKITE_BUILD_URL"] == "" || env["BUILDKITE_JOB_ID"] == "" {
+		return "", fmt.Errorf("no value found for required environment variables: BUILDKITE_BUILD_URL and BUILDKITE_JOB_ID")
+	}
+	return env["BUILDKITE_BUILD_URL"] + "#" + env["BUILDKITE_JOB_ID"], nil
+}
+
+// jobLogByproduct returns the byproduct recording the log of the job whose
+// environment is "env": the file at "path", when given, digested with
+// "algorithms", or otherwise the log fetched from the Buildkite REST API
+// with "client", digested with sha256.
+func jobLogByproduct(client *buildkiteClient, env map[string]string, path string, algorithms []string) (provenance.ResourceDescriptor, error) {
+	uri, err := jobLogURI(env)
+	if err != nil {
+		return provenance.ResourceDescriptor{}, err
+	}
+	if path != "" {
+		digest, err := provenance.DigestFile(path, algorithms)
+		if err != nil {
+			return provenance.ResourceDescriptor{}, err
+		}
+		return provenance.ResourceDescriptor{URI: uri, Digest: digest}, nil
+	}
+	org, pipeline, number := env["BUILDKITE_ORGANIZATION_SLUG"], env["BUILDKITE_PIPELINE_SLUG"], env["BUILDKITE_BUILD_NUMBER"]
+	if org == "" || pipeline == "" || number == "" {
+		return provenance.ResourceDescriptor{}, fmt.Errorf("no value found for required environment variables: BUILDKITE_ORGANIZATION_SLUG, BUILDKITE_PIPELINE_SLUG and BUILDKITE_BUILD_NUMBER")
+	}
+	digest, err := client.sha256(buildURL(org, pipeline, number) + "/jobs/" + env["BUILDKITE_JOB_ID"] + "/log.txt")
+	if err != nil {
+		return provenance.ResourceDescriptor{}, err
+	}
+	return provenance.ResourceDescriptor{URI: uri, Digest: provenance.DigestSet{"sha256": digest}}, nil
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+const LicensesPredicateType = "https://buildkite.com/Attestations/SubjectLicenses@v1"
+
+// LicenseNoAssertion is recorded for subjects without a detected license,
+// as in SPDX.
+const LicenseNoAssertion = "NOASSERTION"
+
+// maxLicenseScan bounds how much of a file or archive entry is read when
+// detecting licenses.
+const maxLicenseScan = 1 << 20
+
+// LicensesStatement is a companion attestation recording the license of
+// each subject for release compliance.
+type LicensesStatement struct {
+	Type          string               `json:"_type"`
+	Subject       []provenance.Subject `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     SubjectLicenses      `json:"predicate"`
+}
+
+type SubjectLicenses struct {
+	Subjects []SubjectLicense `json:"subjects"`
+}
+
+// SubjectLicense is the SPDX license expression of a subject and the
+// evidence it was derived from.
+type SubjectLicense struct {
+	Name     string            `json:"name"`
+	License  string            `json:"license"`
+	Evidence []LicenseEvidence `json:"evidence,omitempty"`
+}
+
+// LicenseEvidence is a license found in a subject, or in a file of an
+// archive subject.
+type LicenseEvidence struct {
+	Path    string `json:"path,omitempty"`
+	License string `json:"license"`
+	// Method is "mapping", "license-text", "spdx-identifier" or
+	// "package-metadata".
+	Method string `json:"method"`
+}
+
+// licenseTexts identifies license files by phrases of their text, after
+// whitespace is collapsed and case folded. More specific licenses come
+// first.
+var licenseTexts = []struct {
+	id      string
+	phrases []string
+}{
+	{"AGPL-3.0-only", []string{"gnu affero general public license", "version 3, 19 november 2007"}},
+	{"LGPL-3.0-only", []string{"gnu lesser general public license", "version 3, 29 june 2007"}},
+	{"LGPL-2.1-only", []string{"gnu lesser general public license", "version 2.1, february 1999"}},
+	{"GPL-3.0-only", []string{"gnu general public license", "version 3, 29 june 2007"}},
+	{"GPL-2.0-only", []string{"gnu general public license", "version 2, june 1991"}},
+	{"Apache-2.0", []string{"apache license", "version 2.0, january 2004"}},
+	{"MPL-2.0", []string{"mozilla public license", "2.0"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "neither the name of"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms"}},
+	{"MIT", []string{"permission is hereby granted, free of charge, to any person obtaining a copy"}},
+	{"ISC", []string{"permission to use, copy, modify, and/or distribute this software for any purpose with or without fee is hereby granted"}},
+	{"Unlicense", []string{"this is free and unencumbered software released into the public domain"}},
+}
+
+var (
+	licenseFileName = regexp.MustCompile(`(?i)^(licen[cs]e|copying)([.-].*)?$`)
+	spdxIdentifier  = regexp.MustCompile(`SPDX-License-Identifier:\s*([A-Za-z0-9.+\-() ]+?)\s*(\*/|-->|$)`)
+	metadataLicense = regexp.MustCompile(`(?m)^License-Expression:\s*(\S.*?)\s*$`)
+)
+
+// loadLicenseMapping reads a JSON object mapping subject names, or glob
+// patterns of them, to SPDX license expressions.
+func loadLicenseMapping(file string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	mapping := map[string]string{}
+	if err := json.Unmarshal(contents, &mapping); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", file, err)
+	}
+	for pattern := range mapping {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in %s", pattern, file)
+		}
+	}
+	return mapping, nil
+}
+
+// mappedLicense returns the license "mapping" gives "name": an exact entry,
+// else the longest matching pattern.
+func mappedLicense(mapping map[string]string, name string) (string, bool) {
+	if license, ok := mapping[name]; ok {
+		return license, true
+	}
+	best := ""
+	for pattern := range mapping {
+		if ok, _ := path.Match(pattern, name); ok && (len(pattern) > len(best) || (len(pattern) == len(best) && pattern < best)) {
+			best = pattern
+		}
+	}
+	if best == "" {
+		return "", false
+	}
+	return mapping[best], true
+}
+
+// subjectLicenses determines the license of every subject. Subjects in
+// "mapping" take its license; the files of others are scanned when "detect"
+// is set.
+func subjectLicenses(subjects []provenance.Subject, mapping map[string]string, detect bool) ([]SubjectLicense, error) {
+	var licenses []SubjectLicense
+	for _, subject := range subjects {
+		result := SubjectLicense{Name: subject.Name, License: LicenseNoAssertion}
+		if license, ok := mappedLicense(mapping, subject.Name); ok {
+			result.License = license
+			result.Evidence = []LicenseEvidence{{License: license, Method: "mapping"}}
+		} else if subject.File != "" && detect {
+			evidence, err := scanLicenses(subject.File)
+			if err != nil {
+				return nil, fmt.Errorf("failed to detect license of %s: %s", subject.Name, err)
+			}
+			result.Evidence = uniqueEvidence(evidence)
+			if expression := licenseExpression(evidence); expression != "" {
+				result.License = expression
+			}
+		}
+		licenses = append(licenses, result)
+	}
+	return licenses, nil
+}
+
+// uniqueEvidence keeps the first evidence of each license and method, so
+// archives with a tag in every file do not list every file.
+func uniqueEvidence(evidence []LicenseEvidence) []LicenseEvidence {
+	seen := map[[2]string]bool{}
+	var unique []LicenseEvidence
+	for _, e := range evidence {
+		if key := [2]string{e.License, e.Method}; !seen[key] {
+			seen[key] = true
+			unique = append(unique, e)
+		}
+	}
+	return unique
+}
+
+// licenseExpression combines the distinct licenses of "evidence".
+func licenseExpression(evidence []LicenseEvidence) string {
+	seen := map[string]bool{}
+	var ids []string
+	for _, e := range evidence {
+		if !seen[e.License] {
+			seen[e.License] = true
+			ids = append(ids, e.License)
+		}
+	}
+	sort.Strings(ids)
+	if len(ids) > 1 {
+		for i, id := range ids {
+			if strings.Contains(id, " ") {
+				ids[i] = "(" + id + ")"
+			}
+		}
+	}
+	return strings.Join(ids, " AND ")
+}
+
+// scanLicenses scans the file at "file" or, for tar, tar.gz and zip
+// archives (including wheels and jars), the files it contains.
+func scanLicenses(file string) ([]LicenseEvidence, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	name := strings.ToLower(file)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		return scanTar(tar.NewReader(gz))
+	case strings.HasSuffix(name, ".tar"):
+		return scanTar(tar.NewReader(f))
+	case strings.HasSuffix(name, ".zip") || strings.HasSuffix(name, ".whl") || strings.HasSuffix(name, ".jar"):
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		r, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			return nil, err
+		}
+		var evidence []LicenseEvidence
+		for _, entry := range r.File {
+			if entry.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := entry.Open()
+			if err != nil {
+				return nil, err
+			}
+			found, err := scanLicenseFile(entry.Name, rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+			evidence = append(evidence, found...)
+		}
+		return evidence, nil
+	}
+	return scanLicenseFile(filepath.Base(file), f)
+}
+
+func scanTar(r *tar.Reader) ([]LicenseEvidence, error) {
+	var evidence []LicenseEvidence
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return evidence, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		found, err := scanLicenseFile(header.Name, r)
+		if err != nil {
+			return nil, err
+		}
+		evidence = append(evidence, found...)
+	}
+}
+
+// scanLicenseFile looks for licenses in the file "name": the text of
+// license files, SPDX-License-Identifier tags and the license declared by
+// package.json or Python package metadata.
+func scanLicenseFile(name string, r io.Reader) ([]LicenseEvidence, error) {
+	contents, err := ioutil.ReadAll(io.LimitReader(r, maxLicenseScan))
+	if err != nil {
+		return nil, err
+	}
+	base := path.Base(name)
+	var evidence []LicenseEvidence
+	if licenseFileName.MatchString(base) {
+		text := strings.ToLower(strings.Join(strings.Fields(string(contents)), " "))
+		for _, license := range licenseTexts {
+			matched := true
+			for _, phrase := range license.phrases {
+				matched = matched && strings.Contains(text, phrase)
+			}
+			if matched {
+				evidence = append(evidence, LicenseEvidence{Path: name, License: license.id, Method: "license-text"})
+				break
+			}
+		}
+	}
+	switch base {
+	case "package.json":
+		var pkg struct {
+			License interface{} `json:"license"`
+		}
+		if json.Unmarshal(contents, &pkg) == nil {
+			if license, ok := pkg.License.(string); ok && license != "" {
+				evidence = append(evidence, LicenseEvidence{Path: name, License: license, Method: "package-metadata"})
+			}
+		}
+	case "METADATA", "PKG-INFO":
+		if m := metadataLicense.FindSubmatch(contents); m != nil {
+			evidence = append(evidence, LicenseEvidence{Path: name, License: string(m[1]), Method: "package-metadata"})
+		}
+	}
+	if bytes.Contains(contents, []byte("SPDX-License-Identifier:")) {
+		seen := map[string]bool{}
+		scanner := bufio.NewScanner(bytes.NewReader(contents))
+		scanner.Buffer(make([]byte, 64*1024), maxLicenseScan)
+		for scanner.Scan() {
+			if m := spdxIdentifier.FindStringSubmatch(scanner.Text()); m != nil && !seen[m[1]] {
+				seen[m[1]] = true
+				evidence = append(evidence, LicenseEvidence{Path: name, License: m[1], Method: "spdx-identifier"})
+			}
+		}
+	}
+	return evidence, nil
+}
+
+// newLicensesStatement returns the companion attestation of "licenses"
+// about "subjects".
+func newLicensesStatement(subjects []provenance.Subject, licenses []SubjectLicense) LicensesStatement {
+	return LicensesStatement{
+		Type:          "https://in-toto.io/Statement/v0.1",
+		Subject:       subjects,
+		PredicateType: LicensesPredicateType,
+		Predicate:     SubjectLicenses{Subjects: licenses},
+	}
+}
@@ -0,0 +1,1423 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/sign"
+)
+
+type arrayFlags []string
+
+func (i *arrayFlags) String() string {
+	return ""
+}
+
+func (i *arrayFlags) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+var (
+	artifactPath       arrayFlags
+	trustedBuilders    arrayFlags
+	signKeys           arrayFlags
+	imageMaterials     arrayFlags
+	digestAlgs         arrayFlags
+	imageRefs          arrayFlags
+	subjectFlags       arrayFlags
+	checksumFiles      arrayFlags
+	purls              arrayFlags
+	urlSubjects        arrayFlags
+	sbomFiles          arrayFlags
+	testReports        arrayFlags
+	dockerfiles        arrayFlags
+	byproductPaths     arrayFlags
+	captureInclude     arrayFlags
+	captureExclude     arrayFlags
+	dockerBuildArgs    arrayFlags
+	monorepoMap        arrayFlags
+	monorepoCommits    arrayFlags
+	helmChartPaths     arrayFlags
+	outputPath         = flag.String("output_path", "provenance.json", "The path to which the generated provenance should be written, or '-' for standard output.")
+	buildContext       = flag.String("build_context", "", "The '${build}' context value, or '-' to read it from standard input.")
+	agentContext       = flag.String("agent_context", "", "The '${agent}' context value, or '-' to read it from standard input.")
+	jobEnvFile         = flag.String("job_env_file", "", "The path to a file holding the job environment as written by 'env -0'.")
+	buildContextFile   = flag.String("build_context_file", "", "The path of a file holding the '${build}' context value, instead of --build_context.")
+	agentContextFile   = flag.String("agent_context_file", "", "The path of a file holding the '${agent}' context value, instead of --agent_context.")
+	apiMetadata        = flag.Bool("buildkite_api_metadata", false, "Record when the build started, its pipeline, number, creator and job state from the Buildkite REST API; requires BUILDKITE_API_TOKEN.")
+	jobLog             = flag.Bool("job_log", false, "Record the digest of the log of the job, fetched from the Buildkite REST API or read from --job_log_path, as a byproduct; the API requires BUILDKITE_API_TOKEN.")
+	jobLogPath         = flag.String("job_log_path", "", "The path of the log of the job to record with --job_log instead of fetching it.")
+	graphqlJob         = flag.Bool("buildkite_graphql", false, "Record the step configuration, plugins and timing of the job from the Buildkite GraphQL API as the recipe arguments; requires BUILDKITE_API_TOKEN.")
+	strictContext      = flag.Bool("strict_context", false, "Fail on unknown or missing required fields in --build_context and --agent_context.")
+	fromEnv            = flag.Bool("from_env", false, "Read the build and agent contexts from the Buildkite variables of the job environment instead of --build_context and --agent_context.")
+	envBaseline        = flag.String("env_baseline", "", "The path to a file listing the environment variables expected for the pipeline.")
+	agentOS            = flag.String("agent_os", "", "The operating system of the agent's host (e.g. 'linux'), recorded in the agent context.")
+	agentArch          = flag.String("agent_arch", "", "The architecture of the agent's host (e.g. 'x86_64'), recorded in the agent context.")
+	captureEnv         = flag.Bool("capture_env", false, "Record the job environment variables matching --capture_env_include, except likely secrets, as the recipe environment.")
+	envEnforce         = flag.Bool("env_baseline_enforce", false, "Fail when the job environment contains variables missing from the baseline.")
+	platform           = flag.String("platform", "", "The platform (e.g. 'linux/arm64') every subject was built for.")
+	normalize          = flag.Bool("normalize_platforms", false, "Detect each subject's platform from its name and annotate it with its logical name.")
+	presetName         = flag.String("preset", "", "The output convention to follow: 'slsa-github-style', 'cosign-style' or 'witness-style'.")
+	registryLimit      = flag.Int("registry_concurrency", 4, "The maximum number of concurrent registry requests when resolving images.")
+	namePrefix         = flag.String("subject_name_prefix", "", "A prefix (e.g. 'releases/v1.2.0/') of the names of the subjects found under --artifact_path.")
+	nameTemplate       = flag.String("subject_name_template", "{relpath}", "The name of the subjects found under --artifact_path, from '{root}', '{relpath}', '{dirname}' and '{basename}'.")
+	purlNames          = flag.Bool("purl_names", false, "Name subjects after the package URLs detected from their package metadata or image.")
+	maxSubjects        = flag.Int("max_subjects", 0, "Fail, or apply --subject_overflow, when --artifact_path holds more files than this; 0 is unlimited.")
+	warnSubjects       = flag.Int("warn_subjects", 10000, "Warn when the provenance has more subjects than this; 0 disables the warning.")
+	subjectOverflow    = flag.String("subject_overflow", provenance.SubjectOverflowFail, "What to do with more files than --max_subjects: 'fail' or 'dirhash' (one directory digest subject per --artifact_path).")
+	hermeticBuild      = flag.Bool("hermetic", false, "Record that the build declared all its inputs and ran without network access; requires complete materials.")
+	isolatedBuild      = flag.Bool("isolated", false, "Record that the build ran isolated from other builds, e.g. on an ephemeral agent.")
+	detectIsolated     = flag.Bool("detect_isolation", false, "Set --hermetic when the command ran under the docker plugin with 'network: none', and --isolated on ephemeral agents.")
+	builderIDFlag      = flag.String("builder_id", "", "The builder ID (a URI) to record instead of the agent's, e.g. of a trusted builder wrapping it.")
+	agentVersion       = flag.String("agent_version", "", "The version of the Buildkite agent, recorded as the builder version.")
+	builderPluginID    = flag.String("builder_plugin_id", "", "The name of the directory the agent checked this plugin out to, to find its version in $BUILDKITE_PLUGINS.")
+	builderPluginDir   = flag.String("builder_plugin_dir", "", "The checkout of this plugin, whose commit is recorded as a builder dependency.")
+	completenessFlag   = flag.String("completeness", "", "The parts of the recipe recorded completely: a comma-separated list of 'arguments', 'environment' and 'materials', or 'none'; checked against what was recorded.")
+	reproducibleBuild  = flag.Bool("reproducible_build", false, "Record that re-running the build with the same inputs produces the same subjects; requires complete environment and materials.")
+	reproducible       = flag.Bool("reproducible", false, "Produce byte-identical provenance from the same inputs: sort subjects and keys, and require a pinned build finish time.")
+	buildStartedOn     = flag.String("build_started_on", "", "The build start time (RFC 3339) to record; defaults to $BUILDKITE_JOB_STARTED_AT.")
+	buildFinishedOn    = flag.String("build_finished_on", "", "The build finish time (RFC 3339) to record instead of the current time; overrides SOURCE_DATE_EPOCH.")
+	slsaVersion        = flag.String("slsa_version", "0.1", "The version of SLSA provenance to write: '0.1' or '1'.")
+	predicateType      = flag.String("predicate_type", "", "The type (a URI) of the predicate of --predicate_file, to attest the subjects with instead of SLSA provenance.")
+	predicateFile      = flag.String("predicate_file", "", "The path of a JSON object to wrap as the predicate of the statement instead of SLSA provenance.")
+	helmRegistry       = flag.String("helm_registry", "", "The OCI registry (e.g. 'oci://ghcr.io/acme/charts') the --helm_chart charts were pushed to; the attestation of each is attached to its manifest there.")
+	npmProvenance      = flag.Bool("npm_provenance", false, "Write a Sigstore bundle of SLSA v1 provenance about each npm package tarball next to it, as '<tarball>.intoto.jsonl', for 'npm publish --provenance-file'.")
+	splitOutput        = flag.Bool("split_output", false, "Write one statement per artifact file next to it, as '<artifact>.intoto.jsonl', and only the other subjects to --output_path.")
+	expandArchives     = flag.Bool("expand_archives", false, "Also attest each file inside the tar, tar.gz and zip archives among the artifacts, as 'archive.tar.gz!path/inside'.")
+	duplicateNames     = flag.String("duplicate_names", DuplicateNamesError, "How different files given the same name by several --artifact_path values are handled: 'error' or 'qualify' (prefix their directory).")
+	concurrency        = flag.Int("concurrency", walker.Workers, concurrencyUsage)
+	payloadEncoding    = flag.String("payload_encoding", PayloadEncodingJCS, "The encoding of the signed statement: 'jcs' (RFC 8785 canonical JSON) or 'json'.")
+	outputFormat       = flag.String("output_format", "", "Set to 'sigstore-bundle' to write a Sigstore bundle instead of a statement or envelope.")
+	rekorURL           = flag.String("rekor_url", DefaultRekorURL, "The Rekor server sigstore bundles are recorded in.")
+	merkleManifest     = flag.String("merkle_manifest", "", "Write the subjects to a Merkle manifest at this path and attest only its root.")
+	writeChecksumsPath = flag.String("write_checksums", "", "Also write the sha256 digests of the file subjects to this path in the format of sha256sum (e.g. SHA256SUMS).")
+	namePolicy         = flag.String("subject_names", provenance.NamePolicyNFC, "How subject names are normalized: 'nfc' (reject control characters), 'escape' (percent-encode them) or 'preserve'.")
+	buildkitProvenance = flag.String("merge_buildkit_provenance", "", "The path of BuildKit (docker buildx) SLSA provenance whose materials and buildConfig are merged into the statement.")
+	imagePlatforms     = flag.Bool("image_platform_subjects", false, "Also add the per-platform manifests of multi-arch --image_ref indexes as subjects.")
+	attachTo           = flag.String("attach_to", "", "An image pinned by digest (image@sha256:...) to push the signed attestation to.")
+	attachMode         = flag.String("attach_mode", AttachModeTag, "How attestations are attached to images: 'tag' (cosign), 'referrers' (OCI 1.1) or 'both'.")
+	imagePolicy        = flag.String("cluster_image_policy", "", "Write a sigstore policy-controller ClusterImagePolicy admitting the --attach_to image to this path.")
+	outputStyle        = addOutputStyleFlags(flag.CommandLine)
+	upload             = addUploadFlags(flag.CommandLine)
+	artifacts          = addArtifactUploadFlags(flag.CommandLine)
+	archivistaURL      = flag.String("archivista_url", "", "The URL of an Archivista server the signed envelope is also stored in.")
+	githubRepo         = flag.String("github_repository", "", "The GitHub repository ('owner/name') whose artifact attestations the Sigstore bundle is published to.")
+	detectLicenses     = flag.Bool("detect_licenses", false, "Detect the license of each subject and write them to a companion attestation.")
+	licenseMapping     = flag.String("license_mapping", "", "The path of a JSON object mapping subject names or glob patterns to SPDX license expressions for the companion license attestation.")
+	licensesPath       = flag.String("licenses_output_path", "licenses.attestation.json", "The path to which the companion license attestation is written.")
+	spdxSBOM           = flag.String("spdx_sbom", "", "The path of an SPDX JSON document to also attest about the subjects in a companion SBOM attestation.")
+	sbomPath           = flag.String("sbom_output_path", "sbom.attestation.json", "The path to which the companion SBOM attestation is written.")
+	cycloneDXSBOM      = flag.String("cyclonedx_sbom", "", "The path of a CycloneDX JSON BOM to also attest about the subjects in a companion SBOM attestation.")
+	cycloneDXPath      = flag.String("cyclonedx_output_path", "cyclonedx.attestation.json", "The path to which the companion CycloneDX attestation is written.")
+	testResultsPath    = flag.String("test_results_output_path", "test-results.attestation.json", "The path to which the companion test result attestation is written.")
+	pipelineFile       = flag.String("pipeline_definition", "", "The path of the checked out pipeline definition (e.g. '.buildkite/pipeline.yml') the step ran from, to record as a material.")
+	checkoutDir        = flag.String("checkout_dir", ".", "The directory the repository is checked out in, which --pipeline_definition and --byproduct are recorded relative to and --submodule_materials are read from.")
+	pluginMaterialsOn  = flag.Bool("plugin_materials", false, "Record the Buildkite plugins of the step ($BUILDKITE_PLUGINS) as materials.")
+	pluginsDir         = flag.String("plugins_dir", "", "The directory the agent checks plugins out to ($BUILDKITE_PLUGINS_PATH), to record their commits from.")
+	submodules         = flag.Bool("submodule_materials", false, "Record the Git submodules checked out in --checkout_dir as materials.")
+	stepImageMaterial  = flag.Bool("step_image_material", false, "Resolve the image the job ran in (docker or docker-compose plugin, or 'image' agent tag) and record it as a material.")
+	vulnScan           = flag.String("vuln_scan", "", "The path of a Trivy or Grype JSON report to attest about the subjects in a companion vulnerability attestation.")
+	vulnsPath          = flag.String("vulns_output_path", "vulns.attestation.json", "The path to which the companion vulnerability attestation is written.")
+	profilesFile       = flag.String("profiles", os.Getenv("BUILDKITE_PROVENANCE_PROFILES"), "The path of a JSON file of configuration profiles selected by pipeline slug.")
+	profileName        = flag.String("profile", "", "The name of the profile to use instead of selecting one by pipeline slug.")
+	pipelineSlug       = flag.String("pipeline_slug", os.Getenv("BUILDKITE_PIPELINE_SLUG"), "The slug of the pipeline, used to select a profile.")
+	tsaURL             = flag.String("tsa_url", "", "The URL of an RFC 3161 timestamp authority used to timestamp signatures.")
+)
+
+// walker is how the commands find and digest artifact files, as set by
+// their flags.
+var walker = provenance.Walker{
+	Workers:  runtime.NumCPU(),
+	Symlinks: provenance.SymlinksFollow,
+	Warn:     func(message string) { fmt.Println(message) },
+}
+
+// concurrencyUsage describes the --concurrency flag of the commands that
+// hash files, which all default to the number of CPUs.
+const concurrencyUsage = "The number of files hashed in parallel; defaults to the number of CPUs."
+
+// Duplicate name policies of --duplicate_names, for different files given
+// the same subject name by several --artifact_path values.
+const (
+	// DuplicateNamesError fails the build.
+	DuplicateNamesError = "error"
+	// DuplicateNamesQualify prefixes each of the names with the name of the
+	// directory its artifact path starts from, e.g. "linux/app".
+	DuplicateNamesQualify = "qualify"
+)
+
+// mergeSubjects combines the subjects found under each artifact path of
+// "roots". A file found under overlapping paths, with the same name and
+// digest, is only kept once; different files with the same name are handled
+// as "duplicates" says.
+func mergeSubjects(roots []string, found [][]provenance.Subject, duplicates string) ([]provenance.Subject, error) {
+	type walked struct {
+		provenance.Subject
+		root int
+	}
+	var merged []walked
+	byName := map[string][]int{}
+	for i, subjects := range found {
+		for _, subject := range subjects {
+			duplicate := false
+			for _, j := range byName[subject.Name] {
+				duplicate = duplicate || merged[j].Digest["sha256"] == subject.Digest["sha256"]
+			}
+			if !duplicate {
+				byName[subject.Name] = append(byName[subject.Name], len(merged))
+				merged = append(merged, walked{subject, i})
+			}
+		}
+	}
+	for name, indexes := range byName {
+		if len(indexes) < 2 {
+			continue
+		}
+		if duplicates != DuplicateNamesQualify {
+			return nil, fmt.Errorf("different files are named %q under %s and %s; use --duplicate_names qualify to name them after their directories", name, roots[merged[indexes[0]].root], roots[merged[indexes[1]].root])
+		}
+		for _, j := range indexes {
+			merged[j].Name = filepath.Join(artifactDirName(roots[merged[j].root]), merged[j].Name)
+		}
+	}
+	var subjects []provenance.Subject
+	seen := map[string]string{}
+	for _, m := range merged {
+		if digest, ok := seen[m.Name]; ok && digest == m.Digest["sha256"] {
+			continue
+		} else if ok {
+			return nil, fmt.Errorf("different files are named %q even when qualified with their directories", m.Name)
+		}
+		seen[m.Name] = m.Digest["sha256"]
+		subjects = append(subjects, m.Subject)
+	}
+	return subjects, nil
+}
+
+// artifactDirName returns the name of the directory subjects of the
+// artifact path "root" are named relative to.
+func artifactDirName(root string) string {
+	base, _ := provenance.SplitGlob(root)
+	if info, err := os.Stat(base); err == nil && !info.IsDir() {
+		base = filepath.Dir(base)
+	}
+	return filepath.Base(filepath.Clean(base))
+}
+
+// subjectNamePlaceholder matches the placeholders of subject name templates.
+var subjectNamePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// validSubjectNameTemplate reports an error for unknown placeholders in a
+// subject name template.
+func validSubjectNameTemplate(template string) error {
+	for _, placeholder := range subjectNamePlaceholder.FindAllString(template, -1) {
+		switch placeholder {
+		case "{root}", "{relpath}", "{dirname}", "{basename}":
+		default:
+			return fmt.Errorf("unknown placeholder %s in %q, supported: {root}, {relpath}, {dirname}, {basename}", placeholder, template)
+		}
+	}
+	return nil
+}
+
+// nameSubjects renames the subjects found under the artifact path "root"
+// after "template", where {root} is the name of the directory the path
+// starts from, {relpath} the name they were found under, and {dirname} and
+// {basename} its directory and file name, and prefixes them with "prefix".
+// This lets names match the paths artifacts are published at.
+func nameSubjects(subjects []provenance.Subject, root, template, prefix string) {
+	for i := range subjects {
+		name := filepath.ToSlash(subjects[i].Name)
+		dirname := path.Dir(name)
+		if dirname == "." {
+			dirname = ""
+		}
+		replacer := strings.NewReplacer(
+			"{root}", artifactDirName(root),
+			"{relpath}", name,
+			"{dirname}", dirname,
+			"{basename}", path.Base(name),
+		)
+		// Placeholders that are empty must not leave doubled slashes.
+		subjects[i].Name = prefix + strings.TrimPrefix(path.Clean("/"+replacer.Replace(template)), "/")
+	}
+}
+
+// now returns the current time. Tests replace it with a fake clock such as
+// provtest.Clock.Now.
+var now = time.Now
+
+// clockPinned is set once the clock is fixed by pinClock or
+// --build_finished_on.
+var clockPinned bool
+
+// pinClock fixes the clock to $SOURCE_DATE_EPOCH when it is set, so repeated
+// runs over the same inputs produce identical statements.
+func pinClock() error {
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return nil
+	}
+	seconds, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid SOURCE_DATE_EPOCH %q", epoch)
+	}
+	now = func() time.Time { return time.Unix(seconds, 0) }
+	clockPinned = true
+	return nil
+}
+
+// buildProperties describes the build to upload stores that index
+// properties.
+func buildProperties(build provenance.BuildContext) map[string][]string {
+	properties := map[string][]string{}
+	for name, value := range map[string]string{"build.url": build.BuildURL, "vcs.revision": build.Commit, "vcs.url": build.Repository} {
+		if value != "" {
+			properties[name] = []string{value}
+		}
+	}
+	return properties
+}
+
+func parseFlags() {
+	flag.Usage = usage
+	flag.Parse()
+	logToStderr(*outputPath)
+	if *profilesFile != "" {
+		config, err := loadProfiles(*profilesFile)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to load profiles: %s", err))
+			os.Exit(1)
+		}
+		profile, err := config.selectProfile(*profileName, *pipelineSlug)
+		if err == nil && profile != nil {
+			fmt.Println(fmt.Sprintf("Using profile %q", profile.Name))
+			err = profile.apply(flag.CommandLine)
+		}
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to apply profile: %s", err))
+			os.Exit(1)
+		}
+	}
+	if len(artifactPath) < 1 && len(imageRefs) < 1 && len(subjectFlags) < 1 && len(checksumFiles) < 1 && len(urlSubjects) < 1 && len(helmChartPaths) < 1 {
+		fmt.Print("No value found for required flag: --artifact_path, --image_ref, --subject, --checksums_file or --url_subject\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *outputPath == "" {
+		fmt.Print("No value found for required flag: --output_path\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *buildStartedOn != "" {
+		if _, err := time.Parse(time.RFC3339, *buildStartedOn); err != nil {
+			fmt.Println(fmt.Sprintf("Invalid build start time: [provided=%s]\n", *buildStartedOn))
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+	if *buildFinishedOn != "" {
+		finished, err := time.Parse(time.RFC3339, *buildFinishedOn)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Invalid build finish time: [provided=%s]\n", *buildFinishedOn))
+			flag.Usage()
+			os.Exit(1)
+		}
+		now = func() time.Time { return finished }
+		clockPinned = true
+	}
+	if *reproducible {
+		if !clockPinned {
+			fmt.Print("Reproducible provenance requires a pinned build finish time: set SOURCE_DATE_EPOCH or --build_finished_on\n\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if *tsaURL != "" {
+			fmt.Print("Reproducible provenance cannot be timestamped: timestamps differ between runs; remove --tsa_url\n\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+		outputStyle.SortKeys = true
+	}
+	if *builderIDFlag != "" && !validPredicateType(*builderIDFlag) {
+		fmt.Println(fmt.Sprintf("Invalid builder ID: [provided=%s] must be an absolute URI\n", *builderIDFlag))
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *completenessFlag != "" {
+		if _, err := parseCompleteness(*completenessFlag); err != nil {
+			fmt.Println(fmt.Sprintf("Invalid completeness: %s\n", err))
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+	if *slsaVersion != "0.1" && *slsaVersion != "1" {
+		fmt.Println(fmt.Sprintf("Invalid SLSA version: [provided=%s] must be '0.1' or '1'\n", *slsaVersion))
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *slsaVersion == "1" && (*predicateFile != "" || *imagePolicy != "") {
+		fmt.Print("SLSA v1 provenance cannot be combined with --predicate_file or --cluster_image_policy\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if (*predicateType == "") != (*predicateFile == "") {
+		fmt.Print("A custom predicate requires both --predicate_type and --predicate_file\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *predicateType != "" && !validPredicateType(*predicateType) {
+		fmt.Println(fmt.Sprintf("Invalid predicate type: [provided=%s] must be an absolute URI\n", *predicateType))
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *predicateType != "" && (len(imageMaterials) > 0 || *buildkitProvenance != "" || *imagePolicy != "") {
+		fmt.Print("A custom predicate cannot record --image_material or --merge_buildkit_provenance, or be admitted by --cluster_image_policy\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if _, err := parseMonorepoMap(monorepoMap, monorepoCommits); err != nil {
+		fmt.Println(fmt.Sprintf("Invalid monorepo map: %s\n", err))
+		flag.Usage()
+		os.Exit(1)
+	}
+	if len(monorepoMap) > 0 && (*splitOutput || *outputPath == "-") {
+		fmt.Print("Monorepo provenance is written to a file per component; it cannot be split or written to standard output\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *helmRegistry != "" {
+		if len(helmChartPaths) == 0 || len(signKeys) == 0 || *outputPath == "-" {
+			fmt.Print("Attaching attestations to Helm charts requires --helm_chart, a --sign_key and an --output_path file\n\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+	if *npmProvenance {
+		if *splitOutput {
+			fmt.Print("npm provenance is written next to the package like split provenance; remove --split_output or --npm_provenance\n\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if len(signKeys) != 1 {
+			fmt.Print("npm provenance is a Sigstore bundle and requires exactly one --sign_key\n\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+		// npm checks the sha512 digest of the package.
+		digestAlgs = append(digestAlgs, "sha512")
+	}
+	if *splitOutput && *merkleManifest != "" {
+		fmt.Print("Split provenance cannot attest a Merkle root; remove --split_output or --merkle_manifest\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err := validOutputFlags(*outputFormat, *attachMode, *payloadEncoding); err != nil {
+		fmt.Println(fmt.Sprintf("%s\n", err))
+		flag.Usage()
+		os.Exit(1)
+	}
+	if walker.Filter.MaxSize > 0 && walker.Filter.MinSize > walker.Filter.MaxSize {
+		fmt.Println(fmt.Sprintf("Invalid size filter: --min_size %d is above --max_size %d\n", walker.Filter.MinSize, walker.Filter.MaxSize))
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err := validSubjectNameTemplate(*nameTemplate); err != nil {
+		fmt.Println(fmt.Sprintf("Invalid subject name template: %s\n", err))
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *subjectOverflow != provenance.SubjectOverflowFail && *subjectOverflow != provenance.SubjectOverflowDirHash {
+		fmt.Println(fmt.Sprintf("Unknown subject overflow policy: [provided=%s]\n", *subjectOverflow))
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *duplicateNames != DuplicateNamesError && *duplicateNames != DuplicateNamesQualify {
+		fmt.Println(fmt.Sprintf("Unknown duplicate names policy: [provided=%s]\n", *duplicateNames))
+		flag.Usage()
+		os.Exit(1)
+	}
+	if !provenance.ValidSymlinkPolicy(walker.Symlinks) {
+		fmt.Println(fmt.Sprintf("Unknown symlink policy: [provided=%s]\n", walker.Symlinks))
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err := upload.validate(); err != nil {
+		fmt.Println(fmt.Sprintf("Invalid upload: %s\n", err))
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *buildContext == StdioPath && *buildContextFile == "" {
+		*buildContext, *buildContextFile = "", StdioPath
+	}
+	if *agentContext == StdioPath && *agentContextFile == "" {
+		*agentContext, *agentContextFile = "", StdioPath
+	}
+	if *buildContextFile == StdioPath && *agentContextFile == StdioPath {
+		fmt.Print("Only one of the build and agent contexts can be read from standard input\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	readContextFile(buildContext, *buildContextFile, "build")
+	readContextFile(agentContext, *agentContextFile, "agent")
+	if *fromEnv {
+		if *buildContext != "" || *agentContext != "" {
+			fmt.Print("--from_env cannot be combined with the build or agent context flags\n\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+		return
+	}
+	if *buildContext == "" {
+		fmt.Print("No value found for required flag: --build_context\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *agentContext == "" {
+		fmt.Print("No value found for required flag: --agent_context\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+// parseContextStrict decodes the context JSON "value" into "v", rejecting
+// fields it does not know, trailing data and "required" fields that are
+// missing or empty, so that typos fail instead of yielding empty builder IDs
+// and commits.
+func parseContextStrict(value string, v interface{}, required ...string) error {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return fmt.Errorf("unexpected data after the context object")
+	}
+	var fields map[string]interface{}
+	json.Unmarshal([]byte(value), &fields)
+	var missing []string
+	for _, name := range required {
+		if s, ok := fields[name].(string); !ok || s == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("no value found for required fields: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// readContextFile sets "context" to the contents of the file at "path",
+// if any, or of standard input for StdioPath, refusing to also take the
+// "name" context from its flag.
+func readContextFile(context *string, path, name string) {
+	if path == "" {
+		return
+	}
+	if *context != "" {
+		fmt.Println(fmt.Sprintf("--%s_context cannot be combined with --%s_context_file\n", name, name))
+		flag.Usage()
+		os.Exit(1)
+	}
+	contents, err := readFileOrStdin(path)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to read %s context: %s", name, err))
+		os.Exit(1)
+	}
+	*context = string(contents)
+}
+
+// flagPassed reports whether the flag "name" was set on the command line.
+func flagPassed(name string) bool {
+	return flagSetPassed(flag.CommandLine, name)
+}
+
+// flagSetPassed reports whether the flag "name" of "fs" was set.
+func flagSetPassed(fs *flag.FlagSet, name string) bool {
+	passed := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
+}
+
+// usage lists the subcommands before the flags of generate, the default.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, `Usage: %s [generate] [flags]
+       %s <command> [flags]
+
+Commands:
+  generate          generate (and sign) provenance of artifacts; the default
+  sign              sign a statement generated without --sign_key
+  verify            check artifacts against the subject digests of provenance
+  upload            upload an attestation to object storage or as a job artifact
+  merge             merge the provenance of several jobs
+  verify-signature  verify the signatures of an envelope
+  summary, vsa, http-api, collector, export, reverify, prove, check, source
+
+Run '%s <command> -h' for the flags of a command. The flags of generate are:
+`, os.Args[0], os.Args[0], os.Args[0])
+	flag.PrintDefaults()
+}
+
+// wasmMain, when set, replaces the command line interface, e.g. to export
+// the verification functions to JavaScript.
+var wasmMain func()
+
+func main() {
+	if err := pinClock(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if wasmMain != nil {
+		wasmMain()
+		return
+	}
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "generate":
+			// Generating is also the default, for the flags the hooks and
+			// existing pipelines pass without a subcommand.
+			os.Args = append(os.Args[:1:1], os.Args[2:]...)
+		case "sign":
+			signCommand(os.Args[2:])
+			return
+		case "upload":
+			uploadCommand(os.Args[2:])
+			return
+		case "summary":
+			summaryCommand(os.Args[2:])
+			return
+		case "verify":
+			verifyCommand(os.Args[2:])
+			return
+		case "merge":
+			mergeCommand(os.Args[2:])
+			return
+		case "vsa":
+			vsaCommand(os.Args[2:])
+			return
+		case "verify-signature":
+			verifySignatureCommand(os.Args[2:])
+			return
+		case "http-api":
+			apiCommand(os.Args[2:])
+			return
+		case "collector":
+			collectorCommand(os.Args[2:])
+			return
+		case "export":
+			exportCommand(os.Args[2:])
+			return
+		case "reverify":
+			reverifyCommand(os.Args[2:])
+			return
+		case "prove":
+			proveCommand(os.Args[2:])
+			return
+		case "check":
+			checkCommand(os.Args[2:])
+			return
+		case "source":
+			sourceCommand(os.Args[2:])
+			return
+		}
+	}
+	flag.Var(&artifactPath, "artifact_path", "The file or dir path of the artifacts for which provenance should be generated.")
+	flag.Var(&signKeys, "sign_key", "A key used to sign the provenance envelope, prefixed with its backend (e.g. 'pkcs11:<label>' or 'ssh:<path>'); may be repeated.")
+	flag.Var(&imageRefs, "image_ref", "A container image reference (e.g. 'ghcr.io/org/app:v1') to resolve and add as a subject; may be repeated.")
+	flag.Var(&imageMaterials, "image_material", "A container image reference (e.g. 'alpine:3.18') to resolve and record as a material; may be repeated.")
+	flag.Var(&captureInclude, "capture_env_include", "A pattern (e.g. 'BUILDKITE_*') of the variables recorded by --capture_env; may be repeated. Defaults to 'BUILDKITE_*'.")
+	flag.Var(&captureExclude, "capture_env_exclude", "A regular expression (e.g. 'TOKEN|SECRET|KEY') of variables never recorded by --capture_env, besides likely secrets; may be repeated.")
+	flag.Var(&byproductPaths, "byproduct", "The path of an output of the build that is not a subject (e.g. a coverage report) to record as a byproduct; may be repeated.")
+	flag.Var(&dockerfiles, "dockerfile", "The path of a Dockerfile whose base (FROM) images are resolved and recorded as materials; may be repeated.")
+	flag.Var(&helmChartPaths, "helm_chart", "The path of a packaged Helm chart (.tgz) to attest, named and annotated after the name and version in its Chart.yaml; may be repeated.")
+	flag.Var(&monorepoMap, "monorepo_map", "A component of a monorepo ('pathprefix=artifactdir') whose artifacts get a statement of their own, with the source scoped to the path prefix; may be repeated.")
+	flag.Var(&monorepoCommits, "monorepo_commit", "The last commit ('pathprefix=commit') that changed a component of --monorepo_map, as of 'git log -1 -- pathprefix'; may be repeated.")
+	flag.Var(&dockerBuildArgs, "dockerfile_build_arg", "A build argument ('NAME=value') substituted in the FROM lines of --dockerfile; may be repeated.")
+	flag.Var(&digestAlgs, "digest_algorithm", "An additional algorithm (e.g. 'sha512') subjects are digested with besides sha256; may be repeated.")
+	flag.Var(&subjectFlags, "subject", "A precomputed subject ('name=sha256:<hex>[,sha512:<hex>]') of an artifact not on disk; may be repeated.")
+	flag.Var((*arrayFlags)(&walker.Exclude), "exclude", "A pattern (e.g. 'node_modules/' or '**/*.tmp') of files under --artifact_path that are not attested; may be repeated.")
+	addFileFilterFlags(flag.CommandLine, &walker.Filter)
+	flag.BoolVar(&walker.Describe, "describe_subjects", false, "Record the media type (e.g. 'application/spdx+json') and size of each file subject.")
+	flag.BoolVar(&walker.SkipUnreadable, "skip_unreadable", false, "Log and skip artifact files that cannot be read, e.g. for lack of permission, instead of failing.")
+	flag.StringVar(&walker.Symlinks, "symlinks", provenance.SymlinksFollow, "How symlinks among the artifacts are attested: 'follow', 'skip' or 'hash-target'.")
+	flag.Var(&urlSubjects, "url_subject", "The URL of a remote artifact to download and add as a subject, or 'URL=sha256:<hex>' to only check it exists; may be repeated.")
+	flag.Var(&testReports, "test_results", "The path of a JUnit XML or summary JSON test report to attest the results of in a companion attestation; may be repeated.")
+	flag.Var(&sbomFiles, "sbom", "The path of an SBOM produced by the build to record as a material of the provenance; may be repeated.")
+	flag.Var(&purls, "purl", "A package URL ('name=pkg:type/namespace/name@version') to name the subjects matching the name or glob pattern after; may be repeated.")
+	flag.Var(&checksumFiles, "checksums_file", "A checksum file in the format of sha256sum (e.g. SHA256SUMS) whose entries are added as subjects; may be repeated.")
+	flag.Var(&digestAlgs, "digest_algorithms", "A comma-separated list of additional algorithms (e.g. 'sha384,sha512') subjects are digested with besides sha256.")
+	flag.Var(&trustedBuilders, "trusted_builder", "Agent tags (e.g. 'queue=release,cluster=abc') an agent must match to be trusted; may be repeated.")
+	parseFlags()
+	walker.Workers = *concurrency
+	var predicate json.RawMessage
+	if *predicateFile != "" {
+		var err error
+		if predicate, err = readPredicate(*predicateFile); err != nil {
+			fmt.Println(fmt.Sprintf("Failed to read predicate: %s", err))
+			os.Exit(1)
+		}
+	}
+	var companions []companionAttestation
+	if *spdxSBOM != "" {
+		document, err := readSPDXDocument(*spdxSBOM)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to read SBOM: %s", err))
+			os.Exit(1)
+		}
+		companions = append(companions, companionAttestation{SPDXPredicateType, document, *sbomPath, "SBOM"})
+	}
+	if *cycloneDXSBOM != "" {
+		document, err := readCycloneDXDocument(*cycloneDXSBOM)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to read SBOM: %s", err))
+			os.Exit(1)
+		}
+		companions = append(companions, companionAttestation{CycloneDXPredicateType, document, *cycloneDXPath, "SBOM"})
+	}
+
+	env, err := loadEnvironment(*jobEnvFile)
+	if err != nil {
+		panic(err)
+	}
+	if len(trustedBuilders) > 0 {
+		trusted, err := trustedBuilder(trustedBuilders, agentTags(env))
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Invalid trusted builder allowlist: %s", err))
+			os.Exit(1)
+		}
+		if !trusted {
+			fmt.Println("Refusing to generate provenance: agent does not match the trusted builder allowlist")
+			os.Exit(1)
+		}
+	}
+
+	context := provenance.AnyContext{}
+	if *fromEnv {
+		if context, err = contextFromEnvironment(env); err != nil {
+			fmt.Println(fmt.Sprintf("Failed to read contexts from environment: %s", err))
+			os.Exit(1)
+		}
+	} else if *strictContext {
+		if err := parseContextStrict(*buildContext, &context.BuildContext, "build_url", "commit", "repository"); err != nil {
+			fmt.Println(fmt.Sprintf("Invalid build context: %s", err))
+			os.Exit(1)
+		}
+		if err := parseContextStrict(*agentContext, &context.AgentContext, "agent_id", "agent_organization"); err != nil {
+			fmt.Println(fmt.Sprintf("Invalid agent context: %s", err))
+			os.Exit(1)
+		}
+	} else {
+		if err := json.Unmarshal([]byte(*buildContext), &context.BuildContext); err != nil {
+			panic(err)
+		}
+		if err := json.Unmarshal([]byte(*agentContext), &context.AgentContext); err != nil {
+			panic(err)
+		}
+	}
+	if len(testReports) > 0 {
+		results, err := readTestResults(testReports, context.BuildURL)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to read test results: %s", err))
+			os.Exit(1)
+		}
+		predicate, _ := json.Marshal(results)
+		companions = append(companions, companionAttestation{TestResultPredicateType, predicate, *testResultsPath, "test result"})
+		fmt.Println(fmt.Sprintf("Test results: %s [passed=%d, warned=%d, failed=%d]", results.Result, len(results.PassedTests), len(results.WarnedTests), len(results.FailedTests)))
+	}
+	if *vulnScan != "" {
+		vulns, err := readVulnScan(*vulnScan)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to read vulnerability scan: %s", err))
+			os.Exit(1)
+		}
+		predicate, _ := json.Marshal(vulns)
+		companions = append(companions, companionAttestation{VulnsPredicateType, predicate, *vulnsPath, "vulnerability"})
+		fmt.Println(fmt.Sprintf("Vulnerability scan: %s found %d vulnerabilities", vulns.Scanner.URI, len(vulns.Scanner.Result)))
+	}
+	agentHost(&context.AgentContext, env, *agentOS, *agentArch)
+	if *captureEnv {
+		if context.Variables, err = captureEnvironment(env, captureInclude, captureExclude); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if *envBaseline != "" {
+		patterns, err := loadBaseline(*envBaseline)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to read environment baseline: [provided=%s]", *envBaseline))
+			os.Exit(1)
+		}
+		context.EnvironmentDiff = diffEnvironment(*envBaseline, patterns, env)
+		if len(context.EnvironmentDiff.Unexpected) > 0 {
+			fmt.Println("Unexpected environment variables: " + strings.Join(context.EnvironmentDiff.Unexpected, ", "))
+			if *envEnforce {
+				os.Exit(1)
+			}
+		}
+	}
+
+	algorithms, err := provenance.DigestAlgorithmNames(digestAlgs)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	var found [][]provenance.Subject
+	for _, path := range artifactPath {
+		subjects, err := walker.Subjects(path, algorithms...)
+		if os.IsNotExist(err) || errors.Is(err, provenance.ErrNoMatch) {
+			fmt.Println(fmt.Sprintf("Resource path not found: [provided=%s]", path))
+			os.Exit(1)
+		} else if err != nil {
+			panic(err)
+		}
+		found = append(found, subjects)
+	}
+	walkedCount := 0
+	for _, subjects := range found {
+		walkedCount += len(subjects)
+	}
+	overflow := *maxSubjects > 0 && walkedCount > *maxSubjects && *merkleManifest == ""
+	if overflow && *subjectOverflow != provenance.SubjectOverflowDirHash {
+		fmt.Println(fmt.Sprintf("Found %d files under --artifact_path, more than --max_subjects %d; narrow --artifact_path, attest directory digests with --subject_overflow dirhash or a Merkle root with --merkle_manifest", walkedCount, *maxSubjects))
+		os.Exit(1)
+	}
+	for i, path := range artifactPath {
+		if !overflow {
+			nameSubjects(found[i], path, *nameTemplate, *namePrefix)
+			continue
+		}
+		dir, err := provenance.DirHashSubject(*namePrefix+artifactDirName(path), found[i])
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to digest %s: %s", path, err))
+			os.Exit(1)
+		}
+		fmt.Println(fmt.Sprintf("Attesting the %d files under %s as %s", len(found[i]), path, dir.Name))
+		found[i] = []provenance.Subject{dir}
+	}
+	allSubjects, err := mergeSubjects(artifactPath, found, *duplicateNames)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Invalid subjects: %s", err))
+		os.Exit(1)
+	}
+	if *expandArchives {
+		if allSubjects, err = provenance.ExpandArchiveSubjects(allSubjects, algorithms); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	walked := map[string]provenance.DigestSet{}
+	for _, subject := range allSubjects {
+		walked[subject.Name] = subject.Digest
+	}
+	// addSubject appends a subject that was not walked; the same name and
+	// digest as an earlier subject is only kept once.
+	addSubject := func(subject provenance.Subject) {
+		if digest, ok := walked[subject.Name]; ok {
+			if !provenance.SameDigests(digest, subject.Digest) {
+				fmt.Println(fmt.Sprintf("Invalid subject: %q is listed more than once or also found under --artifact_path with a different digest", subject.Name))
+				os.Exit(1)
+			}
+			return
+		}
+		walked[subject.Name] = subject.Digest
+		allSubjects = append(allSubjects, subject)
+	}
+	precomputed, err := readChecksumFiles(checksumFiles)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to read checksums: %s", err))
+		os.Exit(1)
+	}
+	for _, value := range subjectFlags {
+		subject, err := provenance.ParseSubject(value)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Invalid subject: %s", err))
+			os.Exit(1)
+		}
+		precomputed = append(precomputed, subject)
+	}
+	for _, value := range urlSubjects {
+		subject, err := urlSubject(value, algorithms)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to attest remote artifact: %s", err))
+			os.Exit(1)
+		}
+		precomputed = append(precomputed, subject)
+	}
+	for _, subject := range precomputed {
+		addSubject(subject)
+	}
+	var charts []helmChart
+	for _, path := range helmChartPaths {
+		chart, err := readHelmChart(path)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to read Helm chart: %s", err))
+			os.Exit(1)
+		}
+		charts = append(charts, chart)
+		// Charts among the artifacts are only annotated.
+		if annotateHelmChart(allSubjects, chart) {
+			continue
+		}
+		subject, err := chart.subject(algorithms)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to read Helm chart: %s", err))
+			os.Exit(1)
+		}
+		addSubject(subject)
+	}
+	if err := provenance.NormalizeSubjectNames(allSubjects, *namePolicy); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *platform != "" || *normalize {
+		normalizePlatforms(allSubjects, *platform)
+	}
+	if *writeChecksumsPath != "" {
+		if err := writeChecksums(*writeChecksumsPath, allSubjects); err != nil {
+			fmt.Println(fmt.Sprintf("Failed to write checksums: %s", err))
+			os.Exit(1)
+		}
+	}
+	var purlMappings []purlMapping
+	for _, value := range purls {
+		m, err := parsePurlMapping(value)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Invalid purl: %s", err))
+			os.Exit(1)
+		}
+		purlMappings = append(purlMappings, m)
+	}
+	if err := namePurls(allSubjects, purlMappings, *purlNames); err != nil {
+		fmt.Println(fmt.Sprintf("Failed to name subjects after purls: %s", err))
+		os.Exit(1)
+	}
+	if *reproducible {
+		provenance.SortSubjects(allSubjects)
+	}
+	var licenses *LicensesStatement
+	if *detectLicenses || *licenseMapping != "" {
+		mapping := map[string]string{}
+		if *licenseMapping != "" {
+			if mapping, err = loadLicenseMapping(*licenseMapping); err != nil {
+				fmt.Println(fmt.Sprintf("Failed to read license mapping: %s", err))
+				os.Exit(1)
+			}
+		}
+		found, err := subjectLicenses(allSubjects, mapping, *detectLicenses)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		stmt := newLicensesStatement(append([]provenance.Subject{}, allSubjects...), found)
+		licenses = &stmt
+	}
+	if *merkleManifest != "" {
+		manifest, err := writeMerkleManifest(*merkleManifest, allSubjects)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to write merkle manifest: %s", err))
+			os.Exit(1)
+		}
+		allSubjects = []provenance.Subject{manifest.Subject(*merkleManifest)}
+	}
+
+	resolver := newRegistryResolver(*registryLimit)
+	images := len(allSubjects)
+	if *attachTo != "" {
+		ref, err := ParseImageRef(*attachTo)
+		if err == nil && ref.Digest == "" {
+			err = fmt.Errorf("image %s must be referenced by digest (image@sha256:...)", *attachTo)
+		}
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Invalid image to attach to: %s", err))
+			os.Exit(1)
+		}
+		allSubjects = append(allSubjects, imageSubject(ref, ref.Digest))
+	}
+	if len(imageRefs) > 0 {
+		refs, err := parseImageRefs(imageRefs)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		digests, err := resolver.ResolveAll(refs)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		seen := map[string]bool{}
+		for _, ref := range refs {
+			if seen[ref.String()] {
+				continue
+			}
+			seen[ref.String()] = true
+			allSubjects = append(allSubjects, imageSubject(ref, digests[ref.String()]))
+			if !*imagePlatforms {
+				continue
+			}
+			manifests, err := resolver.platformManifests(ref, digests[ref.String()])
+			if err != nil {
+				fmt.Println(fmt.Sprintf("Failed to read image index %s: %s", ref, err))
+				os.Exit(1)
+			}
+			for _, m := range manifests {
+				subject := imageSubject(ref, m.Digest)
+				subject.Annotations = map[string]string{"platform": m.Platform}
+				allSubjects = append(allSubjects, subject)
+			}
+		}
+	}
+	if *helmRegistry != "" {
+		for i := range charts {
+			ref, err := charts[i].ociRef(*helmRegistry)
+			if err != nil {
+				fmt.Println(fmt.Sprintf("Invalid Helm registry: %s", err))
+				os.Exit(1)
+			}
+			digest, err := resolver.Resolve(ref)
+			if err != nil {
+				fmt.Println(fmt.Sprintf("Failed to resolve Helm chart %s: %s", ref, err))
+				os.Exit(1)
+			}
+			charts[i].ref, charts[i].digest = &ref, digest
+			allSubjects = append(allSubjects, imageSubject(ref, digest))
+		}
+	}
+
+	if err := namePurls(allSubjects[images:], purlMappings, *purlNames); err != nil {
+		fmt.Println(fmt.Sprintf("Failed to name subjects after purls: %s", err))
+		os.Exit(1)
+	}
+	if *reproducible {
+		provenance.SortSubjects(allSubjects)
+	}
+	if *maxSubjects > 0 && len(allSubjects) > *maxSubjects {
+		fmt.Println(fmt.Sprintf("The provenance has %d subjects, more than --max_subjects %d", len(allSubjects), *maxSubjects))
+		os.Exit(1)
+	}
+	if *warnSubjects > 0 && len(allSubjects) > *warnSubjects {
+		fmt.Println(fmt.Sprintf("Warning: the provenance has %d subjects, more than %d; consider --max_subjects with --subject_overflow dirhash, or --merkle_manifest", len(allSubjects), *warnSubjects))
+	}
+
+	stmt, err := provenance.NewStatement(allSubjects, context, now())
+	if err != nil {
+		panic(err)
+	}
+	if args := stepArguments(context.Command, env); len(args.Commands) > 0 || args.Key != "" || args.Label != "" {
+		stmt.Predicate.Recipe.Arguments, _ = json.Marshal(args)
+	}
+	if *builderIDFlag != "" {
+		stmt.Predicate.Builder.Id = *builderIDFlag
+	}
+	if err := builderVersion(&stmt.Predicate.Builder, env, *agentVersion, *builderPluginID, *builderPluginDir); err != nil {
+		fmt.Println(fmt.Sprintf("Failed to record the builder version: %s", err))
+		os.Exit(1)
+	}
+	agent := context.AgentContext
+	if context.EnvironmentDiff != nil || *captureEnv || agent.OS != "" || agent.Arch != "" || agent.Queue != "" || len(agent.Tags) > 0 {
+		stmt.Predicate.Recipe.Environment = &context
+	}
+	// The variables left out by --capture_env are assumed not to affect the
+	// build.
+	stmt.Predicate.Metadata.Completeness.Environment = *captureEnv
+	started, err := jobStartedOn(*buildStartedOn, env, *reproducible)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Invalid build start time: %s", err))
+		os.Exit(1)
+	}
+	stmt.Predicate.Metadata.BuildStartedOn = started
+	if *apiMetadata {
+		token := os.Getenv("BUILDKITE_API_TOKEN")
+		if token == "" {
+			fmt.Println("No value found for required environment variable: BUILDKITE_API_TOKEN")
+			os.Exit(1)
+		}
+		started, metadata, err := buildMetadata(newBuildkiteClient(token), env)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to fetch build: %s", err))
+			os.Exit(1)
+		}
+		if stmt.Predicate.Metadata.BuildStartedOn == "" {
+			stmt.Predicate.Metadata.BuildStartedOn = started
+		}
+		stmt.Predicate.Metadata.Buildkite = metadata
+	}
+	for _, path := range byproductPaths {
+		byproduct, err := fileByproduct(path, *checkoutDir, algorithms)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to digest byproduct: %s", err))
+			os.Exit(1)
+		}
+		stmt.Predicate.Byproducts = append(stmt.Predicate.Byproducts, byproduct)
+	}
+	if *jobLog {
+		var client *buildkiteClient
+		if *jobLogPath == "" {
+			token := os.Getenv("BUILDKITE_API_TOKEN")
+			if token == "" {
+				fmt.Println("No value found for required environment variable: BUILDKITE_API_TOKEN")
+				os.Exit(1)
+			}
+			client = newBuildkiteClient(token)
+		}
+		byproduct, err := jobLogByproduct(client, env, *jobLogPath, algorithms)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to digest job log: %s", err))
+			os.Exit(1)
+		}
+		stmt.Predicate.Byproducts = append(stmt.Predicate.Byproducts, byproduct)
+	}
+	if *graphqlJob {
+		token := os.Getenv("BUILDKITE_API_TOKEN")
+		if token == "" {
+			fmt.Println("No value found for required environment variable: BUILDKITE_API_TOKEN")
+			os.Exit(1)
+		}
+		details, err := jobDetails(newBuildkiteClient(token), env)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to fetch job: %s", err))
+			os.Exit(1)
+		}
+		stmt.Predicate.Recipe.Arguments, _ = json.Marshal(details)
+	}
+	if len(dockerfiles) > 0 {
+		args := map[string]string{}
+		for _, arg := range dockerBuildArgs {
+			i := strings.Index(arg, "=")
+			if i <= 0 {
+				fmt.Println(fmt.Sprintf("Invalid build argument %q, expected 'NAME=value'", arg))
+				os.Exit(1)
+			}
+			args[arg[:i]] = arg[i+1:]
+		}
+		for _, path := range dockerfiles {
+			images, err := dockerfileBaseImages(path, args)
+			if err != nil {
+				fmt.Println(fmt.Sprintf("Failed to read base images: %s", err))
+				os.Exit(1)
+			}
+			imageMaterials = append(imageMaterials, images...)
+		}
+	}
+	if *stepImageMaterial {
+		images, err := stepImages(env, *checkoutDir)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to find the step image: %s", err))
+			os.Exit(1)
+		}
+		imageMaterials = append(imageMaterials, images...)
+	}
+	if len(imageMaterials) > 0 {
+		refs, err := parseImageRefs(imageMaterials)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		digests, err := resolver.ResolveAll(refs)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		seen := map[string]bool{}
+		for _, ref := range refs {
+			if !seen[ref.String()] {
+				seen[ref.String()] = true
+				stmt.Predicate.Materials = append(stmt.Predicate.Materials, imageMaterial(ref, digests[ref.String()]))
+			}
+		}
+	}
+	// SBOMs attested in companion attestations are also recorded.
+	seen := map[string]bool{}
+	for _, path := range append(append([]string{}, sbomFiles...), *spdxSBOM, *cycloneDXSBOM) {
+		if path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		material, err := sbomMaterial(path, algorithms)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to digest SBOM: %s", err))
+			os.Exit(1)
+		}
+		stmt.Predicate.Materials = append(stmt.Predicate.Materials, material)
+	}
+	if *pluginMaterialsOn && env["BUILDKITE_PLUGINS"] != "" {
+		materials, err := pluginMaterials(env["BUILDKITE_PLUGINS"], *pluginsDir)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to record plugins: %s", err))
+			os.Exit(1)
+		}
+		stmt.Predicate.Materials = append(stmt.Predicate.Materials, materials...)
+	}
+	if *pipelineFile != "" {
+		material, err := pipelineMaterial(*pipelineFile, *checkoutDir, stmt.Predicate, algorithms)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to record pipeline definition: %s", err))
+			os.Exit(1)
+		}
+		stmt.Predicate.Materials = append(stmt.Predicate.Materials, material)
+	}
+	if *submodules {
+		source, _ := definedInMaterial(stmt.Predicate)
+		materials, err := submoduleMaterials(*checkoutDir, source)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to record submodules: %s", err))
+			os.Exit(1)
+		}
+		stmt.Predicate.Materials = append(stmt.Predicate.Materials, materials...)
+	}
+
+	if *buildkitProvenance != "" {
+		predicates, err := readBuildkitProvenance(*buildkitProvenance)
+		if err == nil {
+			err = mergeBuildkitProvenance(&stmt, predicates)
+		}
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to merge BuildKit provenance: %s", err))
+			os.Exit(1)
+		}
+	}
+
+	hermetic, isolated := *hermeticBuild, *isolatedBuild
+	if *detectIsolated {
+		detectedHermetic, detectedIsolated, reasons := detectIsolation(env)
+		hermetic, isolated = hermetic || detectedHermetic, isolated || detectedIsolated
+		for _, reason := range reasons {
+			fmt.Println("Isolation: " + reason)
+		}
+	}
+	// The metadata is only checked when claimed explicitly, to keep the
+	// defaults of earlier versions.
+	if *completenessFlag != "" || *reproducibleBuild || hermetic || isolated {
+		if *completenessFlag != "" {
+			stmt.Predicate.Metadata.Completeness, _ = parseCompleteness(*completenessFlag)
+		}
+		stmt.Predicate.Metadata.Reproducible = *reproducibleBuild
+		stmt.Predicate.Metadata.Hermetic = hermetic
+		stmt.Predicate.Metadata.Isolated = isolated
+		var unrecorded []string
+		if env["BUILDKITE_PLUGINS"] != "" && !*pluginMaterialsOn {
+			unrecorded = append(unrecorded, "the plugins of the step (--plugin_materials)")
+		}
+		if _, err := os.Stat(filepath.Join(*checkoutDir, ".gitmodules")); err == nil && !*submodules {
+			unrecorded = append(unrecorded, "the submodules (--submodule_materials)")
+		}
+		if err := checkCompleteness(stmt.Predicate, unrecorded); err != nil {
+			fmt.Println(fmt.Sprintf("Invalid completeness: %s", err))
+			os.Exit(1)
+		}
+	}
+
+	var policy *ClusterImagePolicy
+	if *imagePolicy != "" {
+		if *attachTo == "" {
+			fmt.Println("A ClusterImagePolicy requires --attach_to")
+			os.Exit(1)
+		}
+		signers, err := sign.NewSigners(signKeys)
+		if err == nil {
+			policy, err = newClusterImagePolicy(*attachTo, *attachMode, stmt, signers, *rekorURL)
+		}
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to create ClusterImagePolicy: %s", err))
+			os.Exit(1)
+		}
+	}
+
+	// attestationOf returns the statement about "subjects" that is written
+	// for the provenance "s": in SLSA v1 with --slsa_version 1, or, with
+	// --predicate_file, the custom predicate.
+	attestationOf := func(s provenance.Statement, subjects []provenance.Subject) interface{} {
+		if *slsaVersion == "1" {
+			v1, _ := json.Marshal(provenance.NewProvenanceV1(s.Predicate, context, env))
+			return provenance.CustomStatement{Type: provenance.StatementV1Type, Subject: subjects, PredicateType: provenance.ProvenanceV1PredicateType, Predicate: v1}
+		}
+		if predicate != nil {
+			return provenance.CustomStatement{Type: s.Type, Subject: subjects, PredicateType: *predicateType, Predicate: predicate}
+		}
+		s.Subject = subjects
+		return s
+	}
+	attestation := func(subjects []provenance.Subject) interface{} {
+		return attestationOf(stmt, subjects)
+	}
+	payload, _ := provenance.EscapedMarshalIndent(attestation(stmt.Subject), "", "  ")
+	fmt.Println("Provenance:\n" + string(payload))
+	opts := outputOptions{
+		path:     *outputPath,
+		pathSet:  flagPassed("output_path"),
+		preset:   *presetName,
+		signKeys: signKeys,
+		tsaURL:   *tsaURL,
+		format:   *outputFormat,
+		rekorURL: *rekorURL,
+		attachTo: *attachTo,
+
+		attachMode:    *attachMode,
+		style:         *outputStyle,
+		legacyPayload: *payloadEncoding == PayloadEncodingJSON,
+		upload:        upload,
+		properties:    buildProperties(context.BuildContext),
+		artifacts:     artifacts,
+		archivistaURL: *archivistaURL,
+		githubRepo:    *githubRepo,
+	}
+	rest := stmt.Subject
+	if len(monorepoMap) > 0 {
+		components, _ := parseMonorepoMap(monorepoMap, monorepoCommits)
+		var grouped [][]provenance.Subject
+		grouped, rest = componentSubjects(components, stmt.Subject)
+		componentOpts := opts
+		componentOpts.pathSet = true
+		componentOpts.attachTo = ""
+		for i, component := range components {
+			if len(grouped[i]) == 0 {
+				fmt.Println(fmt.Sprintf("No artifacts of component %s in %s", component.path, component.artifactDir))
+				continue
+			}
+			componentStmt, err := componentStatement(stmt, component, grouped[i])
+			if err != nil {
+				fmt.Println(fmt.Sprintf("Failed to scope provenance to component %s: %s", component.path, err))
+				os.Exit(1)
+			}
+			componentOpts.path = componentOutputPath(*outputPath, component.path)
+			written, err := writeAttestation(attestationOf(componentStmt, grouped[i]), grouped[i], componentOpts)
+			if err != nil {
+				fmt.Println(fmt.Sprintf("Failed to write provenance of component %s: %s", component.path, err))
+				os.Exit(1)
+			}
+			fmt.Println(fmt.Sprintf("Wrote provenance of component %s: %s", component.path, written))
+		}
+	}
+	if *splitOutput {
+		var split []splitAttestation
+		split, rest = splitSubjects(stmt.Subject)
+		splitOpts := opts
+		splitOpts.pathSet = true
+		splitOpts.attachTo = ""
+		if splitOpts.style.Format == "" {
+			// One statement or envelope per line.
+			splitOpts.style.Format = JSONCompact
+		}
+		for _, artifact := range split {
+			splitOpts.path = artifact.path
+			if _, err := writeAttestation(attestation(artifact.subjects), artifact.subjects, splitOpts); err != nil {
+				fmt.Println(fmt.Sprintf("Failed to write provenance to %s: %s", artifact.path, err))
+				os.Exit(1)
+			}
+			fmt.Println("Wrote provenance: " + artifact.path)
+		}
+	}
+	if *npmProvenance {
+		packages, err := npmPackages(stmt.Subject)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to find npm packages: %s", err))
+			os.Exit(1)
+		}
+		npmOpts := opts
+		npmOpts.pathSet = true
+		npmOpts.preset = ""
+		npmOpts.format = "sigstore-bundle"
+		npmOpts.attachTo = ""
+		npmOpts.githubRepo = ""
+		if npmOpts.style.Format == "" {
+			npmOpts.style.Format = JSONCompact
+		}
+		v1, _ := json.Marshal(provenance.NewProvenanceV1(stmt.Predicate, context, env))
+		for _, pkg := range packages {
+			npmOpts.path = pkg.file + SplitOutputSuffix
+			subjects := []provenance.Subject{pkg.subject}
+			npmStmt := provenance.CustomStatement{Type: provenance.StatementV1Type, Subject: subjects, PredicateType: provenance.ProvenanceV1PredicateType, Predicate: v1}
+			if _, err := writeAttestation(npmStmt, subjects, npmOpts); err != nil {
+				fmt.Println(fmt.Sprintf("Failed to write npm provenance to %s: %s", npmOpts.path, err))
+				os.Exit(1)
+			}
+			fmt.Println(fmt.Sprintf("Wrote npm provenance of %s: %s", pkg.subject.Name, npmOpts.path))
+		}
+	}
+	if len(rest) > 0 || !*splitOutput && len(monorepoMap) == 0 {
+		if _, err := writeAttestation(attestation(rest), rest, opts); err != nil {
+			fmt.Println(fmt.Sprintf("Failed to write provenance: %s", err))
+			os.Exit(1)
+		}
+	}
+	for _, chart := range charts {
+		if chart.ref == nil {
+			continue
+		}
+		// Each chart pushed to a registry gets an attestation of its own
+		// there, next to its manifest.
+		subjects := chart.subjects(stmt.Subject)
+		chartOpts := opts
+		chartOpts.pathSet = true
+		chartOpts.path = componentOutputPath(*outputPath, chart.name)
+		chartOpts.attachTo = chart.ref.Registry + "/" + chart.ref.Repository + "@" + chart.digest
+		if _, err := writeAttestation(attestation(subjects), subjects, chartOpts); err != nil {
+			fmt.Println(fmt.Sprintf("Failed to attest Helm chart %s: %s", chart.name, err))
+			os.Exit(1)
+		}
+		fmt.Println(fmt.Sprintf("Wrote provenance of Helm chart %s %s: %s", chart.name, chart.version, chartOpts.path))
+	}
+	if policy != nil {
+		if err := writeClusterImagePolicy(*imagePolicy, policy); err != nil {
+			fmt.Println(fmt.Sprintf("Failed to write ClusterImagePolicy: %s", err))
+			os.Exit(1)
+		}
+	}
+	if licenses != nil {
+		if _, err := writeAttestation(licenses, licenses.Subject, outputOptions{
+			path:     *licensesPath,
+			pathSet:  true,
+			signKeys: signKeys,
+			tsaURL:   *tsaURL,
+			format:   *outputFormat,
+			rekorURL: *rekorURL,
+			style:    *outputStyle,
+			upload:   upload,
+
+			properties:    buildProperties(context.BuildContext),
+			artifacts:     artifacts,
+			archivistaURL: *archivistaURL,
+			githubRepo:    *githubRepo,
+		}); err != nil {
+			fmt.Println(fmt.Sprintf("Failed to write license attestation: %s", err))
+			os.Exit(1)
+		}
+	}
+	for _, companion := range companions {
+		companionStmt := provenance.CustomStatement{Type: stmt.Type, Subject: stmt.Subject, PredicateType: companion.predicateType, Predicate: companion.predicate}
+		if _, err := writeAttestation(companionStmt, companionStmt.Subject, outputOptions{
+			path:     companion.path,
+			pathSet:  true,
+			signKeys: signKeys,
+			tsaURL:   *tsaURL,
+			format:   *outputFormat,
+			rekorURL: *rekorURL,
+			style:    *outputStyle,
+			upload:   upload,
+
+			properties:    buildProperties(context.BuildContext),
+			artifacts:     artifacts,
+			archivistaURL: *archivistaURL,
+			githubRepo:    *githubRepo,
+		}); err != nil {
+			fmt.Println(fmt.Sprintf("Failed to write %s attestation: %s", companion.kind, err))
+			os.Exit(1)
+		}
+	}
+}
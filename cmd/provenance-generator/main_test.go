@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provtest"
+)
+
+// TestMain runs the generator instead of the tests when the test binary is
+// re-executed by runGenerator.
+func TestMain(m *testing.M) {
+	if os.Getenv("PROVENANCE_GENERATOR_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runGenerator runs the generator with "args" in "dir", with only the fixed
+// provtest environment and its SOURCE_DATE_EPOCH.
+func runGenerator(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"PROVENANCE_GENERATOR_TEST_MAIN=1",
+		"SOURCE_DATE_EPOCH=" + provtest.Env()["SOURCE_DATE_EPOCH"],
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generator failed: %s\n%s", err, output)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "dist"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "dist", "app.tar.gz"), []byte("app"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGenerator(t, dir,
+		"--artifact_path", "dist",
+		"--build_context", provtest.BuildContext,
+		"--agent_context", provtest.AgentContext,
+		"--job_env_file", provtest.WriteEnvFile(t, provtest.Env()),
+		"--output_path", "provenance.json",
+	)
+	got, err := ioutil.ReadFile(filepath.Join(dir, "provenance.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	provtest.AssertGolden(t, "testdata/generate.golden", got)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// mergeStatements combines provenance statements of the same build, e.g.
+// from the parallel jobs of a matrix, into one statement with the subjects
+// and materials of all of them. The statements must describe the same
+// build of the same source with the same recipe type; "builderID", when
+// set, is recorded for builders that differ between them. Entry points,
+// arguments and environments of jobs that differ are left out, the
+// byproducts of all of them are kept, and the build finish time is the
+// latest.
+func mergeStatements(statements []provenance.Statement, builderID string) (provenance.Statement, error) {
+	merged := statements[0]
+	merged.Subject = nil
+	merged.Predicate.Materials = nil
+	merged.Predicate.Byproducts = nil
+	subjects := map[string]int{}
+	materials := map[string]int{}
+	var finished time.Time
+	for i, stmt := range statements {
+		p, first := stmt.Predicate, statements[0].Predicate
+		switch {
+		case stmt.Type != statements[0].Type:
+			return provenance.Statement{}, fmt.Errorf("statement %d is a %s statement, not %s", i+1, stmt.Type, statements[0].Type)
+		case stmt.PredicateType != statements[0].PredicateType:
+			return provenance.Statement{}, fmt.Errorf("statement %d has predicate type %s, not %s", i+1, stmt.PredicateType, statements[0].PredicateType)
+		case p.Metadata.BuildInvocationId != first.Metadata.BuildInvocationId:
+			return provenance.Statement{}, fmt.Errorf("statement %d is of build %s, not %s", i+1, p.Metadata.BuildInvocationId, first.Metadata.BuildInvocationId)
+		case p.Recipe.Type != first.Recipe.Type:
+			return provenance.Statement{}, fmt.Errorf("statement %d has recipe type %s, not %s", i+1, p.Recipe.Type, first.Recipe.Type)
+		case p.Builder.Id != first.Builder.Id && builderID == "":
+			return provenance.Statement{}, fmt.Errorf("statement %d has builder %s, not %s; set --builder_id to merge them", i+1, p.Builder.Id, first.Builder.Id)
+		case !bytes.Equal(p.BuildConfig, first.BuildConfig):
+			return provenance.Statement{}, fmt.Errorf("statement %d has a different buildConfig", i+1)
+		}
+		if source, ok := definedInMaterial(p); ok {
+			if want, _ := definedInMaterial(first); !reflect.DeepEqual(source, want) {
+				return provenance.Statement{}, fmt.Errorf("statement %d is of source %s, not %s", i+1, source.URI, want.URI)
+			}
+		}
+
+		if p.Recipe.EntryPoint != merged.Predicate.Recipe.EntryPoint {
+			merged.Predicate.Recipe.EntryPoint = ""
+		}
+		if !bytes.Equal(p.Recipe.Arguments, merged.Predicate.Recipe.Arguments) {
+			merged.Predicate.Recipe.Arguments = nil
+			merged.Predicate.Metadata.Completeness.Arguments = false
+		}
+		if !reflect.DeepEqual(p.Recipe.Environment, merged.Predicate.Recipe.Environment) {
+			merged.Predicate.Recipe.Environment = nil
+			merged.Predicate.Metadata.Completeness.Environment = false
+		}
+		c := &merged.Predicate.Metadata.Completeness
+		c.Arguments = c.Arguments && p.Metadata.Completeness.Arguments
+		c.Environment = c.Environment && p.Metadata.Completeness.Environment
+		c.Materials = c.Materials && p.Metadata.Completeness.Materials
+		merged.Predicate.Metadata.Reproducible = merged.Predicate.Metadata.Reproducible && p.Metadata.Reproducible
+		if t, err := time.Parse(time.RFC3339, p.Metadata.BuildFinishedOn); err == nil && t.After(finished) {
+			finished = t
+			merged.Predicate.Metadata.BuildFinishedOn = p.Metadata.BuildFinishedOn
+		}
+
+		for _, subject := range stmt.Subject {
+			if j, ok := subjects[subject.Name]; ok {
+				if !reflect.DeepEqual(merged.Subject[j].Digest, subject.Digest) {
+					return provenance.Statement{}, fmt.Errorf("subject %s has different digests in statements", subject.Name)
+				}
+				continue
+			}
+			subjects[subject.Name] = len(merged.Subject)
+			merged.Subject = append(merged.Subject, subject)
+		}
+		merged.Predicate.Byproducts = append(merged.Predicate.Byproducts, p.Byproducts...)
+		for _, material := range p.Materials {
+			if j, ok := materials[material.URI]; ok {
+				if !reflect.DeepEqual(merged.Predicate.Materials[j].Digest, material.Digest) {
+					return provenance.Statement{}, fmt.Errorf("material %s has different digests in statements", material.URI)
+				}
+				continue
+			}
+			materials[material.URI] = len(merged.Predicate.Materials)
+			merged.Predicate.Materials = append(merged.Predicate.Materials, material)
+		}
+	}
+	if builderID != "" {
+		merged.Predicate.Builder.Id = builderID
+	}
+	if merged.Predicate.Materials == nil {
+		merged.Predicate.Materials = []provenance.Item{}
+	}
+	return merged, nil
+}
+
+// definedInMaterial returns the material the recipe of "p" is defined in.
+func definedInMaterial(p provenance.Predicate) (provenance.Item, bool) {
+	if i := p.Recipe.DefinedInMaterial; i >= 0 && i < len(p.Materials) {
+		return p.Materials[i], true
+	}
+	return provenance.Item{}, false
+}
+
+// mergeCommand merges provenance files of the same build into one.
+func mergeCommand(args []string) {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	var paths arrayFlags
+	fs.Var(&paths, "provenance_path", "The path of a provenance file to merge; may be repeated.")
+	output := fs.String("output_path", "provenance.json", "The path to which the merged provenance should be written, or '-' for standard output.")
+	builderID := fs.String("builder_id", "", "The builder ID recorded when the merged provenance was generated by different builders.")
+	preset := fs.String("preset", "", "The output convention to follow.")
+	var keys arrayFlags
+	fs.Var(&keys, "sign_key", "A key used to sign the merged envelope; may be repeated.")
+	tsa := fs.String("tsa_url", "", "The URL of an RFC 3161 timestamp authority used to timestamp signatures.")
+	format := fs.String("output_format", "", "Set to 'sigstore-bundle' to write a Sigstore bundle instead of a statement or envelope.")
+	rekor := fs.String("rekor_url", DefaultRekorURL, "The Rekor server sigstore bundles are recorded in.")
+	style := addOutputStyleFlags(fs)
+	upload := addUploadFlags(fs)
+	artifactUpload := addArtifactUploadFlags(fs)
+	archivista := fs.String("archivista_url", "", "The URL of an Archivista server the signed envelope is also stored in.")
+	github := fs.String("github_repository", "", "The GitHub repository ('owner/name') whose artifact attestations the Sigstore bundle is published to.")
+	fs.Parse(args)
+	logToStderr(*output)
+	if len(paths) < 1 {
+		fmt.Println("No value found for required flag: --provenance_path")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := upload.validate(); err != nil {
+		fmt.Println(fmt.Sprintf("Invalid upload: %s", err))
+		os.Exit(1)
+	}
+
+	var statements []provenance.Statement
+	for _, path := range paths {
+		documents, err := readStatements(path)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to read provenance: %s", err))
+			os.Exit(1)
+		}
+		for _, document := range documents {
+			var stmt provenance.Statement
+			if err := json.Unmarshal(document, &stmt); err != nil {
+				fmt.Println(fmt.Sprintf("Failed to parse provenance %s: %s", path, err))
+				os.Exit(1)
+			}
+			statements = append(statements, stmt)
+		}
+	}
+	stmt, err := mergeStatements(statements, *builderID)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to merge provenance: %s", err))
+		os.Exit(1)
+	}
+
+	payload, _ := provenance.EscapedMarshalIndent(stmt, "", "  ")
+	fmt.Println("Provenance:\n" + string(payload))
+	context := provenance.BuildContext{BuildURL: stmt.Predicate.Metadata.BuildInvocationId}
+	if source, ok := definedInMaterial(stmt.Predicate); ok {
+		context.Commit = source.Digest["sha1"]
+	}
+	written, err := writeAttestation(stmt, stmt.Subject, outputOptions{
+		path:     *output,
+		pathSet:  flagSetPassed(fs, "output_path"),
+		preset:   *preset,
+		signKeys: keys,
+		tsaURL:   *tsa,
+		format:   *format,
+		rekorURL: *rekor,
+		style:    *style,
+		upload:   upload,
+
+		properties:    buildProperties(context),
+		artifacts:     artifactUpload,
+		archivistaURL: *archivista,
+		githubRepo:    *github,
+	})
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to write provenance: %s", err))
+		os.Exit(1)
+	}
+	fmt.Println(fmt.Sprintf("Merged %d statements with %d subjects into %s", len(statements), len(stmt.Subject), written))
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// writeMerkleManifest writes the manifest over "subjects" to "path" and
+// returns it.
+func writeMerkleManifest(path string, subjects []provenance.Subject) (*provenance.MerkleManifest, error) {
+	manifest, err := provenance.NewMerkleManifest(subjects)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return manifest, ioutil.WriteFile(path, payload, 0644)
+}
+
+func readMerkleManifest(path string) (*provenance.MerkleManifest, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest provenance.MerkleManifest
+	if err := json.Unmarshal(contents, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.HashAlgorithm != "sha256" {
+		return nil, fmt.Errorf("unsupported hash algorithm %q", manifest.HashAlgorithm)
+	}
+	if len(manifest.Leaves) == 0 {
+		return nil, fmt.Errorf("manifest has no leaves")
+	}
+	return &manifest, nil
+}
+
+// proveCommand writes inclusion proofs for the named files of a manifest.
+func proveCommand(args []string) {
+	fs := flag.NewFlagSet("prove", flag.ExitOnError)
+	manifestPath := fs.String("manifest", "merkle-manifest.json", "The path of the Merkle manifest written with the provenance.")
+	var names arrayFlags
+	fs.Var(&names, "name", "The subject name of a file to prove; may be repeated.")
+	output := fs.String("output_path", "", "The path to which the proofs are written, one per line; defaults to stdout.")
+	fs.Parse(args)
+	if len(names) < 1 {
+		fmt.Println("No value found for required flag: --name")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	manifest, err := readMerkleManifest(*manifestPath)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to read manifest: %s", err))
+		os.Exit(1)
+	}
+	leaves, err := provenance.MerkleLeaves(manifest.Leaves)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Invalid manifest: %s", err))
+		os.Exit(1)
+	}
+	levels := provenance.MerkleTree(leaves)
+	root := hex.EncodeToString(levels[len(levels)-1][0])
+	if root != manifest.Root || len(leaves) != manifest.TreeSize {
+		fmt.Println(fmt.Sprintf("Manifest does not match its root: [manifest=%s computed=%s]", manifest.Root, root))
+		os.Exit(1)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	encoder := json.NewEncoder(w)
+	for _, name := range names {
+		index := sort.Search(len(manifest.Leaves), func(i int) bool { return manifest.Leaves[i].Name >= name })
+		if index == len(manifest.Leaves) || manifest.Leaves[index].Name != name {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("Subject not found in manifest: %s", name))
+			os.Exit(1)
+		}
+		proof := provenance.MerkleProof{
+			Name:      name,
+			Digest:    manifest.Leaves[index].Digest,
+			LeafIndex: index,
+			TreeSize:  manifest.TreeSize,
+			Root:      manifest.Root,
+			Hashes:    []string{},
+		}
+		for _, h := range provenance.MerkleInclusionProof(levels, index) {
+			proof.Hashes = append(proof.Hashes, hex.EncodeToString(h))
+		}
+		if err := encoder.Encode(proof); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// checkCommand verifies inclusion proofs, and optionally the files they
+// cover, against a provenance with a Merkle root subject.
+func checkCommand(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	proofPath := fs.String("proof", "", "The path of the inclusion proofs written by 'prove'.")
+	provenancePath := fs.String("provenance_path", "provenance.json", "The path of the provenance holding the Merkle root subject.")
+	artifact := fs.String("artifact_path", "", "The file or dir path of the artifacts to check the proven digests against.")
+	namePolicy := fs.String("subject_names", provenance.NamePolicyNFC, "The subject name policy the provenance was generated with: 'nfc', 'escape' or 'preserve'.")
+	fs.Parse(args)
+	if *proofPath == "" {
+		fmt.Println("No value found for required flag: --proof")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	statements, err := readStatements(*provenancePath)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to read provenance: %s", err))
+		os.Exit(1)
+	}
+	roots := map[string]string{}
+	for _, statement := range statements {
+		var stmt struct {
+			Subject []provenance.Subject `json:"subject"`
+		}
+		if err := json.Unmarshal(statement, &stmt); err != nil {
+			fmt.Println(fmt.Sprintf("Failed to parse provenance: %s", err))
+			os.Exit(1)
+		}
+		for _, s := range stmt.Subject {
+			if root, ok := s.Digest[provenance.MerkleDigestAlgorithm]; ok {
+				roots[root] = s.Annotations["merkleTreeSize"]
+			}
+		}
+	}
+
+	contents, err := ioutil.ReadFile(*proofPath)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to read proofs: %s", err))
+		os.Exit(1)
+	}
+	var proofs []provenance.MerkleProof
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	for {
+		var proof provenance.MerkleProof
+		if err := decoder.Decode(&proof); err == io.EOF {
+			break
+		} else if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to parse proofs: %s", err))
+			os.Exit(1)
+		}
+		proofs = append(proofs, proof)
+	}
+
+	var problems []string
+	for _, proof := range proofs {
+		if err := provenance.CheckProof(proof, roots); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid proof for %s: %s", proof.Name, err))
+		}
+	}
+	if *artifact != "" {
+		actual, err := walker.Subjects(*artifact)
+		if os.IsNotExist(err) {
+			fmt.Println(fmt.Sprintf("Resource path not found: [provided=%s]", *artifact))
+			os.Exit(1)
+		} else if err != nil {
+			panic(err)
+		}
+		if err := provenance.NormalizeSubjectNames(actual, *namePolicy); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		var claimed []provenance.Subject
+		for _, proof := range proofs {
+			claimed = append(claimed, provenance.Subject{Name: proof.Name, Digest: proof.Digest})
+		}
+		problems = append(problems, verifySubjects(claimed, actual, false)...)
+	}
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		os.Exit(1)
+	}
+	fmt.Println(fmt.Sprintf("Verified %d inclusion proofs against %s", len(proofs), *provenancePath))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// monorepoComponent is a component of a monorepo: the artifacts under
+// artifactDir, built from the sources under path at commit, the last
+// commit that changed them.
+type monorepoComponent struct {
+	path        string
+	artifactDir string
+	commit      string
+}
+
+// parseMonorepoMap returns the components of the "mappings"
+// ("pathprefix=artifactdir") with the commits of "commits"
+// ("pathprefix=commit"). Components without a commit are recorded at the
+// commit of the build.
+func parseMonorepoMap(mappings, commits []string) ([]monorepoComponent, error) {
+	byPath := map[string]string{}
+	for _, c := range commits {
+		i := strings.Index(c, "=")
+		if i <= 0 || i == len(c)-1 {
+			return nil, fmt.Errorf("invalid component commit %q, expected 'pathprefix=commit'", c)
+		}
+		byPath[path.Clean(c[:i])] = c[i+1:]
+	}
+	var components []monorepoComponent
+	for _, m := range mappings {
+		i := strings.Index(m, "=")
+		if i <= 0 || i == len(m)-1 {
+			return nil, fmt.Errorf("invalid monorepo mapping %q, expected 'pathprefix=artifactdir'", m)
+		}
+		prefix := path.Clean(strings.TrimPrefix(m[:i], "./"))
+		if path.IsAbs(prefix) || prefix == "." || prefix == ".." || strings.HasPrefix(prefix, "../") {
+			return nil, fmt.Errorf("invalid monorepo mapping %q, the path prefix must be in the repository", m)
+		}
+		dir, err := filepath.Abs(m[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		components = append(components, monorepoComponent{path: prefix, artifactDir: dir, commit: byPath[prefix]})
+	}
+	return components, nil
+}
+
+// componentSubjects groups "subjects" by the component whose artifact
+// directory the file they were digested from is in, the innermost when
+// directories are nested, and returns those of no component separately.
+func componentSubjects(components []monorepoComponent, subjects []provenance.Subject) ([][]provenance.Subject, []provenance.Subject) {
+	grouped := make([][]provenance.Subject, len(components))
+	var rest []provenance.Subject
+	for _, subject := range subjects {
+		file := subject.File
+		if subject.Archive != "" {
+			file = subject.Archive
+		}
+		match := -1
+		if file != "" {
+			abs, _ := filepath.Abs(file)
+			for i, c := range components {
+				if strings.HasPrefix(abs, c.artifactDir+string(filepath.Separator)) && (match < 0 || len(c.artifactDir) > len(components[match].artifactDir)) {
+					match = i
+				}
+			}
+		}
+		if match < 0 {
+			rest = append(rest, subject)
+			continue
+		}
+		grouped[match] = append(grouped[match], subject)
+	}
+	return grouped, rest
+}
+
+// componentStatement returns the statement about "subjects" of the
+// component "c", with the source material of "stmt" scoped to the path of
+// the component (e.g. "git+https://github.com/org/repo@refs/heads/main#services/api")
+// at its commit.
+func componentStatement(stmt provenance.Statement, c monorepoComponent, subjects []provenance.Subject) (provenance.Statement, error) {
+	source, ok := definedInMaterial(stmt.Predicate)
+	if !ok {
+		return provenance.Statement{}, fmt.Errorf("no source material")
+	}
+	commit := c.commit
+	if commit == "" {
+		commit = source.Digest["sha1"]
+	}
+	materials := append([]provenance.Item{}, stmt.Predicate.Materials...)
+	materials[stmt.Predicate.Recipe.DefinedInMaterial] = provenance.Item{URI: source.URI + "#" + c.path, Digest: provenance.DigestSet{"sha1": commit}}
+	stmt.Predicate.Materials = materials
+	stmt.Subject = subjects
+	return stmt, nil
+}
+
+// componentOutputPath returns the path the statement of the component at
+// "prefix" is written to: "outputPath" named after it, e.g.
+// "provenance-services-api.json".
+func componentOutputPath(outputPath, prefix string) string {
+	ext := filepath.Ext(outputPath)
+	return strings.TrimSuffix(outputPath, ext) + "-" + nonPluginIDCharacters.ReplaceAllString(prefix, "-") + ext
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// npmPackage is an npm package tarball among the subjects, with the
+// subject npm expects provenance of it to be about.
+type npmPackage struct {
+	file    string
+	subject provenance.Subject
+}
+
+// npmPackages returns the npm package tarballs among "subjects", each with
+// the subject `npm publish --provenance-file` checks the bundle against:
+// the package URL (e.g. "pkg:npm/%40acme/app@1.2.0") with the sha512 digest
+// of the tarball.
+func npmPackages(subjects []provenance.Subject) ([]npmPackage, error) {
+	var packages []npmPackage
+	for _, subject := range subjects {
+		if subject.File == "" || subject.Archive != "" {
+			continue
+		}
+		purl, err := detectPurl(subject.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %s", subject.File, err)
+		}
+		if !strings.HasPrefix(purl, "pkg:npm/") {
+			continue
+		}
+		digest := subject.Digest["sha512"]
+		if digest == "" {
+			return nil, fmt.Errorf("%s has no sha512 digest", subject.File)
+		}
+		packages = append(packages, npmPackage{file: subject.File, subject: provenance.Subject{Name: purl, Digest: provenance.DigestSet{"sha512": digest}}})
+	}
+	return packages, nil
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/sign"
+)
+
+// StdioPath is the path ("-") of standard input, when read, or standard
+// output, when written.
+const StdioPath = "-"
+
+// stdout is where attestations written to StdioPath go.
+var stdout io.Writer = os.Stdout
+
+// logToStderr moves what the command prints to standard error when it
+// writes its attestation to "path" StdioPath, so pipes only get the
+// attestation.
+func logToStderr(path string) {
+	if path == StdioPath {
+		os.Stdout = os.Stderr
+	}
+}
+
+// readFileOrStdin reads the file at "path", or standard input for StdioPath.
+func readFileOrStdin(path string) ([]byte, error) {
+	if path == StdioPath {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// Encodings of --payload_encoding, the form of the statement that is signed.
+const (
+	// PayloadEncodingJCS signs the RFC 8785 canonical JSON of the statement.
+	PayloadEncodingJCS = "jcs"
+	// PayloadEncodingJSON signs its HTML-unescaped encoding/json encoding,
+	// as earlier versions did.
+	PayloadEncodingJSON = "json"
+)
+
+// validOutputFlags checks the values of --output_format, --attach_mode and
+// --payload_encoding, which every command writing attestations takes.
+func validOutputFlags(format, attachMode, payloadEncoding string) error {
+	if format != "" && format != "sigstore-bundle" {
+		return fmt.Errorf("Unknown output format: [provided=%s]", format)
+	}
+	if attachMode != AttachModeTag && attachMode != AttachModeReferrers && attachMode != AttachModeBoth {
+		return fmt.Errorf("Unknown attach mode: [provided=%s]", attachMode)
+	}
+	if payloadEncoding != PayloadEncodingJCS && payloadEncoding != PayloadEncodingJSON {
+		return fmt.Errorf("Unknown payload encoding: [provided=%s]", payloadEncoding)
+	}
+	return nil
+}
+
+// outputOptions controls how an attestation is encoded, signed and where
+// it is written.
+type outputOptions struct {
+	path     string
+	pathSet  bool
+	preset   string
+	signKeys []string
+	tsaURL   string
+	// format is "" for a statement or envelope, or "sigstore-bundle".
+	format   string
+	rekorURL string
+	// attachTo is an image pinned by digest the signed envelope is also
+	// pushed to, stored as selected by attachMode (an AttachMode constant).
+	attachTo   string
+	attachMode string
+	style      OutputStyle
+	// legacyPayload signs the HTML-unescaped json encoding of the statement
+	// instead of its RFC 8785 canonical form.
+	legacyPayload bool
+	// upload, when set, copies the written file to object storage.
+	upload *Upload
+	// properties describe the attestation (e.g. "build.url") to stores
+	// that index them, next to the digests of its subjects.
+	properties map[string][]string
+	// artifacts, when enabled, uploads the written file as a job artifact.
+	artifacts *ArtifactUpload
+	// archivistaURL, when set, is the Archivista server the signed envelope
+	// is stored in.
+	archivistaURL string
+	// githubRepo, when set, is the GitHub repository ("owner/name") whose
+	// artifact attestations the Sigstore bundle is published to.
+	githubRepo string
+}
+
+// writeAttestation encodes the statement "stmt" about "subjects" according
+// to "opts" and writes it out, returning the path it was written to.
+func writeAttestation(stmt interface{}, subjects []provenance.Subject, opts outputOptions) (string, error) {
+	violations, err := validateStatement(stmt)
+	if err != nil {
+		return "", err
+	}
+	if len(violations) > 0 {
+		return "", fmt.Errorf("statement does not match its schema:\n  %s", strings.Join(violations, "\n  "))
+	}
+	preset, err := lookupPreset(opts.preset)
+	if err != nil {
+		return "", err
+	}
+	path := opts.path
+	if opts.preset != "" && !opts.pathSet {
+		if path, err = preset.outputPath(subjects); err != nil {
+			return "", fmt.Errorf("failed to apply preset %s: %s", opts.preset, err)
+		}
+	}
+	// NOTE: At L1, writing the in-toto Statement type is sufficient but, at
+	// higher SLSA levels, the Statement must be encoded and wrapped in an
+	// Envelope to support attaching signatures.
+	var document interface{} = stmt
+	if opts.attachTo != "" && len(opts.signKeys) == 0 {
+		return "", fmt.Errorf("attaching to an image requires a signing key")
+	}
+	if opts.archivistaURL != "" && len(opts.signKeys) == 0 {
+		return "", fmt.Errorf("storing in Archivista requires a signing key")
+	}
+	if opts.githubRepo != "" && opts.format != "sigstore-bundle" {
+		return "", fmt.Errorf("publishing to GitHub requires the sigstore-bundle output format")
+	}
+	if len(opts.signKeys) > 0 || preset.Envelope {
+		signers, err := sign.NewSigners(opts.signKeys)
+		if err != nil {
+			return "", fmt.Errorf("failed to load signing key: %s", err)
+		}
+		statement, err := provenance.CanonicalMarshal(stmt)
+		if opts.legacyPayload {
+			statement, err = provenance.EscapedMarshal(stmt)
+		}
+		if err != nil {
+			return "", err
+		}
+		envelope, err := sign.SignEnvelope(statement, signers...)
+		if err != nil {
+			return "", fmt.Errorf("failed to sign: %s", err)
+		}
+		if opts.tsaURL != "" {
+			if err := sign.TimestampEnvelope(envelope, opts.tsaURL); err != nil {
+				return "", fmt.Errorf("failed to timestamp: %s", err)
+			}
+		}
+		document = envelope
+		if opts.attachTo != "" {
+			attestations, err := attachAttestation(opts.attachTo, envelope, opts.attachMode)
+			if err != nil {
+				return "", fmt.Errorf("failed to attach to %s: %s", opts.attachTo, err)
+			}
+			if err := checkAttached(opts.attachTo, envelope, attestations); err != nil {
+				return "", fmt.Errorf("integrity check failed: %s", err)
+			}
+			for _, attestation := range attestations {
+				fmt.Println("Attached attestation: " + attestation)
+			}
+		}
+		if opts.archivistaURL != "" {
+			gitoid, err := storeInArchivista(opts.archivistaURL, envelope)
+			if err != nil {
+				return "", fmt.Errorf("failed to store in Archivista: %s", err)
+			}
+			if err := checkStored(opts.archivistaURL, gitoid, envelope); err != nil {
+				return "", fmt.Errorf("integrity check failed: %s", err)
+			}
+			fmt.Println("Stored attestation in Archivista: " + gitoid)
+		}
+		if opts.format == "sigstore-bundle" {
+			if len(signers) != 1 {
+				return "", fmt.Errorf("sigstore bundles require exactly one signing key")
+			}
+			bundle, err := newSigstoreBundle(envelope, signers[0], opts.rekorURL)
+			if err != nil {
+				return "", fmt.Errorf("failed to create sigstore bundle: %s", err)
+			}
+			document = bundle
+			if opts.githubRepo != "" {
+				id, err := publishToGitHub(opts.githubRepo, bundle)
+				if err != nil {
+					return "", fmt.Errorf("failed to publish to GitHub: %s", err)
+				}
+				if err := checkPublished(opts.githubRepo, subjects, bundle); err != nil {
+					return "", fmt.Errorf("integrity check failed: %s", err)
+				}
+				fmt.Println(fmt.Sprintf("Published attestation to GitHub: %s (id %d)", opts.githubRepo, id))
+			}
+		}
+	} else if opts.format == "sigstore-bundle" {
+		return "", fmt.Errorf("sigstore bundles require a signing key")
+	}
+	style := opts.style
+	if style.Format == "" {
+		style.Format = JSONIndented
+		if preset.Compact {
+			style.Format = JSONCompact
+		}
+	}
+	payload, err := style.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+	if path == StdioPath {
+		if (opts.upload != nil && opts.upload.URL != "") || (opts.artifacts != nil && opts.artifacts.Enabled) {
+			return "", fmt.Errorf("attestations written to standard output cannot be uploaded")
+		}
+		_, err := stdout.Write(payload)
+		return path, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(path, payload, 0755); err != nil {
+		return "", err
+	}
+	if err := checkWritten(path, payload); err != nil {
+		return "", fmt.Errorf("integrity check failed: %s", err)
+	}
+	if err := publishAttestation(path, payload, subjects, opts); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// publishAttestation copies the attestation "payload" about "subjects",
+// written to "path", to the object storage and job artifacts of "opts".
+func publishAttestation(path string, payload []byte, subjects []provenance.Subject, opts outputOptions) error {
+	if opts.upload != nil && opts.upload.URL != "" {
+		properties := map[string][]string{}
+		for name, values := range opts.properties {
+			properties[name] = values
+		}
+		for _, subject := range subjects {
+			if digest := subject.Digest["sha256"]; digest != "" {
+				properties["provenance.subject.sha256"] = append(properties["provenance.subject.sha256"], digest)
+			}
+		}
+		location, err := opts.upload.upload(path, payload, properties)
+		if err != nil {
+			return fmt.Errorf("failed to upload: %s", err)
+		}
+		if err := checkUploaded(opts.upload, path, payload); err != nil {
+			return fmt.Errorf("integrity check failed: %s", err)
+		}
+		fmt.Println("Uploaded attestation: " + location)
+	}
+	if opts.artifacts != nil && opts.artifacts.Enabled {
+		if err := opts.artifacts.upload(path); err != nil {
+			return fmt.Errorf("failed to upload artifact: %s", err)
+		}
+		fmt.Println("Uploaded artifact: " + path)
+	}
+	return nil
+}
+
+// JSON output formats.
+const (
+	JSONCompact  = "compact"
+	JSONIndented = "indented"
+	// JSONCanonical is the RFC 8785 JSON Canonicalization Scheme.
+	JSONCanonical = "canonical"
+)
+
+// OutputStyle controls how written documents are serialized, so files
+// match what downstream diff and verification tools expect.
+type OutputStyle struct {
+	// Format is one of the JSON format constants; "" defers to the preset.
+	Format string
+	// SortKeys orders object keys lexicographically instead of in struct
+	// declaration order.
+	SortKeys bool
+	// OmitTrailingNewline leaves out the newline ending the file.
+	OmitTrailingNewline bool
+}
+
+// addOutputStyleFlags registers the output style flags on "fs".
+func addOutputStyleFlags(fs *flag.FlagSet) *OutputStyle {
+	style := &OutputStyle{}
+	fs.StringVar(&style.Format, "json_format", "", "The JSON layout: 'compact', 'indented' or 'canonical' (RFC 8785); defaults to the preset's.")
+	fs.BoolVar(&style.SortKeys, "json_sort_keys", false, "Sort object keys instead of keeping the field order of the statement.")
+	fs.BoolVar(&style.OmitTrailingNewline, "no_trailing_newline", false, "Do not end the written file with a newline.")
+	return style
+}
+
+// Marshal serializes "v" in the style.
+func (s OutputStyle) Marshal(v interface{}) ([]byte, error) {
+	var payload []byte
+	var err error
+	switch s.Format {
+	case JSONCanonical:
+		// Canonical JSON always sorts keys.
+		payload, err = provenance.CanonicalMarshal(v)
+	case JSONCompact, JSONIndented:
+		if s.SortKeys {
+			if v, err = sortedKeys(v); err != nil {
+				return nil, err
+			}
+		}
+		if s.Format == JSONCompact {
+			payload, err = provenance.EscapedMarshal(v)
+		} else {
+			payload, err = provenance.EscapedMarshalIndent(v, "", "  ")
+		}
+	default:
+		return nil, fmt.Errorf("unknown JSON format %q", s.Format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	payload = bytes.TrimRight(payload, "\n")
+	if !s.OmitTrailingNewline {
+		payload = append(payload, '\n')
+	}
+	return payload, nil
+}
+
+// sortedKeys round-trips "v" through generic JSON values, whose objects
+// encoding/json writes with sorted keys. Numbers are kept verbatim.
+func sortedKeys(v interface{}) (interface{}, error) {
+	encoded, err := provenance.EscapedMarshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var value interface{}
+	return value, decoder.Decode(&value)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/giturl"
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// pipelineMaterial returns the material recording the pipeline definition
+// at "path", checked out in "checkoutDir" from the source material of "p",
+// as that file at the commit (e.g.
+// "git+https://github.com/org/repo@<commit>#.buildkite/pipeline.yml"), so
+// verifiers can tie the provenance to the exact pipeline and not just the
+// repository.
+func pipelineMaterial(path, checkoutDir string, p provenance.Predicate, algorithms []string) (provenance.Item, error) {
+	source, ok := definedInMaterial(p)
+	if !ok || source.Digest["sha1"] == "" {
+		return provenance.Item{}, fmt.Errorf("no source material with a commit")
+	}
+	abspath, err := filepath.Abs(path)
+	if err != nil {
+		return provenance.Item{}, err
+	}
+	root, err := filepath.Abs(checkoutDir)
+	if err != nil {
+		return provenance.Item{}, err
+	}
+	rel, err := filepath.Rel(root, abspath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return provenance.Item{}, fmt.Errorf("%s is not in the checkout %s", path, checkoutDir)
+	}
+	digest, err := provenance.DigestFile(path, algorithms)
+	if err != nil {
+		return provenance.Item{}, err
+	}
+	repository, _ := giturl.SplitSourceURI(source.URI)
+	return provenance.Item{URI: repository + "@" + source.Digest["sha1"] + "#" + filepath.ToSlash(rel), Digest: digest}, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// platformSyntax matches an "<os>-<arch>" platform segment in a file name,
+// as produced by most release tooling (e.g. "tool_linux_arm64.tar.gz").
+var platformSyntax = regexp.MustCompile(`[-_.](linux|darwin|windows|freebsd|netbsd|openbsd)[-_.](amd64|x86_64|arm64|aarch64|386|armv7|arm|ppc64le|s390x|riscv64)`)
+
+// archAliases maps alternative architecture names to their GOARCH form.
+var archAliases = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+}
+
+// normalizePlatforms annotates subjects with the platform they were built
+// for and the logical artifact they are a build of, so subjects built per
+// platform can be matched against a single logical name. When "platform"
+// is set it applies to every subject, otherwise the platform is detected
+// from each subject's name and subjects without one are left untouched.
+func normalizePlatforms(subjects []provenance.Subject, platform string) {
+	for i := range subjects {
+		name := subjects[i].Name
+		logical, detected := name, platform
+		if detected == "" {
+			m := platformSyntax.FindStringSubmatchIndex(name)
+			if m == nil {
+				continue
+			}
+			goos, goarch := name[m[2]:m[3]], name[m[4]:m[5]]
+			if alias, ok := archAliases[goarch]; ok {
+				goarch = alias
+			}
+			detected = goos + "/" + goarch
+			logical = name[:m[0]] + name[m[1]:]
+		}
+		logical = strings.TrimSuffix(logical, ".exe")
+		if subjects[i].Annotations == nil {
+			subjects[i].Annotations = map[string]string{}
+		}
+		subjects[i].Annotations["platform"] = detected
+		subjects[i].Annotations["logicalName"] = logical
+	}
+}
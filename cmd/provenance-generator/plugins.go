@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/giturl"
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// nonPluginIDCharacters are replaced with "-" in the names of the
+// directories the Buildkite agent checks plugins out to.
+var nonPluginIDCharacters = regexp.MustCompile(`[^a-zA-Z0-9]`)
+
+// pluginMaterials returns a material for each plugin of the step in
+// "pluginsJSON" ($BUILDKITE_PLUGINS): its repository at the version used
+// (e.g. "git+https://github.com/buildkite-plugins/docker-buildkite-plugin@v5.9.0")
+// with the commit checked out under "pluginsDir", when given, as its sha1
+// digest. Plugins run arbitrary code during the build, so they are inputs
+// of it like the source.
+func pluginMaterials(pluginsJSON, pluginsDir string) ([]provenance.Item, error) {
+	labels, err := pluginLabels(pluginsJSON)
+	if err != nil {
+		return nil, err
+	}
+	var materials []provenance.Item
+	for _, label := range labels {
+		dir := ""
+		if pluginsDir != "" {
+			dir = filepath.Join(pluginsDir, pluginDirName(label))
+		}
+		material, err := pluginMaterial(label, dir)
+		if err != nil {
+			return nil, err
+		}
+		materials = append(materials, material)
+	}
+	return materials, nil
+}
+
+// pluginLabels returns the labels (e.g. "docker#v5.9.0") of the plugins in
+// "pluginsJSON" ($BUILDKITE_PLUGINS), in order.
+func pluginLabels(pluginsJSON string) ([]string, error) {
+	var plugins []map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(pluginsJSON), &plugins); err != nil {
+		return nil, fmt.Errorf("invalid BUILDKITE_PLUGINS: %s", err)
+	}
+	var labels []string
+	for _, plugin := range plugins {
+		for label := range plugin {
+			labels = append(labels, label)
+		}
+	}
+	return labels, nil
+}
+
+// pluginDirName returns the name of the directory the Buildkite agent
+// checks the plugin "label" out to.
+func pluginDirName(label string) string {
+	return nonPluginIDCharacters.ReplaceAllString(strings.ToLower(label), "-")
+}
+
+// pluginMaterial returns the material of the plugin "label", with the
+// commit checked out in "dir", when given and checked out, as its digest.
+func pluginMaterial(label, dir string) (provenance.Item, error) {
+	location, version := label, ""
+	if i := strings.LastIndex(label, "#"); i >= 0 {
+		location, version = label[:i], label[i+1:]
+	}
+	var uri string
+	if strings.HasPrefix(location, ".") || strings.HasPrefix(location, "/") {
+		// Plugins in the repository are covered by the source.
+		uri = "file:" + strings.TrimPrefix(filepath.ToSlash(location), "./")
+	} else {
+		if !strings.Contains(location, "://") && !strings.Contains(location, "@") {
+			// Shorthands name GitHub repositories: "docker" is
+			// buildkite-plugins/docker-buildkite-plugin and "org/name" is
+			// org/name-buildkite-plugin.
+			switch parts := strings.Split(location, "/"); {
+			case len(parts) == 1:
+				location = "github.com/buildkite-plugins/" + location + "-buildkite-plugin"
+			case len(parts) == 2 && !strings.Contains(parts[0], "."):
+				location = "github.com/" + location + "-buildkite-plugin"
+			}
+			location = "https://" + location
+		}
+		u, err := giturl.Parse(location)
+		if err != nil {
+			return provenance.Item{}, fmt.Errorf("invalid plugin %s: %s", label, err)
+		}
+		if uri, err = giturl.RepositoryURI(u); err != nil {
+			return provenance.Item{}, fmt.Errorf("invalid plugin %s: %s", label, err)
+		}
+	}
+	if version != "" {
+		uri += "@" + version
+	}
+	material := provenance.Item{URI: uri, Digest: provenance.DigestSet{}}
+	if dir != "" {
+		if commit, err := gitHead(dir); err == nil {
+			material.Digest["sha1"] = commit
+		} else if !os.IsNotExist(err) {
+			return provenance.Item{}, fmt.Errorf("failed to resolve plugin %s: %s", label, err)
+		}
+	}
+	return material, nil
+}
+
+// gitHead returns the commit checked out in the Git repository at "dir",
+// reading .git directly since the generator's image has no git. A .git
+// file (e.g. of submodules) points to the Git directory.
+func gitHead(dir string) (string, error) {
+	gitDir := filepath.Join(dir, ".git")
+	if info, err := os.Stat(gitDir); err != nil {
+		return "", err
+	} else if !info.IsDir() {
+		link, err := ioutil.ReadFile(gitDir)
+		if err != nil {
+			return "", err
+		}
+		target := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(string(link)), "gitdir:"))
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(dir, target)
+		}
+		gitDir = target
+	}
+	head, err := ioutil.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return "", err
+	}
+	ref := strings.TrimSpace(string(head))
+	if !strings.HasPrefix(ref, "ref: ") {
+		return ref, nil
+	}
+	ref = strings.TrimPrefix(ref, "ref: ")
+	if commit, err := ioutil.ReadFile(filepath.Join(gitDir, filepath.FromSlash(ref))); err == nil {
+		return strings.TrimSpace(string(commit)), nil
+	}
+	packed, err := os.Open(filepath.Join(gitDir, "packed-refs"))
+	if err != nil {
+		return "", fmt.Errorf("ref %s of %s not found", ref, dir)
+	}
+	defer packed.Close()
+	scanner := bufio.NewScanner(packed)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) == 2 && fields[1] == ref {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("ref %s of %s not found", ref, dir)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/sign"
+)
+
+// ClusterImagePolicyAPIVersion is the sigstore policy-controller API the
+// generated policies are written for.
+const ClusterImagePolicyAPIVersion = "policy.sigstore.dev/v1beta1"
+
+// ClusterImagePolicy is the sigstore policy-controller resource admitting
+// images only when they carry an attestation signed by one of the
+// generator's keys. Kubernetes accepts it as JSON, e.g. with
+// `kubectl apply -f`.
+type ClusterImagePolicy struct {
+	APIVersion string                 `json:"apiVersion"`
+	Kind       string                 `json:"kind"`
+	Metadata   PolicyMetadata         `json:"metadata"`
+	Spec       ClusterImagePolicySpec `json:"spec"`
+}
+
+type PolicyMetadata struct {
+	Name string `json:"name"`
+}
+
+type ClusterImagePolicySpec struct {
+	Images      []PolicyImage     `json:"images"`
+	Authorities []PolicyAuthority `json:"authorities"`
+}
+
+type PolicyImage struct {
+	Glob string `json:"glob"`
+}
+
+// PolicyAuthority requires the attestations to be signed by "Key".
+type PolicyAuthority struct {
+	Name         string              `json:"name"`
+	Key          PolicyKey           `json:"key"`
+	CTLog        *PolicyCTLog        `json:"ctlog,omitempty"`
+	Attestations []PolicyAttestation `json:"attestations"`
+}
+
+type PolicyKey struct {
+	Data          string `json:"data"`
+	HashAlgorithm string `json:"hashAlgorithm"`
+}
+
+type PolicyCTLog struct {
+	URL string `json:"url"`
+}
+
+// PolicyAttestation matches attestations on their statement's predicate
+// type and, with "Policy", evaluates the statement against it.
+type PolicyAttestation struct {
+	Name          string      `json:"name"`
+	PredicateType string      `json:"predicateType"`
+	Policy        *PolicyBody `json:"policy,omitempty"`
+}
+
+type PolicyBody struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+var policyNameInvalid = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// newClusterImagePolicy returns the policy admitting the image "image" when
+// its provenance "stmt", attached with "mode", was signed by "signers". It
+// fails when policy-controller could not match the attestation: it only
+// reads attestations from the cosign tag and requires the image among the
+// statement's subjects.
+func newClusterImagePolicy(image, mode string, stmt provenance.Statement, signers []sign.Signer, rekorURL string) (*ClusterImagePolicy, error) {
+	ref, err := ParseImageRef(image)
+	if err != nil {
+		return nil, err
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("a ClusterImagePolicy requires a signing key")
+	}
+	if mode == AttachModeReferrers {
+		return nil, fmt.Errorf("policy-controller reads attestations from the cosign tag, but attach mode is %q", mode)
+	}
+	subject := false
+	for _, s := range stmt.Subject {
+		subject = subject || "sha256:"+s.Digest["sha256"] == ref.Digest
+	}
+	if !subject {
+		return nil, fmt.Errorf("image %s is not a subject of the provenance; add it with --image_ref", image)
+	}
+
+	registry := ref.Registry
+	if registry == "docker.io" {
+		registry = "index.docker.io"
+	}
+	name := ref.Repository[strings.LastIndex(ref.Repository, "/")+1:]
+	name = strings.Trim(policyNameInvalid.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	policy := &ClusterImagePolicy{
+		APIVersion: ClusterImagePolicyAPIVersion,
+		Kind:       "ClusterImagePolicy",
+		Metadata:   PolicyMetadata{Name: "buildkite-provenance-" + name},
+		Spec: ClusterImagePolicySpec{
+			Images: []PolicyImage{{Glob: registry + "/" + ref.Repository + "*"}},
+		},
+	}
+	cue := fmt.Sprintf("predicateType: %q\npredicate: recipe: type: %q\npredicate: builder: id: =~%q\n",
+		stmt.PredicateType, stmt.Predicate.Recipe.Type, "^"+regexp.QuoteMeta(stmt.Predicate.Builder.Id[:strings.LastIndex(stmt.Predicate.Builder.Id, "/")+1]))
+	for i, signer := range signers {
+		der, err := signer.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public key of %s: %s", signer.KeyID(), err)
+		}
+		authority := PolicyAuthority{
+			Name: fmt.Sprintf("buildkite-key-%d", i),
+			Key: PolicyKey{
+				Data:          string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+				HashAlgorithm: "sha256",
+			},
+			Attestations: []PolicyAttestation{{
+				Name:          "buildkite-provenance",
+				PredicateType: stmt.PredicateType,
+				Policy:        &PolicyBody{Type: "cue", Data: cue},
+			}},
+		}
+		if rekorURL != "" {
+			authority.CTLog = &PolicyCTLog{URL: rekorURL}
+		}
+		policy.Spec.Authorities = append(policy.Spec.Authorities, authority)
+	}
+	return policy, nil
+}
+
+func writeClusterImagePolicy(path string, policy *ClusterImagePolicy) error {
+	payload, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(payload, '\n'), 0644)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+)
+
+// companionAttestation is an attestation about the subjects of the
+// provenance, such as an SBOM, written to "path" next to it.
+type companionAttestation struct {
+	predicateType string
+	predicate     json.RawMessage
+	path          string
+	// kind names the attestation in messages, e.g. "SBOM".
+	kind string
+}
+
+// validPredicateType reports whether "predicateType" is an absolute URI, as
+// in-toto requires.
+func validPredicateType(predicateType string) bool {
+	u, err := url.Parse(predicateType)
+	return err == nil && u.IsAbs()
+}
+
+// readPredicate reads the JSON object at "path".
+func readPredicate(path string) (json.RawMessage, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var predicate map[string]interface{}
+	if err := json.Unmarshal(contents, &predicate); err != nil || predicate == nil {
+		return nil, fmt.Errorf("%s is not a JSON object", path)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, contents); err != nil {
+		return nil, err
+	}
+	return compact.Bytes(), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// Preset bundles the output conventions expected by an existing family of
+// verifiers.
+type Preset struct {
+	// OutputPath is the path, relative to the upload root, the provenance is
+	// written to. "{subject}" expands to the base name of the only subject,
+	// or "multiple", and "{digest}" to the sha256 digest of the only subject.
+	OutputPath string
+	// Envelope wraps the statement in a DSSE envelope even when unsigned.
+	Envelope bool
+	// Compact writes the document on a single line (JSON Lines).
+	Compact bool
+}
+
+var presets = map[string]Preset{
+	"slsa-github-style": {
+		OutputPath: "{subject}.intoto.jsonl",
+		Envelope:   true,
+		Compact:    true,
+	},
+	"cosign-style": {
+		OutputPath: "attestations/sha256-{digest}.att",
+		Envelope:   true,
+		Compact:    true,
+	},
+	"witness-style": {
+		OutputPath: "attestations/{subject}.attestation.json",
+		Envelope:   true,
+		Compact:    false,
+	},
+}
+
+// lookupPreset returns the named preset, or the zero Preset when "name" is
+// empty.
+func lookupPreset(name string) (Preset, error) {
+	if name == "" {
+		return Preset{}, nil
+	}
+	preset, ok := presets[name]
+	if !ok {
+		return Preset{}, fmt.Errorf("unknown preset %q", name)
+	}
+	return preset, nil
+}
+
+// outputPath expands the preset's output path for "subjects".
+func (p Preset) outputPath(subjects []provenance.Subject) (string, error) {
+	name, digest := "multiple", ""
+	if len(subjects) == 1 {
+		name = filepath.Base(subjects[0].Name)
+		digest = subjects[0].Digest["sha256"]
+	}
+	if strings.Contains(p.OutputPath, "{digest}") && digest == "" {
+		return "", fmt.Errorf("output path %q requires exactly one subject", p.OutputPath)
+	}
+	return strings.NewReplacer("{subject}", name, "{digest}", digest).Replace(p.OutputPath), nil
+}
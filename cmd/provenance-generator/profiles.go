@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path"
+)
+
+// ProfileConfig is a file of named configuration profiles, so one plugin
+// configuration can be shipped org-wide while teams get their own signing
+// keys, outputs and policies.
+type ProfileConfig struct {
+	Profiles []Profile `json:"profiles"`
+}
+
+// Profile sets generator flags for the pipelines it applies to.
+type Profile struct {
+	Name string `json:"name"`
+	// Pipelines are glob patterns (e.g. "payments-*") of the pipeline slugs
+	// the profile applies to. A profile without patterns applies to every
+	// pipeline, which makes it a fallback when listed last.
+	Pipelines []string `json:"pipelines"`
+	// Flags maps generator flag names (e.g. "sign_key", "preset",
+	// "trusted_builder") to a value, or to a list of values for
+	// repeatable flags.
+	Flags map[string]interface{} `json:"flags"`
+}
+
+// loadProfiles reads the profile configuration at "file".
+func loadProfiles(file string) (ProfileConfig, error) {
+	var config ProfileConfig
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(contents, &config); err != nil {
+		return config, fmt.Errorf("failed to parse %s: %s", file, err)
+	}
+	names := map[string]bool{}
+	for _, profile := range config.Profiles {
+		if profile.Name == "" {
+			return config, fmt.Errorf("profile without a name in %s", file)
+		}
+		if names[profile.Name] {
+			return config, fmt.Errorf("duplicate profile %q in %s", profile.Name, file)
+		}
+		names[profile.Name] = true
+		for _, pattern := range profile.Pipelines {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return config, fmt.Errorf("invalid pipeline pattern %q in profile %q", pattern, profile.Name)
+			}
+		}
+	}
+	return config, nil
+}
+
+// selectProfile returns the profile named "name" or, when "name" is empty,
+// the first profile whose patterns match the pipeline "slug". It returns
+// nil when no profile applies.
+func (c ProfileConfig) selectProfile(name, slug string) (*Profile, error) {
+	for i, profile := range c.Profiles {
+		if name != "" {
+			if profile.Name == name {
+				return &c.Profiles[i], nil
+			}
+			continue
+		}
+		if len(profile.Pipelines) == 0 || matchAny(profile.Pipelines, slug) {
+			return &c.Profiles[i], nil
+		}
+	}
+	if name != "" {
+		return nil, fmt.Errorf("unknown profile %q", name)
+	}
+	return nil, nil
+}
+
+// apply sets the profile's flags on "fs". Flags passed on the command line
+// take precedence over the profile.
+func (p *Profile) apply(fs *flag.FlagSet) error {
+	for name, value := range p.Flags {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("profile %q sets unknown flag %q", p.Name, name)
+		}
+		if flagSetPassed(fs, name) {
+			continue
+		}
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		for _, v := range values {
+			var s string
+			switch v := v.(type) {
+			case string:
+				s = v
+			case bool, float64:
+				s = fmt.Sprint(v)
+			default:
+				return fmt.Errorf("profile %q sets flag %q to an unsupported value", p.Name, name)
+			}
+			if err := fs.Set(name, s); err != nil {
+				return fmt.Errorf("profile %q sets flag %q: %s", p.Name, name, err)
+			}
+		}
+	}
+	return nil
+}
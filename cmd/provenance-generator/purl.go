@@ -0,0 +1,371 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"debug/buildinfo"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// Package URLs (https://github.com/package-url/purl-spec) name subjects so
+// provenance can be joined with SBOMs and vulnerability data.
+
+// purlMapping gives the subjects whose name matches "pattern" the purl
+// "purl".
+type purlMapping struct {
+	pattern string
+	purl    string
+}
+
+var purlSyntax = regexp.MustCompile(`^pkg:[A-Za-z.+-][A-Za-z0-9.+-]*/[^?#]+`)
+
+// parsePurlMapping parses "name=pkg:type/namespace/name@version", where
+// "name" is a subject name or a glob pattern of them.
+func parsePurlMapping(value string) (purlMapping, error) {
+	i := strings.Index(value, "=pkg:")
+	if i <= 0 {
+		return purlMapping{}, fmt.Errorf("%q is not name=pkg:type/name@version", value)
+	}
+	m := purlMapping{pattern: value[:i], purl: value[i+1:]}
+	if _, err := path.Match(m.pattern, ""); err != nil {
+		return purlMapping{}, fmt.Errorf("invalid pattern %q", m.pattern)
+	}
+	if !purlSyntax.MatchString(m.purl) {
+		return purlMapping{}, fmt.Errorf("invalid purl %q", m.purl)
+	}
+	return m, nil
+}
+
+// namePurls renames "subjects" after their purl: the purl of the first
+// mapping matching their name or, with "detect", the one detected from the
+// package metadata of their file or from their image. Subjects without a
+// purl keep their name.
+func namePurls(subjects []provenance.Subject, mappings []purlMapping, detect bool) error {
+	named := map[string]string{}
+	for i, subject := range subjects {
+		purl := ""
+		for _, m := range mappings {
+			if ok, _ := path.Match(m.pattern, subject.Name); ok {
+				purl = m.purl
+				break
+			}
+		}
+		if purl == "" && detect && subject.Image != "" {
+			// Image was made with ImageRef.String, so it parses.
+			image, _ := ParseImageRef(subject.Image)
+			purl = imagePurl(image, subject.Digest["sha256"])
+		} else if purl == "" && detect && subject.File != "" {
+			detected, err := detectPurl(subject.File)
+			if err != nil {
+				return fmt.Errorf("failed to read package metadata of %s: %s", subject.Name, err)
+			}
+			purl = detected
+		}
+		if purl == "" {
+			continue
+		}
+		if other, ok := named[purl]; ok {
+			return fmt.Errorf("%s and %s are both %s", other, subject.Name, purl)
+		}
+		named[purl] = subject.Name
+		subjects[i].Name = purl
+	}
+	return nil
+}
+
+// newPurl formats a purl from its components, percent-encoding them.
+func newPurl(typ, namespace, name, version string, qualifiers map[string]string) string {
+	var b strings.Builder
+	b.WriteString("pkg:" + typ + "/")
+	if namespace != "" {
+		for _, segment := range strings.Split(namespace, "/") {
+			b.WriteString(purlEscape(segment, false) + "/")
+		}
+	}
+	b.WriteString(purlEscape(name, false))
+	if version != "" {
+		b.WriteString("@" + purlEscape(version, false))
+	}
+	var keys []string
+	for key, value := range qualifiers {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		if i == 0 {
+			b.WriteByte('?')
+		} else {
+			b.WriteByte('&')
+		}
+		b.WriteString(key + "=" + purlEscape(qualifiers[key], true))
+	}
+	return b.String()
+}
+
+// purlEscape percent-encodes all but unreserved characters and, in
+// qualifier values, slashes.
+func purlEscape(s string, slash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || strings.IndexByte("-._~", c) >= 0 || (slash && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// imagePurl returns the pkg:docker purl of the manifest "digest" of image
+// "ref", with the registry as repository_url unless it is Docker Hub.
+func imagePurl(ref ImageRef, digest string) string {
+	namespace, name := "", ref.Repository
+	if i := strings.LastIndex(ref.Repository, "/"); i >= 0 {
+		namespace, name = ref.Repository[:i], ref.Repository[i+1:]
+	}
+	qualifiers := map[string]string{}
+	if ref.Registry != "docker.io" {
+		qualifiers["repository_url"] = ref.Registry
+	}
+	return newPurl("docker", namespace, name, "sha256:"+digest, qualifiers)
+}
+
+var (
+	gemFileName   = regexp.MustCompile(`^(.+)-(\d[^-]*)(?:-(.+))?\.gem$`)
+	nugetFileName = regexp.MustCompile(`^(.+?)\.(\d+(?:\.\d+)+(?:-[0-9A-Za-z.-]+)?)\.nupkg$`)
+	debFileName   = regexp.MustCompile(`^([^_]+)_([^_]+)_([^_]+)\.deb$`)
+	rpmFileName   = regexp.MustCompile(`^(.+)-([^-]+)-([^-]+)\.([^.]+)\.rpm$`)
+
+	pypiSeparators = regexp.MustCompile(`[-_.]+`)
+)
+
+// detectPurl detects the purl of the package file at "file" from its name
+// (gems, NuGet, Debian and RPM packages), its metadata (Maven jars, wars and
+// ears, Python wheels and sdists, and npm packages) or, for Go binaries,
+// their build information. It returns "" for other files.
+func detectPurl(file string) (string, error) {
+	base := filepath.Base(file)
+	lower := strings.ToLower(base)
+	switch {
+	case strings.HasSuffix(lower, ".whl"):
+		return wheelPurl(file)
+	case strings.HasSuffix(lower, ".gem"):
+		if m := gemFileName.FindStringSubmatch(base); m != nil {
+			return newPurl("gem", "", m[1], m[2], map[string]string{"platform": m[3]}), nil
+		}
+	case strings.HasSuffix(lower, ".nupkg"):
+		if m := nugetFileName.FindStringSubmatch(base); m != nil {
+			return newPurl("nuget", "", m[1], m[2], nil), nil
+		}
+	case strings.HasSuffix(lower, ".deb"):
+		if m := debFileName.FindStringSubmatch(base); m != nil {
+			return newPurl("deb", "", m[1], m[2], map[string]string{"arch": m[3]}), nil
+		}
+	case strings.HasSuffix(lower, ".rpm"):
+		if m := rpmFileName.FindStringSubmatch(base); m != nil {
+			return newPurl("rpm", "", m[1], m[2]+"-"+m[3], map[string]string{"arch": m[4]}), nil
+		}
+	case strings.HasSuffix(lower, ".jar") || strings.HasSuffix(lower, ".war") || strings.HasSuffix(lower, ".ear"):
+		return mavenPurl(file)
+	case strings.HasSuffix(lower, ".tgz") || strings.HasSuffix(lower, ".tar.gz"):
+		return tarballPurl(file)
+	default:
+		if info, err := buildinfo.ReadFile(file); err == nil && info.Main.Path != "" {
+			version := info.Main.Version
+			if version == "(devel)" {
+				version = ""
+			}
+			qualifiers := map[string]string{}
+			for _, setting := range info.Settings {
+				if setting.Key == "GOOS" || setting.Key == "GOARCH" {
+					qualifiers[strings.ToLower(setting.Key)] = setting.Value
+				}
+			}
+			namespace, name := path.Split(info.Main.Path)
+			return newPurl("golang", strings.TrimSuffix(namespace, "/"), name, version, qualifiers), nil
+		}
+	}
+	return "", nil
+}
+
+// pypiName normalizes a Python distribution name as PEP 503 and the purl
+// spec do.
+func pypiName(name string) string {
+	return strings.ToLower(pypiSeparators.ReplaceAllString(name, "-"))
+}
+
+// mavenPurl reads the coordinates of a jar, war or ear from the
+// pom.properties Maven packs into it. Shaded archives also hold those of
+// the artifacts shaded into them, so with several the one the file is named
+// after ("<artifactId>-<version>[-<classifier>]") is taken, and none when
+// no or more than one match. The classifier and, for wars and ears, the
+// type are recorded as qualifiers.
+func mavenPurl(file string) (string, error) {
+	r, err := zip.OpenReader(file)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	var found []map[string]string
+	for _, entry := range r.File {
+		if !strings.HasPrefix(entry.Name, "META-INF/maven/") || path.Base(entry.Name) != "pom.properties" {
+			continue
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			return "", err
+		}
+		properties := map[string]string{}
+		scanner := bufio.NewScanner(io.LimitReader(rc, maxLicenseScan))
+		for scanner.Scan() {
+			if kv := strings.SplitN(scanner.Text(), "=", 2); len(kv) == 2 && !strings.HasPrefix(kv[0], "#") {
+				properties[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+			}
+		}
+		rc.Close()
+		if properties["groupId"] != "" && properties["artifactId"] != "" {
+			found = append(found, properties)
+		}
+	}
+	ext := filepath.Ext(file)
+	stem := strings.TrimSuffix(filepath.Base(file), ext)
+	var match map[string]string
+	classifier := ""
+	for _, properties := range found {
+		prefix := properties["artifactId"] + "-" + properties["version"]
+		if stem != prefix && !strings.HasPrefix(stem, prefix+"-") {
+			continue
+		}
+		if match != nil {
+			return "", nil
+		}
+		match, classifier = properties, strings.TrimPrefix(strings.TrimPrefix(stem, prefix), "-")
+	}
+	if match == nil && len(found) == 1 {
+		match = found[0]
+	}
+	if match == nil {
+		return "", nil
+	}
+	qualifiers := map[string]string{"classifier": classifier}
+	if typ := strings.ToLower(strings.TrimPrefix(ext, ".")); typ != "jar" {
+		qualifiers["type"] = typ
+	}
+	return newPurl("maven", match["groupId"], match["artifactId"], match["version"], qualifiers), nil
+}
+
+// wheelPurl reads the name and version of a wheel from the METADATA of its
+// .dist-info directory, falling back to its file name, which escapes them
+// ("<name>-<version>-<python>-<abi>-<platform>.whl").
+func wheelPurl(file string) (string, error) {
+	base := filepath.Base(file)
+	qualifiers := map[string]string{"file_name": base}
+	var entries []*zip.File
+	if r, err := zip.OpenReader(file); err == nil {
+		defer r.Close()
+		entries = r.File
+	}
+	for _, entry := range entries {
+		dir, name := path.Split(entry.Name)
+		if name != "METADATA" || strings.Count(dir, "/") != 1 || !strings.HasSuffix(dir, ".dist-info/") {
+			continue
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			return "", err
+		}
+		contents, err := ioutil.ReadAll(io.LimitReader(rc, maxLicenseScan))
+		rc.Close()
+		if err != nil {
+			return "", err
+		}
+		if metadata := coreMetadata(contents); metadata["Name"] != "" {
+			return newPurl("pypi", "", pypiName(metadata["Name"]), metadata["Version"], qualifiers), nil
+		}
+	}
+	parts := strings.Split(strings.TrimSuffix(base, filepath.Ext(base)), "-")
+	if len(parts) < 5 {
+		return "", nil
+	}
+	return newPurl("pypi", "", pypiName(parts[0]), parts[1], qualifiers), nil
+}
+
+// coreMetadata returns the headers of Python core metadata (METADATA or
+// PKG-INFO), which end at the first empty line.
+func coreMetadata(contents []byte) map[string]string {
+	metadata := map[string]string{}
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			break
+		}
+		if kv := strings.SplitN(line, ":", 2); len(kv) == 2 {
+			if _, ok := metadata[kv[0]]; !ok {
+				metadata[kv[0]] = strings.TrimSpace(kv[1])
+			}
+		}
+	}
+	return metadata
+}
+
+// tarballPurl reads the name and version of an npm package from its
+// package/package.json, or of a Python sdist from its PKG-INFO.
+func tarballPurl(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", nil
+	}
+	r := tar.NewReader(gz)
+	for {
+		header, err := r.Next()
+		if err != nil {
+			// The end of the archive, or not a tar archive at all.
+			return "", nil
+		}
+		name := strings.TrimPrefix(header.Name, "./")
+		switch {
+		case name == "package/package.json":
+			var pkg struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			}
+			contents, err := ioutil.ReadAll(io.LimitReader(r, maxLicenseScan))
+			if err != nil || json.Unmarshal(contents, &pkg) != nil || pkg.Name == "" {
+				return "", nil
+			}
+			namespace, name := "", pkg.Name
+			if i := strings.Index(pkg.Name, "/"); strings.HasPrefix(pkg.Name, "@") && i > 0 {
+				namespace, name = pkg.Name[:i], pkg.Name[i+1:]
+			}
+			return newPurl("npm", namespace, name, pkg.Version, nil), nil
+		case strings.Count(name, "/") == 1 && path.Base(name) == "PKG-INFO":
+			contents, err := ioutil.ReadAll(io.LimitReader(r, maxLicenseScan))
+			if err != nil {
+				return "", nil
+			}
+			if metadata := coreMetadata(contents); metadata["Name"] != "" {
+				return newPurl("pypi", "", pypiName(metadata["Name"]), metadata["Version"], map[string]string{"file_name": filepath.Base(file)}), nil
+			}
+		}
+	}
+}
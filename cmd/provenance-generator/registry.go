@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// manifestMediaTypes are the manifest types accepted from registries.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// bearerChallenge parses the parameters of a WWW-Authenticate challenge.
+var bearerChallenge = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// ImageRef is a parsed container image reference.
+type ImageRef struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseImageRef parses "[registry/]repository[:tag][@digest]", applying
+// Docker Hub defaults for the registry, "library/" namespace and tag.
+func ParseImageRef(ref string) (ImageRef, error) {
+	var r ImageRef
+	if i := strings.Index(ref, "@"); i >= 0 {
+		r.Digest = ref[i+1:]
+		ref = ref[:i]
+		if !strings.HasPrefix(r.Digest, "sha256:") {
+			return r, fmt.Errorf("unsupported digest in image reference %q", r.Digest)
+		}
+	}
+	if i := strings.LastIndex(ref, ":"); i >= 0 && !strings.Contains(ref[i:], "/") {
+		r.Tag = ref[i+1:]
+		ref = ref[:i]
+	}
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		r.Registry, r.Repository = parts[0], parts[1]
+	} else {
+		r.Registry, r.Repository = "docker.io", ref
+	}
+	if r.Registry == "docker.io" && !strings.Contains(r.Repository, "/") {
+		r.Repository = "library/" + r.Repository
+	}
+	if r.Repository == "" {
+		return r, fmt.Errorf("no repository in image reference")
+	}
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = "latest"
+	}
+	return r, nil
+}
+
+// endpoint returns the registry's API host.
+func (r ImageRef) endpoint() string {
+	if r.Registry == "docker.io" {
+		return "registry-1.docker.io"
+	}
+	return r.Registry
+}
+
+// scheme returns "http" for registries on the local host, which Docker
+// also treats as insecure, and "https" otherwise.
+func (r ImageRef) scheme() string {
+	if strings.HasPrefix(r.Registry, "localhost") || strings.HasPrefix(r.Registry, "127.0.0.1") {
+		return "http"
+	}
+	return "https"
+}
+
+// reference returns the tag, or the digest when the reference is pinned.
+func (r ImageRef) reference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+func (r ImageRef) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// PURL returns the package URL of the image, e.g.
+// "pkg:docker/library/alpine@3.18?repository_url=ghcr.io".
+func (r ImageRef) PURL() string {
+	purl := "pkg:docker/" + r.Repository
+	if r.Tag != "" {
+		purl += "@" + r.Tag
+	}
+	if r.Registry != "docker.io" {
+		purl += "?repository_url=" + r.Registry
+	}
+	return purl
+}
+
+// resolution is a cached, possibly in-flight, digest resolution.
+type resolution struct {
+	done   chan struct{}
+	digest string
+	err    error
+}
+
+// registryResolver resolves image references to manifest digests. Lookups
+// for the same reference are de-duplicated and cached, at most "limit"
+// requests are in flight at once, and throttled requests are retried with
+// exponential backoff.
+type registryResolver struct {
+	http    *http.Client
+	limit   chan struct{}
+	retries int
+
+	mu     sync.Mutex
+	cache  map[string]*resolution
+	tokens map[string]string
+}
+
+func newRegistryResolver(concurrency int) *registryResolver {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &registryResolver{
+		http:    &http.Client{Timeout: 30 * time.Second},
+		limit:   make(chan struct{}, concurrency),
+		retries: 5,
+		cache:   map[string]*resolution{},
+		tokens:  map[string]string{},
+	}
+}
+
+// Resolve returns the "sha256:..." manifest digest of "ref".
+func (r *registryResolver) Resolve(ref ImageRef) (string, error) {
+	if ref.Digest != "" {
+		return ref.Digest, nil
+	}
+	key := ref.String()
+	r.mu.Lock()
+	res, ok := r.cache[key]
+	if !ok {
+		res = &resolution{done: make(chan struct{})}
+		r.cache[key] = res
+	}
+	r.mu.Unlock()
+	if ok {
+		<-res.done
+		return res.digest, res.err
+	}
+	res.digest, res.err = r.resolve(ref)
+	close(res.done)
+	return res.digest, res.err
+}
+
+// ResolveAll resolves every reference concurrently, keyed by reference.
+func (r *registryResolver) ResolveAll(refs []ImageRef) (map[string]string, error) {
+	type result struct {
+		ref    string
+		digest string
+		err    error
+	}
+	results := make(chan result, len(refs))
+	for _, ref := range refs {
+		go func(ref ImageRef) {
+			digest, err := r.Resolve(ref)
+			results <- result{ref.String(), digest, err}
+		}(ref)
+	}
+	digests := map[string]string{}
+	var firstErr error
+	for range refs {
+		res := <-results
+		if res.err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to resolve %s: %s", res.ref, res.err)
+		}
+		digests[res.ref] = res.digest
+	}
+	return digests, firstErr
+}
+
+func (r *registryResolver) resolve(ref ImageRef) (string, error) {
+	url := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", ref.scheme(), ref.endpoint(), ref.Repository, ref.reference())
+	resp, err := r.do("HEAD", url, ref)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	// Some registries omit the digest header on HEAD; hash the manifest.
+	resp, err = r.do("GET", url, ref)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// registryStatusError is returned for unexpected registry responses.
+type registryStatusError struct {
+	method, url string
+	status      int
+	text        string
+}
+
+func (e *registryStatusError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.method, e.url, e.text)
+}
+
+// isNotFound reports whether "err" is a registry 404 response.
+func isNotFound(err error) bool {
+	e, ok := err.(*registryStatusError)
+	return ok && e.status == http.StatusNotFound
+}
+
+// PlatformManifest is an entry of a multi-arch image index.
+type PlatformManifest struct {
+	Digest   string
+	Platform string
+}
+
+// platformManifests returns the per-platform manifests of the index with
+// "digest", or nothing when it is a single-platform manifest. Attestation
+// manifests that buildx attaches to indexes as "unknown/unknown" are left
+// out.
+func (r *registryResolver) platformManifests(ref ImageRef, digest string) ([]PlatformManifest, error) {
+	url := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", ref.scheme(), ref.endpoint(), ref.Repository, digest)
+	resp, err := r.do("GET", url, ref)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var index struct {
+		MediaType string `json:"mediaType"`
+		Manifests []struct {
+			Digest   string `json:"digest"`
+			Platform *struct {
+				OS           string `json:"os"`
+				Architecture string `json:"architecture"`
+				Variant      string `json:"variant"`
+			} `json:"platform"`
+		} `json:"manifests"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return nil, err
+	}
+	var manifests []PlatformManifest
+	for _, m := range index.Manifests {
+		if m.Platform == nil || m.Platform.OS == "unknown" {
+			continue
+		}
+		platform := m.Platform.OS + "/" + m.Platform.Architecture
+		if m.Platform.Variant != "" {
+			platform += "/" + m.Platform.Variant
+		}
+		manifests = append(manifests, PlatformManifest{Digest: m.Digest, Platform: platform})
+	}
+	return manifests, nil
+}
+
+// do performs an authenticated registry request without a body.
+func (r *registryResolver) do(method, url string, ref ImageRef) (*http.Response, error) {
+	return r.send(method, url, ref, "", nil)
+}
+
+// send performs an authenticated registry request, retrying on throttling
+// and transient server errors. A cached token is refreshed once when the
+// registry rejects it, e.g. because pushing needs a wider scope.
+func (r *registryResolver) send(method, url string, ref ImageRef, contentType string, body []byte) (*http.Response, error) {
+	r.limit <- struct{}{}
+	defer func() { <-r.limit }()
+	backoff := 500 * time.Millisecond
+	authenticated := false
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		r.mu.Lock()
+		token := r.tokens[ref.Registry+"/"+ref.Repository]
+		r.mu.Unlock()
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		resp, err := r.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return resp, nil
+		case resp.StatusCode == http.StatusUnauthorized && !authenticated:
+			resp.Body.Close()
+			if err := r.authenticate(resp.Header.Get("WWW-Authenticate"), ref); err != nil {
+				return nil, err
+			}
+			authenticated = true
+			continue
+		case (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500) && attempt < r.retries:
+			resp.Body.Close()
+			wait := backoff
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				wait = time.Duration(seconds) * time.Second
+			}
+			time.Sleep(wait)
+			backoff *= 2
+			continue
+		}
+		resp.Body.Close()
+		return nil, &registryStatusError{method: method, url: url, status: resp.StatusCode, text: resp.Status}
+	}
+}
+
+// authenticate answers a registry's auth challenge, caching the resulting
+// Authorization header for the repository.
+func (r *registryResolver) authenticate(challenge string, ref ImageRef) error {
+	basic := registryCredentials(ref.Registry)
+	header := ""
+	switch {
+	case strings.HasPrefix(challenge, "Bearer "):
+		params := map[string]string{}
+		for _, m := range bearerChallenge.FindAllStringSubmatch(challenge, -1) {
+			params[m[1]] = m[2]
+		}
+		scope := params["scope"]
+		if scope == "" {
+			scope = "repository:" + ref.Repository + ":pull"
+		}
+		req, err := http.NewRequest("GET", params["realm"], nil)
+		if err != nil {
+			return err
+		}
+		q := req.URL.Query()
+		q.Set("service", params["service"])
+		q.Set("scope", scope)
+		req.URL.RawQuery = q.Encode()
+		if basic != "" {
+			req.Header.Set("Authorization", "Basic "+basic)
+		}
+		resp, err := r.http.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("registry token request failed: %s", resp.Status)
+		}
+		var token struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+			return err
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		header = "Bearer " + token.Token
+	case strings.HasPrefix(challenge, "Basic ") && basic != "":
+		header = "Basic " + basic
+	default:
+		return fmt.Errorf("unsupported registry auth challenge %q", challenge)
+	}
+	r.mu.Lock()
+	r.tokens[ref.Registry+"/"+ref.Repository] = header
+	r.mu.Unlock()
+	return nil
+}
+
+// registryCredentials returns the base64 "user:password" for "registry"
+// from the Docker config file, or "" when there are none.
+func registryCredentials(registry string) string {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".docker")
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return ""
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if json.Unmarshal(contents, &config) != nil {
+		return ""
+	}
+	for _, key := range []string{registry, "https://" + registry, "https://index.docker.io/v1/"} {
+		if auth, ok := config.Auths[key]; ok && auth.Auth != "" {
+			if key == "https://index.docker.io/v1/" && registry != "docker.io" {
+				continue
+			}
+			if _, err := base64.StdEncoding.DecodeString(auth.Auth); err == nil {
+				return auth.Auth
+			}
+		}
+	}
+	return ""
+}
+
+// parseImageRefs parses every reference in "refs".
+func parseImageRefs(refs []string) ([]ImageRef, error) {
+	var parsed []ImageRef
+	for _, r := range refs {
+		ref, err := ParseImageRef(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image reference %q: %s", r, err)
+		}
+		parsed = append(parsed, ref)
+	}
+	return parsed, nil
+}
+
+// imageSubject returns the subject for the manifest of image "ref" at
+// "digest", named after its repository.
+func imageSubject(ref ImageRef, digest string) provenance.Subject {
+	return provenance.Subject{Name: ref.Registry + "/" + ref.Repository, Digest: provenance.DigestSet{"sha256": strings.TrimPrefix(digest, "sha256:")}, Image: ref.String()}
+}
+
+// imageMaterial returns the material recording image "ref" at "digest".
+func imageMaterial(ref ImageRef, digest string) provenance.Item {
+	return provenance.Item{URI: ref.PURL(), Digest: provenance.DigestSet{"sha256": strings.TrimPrefix(digest, "sha256:")}}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// remoteClient fetches remote subjects. Downloads have no overall timeout,
+// as artifacts may be large, but servers must answer promptly.
+var remoteClient = &http.Client{Transport: &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	ResponseHeaderTimeout: 30 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+}}
+
+// urlSubject returns the subject for the remote artifact given as "URL" or,
+// with its digests, "URL=sha256:<hex>[,sha512:<hex>]". Without digests the
+// artifact is downloaded and digested with "algorithms"; with them it is
+// only checked to exist, and against a digest the server reports. The
+// subject is named after the last segment of the URL path and records the
+// URL as its downloadLocation.
+func urlSubject(value string, algorithms []string) (provenance.Subject, error) {
+	location, digests := value, ""
+	if i := strings.LastIndex(value, "="); i > 0 {
+		if _, err := provenance.ParseSubject("x" + value[i:]); err == nil {
+			location, digests = value[:i], value[i+1:]
+		}
+	}
+	u, err := url.Parse(location)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return provenance.Subject{}, fmt.Errorf("%q is not an http(s) URL", location)
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		return provenance.Subject{}, fmt.Errorf("%s does not name a file", location)
+	}
+	if digests != "" {
+		subject, err := provenance.ParseSubject(name + "=" + digests)
+		if err != nil {
+			return provenance.Subject{}, err
+		}
+		subject.DownloadLocation = location
+		return subject, checkRemote(location, subject.Digest["sha256"])
+	}
+
+	resp, err := remoteClient.Get(location)
+	if err != nil {
+		return provenance.Subject{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return provenance.Subject{}, fmt.Errorf("GET %s: %s", location, resp.Status)
+	}
+	for _, alg := range algorithms {
+		if provenance.IsGitoidAlgorithm(alg) && resp.ContentLength < 0 {
+			return provenance.Subject{}, fmt.Errorf("GET %s: no Content-Length to compute %s with", location, alg)
+		}
+	}
+	digest, err := provenance.DigestReader(resp.Body, resp.ContentLength, algorithms)
+	if err != nil {
+		return provenance.Subject{}, fmt.Errorf("GET %s: %s", location, err)
+	}
+	return provenance.Subject{Name: name, Digest: digest, DownloadLocation: location}, nil
+}
+
+// checkRemote checks with a HEAD request that the artifact at "location"
+// exists and, when the server reports its sha256 digest, that it is
+// "sha256".
+func checkRemote(location, sha256 string) error {
+	resp, err := remoteClient.Head(location)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HEAD %s: %s", location, resp.Status)
+	}
+	if reported := reportedSHA256(resp.Header); reported != "" && reported != sha256 {
+		return fmt.Errorf("%s has sha256 digest %s, not %s", location, reported, sha256)
+	}
+	return nil
+}
+
+// reportedSHA256 returns the hex sha256 digest of a response's content from
+// Artifactory's X-Checksum-Sha256 or an RFC 9530 Repr-Digest (or RFC 3230
+// Digest) header, if any.
+func reportedSHA256(header http.Header) string {
+	if digest := header.Get("X-Checksum-Sha256"); digest != "" {
+		return strings.ToLower(digest)
+	}
+	for _, name := range []string{"Repr-Digest", "Digest"} {
+		for _, field := range strings.Split(header.Get(name), ",") {
+			kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+			if len(kv) != 2 || !strings.EqualFold(kv[0], "sha-256") {
+				continue
+			}
+			decoded, err := base64.StdEncoding.DecodeString(strings.Trim(kv[1], ":"))
+			if err == nil {
+				return hex.EncodeToString(decoded)
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/sign"
+)
+
+// ReverifyAnnotationContext is the context of the build annotation holding
+// the verification status, so each run replaces the previous one.
+const ReverifyAnnotationContext = "provenance-verification"
+
+// reverifyResult is the verification status of one attestation file.
+type reverifyResult struct {
+	Path  string
+	JobID string
+	KeyID string
+	Err   error
+}
+
+// reverifyBuild downloads the attestations of a build and verifies every
+// envelope in them against "verifiers". An envelope passes when any
+// verifier accepts one of its signatures, so keys can be rotated by
+// passing both the old and the new key.
+func reverifyBuild(client *buildkiteClient, org, pipeline, number string, patterns []string, verifiers []sign.Verifier) ([]reverifyResult, error) {
+	artifacts, err := client.artifacts(org, pipeline, number)
+	if err != nil {
+		return nil, err
+	}
+	var results []reverifyResult
+	for _, artifact := range artifacts {
+		if !matchAny(patterns, path.Base(artifact.Path)) {
+			continue
+		}
+		result := reverifyResult{Path: artifact.Path, JobID: artifact.JobID}
+		body, err := client.open(artifact.DownloadURL)
+		if err != nil {
+			return nil, err
+		}
+		contents, err := ioutil.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+		result.KeyID, result.Err = verifyDocuments(contents, verifiers)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// verifyDocuments verifies every DSSE envelope in "contents", which may be
+// indented or one per line, and returns the key IDs that verified them.
+func verifyDocuments(contents []byte, verifiers []sign.Verifier) (string, error) {
+	var keyIDs []string
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	for {
+		var envelope sign.Envelope
+		if err := decoder.Decode(&envelope); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if envelope.PayloadType == "" {
+			return "", fmt.Errorf("attestation is not signed")
+		}
+		var last error
+		verified := false
+		for _, verifier := range verifiers {
+			keyID, err := sign.VerifyEnvelope(&envelope, verifier)
+			if err == nil {
+				keyIDs = append(keyIDs, keyID)
+				verified = true
+				break
+			}
+			last = err
+		}
+		if !verified {
+			return "", last
+		}
+	}
+	if len(keyIDs) == 0 {
+		return "", fmt.Errorf("no envelope found")
+	}
+	return strings.Join(keyIDs, ", "), nil
+}
+
+// reverifyAnnotation renders the results as an annotation body and style.
+func reverifyAnnotation(results []reverifyResult, reason string) (string, string) {
+	style := "success"
+	var b strings.Builder
+	fmt.Fprintf(&b, "<h3>Provenance verification</h3>\n<p>Re-verified %d attestations at %s", len(results), now().UTC().Format(time.RFC3339))
+	if reason != "" {
+		fmt.Fprintf(&b, " (%s)", html.EscapeString(reason))
+	}
+	b.WriteString(".</p>\n<table>\n<tr><th>Attestation</th><th>Status</th></tr>\n")
+	for _, r := range results {
+		status := "✅ verified by " + html.EscapeString(r.KeyID)
+		if r.Err != nil {
+			style = "error"
+			status = "❌ " + html.EscapeString(r.Err.Error())
+		}
+		fmt.Fprintf(&b, "<tr><td><code>%s</code></td><td>%s</td></tr>\n", html.EscapeString(r.Path), status)
+	}
+	b.WriteString("</table>\n")
+	if len(results) == 0 {
+		style = "warning"
+	}
+	return b.String(), style
+}
+
+// metaDataValue reads a meta-data key of the current build.
+func metaDataValue(key string) (string, error) {
+	out, err := exec.Command("buildkite-agent", "meta-data", "get", key, "--default", "").Output()
+	if err != nil {
+		return "", fmt.Errorf("buildkite-agent meta-data get %s failed: %s", key, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// reverifyCommand re-verifies the published attestations of a build, e.g.
+// after key rotation, and updates the build's annotation with the status.
+// With --watch_meta_data it instead waits for a meta-data key of the
+// current build to name a build number and re-verifies that build whenever
+// the value changes.
+func reverifyCommand(args []string) {
+	fs := flag.NewFlagSet("reverify", flag.ExitOnError)
+	org := fs.String("organization", os.Getenv("BUILDKITE_ORGANIZATION_SLUG"), "The slug of the Buildkite organization.")
+	pipeline := fs.String("pipeline", os.Getenv("BUILDKITE_PIPELINE_SLUG"), "The slug of the pipeline of the build.")
+	build := fs.String("build", "", "The number of the build to re-verify.")
+	var keys arrayFlags
+	fs.Var(&keys, "public_key", "The path of a PEM or SSH public key attestations may be signed with; may be repeated.")
+	patterns := fs.String("attestation_patterns", "*.intoto.jsonl,*.att,*.attestation.json,provenance*.json", "Comma-separated artifact name patterns that identify attestations.")
+	reason := fs.String("reason", "", "Why the build is re-verified (e.g. 'key rotation'), shown in the annotation.")
+	watch := fs.String("watch_meta_data", "", "A meta-data key of the current build to watch for build numbers to re-verify.")
+	interval := fs.Duration("poll_interval", 30*time.Second, "How often the watched meta-data key is read.")
+	timeout := fs.Duration("watch_timeout", time.Hour, "How long to watch the meta-data key for.")
+	fs.Parse(args)
+
+	token := os.Getenv("BUILDKITE_API_TOKEN")
+	if token == "" {
+		fmt.Println("No value found for required environment variable: BUILDKITE_API_TOKEN")
+		os.Exit(1)
+	}
+	if len(keys) == 0 || (*build == "" && *watch == "") {
+		fmt.Println("No value found for required flags: --public_key and one of --build, --watch_meta_data")
+		fs.Usage()
+		os.Exit(1)
+	}
+	var verifiers []sign.Verifier
+	for _, key := range keys {
+		contents, err := ioutil.ReadFile(key)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to read public key: %s", err))
+			os.Exit(1)
+		}
+		v, err := sign.LoadVerifier(contents)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to load public key: [provided=%s] %s", key, err))
+			os.Exit(1)
+		}
+		verifiers = append(verifiers, v)
+	}
+	client := newBuildkiteClient(token)
+
+	reverify := func(number string) bool {
+		results, err := reverifyBuild(client, *org, *pipeline, number, strings.Split(*patterns, ","), verifiers)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to re-verify build %s: %s", number, err))
+			return false
+		}
+		body, style := reverifyAnnotation(results, *reason)
+		if err := client.annotate(*org, *pipeline, number, ReverifyAnnotationContext, style, body); err != nil {
+			fmt.Println(fmt.Sprintf("Failed to annotate build %s: %s", number, err))
+			return false
+		}
+		fmt.Println(fmt.Sprintf("Re-verified %d attestations of build %s: %s", len(results), number, style))
+		return style == "success"
+	}
+
+	if *watch == "" {
+		if !reverify(*build) {
+			os.Exit(1)
+		}
+		return
+	}
+	last := ""
+	for deadline := time.Now().Add(*timeout); time.Now().Before(deadline); time.Sleep(*interval) {
+		value, err := metaDataValue(*watch)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if value != "" && value != last {
+			last = value
+			reverify(value)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Put uploads "body" to the object "key" in "bucket".
+func (u *Upload) s3Put(bucket, key string, body []byte) error {
+	req, err := u.s3Request("PUT", bucket, key, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range u.metadata() {
+		req.Header.Set("X-Amz-Meta-"+name, value)
+	}
+	if u.SSE != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption", u.SSE)
+	}
+	if u.KMSKey != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", u.KMSKey)
+	}
+	_, err = u.s3Send(req, body)
+	return err
+}
+
+// s3Get downloads the object "key" in "bucket".
+func (u *Upload) s3Get(bucket, key string) ([]byte, error) {
+	req, err := u.s3Request("GET", bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return u.s3Send(req, nil)
+}
+
+// s3Request returns a request for the object "key" in "bucket".
+func (u *Upload) s3Request(method, bucket, key string, body []byte) (*http.Request, error) {
+	region := awsRegion()
+	endpoint := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, region, s3EscapePath(key))
+	if u.Endpoint != "" {
+		endpoint = strings.TrimRight(u.Endpoint, "/") + "/" + bucket + "/" + s3EscapePath(key)
+	}
+	return http.NewRequest(method, endpoint, bytes.NewReader(body))
+}
+
+// s3Send signs and performs "req" and returns the response body.
+func (u *Upload) s3Send(req *http.Request, body []byte) ([]byte, error) {
+	creds, err := awsCredentials()
+	if err != nil {
+		return nil, err
+	}
+	// Not now(): a clock pinned by SOURCE_DATE_EPOCH would fail S3's skew check.
+	signV4(req, body, creds, awsRegion(), "s3", time.Now().UTC())
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s %s", req.Method, req.URL, resp.Status, strings.TrimSpace(string(contents)))
+	}
+	return contents, nil
+}
+
+// awsRegion returns the region of the AWS_* environment variables.
+func awsRegion() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	if region := os.Getenv("AWS_DEFAULT_REGION"); region != "" {
+		return region
+	}
+	return "us-east-1"
+}
+
+// AWSCredentials are the credentials requests are signed with.
+type AWSCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"Token"`
+}
+
+// awsCredentials reads credentials from the AWS_* environment variables,
+// falling back to the instance role through the EC2 metadata service
+// (IMDSv2), as used by the Elastic CI Stack.
+func awsCredentials() (AWSCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return AWSCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	const imds = "http://169.254.169.254/latest"
+	client := &http.Client{Timeout: 5 * time.Second}
+	fetch := func(method, url string, header http.Header) ([]byte, error) {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header = header
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s %s: %s", method, url, resp.Status)
+		}
+		return ioutil.ReadAll(resp.Body)
+	}
+	token, err := fetch("PUT", imds+"/api/token", http.Header{"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"300"}})
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("no AWS credentials in the environment and no instance metadata service: %s", err)
+	}
+	header := http.Header{"X-Aws-Ec2-Metadata-Token": {string(token)}}
+	role, err := fetch("GET", imds+"/meta-data/iam/security-credentials/", header)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	contents, err := fetch("GET", imds+"/meta-data/iam/security-credentials/"+strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0]), header)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	var creds AWSCredentials
+	return creds, json.Unmarshal(contents, &creds)
+}
+
+// s3EscapePath URI-encodes an object key as S3 expects it in both the
+// request and the canonical request.
+func s3EscapePath(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// signV4 signs "req" with AWS Signature Version 4, signing every header
+// set on it.
+func signV4(req *http.Request, body []byte, creds AWSCredentials, region, service string, t time.Time) {
+	payloadHash := sha256.Sum256(body)
+	date := t.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", date)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.Join(strings.Fields(headers[name]), " ") + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var canonicalQuery []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			canonicalQuery = append(canonicalQuery, s3EscapeQuery(key)+"="+s3EscapeQuery(value))
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.Join(canonicalQuery, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	scope := strings.Join([]string{date[:8], region, service, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", date, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date[:8], region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func s3EscapeQuery(s string) string {
+	return strings.Replace(s3EscapePath(s), "/", "%2F", -1)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// Predicate types of SBOM attestations.
+const (
+	SPDXPredicateType      = "https://spdx.dev/Document"
+	CycloneDXPredicateType = "https://cyclonedx.org/bom"
+)
+
+// readSPDXDocument reads the SPDX JSON document at "path" to wrap as the
+// predicate of a companion SBOM attestation.
+func readSPDXDocument(path string) (json.RawMessage, error) {
+	document, err := readPredicate(path)
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		SPDXVersion string `json:"spdxVersion"`
+	}
+	if err := json.Unmarshal(document, &header); err != nil || !strings.HasPrefix(header.SPDXVersion, "SPDX-") {
+		return nil, fmt.Errorf("%s is not an SPDX JSON document: no spdxVersion", path)
+	}
+	return document, nil
+}
+
+// readCycloneDXDocument reads the CycloneDX JSON BOM at "path" to wrap as
+// the predicate of a companion SBOM attestation.
+func readCycloneDXDocument(path string) (json.RawMessage, error) {
+	document, err := readPredicate(path)
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		BOMFormat   string `json:"bomFormat"`
+		SpecVersion string `json:"specVersion"`
+	}
+	if err := json.Unmarshal(document, &header); err != nil || header.BOMFormat != "CycloneDX" || header.SpecVersion == "" {
+		return nil, fmt.Errorf("%s is not a CycloneDX JSON BOM: no bomFormat CycloneDX and specVersion", path)
+	}
+	return document, nil
+}
+
+// sbomMaterial returns the material recording the SBOM file at "path" as a
+// relative file URI (e.g. "file:sbom.spdx.json") digested with
+// "algorithms", so verifiers can tie the SBOM to the build that produced it.
+func sbomMaterial(path string, algorithms []string) (provenance.Item, error) {
+	digest, err := provenance.DigestFile(path, algorithms)
+	if err != nil {
+		return provenance.Item{}, err
+	}
+	return provenance.Item{URI: "file:" + url.PathEscape(filepath.Base(path)), Digest: digest}, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// schemaFS holds the JSON schemas of the statement and of the predicates
+// generated by this tool, transcribed from the in-toto and SLSA specs.
+//
+//go:embed schemas/*.json
+var schemaFS embed.FS
+
+// statementSchemas maps statement types to their schema file.
+var statementSchemas = map[string]string{
+	"https://in-toto.io/Statement/v0.1": "schemas/statement-v0.1.json",
+	provenance.StatementV1Type:          "schemas/statement-v1.json",
+}
+
+// predicateSchemas maps predicate types to their schema file.
+var predicateSchemas = map[string]string{
+	"https://slsa.dev/provenance/v0.1":   "schemas/provenance-v0.1.json",
+	provenance.ProvenanceV1PredicateType: "schemas/provenance-v1.json",
+}
+
+// validateStatement validates an in-toto statement against the Statement
+// schema and, when known, the schema of its predicate type. It returns one
+// message per violation.
+func validateStatement(stmt interface{}) ([]string, error) {
+	encoded, err := json.Marshal(stmt)
+	if err != nil {
+		return nil, err
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		return nil, err
+	}
+	statementSchema, ok := statementSchemas[fmt.Sprint(document["_type"])]
+	if !ok {
+		statementSchema = statementSchemas["https://in-toto.io/Statement/v0.1"]
+	}
+	violations, err := validateFile(statementSchema, document, "")
+	if err != nil {
+		return nil, err
+	}
+	predicateType, _ := document["predicateType"].(string)
+	if file, ok := predicateSchemas[predicateType]; ok {
+		more, err := validateFile(file, document["predicate"], "/predicate")
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, more...)
+	}
+	sort.Strings(violations)
+	return violations, nil
+}
+
+func validateFile(file string, value interface{}, path string) ([]string, error) {
+	contents, err := schemaFS.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(contents, &schema); err != nil {
+		return nil, fmt.Errorf("invalid schema %s: %s", file, err)
+	}
+	return validateSchema(schema, schema, value, path), nil
+}
+
+// validateSchema implements the subset of JSON Schema draft-07 used by the
+// embedded schemas. "path" is the JSON pointer of "value".
+func validateSchema(schema, root map[string]interface{}, value interface{}, path string) []string {
+	var violations []string
+	fail := func(format string, args ...interface{}) {
+		location := path
+		if location == "" {
+			location = "/"
+		}
+		violations = append(violations, location+": "+fmt.Sprintf(format, args...))
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		target, _ := root["definitions"].(map[string]interface{})[strings.TrimPrefix(ref, "#/definitions/")].(map[string]interface{})
+		if target == nil {
+			fail("unresolvable $ref %q", ref)
+			return violations
+		}
+		return validateSchema(target, root, value, path)
+	}
+	if want, ok := schema["const"]; ok && fmt.Sprint(want) != fmt.Sprint(value) {
+		fail("must be %v", want)
+	}
+	if types, ok := schema["type"]; ok && !matchesType(types, value) {
+		fail("must be of type %v", types)
+		return violations
+	}
+
+	switch v := value.(type) {
+	case string:
+		if min, ok := schema["minLength"].(float64); ok && float64(len(v)) < min {
+			fail("must not be shorter than %v characters", min)
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(v) {
+			fail("must match %q", pattern)
+		}
+		switch schema["format"] {
+		case "uri":
+			if u, err := url.Parse(v); err != nil || u.Scheme == "" {
+				fail("must be a URI")
+			}
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				fail("must be an RFC 3339 date-time")
+			}
+		}
+	case float64:
+		if min, ok := schema["minimum"].(float64); ok && v < min {
+			fail("must not be less than %v", min)
+		}
+	case []interface{}:
+		if min, ok := schema["minItems"].(float64); ok && float64(len(v)) < min {
+			fail("must have at least %v items", min)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				violations = append(violations, validateSchema(items, root, item, fmt.Sprintf("%s/%d", path, i))...)
+			}
+		}
+	case map[string]interface{}:
+		if min, ok := schema["minProperties"].(float64); ok && float64(len(v)) < min {
+			fail("must have at least %v properties", min)
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := v[name.(string)]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, property := range v {
+			child := path + "/" + name
+			if s, ok := properties[name].(map[string]interface{}); ok {
+				violations = append(violations, validateSchema(s, root, property, child)...)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					fail("unexpected property %q", name)
+				}
+			case map[string]interface{}:
+				violations = append(violations, validateSchema(additional, root, property, child)...)
+			}
+		}
+	}
+	return violations
+}
+
+// matchesType reports whether "value" is of one of the JSON Schema "types".
+func matchesType(types interface{}, value interface{}) bool {
+	var names []interface{}
+	if list, ok := types.([]interface{}); ok {
+		names = list
+	} else {
+		names = []interface{}{types}
+	}
+	for _, name := range names {
+		switch v := value.(type) {
+		case string:
+			if name == "string" {
+				return true
+			}
+		case bool:
+			if name == "boolean" {
+				return true
+			}
+		case float64:
+			if name == "number" || (name == "integer" && v == math.Trunc(v)) {
+				return true
+			}
+		case []interface{}:
+			if name == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if name == "object" {
+				return true
+			}
+		case nil:
+			if name == "null" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/sign"
+)
+
+// The integrity self-check re-reads every destination an attestation was
+// written to and compares it with what was generated, so a corrupt or
+// truncated copy fails the step instead of surfacing at verification time.
+
+// checkWritten verifies that the file at "path" holds exactly "payload".
+func checkWritten(path string, payload []byte) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return compareDigest(path, contents, blobDigest(payload))
+}
+
+// checkUploaded verifies that the object uploaded for "path" holds
+// exactly "payload".
+func checkUploaded(u *Upload, path string, payload []byte) error {
+	contents, err := u.download(path)
+	if err != nil {
+		return err
+	}
+	scheme, bucket, key, _ := u.location(path)
+	return compareDigest(scheme+"://"+bucket+"/"+key, contents, blobDigest(payload))
+}
+
+// checkStored verifies that Archivista serves "envelope" under "gitoid".
+func checkStored(baseURL, gitoid string, envelope *sign.Envelope) error {
+	contents, err := fetchFromArchivista(baseURL, gitoid)
+	if err != nil {
+		return err
+	}
+	stored, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return compareDigest("Archivista "+gitoid, contents, blobDigest(stored))
+}
+
+// checkPublished verifies that GitHub lists "bundle" among the
+// attestations of "repo" about each of "subjects".
+func checkPublished(repo string, subjects []provenance.Subject, bundle *SigstoreBundle) error {
+	for _, subject := range subjects {
+		if subject.Digest["sha256"] == "" {
+			continue
+		}
+		digest := "sha256:" + subject.Digest["sha256"]
+		attestations, err := gitHubAttestations(repo, digest)
+		if err != nil {
+			return err
+		}
+		listed := false
+		for _, a := range attestations {
+			if e := a.Bundle.DSSEEnvelope; e != nil && e.Payload == bundle.DSSEEnvelope.Payload && len(e.Signatures) == 1 {
+				listed = listed || e.Signatures[0].Sig == bundle.DSSEEnvelope.Signatures[0].Sig
+			}
+		}
+		if !listed {
+			return fmt.Errorf("GitHub does not list the attestation for %s in %s", digest, repo)
+		}
+	}
+	return nil
+}
+
+// checkAttached verifies the manifests attachAttestation pushed for
+// "envelope": each must be readable, list the envelope's layer and serve
+// the layer intact, and manifests pushed as referrers must be listed among
+// the image's referrers.
+func checkAttached(image string, envelope *sign.Envelope, references []string) error {
+	ref, err := ParseImageRef(image)
+	if err != nil {
+		return err
+	}
+	_, descriptor, err := attestationLayer(envelope)
+	if err != nil {
+		return err
+	}
+	r := newRegistryResolver(1)
+	base := fmt.Sprintf("%s://%s/v2/%s", ref.scheme(), ref.endpoint(), ref.Repository)
+	for _, reference := range references {
+		var manifestRef string
+		if i := strings.LastIndex(reference, "@"); i >= 0 {
+			manifestRef = reference[i+1:]
+		} else {
+			manifestRef = reference[strings.LastIndex(reference, ":")+1:]
+		}
+		manifest, err := fetchBlob(r, ref, base+"/manifests/"+manifestRef)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %s", reference, err)
+		}
+		if strings.HasPrefix(manifestRef, "sha256:") {
+			if err := compareDigest(reference, manifest, manifestRef); err != nil {
+				return err
+			}
+		}
+		var m OCIManifest
+		if err := json.Unmarshal(manifest, &m); err != nil {
+			return fmt.Errorf("failed to parse %s: %s", reference, err)
+		}
+		listed := false
+		for _, layer := range m.Layers {
+			listed = listed || (layer.Digest == descriptor.Digest && layer.Size == descriptor.Size)
+		}
+		if !listed {
+			return fmt.Errorf("%s does not list the attestation layer %s", reference, descriptor.Digest)
+		}
+		layer, err := fetchBlob(r, ref, base+"/blobs/"+descriptor.Digest)
+		if err != nil {
+			return fmt.Errorf("failed to read attestation layer of %s: %s", reference, err)
+		}
+		if err := compareDigest(reference+" layer", layer, descriptor.Digest); err != nil {
+			return err
+		}
+		if m.Subject != nil {
+			if err := checkReferrer(r, ref, base, manifestRef); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkReferrer verifies that the manifest "digest" resolves as a referrer
+// of the image, through the referrers API or its tag fallback.
+func checkReferrer(r *registryResolver, ref ImageRef, base, digest string) error {
+	index, err := fetchBlob(r, ref, base+"/referrers/"+ref.Digest)
+	if isNotFound(err) {
+		index, err = fetchBlob(r, ref, base+"/manifests/"+strings.Replace(ref.Digest, ":", "-", 1))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list referrers of %s: %s", ref.Digest, err)
+	}
+	var referrers OCIIndex
+	if err := json.Unmarshal(index, &referrers); err != nil {
+		return fmt.Errorf("failed to parse referrers of %s: %s", ref.Digest, err)
+	}
+	for _, m := range referrers.Manifests {
+		if m.Digest == digest {
+			return nil
+		}
+	}
+	return fmt.Errorf("attestation %s is not listed among the referrers of %s", digest, ref.Digest)
+}
+
+func compareDigest(destination string, contents []byte, digest string) error {
+	if got := blobDigest(contents); got != digest {
+		return fmt.Errorf("%s is corrupt: digest %s (%d bytes), expected %s", destination, got, len(contents), digest)
+	}
+	return nil
+}
+
+func fetchBlob(r *registryResolver, ref ImageRef, url string) ([]byte, error) {
+	resp, err := r.do("GET", url, ref)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// signCommand signs a statement written by an earlier, unsigned run, so
+// the keys need only be on the agent that signs, not the one that built.
+func signCommand(args []string) {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	statementPath := fs.String("statement_path", "", "The path of the unsigned statement to sign, or '-' to read it from standard input.")
+	output := fs.String("output_path", "provenance.json", "The path to which the signed envelope should be written, or '-' for standard output.")
+	preset := fs.String("preset", "", "The output convention to follow.")
+	var keys arrayFlags
+	fs.Var(&keys, "sign_key", "A key used to sign the envelope, prefixed with its backend (e.g. 'pkcs11:<label>' or 'ssh:<path>'); may be repeated.")
+	tsa := fs.String("tsa_url", "", "The URL of an RFC 3161 timestamp authority used to timestamp signatures.")
+	format := fs.String("output_format", "", "Set to 'sigstore-bundle' to write a Sigstore bundle instead of an envelope.")
+	rekor := fs.String("rekor_url", DefaultRekorURL, "The Rekor server sigstore bundles are recorded in.")
+	attachTo := fs.String("attach_to", "", "An image pinned by digest (image@sha256:...) to push the signed attestation to.")
+	attachMode := fs.String("attach_mode", AttachModeTag, "How the attestation is attached to the image: 'tag' (cosign), 'referrers' (OCI 1.1) or 'both'.")
+	payloadEncoding := fs.String("payload_encoding", PayloadEncodingJCS, "The encoding of the signed statement: 'jcs' (RFC 8785 canonical JSON) or 'json'.")
+	style := addOutputStyleFlags(fs)
+	upload := addUploadFlags(fs)
+	artifactUpload := addArtifactUploadFlags(fs)
+	archivista := fs.String("archivista_url", "", "The URL of an Archivista server the signed envelope is also stored in.")
+	github := fs.String("github_repository", "", "The GitHub repository ('owner/name') whose artifact attestations the Sigstore bundle is published to.")
+	fs.Parse(args)
+	logToStderr(*output)
+	if *statementPath == "" || len(keys) == 0 {
+		fmt.Println("No value found for required flags: --statement_path and --sign_key")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := validOutputFlags(*format, *attachMode, *payloadEncoding); err != nil {
+		fmt.Println(err)
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := upload.validate(); err != nil {
+		fmt.Println(fmt.Sprintf("Invalid upload: %s", err))
+		os.Exit(1)
+	}
+
+	contents, err := readFileOrStdin(*statementPath)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to read statement: %s", err))
+		os.Exit(1)
+	}
+	var stmt provenance.CustomStatement
+	if err := json.Unmarshal(contents, &stmt); err != nil {
+		fmt.Println(fmt.Sprintf("Failed to parse statement %s: %s", *statementPath, err))
+		os.Exit(1)
+	}
+	if stmt.Type == "" {
+		fmt.Println(fmt.Sprintf("%s is not an unsigned in-toto statement", *statementPath))
+		os.Exit(1)
+	}
+	context := provenance.BuildContext{}
+	var provenance provenance.Statement
+	if json.Unmarshal(contents, &provenance) == nil {
+		context.BuildURL = provenance.Predicate.Metadata.BuildInvocationId
+		if source, ok := definedInMaterial(provenance.Predicate); ok {
+			context.Commit = source.Digest["sha1"]
+		}
+	}
+	written, err := writeAttestation(stmt, stmt.Subject, outputOptions{
+		path:       *output,
+		pathSet:    flagSetPassed(fs, "output_path"),
+		preset:     *preset,
+		signKeys:   keys,
+		tsaURL:     *tsa,
+		format:     *format,
+		rekorURL:   *rekor,
+		attachTo:   *attachTo,
+		attachMode: *attachMode,
+		style:      *style,
+		upload:     upload,
+
+		legacyPayload: *payloadEncoding == PayloadEncodingJSON,
+		properties:    buildProperties(context),
+		artifacts:     artifactUpload,
+		archivistaURL: *archivista,
+		githubRepo:    *github,
+	})
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to write signed attestation: %s", err))
+		os.Exit(1)
+	}
+	fmt.Println(fmt.Sprintf("Signed %s with %d keys into %s", *statementPath, len(keys), written))
+}
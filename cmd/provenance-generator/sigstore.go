@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/sign"
+)
+
+const (
+	SigstoreBundleMediaType = "application/vnd.dev.sigstore.bundle.v0.3+json"
+	DefaultRekorURL         = "https://rekor.sigstore.dev"
+)
+
+// SigstoreBundle is the Sigstore bundle format understood by
+// `cosign verify-blob-attestation --bundle`.
+type SigstoreBundle struct {
+	MediaType            string               `json:"mediaType"`
+	VerificationMaterial VerificationMaterial `json:"verificationMaterial"`
+	DSSEEnvelope         *sign.Envelope       `json:"dsseEnvelope"`
+}
+
+type VerificationMaterial struct {
+	PublicKey                 *PublicKeyIdentifier       `json:"publicKey,omitempty"`
+	TlogEntries               []TransparencyLogEntry     `json:"tlogEntries"`
+	TimestampVerificationData *TimestampVerificationData `json:"timestampVerificationData,omitempty"`
+}
+
+type PublicKeyIdentifier struct {
+	Hint string `json:"hint"`
+}
+
+type TimestampVerificationData struct {
+	RFC3161Timestamps []RFC3161Timestamp `json:"rfc3161Timestamps"`
+}
+
+type RFC3161Timestamp struct {
+	SignedTimestamp string `json:"signedTimestamp"`
+}
+
+type TransparencyLogEntry struct {
+	LogIndex          string            `json:"logIndex"`
+	LogID             LogID             `json:"logId"`
+	KindVersion       KindVersion       `json:"kindVersion"`
+	IntegratedTime    string            `json:"integratedTime"`
+	InclusionPromise  *InclusionPromise `json:"inclusionPromise,omitempty"`
+	InclusionProof    *InclusionProof   `json:"inclusionProof,omitempty"`
+	CanonicalizedBody string            `json:"canonicalizedBody"`
+}
+
+type LogID struct {
+	KeyID string `json:"keyId"`
+}
+
+type KindVersion struct {
+	Kind    string `json:"kind"`
+	Version string `json:"version"`
+}
+
+type InclusionPromise struct {
+	SignedEntryTimestamp string `json:"signedEntryTimestamp"`
+}
+
+type InclusionProof struct {
+	LogIndex   string     `json:"logIndex"`
+	RootHash   string     `json:"rootHash"`
+	TreeSize   string     `json:"treeSize"`
+	Hashes     []string   `json:"hashes"`
+	Checkpoint Checkpoint `json:"checkpoint"`
+}
+
+type Checkpoint struct {
+	Envelope string `json:"envelope"`
+}
+
+// rekorLogEntry is an entry as returned by the Rekor API.
+type rekorLogEntry struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+	Verification   struct {
+		SignedEntryTimestamp string `json:"signedEntryTimestamp"`
+		InclusionProof       *struct {
+			Checkpoint string   `json:"checkpoint"`
+			Hashes     []string `json:"hashes"`
+			LogIndex   int64    `json:"logIndex"`
+			RootHash   string   `json:"rootHash"`
+			TreeSize   int64    `json:"treeSize"`
+		} `json:"inclusionProof"`
+	} `json:"verification"`
+}
+
+// uploadToRekor records the signed envelope as a "dsse" entry in the Rekor
+// transparency log at "rekorURL" and returns the created entry.
+func uploadToRekor(rekorURL string, envelope *sign.Envelope, publicKey []byte) (*rekorLogEntry, error) {
+	encoded, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+	entry := map[string]interface{}{
+		"apiVersion": "0.0.1",
+		"kind":       "dsse",
+		"spec": map[string]interface{}{
+			"proposedContent": map[string]interface{}{
+				"envelope":  string(encoded),
+				"verifiers": []string{base64.StdEncoding.EncodeToString(publicKey)},
+			},
+		},
+	}
+	body, _ := json.Marshal(entry)
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(strings.TrimSuffix(rekorURL, "/")+"/api/v1/log/entries", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("rekor returned %s: %s", resp.Status, message)
+	}
+	var entries map[string]rekorLogEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		return &e, nil
+	}
+	return nil, fmt.Errorf("rekor returned no entry")
+}
+
+// hexToBase64 re-encodes a hex string, as used by the Rekor API, as the
+// base64 expected in bundles.
+func hexToBase64(s string) (string, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// newSigstoreBundle records "envelope", which must carry exactly one
+// signature made by "signer", in Rekor and returns it as a Sigstore bundle.
+func newSigstoreBundle(envelope *sign.Envelope, signer sign.Signer, rekorURL string) (*SigstoreBundle, error) {
+	if len(envelope.Signatures) != 1 {
+		return nil, fmt.Errorf("sigstore bundles carry exactly one signature, found %d", len(envelope.Signatures))
+	}
+	der, err := signer.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	publicKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	bundle := &SigstoreBundle{
+		MediaType: SigstoreBundleMediaType,
+		VerificationMaterial: VerificationMaterial{
+			PublicKey: &PublicKeyIdentifier{Hint: signer.KeyID()},
+		},
+	}
+	// Timestamps live in the verification material rather than the envelope.
+	signature := envelope.Signatures[0]
+	if signature.Timestamp != "" {
+		bundle.VerificationMaterial.TimestampVerificationData = &TimestampVerificationData{
+			RFC3161Timestamps: []RFC3161Timestamp{{SignedTimestamp: signature.Timestamp}},
+		}
+	}
+	bundle.DSSEEnvelope = &sign.Envelope{
+		PayloadType: envelope.PayloadType,
+		Payload:     envelope.Payload,
+		Signatures:  []sign.Signature{{KeyID: signature.KeyID, Sig: signature.Sig}},
+	}
+
+	entry, err := uploadToRekor(rekorURL, bundle.DSSEEnvelope, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	logID, err := hexToBase64(entry.LogID)
+	if err != nil {
+		return nil, err
+	}
+	tlog := TransparencyLogEntry{
+		LogIndex:          strconv.FormatInt(entry.LogIndex, 10),
+		LogID:             LogID{KeyID: logID},
+		KindVersion:       KindVersion{Kind: "dsse", Version: "0.0.1"},
+		IntegratedTime:    strconv.FormatInt(entry.IntegratedTime, 10),
+		CanonicalizedBody: entry.Body,
+	}
+	if entry.Verification.SignedEntryTimestamp != "" {
+		tlog.InclusionPromise = &InclusionPromise{SignedEntryTimestamp: entry.Verification.SignedEntryTimestamp}
+	}
+	if proof := entry.Verification.InclusionProof; proof != nil {
+		rootHash, err := hexToBase64(proof.RootHash)
+		if err != nil {
+			return nil, err
+		}
+		tlog.InclusionProof = &InclusionProof{
+			LogIndex:   strconv.FormatInt(proof.LogIndex, 10),
+			RootHash:   rootHash,
+			TreeSize:   strconv.FormatInt(proof.TreeSize, 10),
+			Hashes:     []string{},
+			Checkpoint: Checkpoint{Envelope: proof.Checkpoint},
+		}
+		for _, h := range proof.Hashes {
+			encoded, err := hexToBase64(h)
+			if err != nil {
+				return nil, err
+			}
+			tlog.InclusionProof.Hashes = append(tlog.InclusionProof.Hashes, encoded)
+		}
+	}
+	bundle.VerificationMaterial.TlogEntries = []TransparencyLogEntry{tlog}
+	return bundle, nil
+}
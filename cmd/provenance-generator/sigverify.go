@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/sign"
+)
+
+// rekorEntry is the subset of a Rekor "dsse" entry body needed to check
+// an envelope against the transparency log.
+type rekorEntry struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		PayloadHash struct {
+			Algorithm string `json:"algorithm"`
+			Value     string `json:"value"`
+		} `json:"payloadHash"`
+		Signatures []struct {
+			Signature string `json:"signature"`
+			Verifier  string `json:"verifier"`
+		} `json:"signatures"`
+	} `json:"spec"`
+}
+
+// rekorRecord is a Rekor entry recording an envelope, once its signed
+// entry timestamp has been checked against the key of the log.
+type rekorRecord struct {
+	integratedTime time.Time
+	// verifiers are the PEM public keys or certificates recorded as having
+	// signed the envelope.
+	verifiers [][]byte
+}
+
+// fetchRekorRecord fetches the Rekor entry "uuid", checks that it was
+// signed by the log whose key is "logKey" and that it records "payload".
+// The keys it records are only as trustworthy as whoever uploaded the
+// entry, so callers must check them against a pinned key or identity.
+func fetchRekorRecord(rekorURL, uuid string, payload []byte, logKey crypto.PublicKey) (*rekorRecord, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(strings.TrimSuffix(rekorURL, "/") + "/api/v1/log/entries/" + uuid)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rekor returned %s", resp.Status)
+	}
+	var entries map[string]rekorLogEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		return nil, fmt.Errorf("rekor entry %s not found", uuid)
+	}
+	for _, e := range entries {
+		if err := verifySignedEntryTimestamp(e, logKey); err != nil {
+			return nil, err
+		}
+		body, err := base64.StdEncoding.DecodeString(e.Body)
+		if err != nil {
+			return nil, err
+		}
+		var entry rekorEntry
+		if err := json.Unmarshal(body, &entry); err != nil {
+			return nil, err
+		}
+		if entry.Kind != "dsse" {
+			return nil, fmt.Errorf("unsupported rekor entry kind %q", entry.Kind)
+		}
+		digest := sha256.Sum256(payload)
+		if entry.Spec.PayloadHash.Algorithm != "sha256" || entry.Spec.PayloadHash.Value != hex.EncodeToString(digest[:]) {
+			return nil, fmt.Errorf("rekor entry does not record this payload")
+		}
+		record := &rekorRecord{integratedTime: time.Unix(e.IntegratedTime, 0)}
+		for _, sig := range entry.Spec.Signatures {
+			verifier, err := base64.StdEncoding.DecodeString(sig.Verifier)
+			if err != nil {
+				return nil, err
+			}
+			record.verifiers = append(record.verifiers, verifier)
+		}
+		return record, nil
+	}
+	panic("unreachable")
+}
+
+// verifySignedEntryTimestamp checks the signed entry timestamp of "e", the
+// signature of the log over the canonical JSON of the entry's body,
+// integrated time, log ID and index, against the key of the log.
+func verifySignedEntryTimestamp(e rekorLogEntry, logKey crypto.PublicKey) error {
+	der, err := x509.MarshalPKIXPublicKey(logKey)
+	if err != nil {
+		return err
+	}
+	logID := sha256.Sum256(der)
+	if e.LogID != hex.EncodeToString(logID[:]) {
+		return fmt.Errorf("rekor entry is from log %s, not the one of the rekor public key", e.LogID)
+	}
+	signed, err := provenance.CanonicalMarshal(map[string]interface{}{
+		"body":           e.Body,
+		"integratedTime": e.IntegratedTime,
+		"logID":          e.LogID,
+		"logIndex":       e.LogIndex,
+	})
+	if err != nil {
+		return err
+	}
+	set, err := base64.StdEncoding.DecodeString(e.Verification.SignedEntryTimestamp)
+	if err != nil {
+		return err
+	}
+	if err := (sign.PublicKeyVerifier{Key: logKey}).Verify(signed, set); err != nil {
+		return fmt.Errorf("rekor signed entry timestamp: %s", err)
+	}
+	return nil
+}
+
+// recordsKey reports whether "verifier", a PEM public key or certificate
+// recorded in a Rekor entry, is or certifies "key".
+func recordsKey(verifier []byte, key crypto.PublicKey) bool {
+	block, _ := pem.Decode(verifier)
+	if block == nil {
+		return false
+	}
+	recorded := block.Bytes
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return false
+		}
+		recorded = cert.RawSubjectPublicKeyInfo
+	}
+	want, err := x509.MarshalPKIXPublicKey(key)
+	return err == nil && bytes.Equal(recorded, want)
+}
+
+// verifyEnvelopeTimestamps checks the RFC 3161 timestamp token of every
+// signature of "envelope" that has one against the TSA certificates in the
+// PEM file "chainPath", or the system roots when it is empty, and returns
+// the times they attest.
+func verifyEnvelopeTimestamps(envelope *sign.Envelope, chainPath string) ([]time.Time, error) {
+	var roots *x509.CertPool
+	var times []time.Time
+	for _, signature := range envelope.Signatures {
+		if signature.Timestamp == "" {
+			continue
+		}
+		if roots == nil {
+			var err error
+			if roots, err = readCertPool(chainPath); err != nil {
+				return nil, err
+			}
+		}
+		sig, err := base64.StdEncoding.DecodeString(signature.Sig)
+		if err != nil {
+			return nil, err
+		}
+		genTime, err := sign.VerifyTimestamp(signature.Timestamp, sig, roots)
+		if err != nil {
+			return nil, fmt.Errorf("signature %s: %s", signature.KeyID, err)
+		}
+		fmt.Println(fmt.Sprintf("Verified timestamp: [keyid=%s, time=%s]", signature.KeyID, genTime.UTC().Format(time.RFC3339)))
+		times = append(times, genTime)
+	}
+	return times, nil
+}
+
+// readCertPool returns the certificates of the PEM file at "path", or the
+// system roots when it is empty.
+func readCertPool(path string) (*x509.CertPool, error) {
+	if path == "" {
+		return x509.SystemCertPool()
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(contents) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// verifySignatureCommand validates the signatures and payload type of a
+// DSSE envelope against a public key or a Fulcio certificate issued to an
+// identity, optionally recorded in a Rekor entry.
+func verifySignatureCommand(args []string) {
+	fs := flag.NewFlagSet("verify-signature", flag.ExitOnError)
+	envelopePath := fs.String("envelope_path", "provenance.json", "The path of the DSSE envelope to verify.")
+	publicKey := fs.String("public_key", "", "The path of a PEM or SSH public key the envelope must be signed with.")
+	certificate := fs.String("certificate", "", "The path of a PEM Fulcio certificate the envelope must be signed with.")
+	roots := fs.String("certificate_chain", "", "The path of the PEM root and intermediate certificates the Fulcio certificate must chain to.")
+	identity := fs.String("certificate_identity", "", "The email or URI the Fulcio certificate must be issued to.")
+	issuer := fs.String("certificate_oidc_issuer", "", "The OIDC issuer the Fulcio certificate identity must come from.")
+	rekorUUID := fs.String("rekor_uuid", "", "The UUID of the Rekor entry recording the envelope.")
+	rekorURL := fs.String("rekor_url", "https://rekor.sigstore.dev", "The URL of the Rekor server.")
+	rekorKey := fs.String("rekor_public_key", "", "The path of the PEM public key of the Rekor log, which must have signed the entry of --rekor_uuid.")
+	tsaChain := fs.String("tsa_certificate_chain", "", "The path of the PEM certificates the certificates of timestamp authorities must chain to; defaults to the system roots.")
+	fs.Parse(args)
+	if *publicKey == "" && *certificate == "" && *rekorUUID == "" {
+		fmt.Println("No value found for one of the required flags: --public_key, --certificate, --rekor_uuid")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *certificate != "" && (*identity == "" || *issuer == "") {
+		fmt.Println("--certificate requires --certificate_identity and --certificate_oidc_issuer")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *rekorUUID != "" && *publicKey == "" && (*identity == "" || *issuer == "") {
+		fmt.Println("--rekor_uuid requires --public_key, or --certificate_identity and --certificate_oidc_issuer")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *rekorUUID != "" && *rekorKey == "" {
+		fmt.Println("--rekor_uuid requires --rekor_public_key")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	contents, err := ioutil.ReadFile(*envelopePath)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to read envelope: %s", err))
+		os.Exit(1)
+	}
+	var envelope sign.Envelope
+	if err := json.Unmarshal(contents, &envelope); err != nil {
+		fmt.Println(fmt.Sprintf("Failed to parse envelope: %s", err))
+		os.Exit(1)
+	}
+	if envelope.PayloadType != sign.PayloadContentType {
+		fmt.Println(fmt.Sprintf("Unexpected payload type: [expected=%s, found=%s]", sign.PayloadContentType, envelope.PayloadType))
+		os.Exit(1)
+	}
+	signedAt, err := verifyEnvelopeTimestamps(&envelope, *tsaChain)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Timestamp verification failed: %s", err))
+		os.Exit(1)
+	}
+
+	var record *rekorRecord
+	if *rekorUUID != "" {
+		logKey, err := readPublicKey(*rekorKey)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to read rekor public key: %s", err))
+			os.Exit(1)
+		}
+		payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+		if err != nil {
+			panic(err)
+		}
+		if record, err = fetchRekorRecord(*rekorURL, *rekorUUID, payload, logKey); err != nil {
+			fmt.Println(fmt.Sprintf("Rekor verification failed: %s", err))
+			os.Exit(1)
+		}
+		signedAt = append(signedAt, record.integratedTime)
+	}
+
+	var verifiers []sign.Verifier
+	if *publicKey != "" {
+		contents, err := ioutil.ReadFile(*publicKey)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to read public key: %s", err))
+			os.Exit(1)
+		}
+		v, err := sign.LoadVerifier(contents)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to load public key: %s", err))
+			os.Exit(1)
+		}
+		if record != nil && !recordedKey(record, v) {
+			fmt.Println("Rekor verification failed: the entry does not record --public_key")
+			os.Exit(1)
+		}
+		verifiers = append(verifiers, v)
+	}
+
+	var certs []*x509.Certificate
+	if *certificate != "" {
+		cert, err := readCertificate(*certificate)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to read certificate: %s", err))
+			os.Exit(1)
+		}
+		if record != nil && !recordedKey(record, sign.PublicKeyVerifier{Key: cert.PublicKey}) {
+			fmt.Println("Rekor verification failed: the entry does not record --certificate")
+			os.Exit(1)
+		}
+		certs = append(certs, cert)
+	} else if record != nil && *publicKey == "" {
+		// The envelope must be signed by every certificate the entry records,
+		// each issued to the identity.
+		for _, verifier := range record.verifiers {
+			block, _ := pem.Decode(verifier)
+			if block == nil || block.Type != "CERTIFICATE" {
+				fmt.Println("Rekor verification failed: the entry records a key, not a certificate issued to --certificate_identity")
+				os.Exit(1)
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				fmt.Println(fmt.Sprintf("Rekor verification failed: %s", err))
+				os.Exit(1)
+			}
+			certs = append(certs, cert)
+		}
+	}
+	if len(certs) > 0 {
+		if len(signedAt) == 0 {
+			fmt.Println("Certificate verification failed: no evidence of when the envelope was signed; pass --rekor_uuid or timestamp signatures with a TSA")
+			os.Exit(1)
+		}
+		pool, err := readCertPool(*roots)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to read certificate chain: %s", err))
+			os.Exit(1)
+		}
+		for _, cert := range certs {
+			for i, at := range signedAt {
+				if err = sign.VerifyFulcioCertificate(cert, pool, *identity, *issuer, at); err == nil {
+					break
+				} else if i == len(signedAt)-1 {
+					fmt.Println(fmt.Sprintf("Certificate verification failed: %s", err))
+					os.Exit(1)
+				}
+			}
+			verifiers = append(verifiers, sign.PublicKeyVerifier{Key: cert.PublicKey})
+		}
+	}
+	if len(verifiers) == 0 {
+		fmt.Println("Rekor verification failed: the entry records no keys")
+		os.Exit(1)
+	}
+
+	for _, verifier := range verifiers {
+		keyID, err := sign.VerifyEnvelope(&envelope, verifier)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Signature verification failed: %s", err))
+			os.Exit(1)
+		}
+		fmt.Println(fmt.Sprintf("Verified signature: [keyid=%s]", keyID))
+	}
+}
+
+// recordedKey reports whether "record" records the key of "v". Rekor
+// entries record PEM keys and certificates, so SSH keys never match.
+func recordedKey(record *rekorRecord, v sign.Verifier) bool {
+	key, ok := v.(sign.PublicKeyVerifier)
+	if !ok {
+		return false
+	}
+	for _, verifier := range record.verifiers {
+		if recordsKey(verifier, key.Key) {
+			return true
+		}
+	}
+	return false
+}
+
+// readPublicKey reads the PEM public key at "path".
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("no PEM public key found in %s", path)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// readCertificate reads the first certificate of a PEM file.
+func readCertificate(path string) (*x509.Certificate, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM certificate found in %s", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
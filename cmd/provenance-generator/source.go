@@ -0,0 +1,277 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/giturl"
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+const SourcePredicateType = "https://slsa.dev/source_provenance/v1-draft"
+
+// SourceStatement is an in-toto Statement about a revision of a repository,
+// complementing the provenance of what was built from it.
+type SourceStatement struct {
+	Type          string               `json:"_type"`
+	Subject       []provenance.Subject `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     SourceProvenance     `json:"predicate"`
+}
+
+// SourceProvenance records how a revision got onto the ref it was built
+// from: who authored it, the pull request it was reviewed in and how the
+// branch is protected.
+type SourceProvenance struct {
+	RepoURI string `json:"repoUri"`
+	Ref     string `json:"ref"`
+	Commit  string `json:"commit"`
+	Author  string `json:"author,omitempty"`
+	// RecordedBy is the build the revision was recorded by.
+	RecordedBy       string             `json:"recordedBy"`
+	RecordedOn       string             `json:"recordedOn"`
+	PullRequest      *SourcePullRequest `json:"pullRequest,omitempty"`
+	BranchProtection *BranchProtection  `json:"branchProtection,omitempty"`
+}
+
+type SourcePullRequest struct {
+	Number   int    `json:"number"`
+	BaseRef  string `json:"baseRef,omitempty"`
+	URL      string `json:"url,omitempty"`
+	MergedAt string `json:"mergedAt,omitempty"`
+	// Reviewers are the users whose latest review approved the pull request.
+	Reviewers []string `json:"reviewers,omitempty"`
+}
+
+// BranchProtection is the protection of the branch a revision was merged to
+// or proposed for. An unprotected branch only records Protected false.
+type BranchProtection struct {
+	Branch                  string `json:"branch"`
+	Protected               bool   `json:"protected"`
+	RequiredApprovals       int    `json:"requiredApprovals,omitempty"`
+	RequireCodeOwnerReviews bool   `json:"requireCodeOwnerReviews,omitempty"`
+	DismissStaleReviews     bool   `json:"dismissStaleReviews,omitempty"`
+	EnforceAdmins           bool   `json:"enforceAdmins,omitempty"`
+	RequireSignatures       bool   `json:"requireSignatures,omitempty"`
+	AllowForcePushes        bool   `json:"allowForcePushes,omitempty"`
+	AllowDeletions          bool   `json:"allowDeletions,omitempty"`
+}
+
+// sourceProvenance describes the revision built by the job whose
+// environment is "env".
+func sourceProvenance(env map[string]string) (SourceProvenance, error) {
+	commit := env["BUILDKITE_COMMIT"]
+	if len(commit) != 40 {
+		return SourceProvenance{}, fmt.Errorf("commit %q is not a resolved commit SHA", commit)
+	}
+	uri, err := giturl.SourceURI(env["BUILDKITE_REPO"])
+	if err != nil {
+		return SourceProvenance{}, err
+	}
+	source := SourceProvenance{
+		RepoURI:    uri,
+		Ref:        "refs/heads/" + env["BUILDKITE_BRANCH"],
+		Commit:     commit,
+		Author:     env["BUILDKITE_BUILD_AUTHOR_EMAIL"],
+		RecordedBy: env["BUILDKITE_BUILD_URL"],
+		RecordedOn: now().UTC().Format(time.RFC3339),
+	}
+	if tag := env["BUILDKITE_TAG"]; tag != "" {
+		source.Ref = "refs/tags/" + tag
+	}
+	if n, err := strconv.Atoi(env["BUILDKITE_PULL_REQUEST"]); err == nil {
+		source.PullRequest = &SourcePullRequest{Number: n}
+		if base := env["BUILDKITE_PULL_REQUEST_BASE_BRANCH"]; base != "" {
+			source.PullRequest.BaseRef = "refs/heads/" + base
+		}
+	}
+	return source, nil
+}
+
+// gitHubRepository returns the "owner/name" of the GitHub repository with
+// material URI "uri".
+func gitHubRepository(uri string) (string, error) {
+	u, err := url.Parse(strings.TrimPrefix(uri, "git+"))
+	if err != nil {
+		return "", err
+	}
+	repo := strings.Trim(strings.TrimSuffix(u.Path, ".git"), "/")
+	if parts := strings.Split(repo, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("%s is not a GitHub repository", uri)
+	}
+	return repo, nil
+}
+
+// lookupGitHubSource adds the pull request that introduced the revision,
+// its approving reviewers and the protection of its branch to "source" from
+// the GitHub repository "repo".
+func lookupGitHubSource(repo string, source *SourceProvenance) error {
+	var pulls []struct {
+		Number   int    `json:"number"`
+		HTMLURL  string `json:"html_url"`
+		MergedAt string `json:"merged_at"`
+		Base     struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+	}
+	if err := gitHubDo("GET", "/repos/"+repo+"/commits/"+source.Commit+"/pulls", nil, http.StatusOK, &pulls); err != nil {
+		return err
+	}
+	for _, pull := range pulls {
+		if source.PullRequest != nil && pull.Number != source.PullRequest.Number {
+			continue
+		}
+		if source.PullRequest == nil && pull.MergedAt == "" {
+			continue
+		}
+		source.PullRequest = &SourcePullRequest{Number: pull.Number, BaseRef: "refs/heads/" + pull.Base.Ref, URL: pull.HTMLURL, MergedAt: pull.MergedAt}
+		break
+	}
+
+	branch := strings.TrimPrefix(source.Ref, "refs/heads/")
+	if source.PullRequest != nil {
+		var reviews []struct {
+			User struct {
+				Login string `json:"login"`
+			} `json:"user"`
+			State string `json:"state"`
+		}
+		if err := gitHubDo("GET", fmt.Sprintf("/repos/%s/pulls/%d/reviews?per_page=100", repo, source.PullRequest.Number), nil, http.StatusOK, &reviews); err != nil {
+			return err
+		}
+		// Reviews are listed oldest first; a later review (e.g. requesting
+		// changes) replaces an approval. Comments do not.
+		latest := map[string]string{}
+		for _, review := range reviews {
+			if review.State != "COMMENTED" {
+				latest[review.User.Login] = review.State
+			}
+		}
+		for login, state := range latest {
+			if state == "APPROVED" {
+				source.PullRequest.Reviewers = append(source.PullRequest.Reviewers, login)
+			}
+		}
+		sort.Strings(source.PullRequest.Reviewers)
+		if source.PullRequest.BaseRef != "" {
+			branch = strings.TrimPrefix(source.PullRequest.BaseRef, "refs/heads/")
+		}
+	}
+	if strings.HasPrefix(source.Ref, "refs/tags/") && source.PullRequest == nil {
+		return nil
+	}
+
+	var protection struct {
+		RequiredPullRequestReviews *struct {
+			RequiredApprovingReviewCount int  `json:"required_approving_review_count"`
+			RequireCodeOwnerReviews      bool `json:"require_code_owner_reviews"`
+			DismissStaleReviews          bool `json:"dismiss_stale_reviews"`
+		} `json:"required_pull_request_reviews"`
+		EnforceAdmins      struct{ Enabled bool } `json:"enforce_admins"`
+		RequiredSignatures struct{ Enabled bool } `json:"required_signatures"`
+		AllowForcePushes   struct{ Enabled bool } `json:"allow_force_pushes"`
+		AllowDeletions     struct{ Enabled bool } `json:"allow_deletions"`
+	}
+	err := gitHubDo("GET", "/repos/"+repo+"/branches/"+url.PathEscape(branch)+"/protection", nil, http.StatusOK, &protection)
+	if e, ok := err.(*gitHubError); ok && e.StatusCode == http.StatusNotFound {
+		source.BranchProtection = &BranchProtection{Branch: branch}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	source.BranchProtection = &BranchProtection{
+		Branch:            branch,
+		Protected:         true,
+		EnforceAdmins:     protection.EnforceAdmins.Enabled,
+		RequireSignatures: protection.RequiredSignatures.Enabled,
+		AllowForcePushes:  protection.AllowForcePushes.Enabled,
+		AllowDeletions:    protection.AllowDeletions.Enabled,
+	}
+	if reviews := protection.RequiredPullRequestReviews; reviews != nil {
+		source.BranchProtection.RequiredApprovals = reviews.RequiredApprovingReviewCount
+		source.BranchProtection.RequireCodeOwnerReviews = reviews.RequireCodeOwnerReviews
+		source.BranchProtection.DismissStaleReviews = reviews.DismissStaleReviews
+	}
+	return nil
+}
+
+// sourceCommand emits a source attestation about the repository revision
+// the current build is of.
+func sourceCommand(args []string) {
+	fs := flag.NewFlagSet("source", flag.ExitOnError)
+	output := fs.String("output_path", "source.json", "The path to which the source attestation should be written.")
+	jobEnv := fs.String("job_env_file", "", "The path to a file holding the job environment as written by 'env -0'.")
+	lookup := fs.Bool("github_lookup", false, "Look up the pull request, its reviewers and the branch protection of the revision in the GitHub API.")
+	preset := fs.String("preset", "", "The output convention to follow.")
+	var keys arrayFlags
+	fs.Var(&keys, "sign_key", "A key used to sign the source envelope; may be repeated.")
+	tsa := fs.String("tsa_url", "", "The URL of an RFC 3161 timestamp authority used to timestamp signatures.")
+	format := fs.String("output_format", "", "Set to 'sigstore-bundle' to write a Sigstore bundle instead of a statement or envelope.")
+	rekor := fs.String("rekor_url", DefaultRekorURL, "The Rekor server sigstore bundles are recorded in.")
+	style := addOutputStyleFlags(fs)
+	upload := addUploadFlags(fs)
+	artifactUpload := addArtifactUploadFlags(fs)
+	archivista := fs.String("archivista_url", "", "The URL of an Archivista server the signed envelope is also stored in.")
+	github := fs.String("github_repository", "", "The GitHub repository ('owner/name') whose artifact attestations the Sigstore bundle is published to.")
+	fs.Parse(args)
+	logToStderr(*output)
+	if err := upload.validate(); err != nil {
+		fmt.Println(fmt.Sprintf("Invalid upload: %s", err))
+		os.Exit(1)
+	}
+
+	env, err := loadEnvironment(*jobEnv)
+	if err != nil {
+		panic(err)
+	}
+	source, err := sourceProvenance(env)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to describe source: %s", err))
+		os.Exit(1)
+	}
+	if *lookup {
+		repo, err := gitHubRepository(source.RepoURI)
+		if err == nil {
+			err = lookupGitHubSource(repo, &source)
+		}
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to look up source in GitHub: %s", err))
+			os.Exit(1)
+		}
+	}
+
+	stmt := SourceStatement{
+		Type:          "https://in-toto.io/Statement/v0.1",
+		Subject:       []provenance.Subject{{Name: source.RepoURI, Digest: provenance.DigestSet{"sha1": source.Commit}}},
+		PredicateType: SourcePredicateType,
+		Predicate:     source,
+	}
+	payload, _ := provenance.EscapedMarshalIndent(stmt, "", "  ")
+	fmt.Println("Source:\n" + string(payload))
+	if _, err := writeAttestation(stmt, stmt.Subject, outputOptions{
+		path:     *output,
+		pathSet:  flagSetPassed(fs, "output_path"),
+		preset:   *preset,
+		signKeys: keys,
+		tsaURL:   *tsa,
+		format:   *format,
+		rekorURL: *rekor,
+		style:    *style,
+		upload:   upload,
+
+		properties:    buildProperties(provenance.BuildContext{BuildURL: source.RecordedBy, Commit: source.Commit}),
+		artifacts:     artifactUpload,
+		archivistaURL: *archivista,
+		githubRepo:    *github,
+	}); err != nil {
+		fmt.Println(fmt.Sprintf("Failed to write source attestation: %s", err))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func sourceEnv() map[string]string {
+	return map[string]string{
+		"BUILDKITE_BUILD_URL": "https://buildkite.com/acme/app/builds/42",
+		"BUILDKITE_COMMIT":    "1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c",
+		"BUILDKITE_REPO":      "git@github.com:acme/app.git",
+	}
+}
+
+func TestSourceProvenance(t *testing.T) {
+	defer func(saved func() time.Time) { now = saved }(now)
+	now = func() time.Time { return time.Date(2021, time.June, 1, 12, 1, 0, 0, time.UTC) }
+
+	env := sourceEnv()
+	env["BUILDKITE_BRANCH"] = "feature/login"
+	env["BUILDKITE_PULL_REQUEST"] = "17"
+	env["BUILDKITE_PULL_REQUEST_BASE_BRANCH"] = "main"
+	got, err := sourceProvenance(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SourceProvenance{
+		RepoURI:     "git+https://github.com/acme/app",
+		Ref:         "refs/heads/feature/login",
+		Commit:      "1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c",
+		RecordedBy:  "https://buildkite.com/acme/app/builds/42",
+		RecordedOn:  "2021-06-01T12:01:00Z",
+		PullRequest: &SourcePullRequest{Number: 17, BaseRef: "refs/heads/main"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	env = sourceEnv()
+	env["BUILDKITE_TAG"] = "v1.2.3"
+	if got, err := sourceProvenance(env); err != nil {
+		t.Error(err)
+	} else if got.Ref != "refs/tags/v1.2.3" {
+		t.Errorf("tag build: got ref %s, want refs/tags/v1.2.3", got.Ref)
+	}
+
+	env["BUILDKITE_COMMIT"] = "HEAD"
+	if _, err := sourceProvenance(env); err == nil {
+		t.Errorf("got no error for an unresolved commit")
+	}
+}
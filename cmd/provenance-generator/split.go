@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// SplitOutputSuffix is appended to the path of each artifact to name the
+// attestation --split_output writes for it, as npm, Homebrew and
+// slsa-verifier expect.
+const SplitOutputSuffix = ".intoto.jsonl"
+
+// splitAttestation is the statement about one artifact file, and the
+// entries of its archive, that --split_output writes next to it.
+type splitAttestation struct {
+	path     string
+	subjects []provenance.Subject
+}
+
+// splitSubjects groups "subjects" by the artifact file they were digested
+// from, in order, and returns the subjects of no file (e.g. images and
+// precomputed subjects) separately.
+func splitSubjects(subjects []provenance.Subject) ([]splitAttestation, []provenance.Subject) {
+	var split []splitAttestation
+	var rest []provenance.Subject
+	byFile := map[string]int{}
+	for _, subject := range subjects {
+		file := subject.File
+		if subject.Archive != "" {
+			file = subject.Archive
+		}
+		if file == "" {
+			rest = append(rest, subject)
+			continue
+		}
+		i, ok := byFile[file]
+		if !ok {
+			i = len(split)
+			byFile[file] = i
+			split = append(split, splitAttestation{path: file + SplitOutputSuffix})
+		}
+		split[i].subjects = append(split[i].subjects, subject)
+	}
+	return split, rest
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stepImages returns the container images the job ran its command in: the
+// "image" of the docker plugin of the step, the image of the service the
+// docker-compose plugin runs as set in the Compose files in "checkoutDir",
+// and the "image" agent tag (e.g. of agents running in containers).
+func stepImages(env map[string]string, checkoutDir string) ([]string, error) {
+	var images []string
+	if tag := agentTags(env)["image"]; tag != "" {
+		images = append(images, tag)
+	}
+	if env["BUILDKITE_PLUGINS"] == "" {
+		return images, nil
+	}
+	var plugins []map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(env["BUILDKITE_PLUGINS"]), &plugins); err != nil {
+		return nil, fmt.Errorf("invalid BUILDKITE_PLUGINS: %s", err)
+	}
+	for _, plugin := range plugins {
+		for label, config := range plugin {
+			name := label
+			if i := strings.LastIndex(name, "#"); i >= 0 {
+				name = name[:i]
+			}
+			name = strings.TrimSuffix(name[strings.LastIndex(name, "/")+1:], ".git")
+			var options struct {
+				Image  string          `json:"image"`
+				Run    string          `json:"run"`
+				Config json.RawMessage `json:"config"`
+			}
+			json.Unmarshal(config, &options)
+			switch name {
+			case "docker-buildkite-plugin":
+				if options.Image != "" {
+					images = append(images, options.Image)
+				}
+			case "docker-compose-buildkite-plugin":
+				if options.Run == "" {
+					continue
+				}
+				files := []string{"docker-compose.yml"}
+				var file string
+				var list []string
+				if json.Unmarshal(options.Config, &file) == nil && file != "" {
+					files = []string{file}
+				} else if json.Unmarshal(options.Config, &list) == nil && len(list) > 0 {
+					files = list
+				}
+				for _, file := range files {
+					image, err := composeServiceImage(filepath.Join(checkoutDir, file), options.Run)
+					if err != nil {
+						return nil, fmt.Errorf("failed to read the image of service %s: %s", options.Run, err)
+					}
+					if image != "" {
+						images = append(images, image)
+						break
+					}
+				}
+			}
+		}
+	}
+	return images, nil
+}
+
+// composeServiceImage returns the "image" of "service" in the Compose file
+// at "path", or "" when it has none (e.g. it is built by the step). Only
+// the block style YAML of Compose files is understood.
+func composeServiceImage(path, service string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	// Indentation of the service keys, of the keys of the service and
+	// whether the current line is in the service.
+	inServices, keyIndent, childIndent, inService := false, -1, -1, false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(trimmed)
+		switch {
+		case indent == 0:
+			inServices, inService = trimmed == "services:", false
+		case !inServices:
+		case keyIndent < 0 || indent == keyIndent:
+			keyIndent, childIndent = indent, -1
+			inService = strings.Trim(strings.TrimSuffix(trimmed, ":"), `"'`) == service && strings.HasSuffix(trimmed, ":")
+		case inService:
+			if childIndent < 0 {
+				childIndent = indent
+			}
+			if indent == childIndent && strings.HasPrefix(trimmed, "image:") {
+				return strings.Trim(strings.TrimSpace(strings.TrimPrefix(trimmed, "image:")), `"'`), nil
+			}
+		}
+	}
+	return "", scanner.Err()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/giturl"
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// submodule is a submodule declared in .gitmodules.
+type submodule struct {
+	Name string
+	Path string
+	URL  string
+}
+
+// readGitModules returns the submodules declared in the .gitmodules file
+// at "file", in the order they are declared.
+func readGitModules(file string) ([]submodule, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var modules []submodule
+	var current *submodule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			current = nil
+			section := strings.TrimSpace(strings.Trim(line, "[]"))
+			if strings.HasPrefix(section, "submodule ") {
+				modules = append(modules, submodule{Name: strings.Trim(strings.TrimPrefix(section, "submodule "), ` "`)})
+				current = &modules[len(modules)-1]
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		key, value := line, ""
+		if i := strings.Index(line, "="); i >= 0 {
+			key, value = strings.TrimSpace(line[:i]), strings.Trim(strings.TrimSpace(line[i+1:]), `"`)
+		}
+		switch strings.ToLower(key) {
+		case "path":
+			current.Path = value
+		case "url":
+			current.URL = value
+		}
+	}
+	return modules, scanner.Err()
+}
+
+// submoduleMaterials returns a material for each submodule of the
+// repository checked out in "checkoutDir" that is checked out itself: its
+// repository, with URLs relative to the repository of "source" resolved
+// against it, and the commit checked out as its sha1 digest, as in
+// "git submodule status". Submodules that are not initialized were not
+// part of the build and are left out.
+func submoduleMaterials(checkoutDir string, source provenance.Item) ([]provenance.Item, error) {
+	modules, err := readGitModules(filepath.Join(checkoutDir, ".gitmodules"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var materials []provenance.Item
+	for _, module := range modules {
+		if module.Path == "" || module.URL == "" {
+			return nil, fmt.Errorf("submodule %s has no path or url", module.Name)
+		}
+		commit, err := gitHead(filepath.Join(checkoutDir, filepath.FromSlash(module.Path)))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to resolve submodule %s: %s", module.Name, err)
+		}
+		var uri string
+		if strings.HasPrefix(module.URL, "./") || strings.HasPrefix(module.URL, "../") {
+			repository, _ := giturl.SplitSourceURI(source.URI)
+			if repository == "" {
+				return nil, fmt.Errorf("submodule %s has a relative url but there is no source material", module.Name)
+			}
+			i := strings.Index(repository, "://") + len("://")
+			if i < len("://") {
+				return nil, fmt.Errorf("invalid source material %s", source.URI)
+			}
+			uri = repository[:i] + strings.TrimSuffix(path.Join(repository[i:], module.URL), ".git")
+		} else {
+			u, err := giturl.Parse(module.URL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid url of submodule %s: %s", module.Name, err)
+			}
+			if uri, err = giturl.RepositoryURI(u); err != nil {
+				return nil, fmt.Errorf("invalid url of submodule %s: %s", module.Name, err)
+			}
+		}
+		materials = append(materials, provenance.Item{URI: uri, Digest: provenance.DigestSet{"sha1": commit}})
+	}
+	return materials, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+const SummaryPredicateType = "https://buildkite.com/Attestations/BuildSummary@v1"
+
+// SummaryStatement is an in-toto Statement whose subjects are the
+// attestations produced by a build.
+type SummaryStatement struct {
+	Type          string               `json:"_type"`
+	Subject       []provenance.Subject `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     BuildSummary         `json:"predicate"`
+}
+
+type BuildSummary struct {
+	BuildURL string       `json:"buildUrl"`
+	Number   int          `json:"number"`
+	Commit   string       `json:"commit"`
+	State    string       `json:"state"`
+	Jobs     []JobSummary `json:"jobs"`
+}
+
+type JobSummary struct {
+	ID           string               `json:"id"`
+	Name         string               `json:"name"`
+	StepKey      string               `json:"stepKey,omitempty"`
+	State        string               `json:"state"`
+	URL          string               `json:"url"`
+	Attestations []provenance.Subject `json:"attestations"`
+}
+
+// summaryCommand emits a summary attestation listing every job of the
+// current build, its state and the attestations it uploaded.
+func summaryCommand(args []string) {
+	fs := flag.NewFlagSet("summary", flag.ExitOnError)
+	output := fs.String("output_path", "build-summary.json", "The path to which the summary attestation should be written.")
+	jobEnv := fs.String("job_env_file", "", "The path to a file holding the job environment as written by 'env -0'.")
+	patterns := fs.String("attestation_patterns", "*.intoto.jsonl,*.att,*.attestation.json,provenance*.json", "Comma-separated artifact name patterns that identify attestations.")
+	preset := fs.String("preset", "", "The output convention to follow.")
+	var keys arrayFlags
+	fs.Var(&keys, "sign_key", "A key used to sign the summary envelope; may be repeated.")
+	tsa := fs.String("tsa_url", "", "The URL of an RFC 3161 timestamp authority used to timestamp signatures.")
+	format := fs.String("output_format", "", "Set to 'sigstore-bundle' to write a Sigstore bundle instead of a statement or envelope.")
+	rekor := fs.String("rekor_url", DefaultRekorURL, "The Rekor server sigstore bundles are recorded in.")
+	style := addOutputStyleFlags(fs)
+	upload := addUploadFlags(fs)
+	artifactUpload := addArtifactUploadFlags(fs)
+	archivista := fs.String("archivista_url", "", "The URL of an Archivista server the signed envelope is also stored in.")
+	github := fs.String("github_repository", "", "The GitHub repository ('owner/name') whose artifact attestations the Sigstore bundle is published to.")
+	fs.Parse(args)
+	logToStderr(*output)
+	if err := upload.validate(); err != nil {
+		fmt.Println(fmt.Sprintf("Invalid upload: %s", err))
+		os.Exit(1)
+	}
+
+	token := os.Getenv("BUILDKITE_API_TOKEN")
+	if token == "" {
+		fmt.Println("No value found for required environment variable: BUILDKITE_API_TOKEN")
+		os.Exit(1)
+	}
+	env, err := loadEnvironment(*jobEnv)
+	if err != nil {
+		panic(err)
+	}
+	org, pipeline, number := env["BUILDKITE_ORGANIZATION_SLUG"], env["BUILDKITE_PIPELINE_SLUG"], env["BUILDKITE_BUILD_NUMBER"]
+	client := newBuildkiteClient(token)
+	build, err := client.build(org, pipeline, number)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to fetch build: %s", err))
+		os.Exit(1)
+	}
+	artifacts, err := client.artifacts(org, pipeline, number)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to fetch build artifacts: %s", err))
+		os.Exit(1)
+	}
+
+	summary := BuildSummary{
+		BuildURL: build.WebURL,
+		Number:   build.Number,
+		Commit:   build.Commit,
+		State:    build.State,
+		Jobs:     []JobSummary{},
+	}
+	stmt := SummaryStatement{Type: "https://in-toto.io/Statement/v0.1", PredicateType: SummaryPredicateType, Subject: []provenance.Subject{}}
+	for _, job := range build.Jobs {
+		if job.Type != "script" || job.ID == env["BUILDKITE_JOB_ID"] {
+			continue
+		}
+		js := JobSummary{ID: job.ID, Name: job.Name, StepKey: job.StepKey, State: job.State, URL: job.WebURL, Attestations: []provenance.Subject{}}
+		for _, artifact := range artifacts {
+			if artifact.JobID != job.ID || !matchAny(strings.Split(*patterns, ","), path.Base(artifact.Path)) {
+				continue
+			}
+			if artifact.SHA256 == "" {
+				if artifact.SHA256, err = client.sha256(artifact.DownloadURL); err != nil {
+					fmt.Println(fmt.Sprintf("Failed to download artifact: [path=%s] %s", artifact.Path, err))
+					os.Exit(1)
+				}
+			}
+			attestation := provenance.Subject{Name: artifact.Path, Digest: provenance.DigestSet{"sha256": artifact.SHA256}}
+			js.Attestations = append(js.Attestations, attestation)
+			stmt.Subject = append(stmt.Subject, attestation)
+		}
+		summary.Jobs = append(summary.Jobs, js)
+	}
+	sort.Slice(stmt.Subject, func(i, j int) bool { return stmt.Subject[i].Name < stmt.Subject[j].Name })
+	stmt.Predicate = summary
+
+	payload, _ := provenance.EscapedMarshalIndent(stmt, "", "  ")
+	fmt.Println("Build summary:\n" + string(payload))
+	if _, err := writeAttestation(stmt, stmt.Subject, outputOptions{
+		path:     *output,
+		pathSet:  flagSetPassed(fs, "output_path"),
+		preset:   *preset,
+		signKeys: keys,
+		tsaURL:   *tsa,
+		format:   *format,
+		rekorURL: *rekor,
+		style:    *style,
+		upload:   upload,
+
+		properties:    buildProperties(provenance.BuildContext{BuildURL: summary.BuildURL, Commit: summary.Commit}),
+		artifacts:     artifactUpload,
+		archivistaURL: *archivista,
+		githubRepo:    *github,
+	}); err != nil {
+		fmt.Println(fmt.Sprintf("Failed to write build summary: %s", err))
+		os.Exit(1)
+	}
+}
+
+// matchAny reports whether "name" matches any of the glob "patterns".
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.TrimSpace(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+const TestResultPredicateType = "https://in-toto.io/attestation/test-result/v0.1"
+
+// Results of test result attestations.
+const (
+	TestResultPassed = "PASSED"
+	TestResultWarned = "WARNED"
+	TestResultFailed = "FAILED"
+)
+
+// TestResult is the in-toto test result predicate: the outcome of the tests
+// the build ran on its subjects, so deploy gates can require that they
+// passed.
+type TestResult struct {
+	Result string `json:"result"`
+	// Configuration lists the test reports the results were read from.
+	Configuration []provenance.ResourceDescriptor `json:"configuration"`
+	URL           string                          `json:"url,omitempty"`
+	PassedTests   []string                        `json:"passedTests"`
+	WarnedTests   []string                        `json:"warnedTests"`
+	FailedTests   []string                        `json:"failedTests"`
+}
+
+// readTestResults combines the JUnit XML and summary JSON reports at
+// "paths" into a test result of the build at "buildURL". Skipped tests are
+// warnings and errors failures. A summary JSON report lists the names of
+// its "passedTests", "warnedTests" and "failedTests".
+func readTestResults(paths []string, buildURL string) (TestResult, error) {
+	results := TestResult{URL: buildURL, Configuration: []provenance.ResourceDescriptor{}, PassedTests: []string{}, WarnedTests: []string{}, FailedTests: []string{}}
+	for _, path := range paths {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return TestResult{}, err
+		}
+		if strings.HasPrefix(string(bytes.TrimSpace(contents)), "<") {
+			err = readJUnitResults(contents, &results)
+		} else {
+			err = readSummaryResults(contents, &results)
+		}
+		if err != nil {
+			return TestResult{}, fmt.Errorf("invalid test report %s: %s", path, err)
+		}
+		digest, err := provenance.DigestReader(bytes.NewReader(contents), int64(len(contents)), []string{"sha256"})
+		if err != nil {
+			return TestResult{}, err
+		}
+		results.Configuration = append(results.Configuration, provenance.ResourceDescriptor{URI: "file:" + url.PathEscape(filepath.Base(path)), Digest: digest})
+	}
+	if len(results.PassedTests)+len(results.WarnedTests)+len(results.FailedTests) == 0 {
+		return TestResult{}, fmt.Errorf("no tests found in %s", strings.Join(paths, ", "))
+	}
+	results.Result = TestResultPassed
+	if len(results.FailedTests) > 0 {
+		results.Result = TestResultFailed
+	} else if len(results.WarnedTests) > 0 {
+		results.Result = TestResultWarned
+	}
+	return results, nil
+}
+
+// junitSuite is a JUnit <testsuites> or <testsuite> element.
+type junitSuite struct {
+	Suites []junitSuite `xml:"testsuite"`
+	Cases  []struct {
+		Name      string     `xml:"name,attr"`
+		ClassName string     `xml:"classname,attr"`
+		Failures  []xml.Name `xml:"failure"`
+		Errors    []xml.Name `xml:"error"`
+		Skipped   []xml.Name `xml:"skipped"`
+	} `xml:"testcase"`
+}
+
+func readJUnitResults(contents []byte, results *TestResult) error {
+	var root junitSuite
+	if err := xml.Unmarshal(contents, &root); err != nil {
+		return err
+	}
+	var visit func(suite junitSuite)
+	visit = func(suite junitSuite) {
+		for _, c := range suite.Cases {
+			name := c.Name
+			if c.ClassName != "" {
+				name = c.ClassName + "." + c.Name
+			}
+			switch {
+			case len(c.Failures) > 0 || len(c.Errors) > 0:
+				results.FailedTests = append(results.FailedTests, name)
+			case len(c.Skipped) > 0:
+				results.WarnedTests = append(results.WarnedTests, name)
+			default:
+				results.PassedTests = append(results.PassedTests, name)
+			}
+		}
+		for _, nested := range suite.Suites {
+			visit(nested)
+		}
+	}
+	visit(root)
+	return nil
+}
+
+func readSummaryResults(contents []byte, results *TestResult) error {
+	var summary struct {
+		PassedTests []string `json:"passedTests"`
+		WarnedTests []string `json:"warnedTests"`
+		FailedTests []string `json:"failedTests"`
+	}
+	if err := json.Unmarshal(contents, &summary); err != nil {
+		return err
+	}
+	results.PassedTests = append(results.PassedTests, summary.PassedTests...)
+	results.WarnedTests = append(results.WarnedTests, summary.WarnedTests...)
+	results.FailedTests = append(results.FailedTests, summary.FailedTests...)
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// agentTags returns the tags of the agent running the job, as exposed to
+// the job through BUILDKITE_AGENT_META_DATA_* variables. The agent's
+// cluster is reported under the "cluster" tag.
+func agentTags(env map[string]string) map[string]string {
+	tags := map[string]string{}
+	for name, value := range env {
+		if strings.HasPrefix(name, "BUILDKITE_AGENT_META_DATA_") {
+			tags[strings.ToLower(strings.TrimPrefix(name, "BUILDKITE_AGENT_META_DATA_"))] = value
+		}
+	}
+	if cluster, ok := env["BUILDKITE_CLUSTER_ID"]; ok {
+		tags["cluster"] = cluster
+	}
+	return tags
+}
+
+// trustedBuilder reports whether an agent with "tags" satisfies at least one
+// of the allowlist entries. Each entry is a comma-separated list of
+// "tag=value" requirements, e.g. "queue=release,cluster=abc"; a trailing "*"
+// in a value matches any value with that prefix.
+func trustedBuilder(allowlist []string, tags map[string]string) (bool, error) {
+	for _, entry := range allowlist {
+		matched := true
+		for _, requirement := range strings.Split(entry, ",") {
+			i := strings.Index(requirement, "=")
+			if i <= 0 {
+				return false, fmt.Errorf("malformed trusted builder requirement %q", requirement)
+			}
+			value, ok := tags[strings.ToLower(strings.TrimSpace(requirement[:i]))]
+			if !ok || !matchName(strings.TrimSpace(requirement[i+1:]), value) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
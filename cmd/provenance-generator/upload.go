@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// uploadSchemes are the schemes of supported upload locations.
+var uploadSchemes = map[string]bool{"s3": true, "gs": true, "azblob": true, "artifactory": true}
+
+// Upload controls copying written attestations to object storage for
+// central archival.
+type Upload struct {
+	// URL is "s3://bucket/prefix/", "gs://bucket/prefix/",
+	// "azblob://account/container/prefix/" or
+	// "artifactory://host/artifactory/repo/prefix/", to which the output
+	// path is appended, or a URL of a single object. Empty disables
+	// uploads.
+	URL string
+	// Metadata holds "key=value" pairs stored as object metadata, or as
+	// properties in Artifactory.
+	Metadata arrayFlags
+	// SSE is the S3 server-side encryption: "", "AES256" or "aws:kms".
+	SSE string
+	// KMSKey is the AWS KMS key used with SSE "aws:kms", the Cloud KMS key
+	// name of a GCS customer-managed encryption key, or an Azure encryption
+	// scope. Empty uses the bucket's default.
+	KMSKey string
+	// Endpoint replaces the AWS, Google or Azure endpoint for compatible
+	// stores such as Azurite, or the scheme and host of an Artifactory
+	// location. S3-compatible stores are addressed path-style.
+	Endpoint string
+}
+
+// addUploadFlags registers the upload flags on "fs".
+func addUploadFlags(fs *flag.FlagSet) *Upload {
+	u := &Upload{}
+	fs.StringVar(&u.URL, "upload", "", "An S3, GCS, Azure Blob Storage or Artifactory location ('s3://bucket/prefix/', 'gs://bucket/prefix/', 'azblob://account/container/prefix/', 'artifactory://host/artifactory/repo/prefix/') the written file is also uploaded to.")
+	fs.Var(&u.Metadata, "upload_metadata", "Object metadata ('key=value') set on uploads; may be repeated.")
+	fs.StringVar(&u.SSE, "upload_sse", "", "The S3 server-side encryption of uploads: 'AES256' or 'aws:kms'.")
+	fs.StringVar(&u.KMSKey, "upload_kms_key", "", "The AWS KMS key uploads are encrypted with when --upload_sse is 'aws:kms', the Cloud KMS key name for GCS or the encryption scope for Azure.")
+	fs.StringVar(&u.Endpoint, "upload_endpoint", "", "The endpoint of an S3-, GCS- or Azure-compatible store to upload to instead of the cloud provider's, or the base URL of an Artifactory server.")
+	return u
+}
+
+// validate checks the settings without contacting the store.
+func (u *Upload) validate() error {
+	if u == nil || u.URL == "" {
+		return nil
+	}
+	scheme, _, _, err := u.location("")
+	if err != nil {
+		return err
+	}
+	if u.SSE != "" && (scheme != "s3" || (u.SSE != "AES256" && u.SSE != "aws:kms")) {
+		return fmt.Errorf("unknown server-side encryption %q for %s:// uploads", u.SSE, scheme)
+	}
+	if u.KMSKey != "" && scheme == "s3" && u.SSE != "aws:kms" {
+		return fmt.Errorf("a KMS key requires server-side encryption 'aws:kms'")
+	}
+	for _, pair := range u.Metadata {
+		if !strings.Contains(pair, "=") {
+			return fmt.Errorf("invalid upload metadata %q, expected key=value", pair)
+		}
+	}
+	return nil
+}
+
+// location returns the scheme, bucket and object key "path" is uploaded to.
+func (u *Upload) location(path string) (string, string, string, error) {
+	parsed, err := url.Parse(u.URL)
+	if err != nil || !uploadSchemes[parsed.Scheme] || parsed.Host == "" {
+		return "", "", "", fmt.Errorf("invalid upload location %q, expected s3://bucket/prefix/, gs://bucket/prefix/, azblob://account/container/prefix/ or artifactory://host/artifactory/repo/prefix/", u.URL)
+	}
+	key := strings.TrimPrefix(parsed.Path, "/")
+	if parsed.Scheme == "azblob" || parsed.Scheme == "artifactory" {
+		// Azure locations name the storage account and then the
+		// container, and Artifactory locations the server and then the
+		// repository path, which is kept at the start of the key.
+		container := strings.SplitN(key, "/", 2)[0]
+		if container == "" {
+			return "", "", "", fmt.Errorf("invalid upload location %q, expected a container or repository path", u.URL)
+		}
+		if key == container {
+			key += "/"
+		}
+	}
+	if key == "" || strings.HasSuffix(key, "/") {
+		key += strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "/")
+	}
+	return parsed.Scheme, parsed.Host, key, nil
+}
+
+// metadata returns the object metadata by key.
+func (u *Upload) metadata() map[string]string {
+	metadata := map[string]string{}
+	for _, pair := range u.Metadata {
+		kv := strings.SplitN(pair, "=", 2)
+		metadata[strings.TrimSpace(kv[0])] = kv[1]
+	}
+	return metadata
+}
+
+// upload stores "body", written to "path", and returns the URL of the
+// object. Stores that index properties (Artifactory) are given
+// "properties"; others store only the configured metadata.
+func (u *Upload) upload(path string, body []byte, properties map[string][]string) (string, error) {
+	scheme, bucket, key, err := u.location(path)
+	if err != nil {
+		return "", err
+	}
+	switch scheme {
+	case "gs":
+		err = u.gcsPut(bucket, key, body)
+	case "azblob":
+		err = u.azurePut(bucket, key, body)
+	case "artifactory":
+		err = u.artifactoryPut(bucket, key, body, properties)
+	default:
+		err = u.s3Put(bucket, key, body)
+	}
+	if err != nil {
+		return "", err
+	}
+	return scheme + "://" + bucket + "/" + key, nil
+}
+
+// download gets the object uploaded for "path".
+func (u *Upload) download(path string) ([]byte, error) {
+	scheme, bucket, key, err := u.location(path)
+	if err != nil {
+		return nil, err
+	}
+	switch scheme {
+	case "gs":
+		return u.gcsGet(bucket, key)
+	case "azblob":
+		return u.azureGet(bucket, key)
+	case "artifactory":
+		return u.artifactoryGet(bucket, key)
+	}
+	return u.s3Get(bucket, key)
+}
+
+// uploadCommand uploads an attestation written by an earlier run, e.g. one
+// signed on another agent, to object storage and as a job artifact.
+func uploadCommand(args []string) {
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+	path := fs.String("attestation_path", "", "The path of the statement, envelope or Sigstore bundle to upload.")
+	upload := addUploadFlags(fs)
+	artifactUpload := addArtifactUploadFlags(fs)
+	fs.Parse(args)
+	if *path == "" {
+		fmt.Println("No value found for required flag: --attestation_path")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if upload.URL == "" && !artifactUpload.Enabled {
+		fmt.Println("Nowhere to upload to; set --upload or --artifact_upload")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := upload.validate(); err != nil {
+		fmt.Println(fmt.Sprintf("Invalid upload: %s", err))
+		os.Exit(1)
+	}
+	payload, err := ioutil.ReadFile(*path)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to read attestation: %s", err))
+		os.Exit(1)
+	}
+	stmt, err := attestationStatement(payload)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to parse attestation %s: %s", *path, err))
+		os.Exit(1)
+	}
+	context := provenance.BuildContext{}
+	if stmt.PredicateType == "https://slsa.dev/provenance/v0.1" {
+		var provenance provenance.Statement
+		json.Unmarshal(payload, &provenance)
+		context.BuildURL = provenance.Predicate.Metadata.BuildInvocationId
+	}
+	if err := publishAttestation(*path, payload, stmt.Subject, outputOptions{
+		upload:     upload,
+		properties: buildProperties(context),
+		artifacts:  artifactUpload,
+	}); err != nil {
+		fmt.Println(fmt.Sprintf("Failed to upload attestation: %s", err))
+		os.Exit(1)
+	}
+}
+
+// attestationStatement returns the statement of the attestation
+// "document": a statement, a DSSE envelope or a Sigstore bundle.
+func attestationStatement(document []byte) (provenance.CustomStatement, error) {
+	var bundle SigstoreBundle
+	if err := json.Unmarshal(document, &bundle); err != nil {
+		return provenance.CustomStatement{}, err
+	}
+	if bundle.DSSEEnvelope != nil {
+		document, _ = json.Marshal(bundle.DSSEEnvelope)
+	}
+	statement, err := unwrapEnvelope(document)
+	if err != nil {
+		return provenance.CustomStatement{}, err
+	}
+	var stmt provenance.CustomStatement
+	if err := json.Unmarshal(statement, &stmt); err != nil {
+		return provenance.CustomStatement{}, err
+	}
+	if stmt.Type == "" {
+		return provenance.CustomStatement{}, fmt.Errorf("not an in-toto statement")
+	}
+	return stmt, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/sign"
+)
+
+// readStatements reads every in-toto statement in the file at "path". The
+// file may hold bare statements or DSSE envelopes, either indented or one
+// per line.
+func readStatements(path string) ([]json.RawMessage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	statements, err := decodeStatements(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(statements) == 0 {
+		return nil, fmt.Errorf("no statement found in %s", path)
+	}
+	return statements, nil
+}
+
+// decodeStatements decodes every statement or DSSE envelope read from "r".
+func decodeStatements(r io.Reader) ([]json.RawMessage, error) {
+	var statements []json.RawMessage
+	decoder := json.NewDecoder(r)
+	for {
+		var document json.RawMessage
+		if err := decoder.Decode(&document); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		statement, err := unwrapEnvelope(document)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, statement)
+	}
+	return statements, nil
+}
+
+// unwrapEnvelope returns the statement carried by a DSSE envelope, or the
+// document itself when it is not an envelope.
+func unwrapEnvelope(document json.RawMessage) (json.RawMessage, error) {
+	var envelope sign.Envelope
+	if err := json.Unmarshal(document, &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.PayloadType == "" {
+		return document, nil
+	}
+	if envelope.PayloadType != sign.PayloadContentType {
+		return nil, fmt.Errorf("unexpected envelope payload type %q", envelope.PayloadType)
+	}
+	return base64.StdEncoding.DecodeString(envelope.Payload)
+}
+
+// verifySubjects checks "actual" against the subjects claimed by the
+// provenance and returns a description of every discrepancy. Files with no
+// matching subject are only reported when "exhaustive" is set.
+func verifySubjects(claimed, actual []provenance.Subject, exhaustive bool) []string {
+	files := map[string]provenance.DigestSet{}
+	for _, s := range actual {
+		files[s.Name] = s.Digest
+	}
+	var problems []string
+	attested := map[string]bool{}
+	for _, s := range claimed {
+		attested[s.Name] = true
+		digest, ok := files[s.Name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("subject not found: %s", s.Name))
+			continue
+		}
+		compared := 0
+		for alg, want := range s.Digest {
+			got, ok := digest[alg]
+			if !ok {
+				continue
+			}
+			compared++
+			if got != want {
+				problems = append(problems, fmt.Sprintf("digest mismatch: %s [%s: provenance=%s actual=%s]", s.Name, alg, want, got))
+			}
+		}
+		if compared == 0 {
+			problems = append(problems, fmt.Sprintf("no supported digest for subject: %s", s.Name))
+		}
+	}
+	if exhaustive {
+		for name := range files {
+			if !attested[name] {
+				problems = append(problems, fmt.Sprintf("file not covered by provenance: %s", name))
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// claimedAlgorithms returns every supported digest algorithm of "claimed",
+// to digest the artifacts they are checked against with.
+func claimedAlgorithms(claimed []provenance.Subject) []string {
+	var algorithms []string
+	seen := map[string]bool{}
+	for _, s := range claimed {
+		for alg := range s.Digest {
+			if provenance.KnownDigestAlgorithm(alg) && !seen[alg] {
+				seen[alg] = true
+				algorithms = append(algorithms, alg)
+			}
+		}
+	}
+	return algorithms
+}
+
+// verifyCommand checks the artifacts at the given paths against the
+// subjects of a provenance file.
+func verifyCommand(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	var paths arrayFlags
+	fs.Var(&paths, "artifact_path", "The file or dir path of the artifacts to verify; may be repeated.")
+	provenancePath := fs.String("provenance_path", "provenance.json", "The path of the provenance to verify the artifacts against.")
+	exhaustive := fs.Bool("no_extra_files", false, "Fail when an artifact file is not a subject of the provenance.")
+	namePolicy := fs.String("subject_names", provenance.NamePolicyNFC, "The subject name policy the provenance was generated with: 'nfc', 'escape' or 'preserve'.")
+	fs.Var((*arrayFlags)(&walker.Exclude), "exclude", "A pattern of files under --artifact_path the provenance was generated without; may be repeated.")
+	namePrefix := fs.String("subject_name_prefix", "", "The prefix of subject names the provenance was generated with.")
+	nameTemplate := fs.String("subject_name_template", "{relpath}", "The subject name template the provenance was generated with.")
+	expand := fs.Bool("expand_archives", false, "Also verify the files inside archives the provenance was generated with --expand_archives for.")
+	addFileFilterFlags(fs, &walker.Filter)
+	fs.StringVar(&walker.Symlinks, "symlinks", provenance.SymlinksFollow, "The symlink policy the provenance was generated with: 'follow', 'skip' or 'hash-target'.")
+	fs.IntVar(&walker.Workers, "concurrency", walker.Workers, concurrencyUsage)
+	fs.Parse(args)
+	if len(paths) < 1 {
+		fmt.Println("No value found for required flag: --artifact_path")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if !provenance.ValidSymlinkPolicy(walker.Symlinks) {
+		fmt.Println(fmt.Sprintf("Unknown symlink policy: [provided=%s]", walker.Symlinks))
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := validSubjectNameTemplate(*nameTemplate); err != nil {
+		fmt.Println(fmt.Sprintf("Invalid subject name template: %s", err))
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	statements, err := readStatements(*provenancePath)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to read provenance: %s", err))
+		os.Exit(1)
+	}
+	var claimed []provenance.Subject
+	for _, statement := range statements {
+		var stmt struct {
+			Subject []provenance.Subject `json:"subject"`
+		}
+		if err := json.Unmarshal(statement, &stmt); err != nil {
+			fmt.Println(fmt.Sprintf("Failed to parse provenance: %s", err))
+			os.Exit(1)
+		}
+		claimed = append(claimed, stmt.Subject...)
+	}
+	algorithms := claimedAlgorithms(claimed)
+	dirHashes := map[string]bool{}
+	for _, s := range claimed {
+		dirHashes[s.Name] = s.Digest[provenance.DirHashAlgorithm] != ""
+	}
+	var actual []provenance.Subject
+	for _, path := range paths {
+		s, err := walker.Subjects(path, algorithms...)
+		if os.IsNotExist(err) || errors.Is(err, provenance.ErrNoMatch) {
+			fmt.Println(fmt.Sprintf("Resource path not found: [provided=%s]", path))
+			os.Exit(1)
+		} else if err != nil {
+			panic(err)
+		}
+		// Provenance generated with --subject_overflow dirhash attests
+		// the directory as a whole.
+		if name := *namePrefix + artifactDirName(path); dirHashes[name] {
+			dir, err := provenance.DirHashSubject(name, s)
+			if err != nil {
+				fmt.Println(fmt.Sprintf("Failed to digest %s: %s", path, err))
+				os.Exit(1)
+			}
+			s = []provenance.Subject{dir}
+		} else {
+			nameSubjects(s, path, *nameTemplate, *namePrefix)
+		}
+		if *expand {
+			if s, err = provenance.ExpandArchiveSubjects(s, algorithms); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		if err := provenance.NormalizeSubjectNames(s, *namePolicy); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		actual = append(actual, s...)
+	}
+
+	if problems := verifySubjects(claimed, actual, *exhaustive); len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		os.Exit(1)
+	}
+	fmt.Println(fmt.Sprintf("Verified %d subjects against %s", len(claimed), *provenancePath))
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/giturl"
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/sign"
+)
+
+const VSAPredicateType = "https://slsa.dev/verification_summary/v1"
+
+// VSAStatement is an in-toto Statement recording that its subjects passed
+// verification of their provenance against a policy.
+type VSAStatement struct {
+	Type          string               `json:"_type"`
+	Subject       []provenance.Subject `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     VerificationSummary  `json:"predicate"`
+}
+
+type VerificationSummary struct {
+	Verifier           VSAVerifier                     `json:"verifier"`
+	TimeVerified       string                          `json:"timeVerified"`
+	ResourceURI        string                          `json:"resourceUri"`
+	Policy             provenance.ResourceDescriptor   `json:"policy"`
+	InputAttestations  []provenance.ResourceDescriptor `json:"inputAttestations"`
+	VerificationResult string                          `json:"verificationResult"`
+	VerifiedLevels     []string                        `json:"verifiedLevels"`
+	SLSAVersion        string                          `json:"slsaVersion"`
+}
+
+type VSAVerifier struct {
+	ID string `json:"id"`
+}
+
+// VerificationPolicy is what provenance must show for its subjects to pass
+// verification. Empty lists allow any value.
+type VerificationPolicy struct {
+	// BuilderIDPrefixes are prefixes of the builder IDs allowed, e.g.
+	// "https://buildkite.com/organizations/acme/agents/".
+	BuilderIDPrefixes []string `json:"builderIdPrefixes"`
+	// SourceURIs are the material URIs the recipe may be defined in, e.g.
+	// "git+https://github.com/acme/app", matching them with or without
+	// their ref.
+	SourceURIs []string `json:"sourceUris"`
+	// SourceRefPrefixes are prefixes of the Git refs the source material
+	// may be of, e.g. "refs/tags/" for releases built from tags only.
+	SourceRefPrefixes []string `json:"sourceRefPrefixes"`
+	RecipeTypes       []string `json:"recipeTypes"`
+	// VerifiedLevels are the SLSA levels passing subjects are recorded to
+	// meet; SLSA_BUILD_LEVEL_1 by default.
+	VerifiedLevels []string `json:"verifiedLevels"`
+}
+
+// readVerificationPolicy reads the policy at "path", rejecting fields it
+// does not know so that misspelt constraints are not silently ignored.
+func readVerificationPolicy(path string) (VerificationPolicy, []byte, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return VerificationPolicy{}, nil, err
+	}
+	var policy VerificationPolicy
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policy); err != nil {
+		return VerificationPolicy{}, nil, fmt.Errorf("invalid policy %s: %s", path, err)
+	}
+	if len(policy.VerifiedLevels) == 0 {
+		policy.VerifiedLevels = []string{"SLSA_BUILD_LEVEL_1"}
+	}
+	return policy, contents, nil
+}
+
+// check returns a description of every way "stmt" violates the policy.
+func (p VerificationPolicy) check(stmt provenance.Statement) []string {
+	var problems []string
+	if stmt.PredicateType != "https://slsa.dev/provenance/v0.1" {
+		problems = append(problems, fmt.Sprintf("unsupported predicate type: %s", stmt.PredicateType))
+	}
+	if len(p.BuilderIDPrefixes) > 0 && !hasAnyPrefix(stmt.Predicate.Builder.Id, p.BuilderIDPrefixes) {
+		problems = append(problems, fmt.Sprintf("builder not allowed: %s", stmt.Predicate.Builder.Id))
+	}
+	if len(p.RecipeTypes) > 0 && !containsString(p.RecipeTypes, stmt.Predicate.Recipe.Type) {
+		problems = append(problems, fmt.Sprintf("recipe type not allowed: %s", stmt.Predicate.Recipe.Type))
+	}
+	if len(p.SourceURIs) > 0 || len(p.SourceRefPrefixes) > 0 {
+		if source, ok := definedInMaterial(stmt.Predicate); !ok {
+			problems = append(problems, "no source material")
+		} else {
+			repository, ref := giturl.SplitSourceURI(source.URI)
+			if len(p.SourceURIs) > 0 && !containsString(p.SourceURIs, source.URI) && !containsString(p.SourceURIs, repository) {
+				problems = append(problems, fmt.Sprintf("source not allowed: %s", source.URI))
+			}
+			if len(p.SourceRefPrefixes) > 0 && !hasAnyPrefix(ref, p.SourceRefPrefixes) {
+				problems = append(problems, fmt.Sprintf("source ref not allowed: %s", source.URI))
+			}
+		}
+	}
+	return problems
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAttested returns a description of every artifact of "artifacts" that
+// is not a subject of "claimed" with the same digests.
+func checkAttested(claimed, artifacts []provenance.Subject) []string {
+	subjects := map[string]provenance.DigestSet{}
+	for _, s := range claimed {
+		subjects[s.Name] = s.Digest
+	}
+	var problems []string
+	for _, artifact := range artifacts {
+		digest, ok := subjects[artifact.Name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("artifact not attested: %s", artifact.Name))
+			continue
+		}
+		compared := 0
+		for alg, got := range artifact.Digest {
+			if want, ok := digest[alg]; ok {
+				compared++
+				if got != want {
+					problems = append(problems, fmt.Sprintf("digest mismatch: %s [%s: provenance=%s actual=%s]", artifact.Name, alg, want, got))
+				}
+			}
+		}
+		if compared == 0 {
+			problems = append(problems, fmt.Sprintf("no supported digest for subject: %s", artifact.Name))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// fileDescriptor describes the file at "path" as "uri" or, when that is
+// empty, a relative file URI named after it.
+func fileDescriptor(path, uri string, contents []byte) provenance.ResourceDescriptor {
+	if uri == "" {
+		uri = "file:" + url.PathEscape(filepath.Base(path))
+	}
+	digest, _ := provenance.DigestReader(bytes.NewReader(contents), int64(len(contents)), []string{"sha256"})
+	return provenance.ResourceDescriptor{URI: uri, Digest: digest}
+}
+
+// vsaCommand verifies the signed provenance of artifacts against a policy
+// and, when they pass, emits a signed SLSA Verification Summary Attestation
+// about them, so consumers can check one small attestation instead.
+func vsaCommand(args []string) {
+	fs := flag.NewFlagSet("vsa", flag.ExitOnError)
+	var paths, publicKeys, keys arrayFlags
+	fs.Var(&paths, "artifact_path", "The file or dir path of the artifacts to verify; may be repeated.")
+	provenancePath := fs.String("provenance_path", "provenance.json", "The path of the signed provenance to verify the artifacts against.")
+	provenanceURI := fs.String("provenance_uri", "", "The URI the provenance is recorded under as an input attestation; defaults to its file name.")
+	fs.Var(&publicKeys, "public_key", "The path of a PEM or SSH public key the provenance may be signed with; may be repeated.")
+	policyPath := fs.String("policy", "", "The path of the JSON verification policy.")
+	policyURI := fs.String("policy_uri", "", "The URI the policy is recorded under; defaults to its file name.")
+	verifierID := fs.String("verifier_id", "", "The ID (a URI) of the verifier recorded in the summary.")
+	resourceURI := fs.String("resource_uri", "", "The URI of the verified resource (e.g. 'pkg:npm/app@1.0.0') recorded in the summary.")
+	output := fs.String("output_path", "vsa.json", "The path to which the verification summary attestation should be written.")
+	fs.Var(&keys, "sign_key", "A key of the verifier used to sign the summary envelope; may be repeated.")
+	tsa := fs.String("tsa_url", "", "The URL of an RFC 3161 timestamp authority used to timestamp signatures.")
+	format := fs.String("output_format", "", "Set to 'sigstore-bundle' to write a Sigstore bundle instead of an envelope.")
+	rekor := fs.String("rekor_url", DefaultRekorURL, "The Rekor server sigstore bundles are recorded in.")
+	style := addOutputStyleFlags(fs)
+	upload := addUploadFlags(fs)
+	artifactUpload := addArtifactUploadFlags(fs)
+	fs.Parse(args)
+	logToStderr(*output)
+	if len(paths) == 0 || len(publicKeys) == 0 || *policyPath == "" || *verifierID == "" || *resourceURI == "" || len(keys) == 0 {
+		fmt.Println("No value found for required flags: --artifact_path, --public_key, --policy, --verifier_id, --resource_uri and --sign_key")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := upload.validate(); err != nil {
+		fmt.Println(fmt.Sprintf("Invalid upload: %s", err))
+		os.Exit(1)
+	}
+	policy, policyContents, err := readVerificationPolicy(*policyPath)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to read policy: %s", err))
+		os.Exit(1)
+	}
+	var verifiers []sign.Verifier
+	for _, key := range publicKeys {
+		contents, err := ioutil.ReadFile(key)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to read public key: %s", err))
+			os.Exit(1)
+		}
+		v, err := sign.LoadVerifier(contents)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to load public key: [provided=%s] %s", key, err))
+			os.Exit(1)
+		}
+		verifiers = append(verifiers, v)
+	}
+
+	contents, err := ioutil.ReadFile(*provenancePath)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to read provenance: %s", err))
+		os.Exit(1)
+	}
+	if _, err := verifyDocuments(contents, verifiers); err != nil {
+		fmt.Println(fmt.Sprintf("Signature verification failed: %s", err))
+		os.Exit(1)
+	}
+	documents, err := decodeStatements(bytes.NewReader(contents))
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to read provenance: %s", err))
+		os.Exit(1)
+	}
+	var problems []string
+	var claimed []provenance.Subject
+	for _, document := range documents {
+		var stmt provenance.Statement
+		if err := json.Unmarshal(document, &stmt); err != nil {
+			fmt.Println(fmt.Sprintf("Failed to parse provenance: %s", err))
+			os.Exit(1)
+		}
+		problems = append(problems, policy.check(stmt)...)
+		claimed = append(claimed, stmt.Subject...)
+	}
+	var artifacts []provenance.Subject
+	for _, path := range paths {
+		found, err := walker.Subjects(path, claimedAlgorithms(claimed)...)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Failed to digest %s: %s", path, err))
+			os.Exit(1)
+		}
+		artifacts = append(artifacts, found...)
+	}
+	problems = append(problems, checkAttested(claimed, artifacts)...)
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		fmt.Println(fmt.Sprintf("Verification of %s failed; no summary written", *resourceURI))
+		os.Exit(1)
+	}
+
+	stmt := VSAStatement{
+		Type:          "https://in-toto.io/Statement/v0.1",
+		Subject:       artifacts,
+		PredicateType: VSAPredicateType,
+		Predicate: VerificationSummary{
+			Verifier:           VSAVerifier{ID: *verifierID},
+			TimeVerified:       now().UTC().Format(time.RFC3339),
+			ResourceURI:        *resourceURI,
+			Policy:             fileDescriptor(*policyPath, *policyURI, policyContents),
+			InputAttestations:  []provenance.ResourceDescriptor{fileDescriptor(*provenancePath, *provenanceURI, contents)},
+			VerificationResult: "PASSED",
+			VerifiedLevels:     policy.VerifiedLevels,
+			SLSAVersion:        "1.0",
+		},
+	}
+	written, err := writeAttestation(stmt, stmt.Subject, outputOptions{
+		path:     *output,
+		pathSet:  true,
+		signKeys: keys,
+		tsaURL:   *tsa,
+		format:   *format,
+		rekorURL: *rekor,
+		style:    *style,
+		upload:   upload,
+
+		artifacts: artifactUpload,
+	})
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Failed to write verification summary: %s", err))
+		os.Exit(1)
+	}
+	fmt.Println(fmt.Sprintf("Verified %d artifacts of %s; wrote verification summary %s", len(artifacts), *resourceURI, written))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+const VulnsPredicateType = "https://in-toto.io/attestation/vulns/v0.1"
+
+// Vulns is the in-toto vulnerability scan predicate: the vulnerabilities a
+// scanner found in the subjects.
+type Vulns struct {
+	Scanner  VulnScanner  `json:"scanner"`
+	Metadata VulnMetadata `json:"metadata"`
+}
+
+type VulnScanner struct {
+	URI     string       `json:"uri"`
+	Version string       `json:"version,omitempty"`
+	DB      *VulnDB      `json:"db,omitempty"`
+	Result  []VulnResult `json:"result"`
+}
+
+type VulnDB struct {
+	URI     string `json:"uri,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+type VulnResult struct {
+	ID       string         `json:"id"`
+	Severity []VulnSeverity `json:"severity"`
+	// Annotations name the affected package and its versions.
+	Annotations []map[string]string `json:"annotations,omitempty"`
+}
+
+type VulnSeverity struct {
+	Method string `json:"method"`
+	Score  string `json:"score"`
+}
+
+type VulnMetadata struct {
+	ScanStartedOn  string `json:"scanStartedOn,omitempty"`
+	ScanFinishedOn string `json:"scanFinishedOn,omitempty"`
+}
+
+// trivyReport is the part of `trivy --format json` output that is attested.
+type trivyReport struct {
+	SchemaVersion int    `json:"SchemaVersion"`
+	CreatedAt     string `json:"CreatedAt"`
+	Trivy         struct {
+		Version string `json:"Version"`
+	} `json:"Trivy"`
+	Results []struct {
+		Target          string `json:"Target"`
+		Vulnerabilities []struct {
+			VulnerabilityID  string `json:"VulnerabilityID"`
+			PkgName          string `json:"PkgName"`
+			InstalledVersion string `json:"InstalledVersion"`
+			FixedVersion     string `json:"FixedVersion"`
+			SeveritySource   string `json:"SeveritySource"`
+			Severity         string `json:"Severity"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+// grypeReport is the part of `grype -o json` output that is attested.
+type grypeReport struct {
+	Matches []struct {
+		Vulnerability struct {
+			ID        string `json:"id"`
+			Namespace string `json:"namespace"`
+			Severity  string `json:"severity"`
+			Fix       struct {
+				Versions []string `json:"versions"`
+			} `json:"fix"`
+		} `json:"vulnerability"`
+		Artifact struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+			PURL    string `json:"purl"`
+		} `json:"artifact"`
+	} `json:"matches"`
+	Descriptor *struct {
+		Name      string `json:"name"`
+		Version   string `json:"version"`
+		Timestamp string `json:"timestamp"`
+		DB        struct {
+			Built         string      `json:"built"`
+			SchemaVersion interface{} `json:"schemaVersion"`
+		} `json:"db"`
+	} `json:"descriptor"`
+}
+
+// readVulnScan reads the Trivy or Grype JSON report at "path" as a
+// vulnerability scan predicate.
+func readVulnScan(path string) (Vulns, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Vulns{}, err
+	}
+	var grype grypeReport
+	if err := json.Unmarshal(contents, &grype); err == nil && grype.Descriptor != nil && grype.Descriptor.Name == "grype" {
+		return grypeVulns(grype), nil
+	}
+	var trivy trivyReport
+	if err := json.Unmarshal(contents, &trivy); err == nil && trivy.SchemaVersion > 0 {
+		return trivyVulns(trivy), nil
+	}
+	return Vulns{}, fmt.Errorf("%s is not a Trivy or Grype JSON report", path)
+}
+
+func trivyVulns(report trivyReport) Vulns {
+	vulns := Vulns{
+		Scanner:  VulnScanner{URI: "pkg:github/aquasecurity/trivy", Version: report.Trivy.Version, Result: []VulnResult{}},
+		Metadata: VulnMetadata{ScanStartedOn: report.CreatedAt, ScanFinishedOn: report.CreatedAt},
+	}
+	if report.Trivy.Version != "" {
+		vulns.Scanner.URI += "@v" + report.Trivy.Version
+	}
+	for _, result := range report.Results {
+		for _, v := range result.Vulnerabilities {
+			method := v.SeveritySource
+			if method == "" {
+				method = "trivy"
+			}
+			vulns.Scanner.Result = append(vulns.Scanner.Result, VulnResult{
+				ID:       v.VulnerabilityID,
+				Severity: []VulnSeverity{{Method: method, Score: v.Severity}},
+				Annotations: []map[string]string{{
+					"target":           result.Target,
+					"package":          v.PkgName,
+					"installedVersion": v.InstalledVersion,
+					"fixedVersion":     v.FixedVersion,
+				}},
+			})
+		}
+	}
+	return vulns
+}
+
+func grypeVulns(report grypeReport) Vulns {
+	d := report.Descriptor
+	vulns := Vulns{
+		Scanner:  VulnScanner{URI: "pkg:github/anchore/grype", Version: d.Version, Result: []VulnResult{}},
+		Metadata: VulnMetadata{ScanStartedOn: d.Timestamp, ScanFinishedOn: d.Timestamp},
+	}
+	if d.Version != "" {
+		vulns.Scanner.URI += "@v" + d.Version
+	}
+	if d.DB.Built != "" {
+		vulns.Scanner.DB = &VulnDB{URI: "pkg:github/anchore/grype-db", Version: fmt.Sprintf("%v", d.DB.SchemaVersion) + "@" + d.DB.Built}
+	}
+	for _, m := range report.Matches {
+		fixed := ""
+		if len(m.Vulnerability.Fix.Versions) > 0 {
+			fixed = m.Vulnerability.Fix.Versions[0]
+		}
+		vulns.Scanner.Result = append(vulns.Scanner.Result, VulnResult{
+			ID:       m.Vulnerability.ID,
+			Severity: []VulnSeverity{{Method: m.Vulnerability.Namespace, Score: m.Vulnerability.Severity}},
+			Annotations: []map[string]string{{
+				"package":          m.Artifact.Name,
+				"purl":             m.Artifact.PURL,
+				"installedVersion": m.Artifact.Version,
+				"fixedVersion":     fixed,
+			}},
+		})
+	}
+	return vulns
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+)
+
+// addFileFilterFlags registers --min_size, --max_size, --include_ext and
+// --exclude_ext, the flags of "filter".
+func addFileFilterFlags(fs *flag.FlagSet, filter *provenance.FileFilter) {
+	fs.Var((*byteSize)(&filter.MinSize), "min_size", "Only attest artifact files of at least this size (e.g. '1024', '10KiB' or '5MB').")
+	fs.Var((*byteSize)(&filter.MaxSize), "max_size", "Only attest artifact files of at most this size (e.g. '100MiB').")
+	fs.Var((*arrayFlags)(&filter.IncludeExts), "include_ext", "Only attest artifact files with one of these comma-separated extensions (e.g. 'tar.gz,exe'); may be repeated.")
+	fs.Var((*arrayFlags)(&filter.ExcludeExts), "exclude_ext", "Do not attest artifact files with one of these comma-separated extensions (e.g. 'debug,log'); may be repeated.")
+}
+
+// byteSize is a flag holding a number of bytes, given with an optional
+// decimal (KB, MB, GB) or binary (KiB, MiB, GiB) unit.
+type byteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30},
+	{"kb", 1e3}, {"mb", 1e6}, {"gb", 1e9},
+	{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30},
+	{"b", 1},
+}
+
+func (b *byteSize) String() string {
+	return ""
+}
+
+func (b *byteSize) Set(value string) error {
+	number, unit := strings.ToLower(strings.TrimSpace(value)), int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(number, u.suffix) {
+			number, unit = strings.TrimSpace(strings.TrimSuffix(number, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", value)
+	}
+	*b = byteSize(n * unit)
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"syscall/js"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provenance"
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/sign"
+)
+
+func init() {
+	wasmMain = exportVerifier
+}
+
+// exportVerifier exposes the verification core to JavaScript as the global
+// "provenanceVerifier" object and keeps the module running. Every function
+// takes and returns strings of JSON so callers need no shared types.
+func exportVerifier() {
+	js.Global().Set("provenanceVerifier", js.ValueOf(map[string]interface{}{
+		"verifySignature":   js.FuncOf(jsVerifySignature),
+		"verifySubjects":    js.FuncOf(jsVerifySubjects),
+		"validateStatement": js.FuncOf(jsValidateStatement),
+	}))
+	select {}
+}
+
+// jsResult encodes "v", or "err" as {"error": "..."}, as a JSON string.
+func jsResult(v interface{}, err error) interface{} {
+	if err != nil {
+		v = map[string]string{"error": err.Error()}
+	}
+	encoded, _ := json.Marshal(v)
+	return string(encoded)
+}
+
+// jsVerifySignature(envelope, publicKey) checks a DSSE envelope against a
+// PEM encoded public key or certificate and returns {"keyid": "..."}. SSH
+// keys are rejected as unsupported.
+func jsVerifySignature(this js.Value, args []js.Value) interface{} {
+	if len(args) != 2 {
+		return jsResult(nil, fmt.Errorf("usage: verifySignature(envelope, publicKey)"))
+	}
+	var envelope sign.Envelope
+	if err := json.Unmarshal([]byte(args[0].String()), &envelope); err != nil {
+		return jsResult(nil, err)
+	}
+	if envelope.PayloadType != sign.PayloadContentType {
+		return jsResult(nil, fmt.Errorf("unexpected payload type %q", envelope.PayloadType))
+	}
+	verifier, err := sign.LoadVerifier([]byte(args[1].String()))
+	if err != nil {
+		return jsResult(nil, err)
+	}
+	keyID, err := sign.VerifyEnvelope(&envelope, verifier)
+	return jsResult(map[string]string{"keyid": keyID}, err)
+}
+
+// jsVerifySubjects(provenance, subjects, exhaustive) checks subjects
+// computed by the caller ([{"name": ..., "digest": {"sha256": ...}}])
+// against a provenance file and returns {"problems": [...]}.
+func jsVerifySubjects(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return jsResult(nil, fmt.Errorf("usage: verifySubjects(provenance, subjects, exhaustive)"))
+	}
+	statements, err := decodeStatements(strings.NewReader(args[0].String()))
+	if err != nil {
+		return jsResult(nil, err)
+	}
+	var claimed []provenance.Subject
+	for _, statement := range statements {
+		var stmt struct {
+			Subject []provenance.Subject `json:"subject"`
+		}
+		if err := json.Unmarshal(statement, &stmt); err != nil {
+			return jsResult(nil, err)
+		}
+		claimed = append(claimed, stmt.Subject...)
+	}
+	var actual []provenance.Subject
+	if err := json.Unmarshal([]byte(args[1].String()), &actual); err != nil {
+		return jsResult(nil, err)
+	}
+	exhaustive := len(args) > 2 && args[2].Truthy()
+	problems := verifySubjects(claimed, actual, exhaustive)
+	if problems == nil {
+		problems = []string{}
+	}
+	return jsResult(map[string][]string{"problems": problems}, nil)
+}
+
+// jsValidateStatement(statement) validates a statement against the embedded
+// schemas and returns {"violations": [...]}.
+func jsValidateStatement(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 {
+		return jsResult(nil, fmt.Errorf("usage: validateStatement(statement)"))
+	}
+	var stmt interface{}
+	if err := json.Unmarshal([]byte(args[0].String()), &stmt); err != nil {
+		return jsResult(nil, err)
+	}
+	violations, err := validateStatement(stmt)
+	if violations == nil {
+		violations = []string{}
+	}
+	return jsResult(map[string][]string{"violations": violations}, err)
+}
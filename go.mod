@@ -0,0 +1,3 @@
+module github.com/hi-artem/provenance-generator-buildkite-plugin
+
+go 1.16
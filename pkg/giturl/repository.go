@@ -0,0 +1,237 @@
+// Package giturl parses Git clone URLs, scp-like SSH addresses included,
+// and turns them into the repository URIs recorded as provenance materials.
+package giturl
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// RepositoryURI returns the material URI (e.g.
+// "git+https://gitlab.com/group/subgroup/repo") of the Git repository "u"
+// is the clone URL of, as parsed by Parse, so that its SSH and HTTPS clone
+// URLs name the same material. The path is kept whole, subgroups included,
+// and only a trailing ".git" is removed from it. Ports are only kept for
+// HTTP(S) URLs, as SSH ports (e.g. GitLab's 2222) say nothing of where the
+// repository is browsed. Hosts with clone URLs that differ from their web
+// URLs in more than that are rewritten by repositoryHostRules.
+func RepositoryURI(u *url.URL) (string, error) {
+	host := strings.ToLower(u.Hostname())
+	if u.Scheme == "file" && host == "" {
+		// Repositories on the agent (e.g. "/var/repos/app.git") have no
+		// web URL.
+		return "git+file://" + u.Path, nil
+	}
+	if host == "" {
+		return "", fmt.Errorf("no host in repository URL %s", u)
+	}
+	if port := u.Port(); port != "" && (u.Scheme == "https" && port != "443" || u.Scheme == "http" && port != "80") {
+		host = net.JoinHostPort(host, port)
+	}
+	path := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	for _, rule := range repositoryHostRules {
+		if rule.match(host) {
+			host, path = rule.rewrite(host, path)
+			break
+		}
+	}
+	return "git+https://" + host + "/" + path, nil
+}
+
+// repositoryHostRule rewrites the host and path of the clone URLs of hosts
+// it matches to those of the repository's web URL.
+type repositoryHostRule struct {
+	match   func(host string) bool
+	rewrite func(host, path string) (string, string)
+}
+
+// repositoryHostRules are the rules of hosts whose clone URLs need more
+// than the port and ".git" removed. GitHub, GitLab and Bitbucket Cloud
+// clone URLs need none.
+var repositoryHostRules = []repositoryHostRule{
+	// Azure DevOps SSH URLs are
+	// "git@ssh.dev.azure.com:v3/org/project/repo" and HTTPS ones
+	// "https://dev.azure.com/org/project/_git/repo".
+	{
+		match: func(host string) bool { return host == "ssh.dev.azure.com" },
+		rewrite: func(host, path string) (string, string) {
+			if parts := strings.Split(path, "/"); len(parts) == 4 && parts[0] == "v3" {
+				return "dev.azure.com", parts[1] + "/" + parts[2] + "/_git/" + parts[3]
+			}
+			return "dev.azure.com", path
+		},
+	},
+	// Azure DevOps organizations created before it are also at
+	// "https://org.visualstudio.com/project/_git/repo", cloned over SSH from
+	// "org@vs-ssh.visualstudio.com:v3/org/project/repo".
+	{
+		match: func(host string) bool { return strings.HasSuffix(host, ".visualstudio.com") },
+		rewrite: func(host, path string) (string, string) {
+			parts := strings.Split(path, "/")
+			if host == "vs-ssh.visualstudio.com" && len(parts) == 4 && parts[0] == "v3" {
+				return "dev.azure.com", parts[1] + "/" + parts[2] + "/_git/" + parts[3]
+			}
+			if org := strings.TrimSuffix(host, ".visualstudio.com"); len(parts) == 3 && parts[1] == "_git" {
+				return "dev.azure.com", org + "/" + path
+			}
+			return host, path
+		},
+	},
+	// Bitbucket Server serves repositories over HTTPS at
+	// "/scm/project/repo" and over SSH, on port 7999 by default, at
+	// "/project/repo"; both are browsed at "/projects/PROJECT/repos/repo",
+	// so the SSH path is recorded in the form of the HTTPS one.
+	{
+		match: func(host string) bool { return strings.HasPrefix(host, "bitbucket.") && host != "bitbucket.org" },
+		rewrite: func(host, path string) (string, string) {
+			if parts := strings.Split(path, "/"); len(parts) == 2 {
+				return host, "scm/" + strings.ToLower(parts[0]) + "/" + parts[1]
+			}
+			return host, path
+		},
+	},
+}
+
+// SourceURI returns the material URI (e.g.
+// "git+https://github.com/org/repo") of the Git repository at "repository".
+func SourceURI(repository string) (string, error) {
+	repositoryURL, err := Parse(repository)
+	if err != nil {
+		return "", err
+	}
+	return RepositoryURI(repositoryURL)
+}
+
+// SplitSourceURI returns the repository and the ref of the source material
+// URI "uri" (e.g. "git+https://github.com/org/repo@refs/tags/v1.2.3"),
+// ignoring the path of a monorepo component after "#". The ref is empty
+// when the URI names the repository alone.
+func SplitSourceURI(uri string) (repository, ref string) {
+	uri = strings.SplitN(uri, "#", 2)[0]
+	if i := strings.Index(uri, "@"); i >= 0 {
+		return uri[:i], uri[i+1:]
+	}
+	return uri, ""
+}
+
+var (
+	// scpSyntax was modified from https://golang.org/src/cmd/go/vcs.go.
+	scpSyntax = regexp.MustCompile(`^([a-zA-Z0-9-._~]+@)?([a-zA-Z0-9._-]+):([a-zA-Z0-9./._-]+)(?:\?||$)(.*)$`)
+
+	// Transports is a set of known Git URL schemes.
+	Transports = NewTransportSet(
+		"ssh",
+		"git",
+		"git+ssh",
+		"http",
+		"https",
+		"ftp",
+		"ftps",
+		"rsync",
+		"file",
+	)
+)
+
+// Parser converts a string into a URL.
+type Parser func(string) (*url.URL, error)
+
+// Parse parses rawurl into a URL structure. Parse first attempts to
+// find a standard URL with a valid Git transport as its scheme. If
+// that cannot be found, it then attempts to find a SCP-like URL. And
+// if that cannot be found, it assumes rawurl is a local path. If none
+// of these rules apply, Parse returns an error.
+func Parse(rawurl string) (u *url.URL, err error) {
+	parsers := []Parser{
+		ParseTransport,
+		ParseScp,
+		ParseLocal,
+	}
+
+	// Apply each parser in turn; if the parser succeeds, accept its
+	// result and return.
+	for _, p := range parsers {
+		u, err = p(rawurl)
+		if err == nil {
+			return u, err
+		}
+	}
+
+	// It's unlikely that none of the parsers will succeed, since
+	// ParseLocal is very forgiving.
+	return new(url.URL), fmt.Errorf("failed to parse %q", rawurl)
+}
+
+// ParseTransport parses rawurl into a URL object. Unless the URL's
+// scheme is a known Git transport, ParseTransport returns an error.
+func ParseTransport(rawurl string) (*url.URL, error) {
+	u, err := url.Parse(rawurl)
+	if err == nil && !Transports.Valid(u.Scheme) {
+		err = fmt.Errorf("scheme %q is not a valid transport", u.Scheme)
+	}
+	return u, err
+}
+
+// ParseScp parses rawurl into a URL object. The rawurl must be
+// an SCP-like URL, otherwise ParseScp returns an error.
+func ParseScp(rawurl string) (*url.URL, error) {
+	match := scpSyntax.FindAllStringSubmatch(rawurl, -1)
+	if len(match) == 0 {
+		return nil, fmt.Errorf("no scp URL found in %q", rawurl)
+	}
+	m := match[0]
+	user := strings.TrimRight(m[1], "@")
+	var userinfo *url.Userinfo
+	if user != "" {
+		userinfo = url.User(user)
+	}
+	rawquery := ""
+	if len(m) > 3 {
+		rawquery = m[4]
+	}
+	return &url.URL{
+		Scheme:   "ssh",
+		User:     userinfo,
+		Host:     m[2],
+		Path:     m[3],
+		RawQuery: rawquery,
+	}, nil
+}
+
+// ParseLocal parses rawurl into a URL object with a "file"
+// scheme. This will effectively never return an error.
+func ParseLocal(rawurl string) (*url.URL, error) {
+	return &url.URL{
+		Scheme: "file",
+		Host:   "",
+		Path:   rawurl,
+	}, nil
+}
+
+// TransportSet represents a set of valid Git transport schemes. It
+// maps these schemes to empty structs, providing a set-like
+// interface.
+type TransportSet struct {
+	Transports map[string]struct{}
+}
+
+// NewTransportSet returns a TransportSet with the items keys mapped
+// to empty struct values.
+func NewTransportSet(items ...string) *TransportSet {
+	t := &TransportSet{
+		Transports: map[string]struct{}{},
+	}
+	for _, i := range items {
+		t.Transports[i] = struct{}{}
+	}
+	return t
+}
+
+// Valid returns true if transport is a known Git URL scheme and false
+// if not.
+func (t *TransportSet) Valid(transport string) bool {
+	_, ok := t.Transports[transport]
+	return ok
+}
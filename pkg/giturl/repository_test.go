@@ -0,0 +1,44 @@
+package giturl
+
+import "testing"
+
+func TestSourceURI(t *testing.T) {
+	tests := map[string]string{
+		"git@github.com:acme/app.git":                       "git+https://github.com/acme/app",
+		"https://github.com/acme/app.git":                   "git+https://github.com/acme/app",
+		"ssh://git@GitHub.com/acme/app":                     "git+https://github.com/acme/app",
+		"git@gitlab.com:group/subgroup/app.git":             "git+https://gitlab.com/group/subgroup/app",
+		"ssh://git@gitlab.example.com:2222/group/app.git":   "git+https://gitlab.example.com/group/app",
+		"https://git.example.com:8443/app.git":              "git+https://git.example.com:8443/app",
+		"git@ssh.dev.azure.com:v3/acme/project/app":         "git+https://dev.azure.com/acme/project/_git/app",
+		"https://acme.visualstudio.com/project/_git/app":    "git+https://dev.azure.com/acme/project/_git/app",
+		"acme@vs-ssh.visualstudio.com:v3/acme/project/app":  "git+https://dev.azure.com/acme/project/_git/app",
+		"ssh://git@bitbucket.example.com:7999/PROJ/app.git": "git+https://bitbucket.example.com/scm/proj/app",
+		"https://bitbucket.example.com/scm/proj/app.git":    "git+https://bitbucket.example.com/scm/proj/app",
+		"git@bitbucket.org:acme/app.git":                    "git+https://bitbucket.org/acme/app",
+	}
+	for repository, want := range tests {
+		got, err := SourceURI(repository)
+		if err != nil {
+			t.Errorf("SourceURI(%q): %s", repository, err)
+		} else if got != want {
+			t.Errorf("SourceURI(%q) = %q, want %q", repository, got, want)
+		}
+	}
+}
+
+func TestSplitSourceURI(t *testing.T) {
+	tests := []struct {
+		uri, repository, ref string
+	}{
+		{"git+https://github.com/acme/app", "git+https://github.com/acme/app", ""},
+		{"git+https://github.com/acme/app@refs/tags/v1.2.3", "git+https://github.com/acme/app", "refs/tags/v1.2.3"},
+		{"git+https://github.com/acme/app@refs/heads/main#services/api", "git+https://github.com/acme/app", "refs/heads/main"},
+	}
+	for _, test := range tests {
+		repository, ref := SplitSourceURI(test.uri)
+		if repository != test.repository || ref != test.ref {
+			t.Errorf("SplitSourceURI(%q) = %q, %q, want %q, %q", test.uri, repository, ref, test.repository, test.ref)
+		}
+	}
+}
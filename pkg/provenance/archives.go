@@ -0,0 +1,125 @@
+package provenance
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// ArchiveEntrySeparator separates the name of an archive subject from the
+// path of an entry inside it in the names of entry subjects.
+const ArchiveEntrySeparator = "!"
+
+// isArchive reports whether ExpandArchiveSubjects reads the file "name" as an
+// archive.
+func isArchive(name string) bool {
+	name = strings.ToLower(name)
+	for _, suffix := range []string{".tar", ".tar.gz", ".tgz", ".zip", ".jar", ".whl"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpandArchiveSubjects returns "subjects" with, after each tar, tar.gz or zip
+// archive among them, a subject for every regular file it contains, named
+// "archive.tar.gz!path/inside" and digested with "algorithms" as it is read,
+// without extracting it to disk.
+func ExpandArchiveSubjects(subjects []Subject, algorithms []string) ([]Subject, error) {
+	if len(algorithms) == 0 {
+		algorithms = []string{"sha256"}
+	}
+	var expanded []Subject
+	for _, subject := range subjects {
+		expanded = append(expanded, subject)
+		if subject.File == "" || !isArchive(subject.File) {
+			continue
+		}
+		entries, err := archiveEntries(subject.File, algorithms)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive %s: %s", subject.Name, err)
+		}
+		for _, entry := range entries {
+			entry.Name = subject.Name + ArchiveEntrySeparator + entry.Name
+			entry.Archive = subject.File
+			expanded = append(expanded, entry)
+		}
+	}
+	return expanded, nil
+}
+
+// archiveEntries digests the regular files of the archive at "file".
+func archiveEntries(file string, algorithms []string) ([]Subject, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	name := strings.ToLower(file)
+	if strings.HasSuffix(name, ".tar") {
+		return tarEntries(tar.NewReader(f), algorithms)
+	}
+	if strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		return tarEntries(tar.NewReader(gz), algorithms)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	var entries []Subject
+	for _, entry := range r.File {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			return nil, err
+		}
+		digest, err := DigestReader(rc, int64(entry.UncompressedSize64), algorithms)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", entry.Name, err)
+		}
+		entries = append(entries, Subject{Name: entryName(entry.Name), Digest: digest})
+	}
+	return entries, nil
+}
+
+func tarEntries(r *tar.Reader, algorithms []string) ([]Subject, error) {
+	var entries []Subject
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		digest, err := DigestReader(r, header.Size, algorithms)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", header.Name, err)
+		}
+		entries = append(entries, Subject{Name: entryName(header.Name), Digest: digest})
+	}
+}
+
+// entryName cleans the path of an archive entry, e.g. "./bin/app".
+func entryName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
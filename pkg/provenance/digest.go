@@ -0,0 +1,176 @@
+package provenance
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// digestAlgorithms holds the hash constructors subjects can be digested
+// with, keyed by their DigestSet name.
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+	// BLAKE2b-512, implemented in blake2b.go.
+	"blake2b": newBLAKE2b512,
+}
+
+// gitoidAlgorithms hold the hashes of gitoid digests, the git object IDs
+// Witness and Archivista key attestations on. A gitoid hashes a git blob
+// header with the file size before the content and is recorded as a
+// "gitoid:blob:<hash>:<hex>" URI under "gitoid:<hash>".
+var gitoidAlgorithms = map[string]func() hash.Hash{
+	"gitoid:sha1":   sha1.New,
+	"gitoid:sha256": sha256.New,
+}
+
+// KnownDigestAlgorithm reports whether subjects can be digested with "name".
+func KnownDigestAlgorithm(name string) bool {
+	_, ok := digestAlgorithms[name]
+	_, gitoid := gitoidAlgorithms[name]
+	return ok || gitoid
+}
+
+// RegisterDigestAlgorithm makes an additional digest algorithm, such as a
+// national standard mandated by some verifiers, available under "name". It
+// is meant to be called from the init function of a file that is only built
+// when the algorithm is wanted (see digest_sm3.go).
+func RegisterDigestAlgorithm(name string, newHash func() hash.Hash) {
+	if KnownDigestAlgorithm(name) {
+		panic("digest algorithm registered twice: " + name)
+	}
+	digestAlgorithms[name] = newHash
+}
+
+// DigestSize returns the size in bytes of the digests of "algorithm", or 0
+// when it is unknown.
+func DigestSize(algorithm string) int {
+	newHash, ok := digestAlgorithms[algorithm]
+	if !ok {
+		newHash, ok = gitoidAlgorithms[algorithm]
+	}
+	if !ok {
+		return 0
+	}
+	return newHash().Size()
+}
+
+// IsGitoidAlgorithm reports whether "algorithm" digests files as git
+// objects, which needs their size up front.
+func IsGitoidAlgorithm(algorithm string) bool {
+	_, ok := gitoidAlgorithms[algorithm]
+	return ok
+}
+
+// supportedDigestAlgorithms returns the names of all registered algorithms.
+func supportedDigestAlgorithms() []string {
+	var names []string
+	for name := range digestAlgorithms {
+		names = append(names, name)
+	}
+	for name := range gitoidAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DigestAlgorithmNames returns "sha256", which presets and Merkle manifests
+// rely on, followed by the other requested algorithms without duplicates.
+// Each requested entry may be a comma-separated list.
+func DigestAlgorithmNames(requested []string) ([]string, error) {
+	names := []string{"sha256"}
+	seen := map[string]bool{"sha256": true}
+	var split []string
+	for _, entry := range requested {
+		for _, name := range strings.Split(entry, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				split = append(split, name)
+			}
+		}
+	}
+	for _, name := range split {
+		if !KnownDigestAlgorithm(name) {
+			return nil, fmt.Errorf("unknown digest algorithm %q, supported: %v", name, supportedDigestAlgorithms())
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// hashBufferSize is the size of the buffer files are streamed through when
+// hashed, which bounds the memory each hashing worker uses regardless of
+// the size of the file.
+const hashBufferSize = 1 << 20
+
+var hashBuffers = sync.Pool{New: func() interface{} {
+	buf := make([]byte, hashBufferSize)
+	return &buf
+}}
+
+// DigestFile hashes the file at "path" with every algorithm in a single
+// streaming read.
+func DigestFile(path string, algorithms []string) (DigestSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return DigestReader(f, info.Size(), algorithms)
+}
+
+// digestLink digests the path the symlink at "path" points to, as git
+// stores symlinks.
+func digestLink(path string, algorithms []string) (DigestSet, error) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return nil, err
+	}
+	return DigestReader(strings.NewReader(target), int64(len(target)), algorithms)
+}
+
+// DigestReader digests the "size" bytes of "r" with each of "algorithms".
+func DigestReader(r io.Reader, size int64, algorithms []string) (DigestSet, error) {
+	hashes := map[string]hash.Hash{}
+	var writers []io.Writer
+	for _, name := range algorithms {
+		if newHash, ok := gitoidAlgorithms[name]; ok {
+			hashes[name] = newHash()
+			fmt.Fprintf(hashes[name], "blob %d\x00", size)
+		} else if newHash, ok := digestAlgorithms[name]; ok {
+			hashes[name] = newHash()
+		} else {
+			return nil, fmt.Errorf("unknown digest algorithm %q", name)
+		}
+		writers = append(writers, hashes[name])
+	}
+	buf := hashBuffers.Get().(*[]byte)
+	defer hashBuffers.Put(buf)
+	// Hide r's WriteTo so the copy goes through the pooled buffer.
+	if _, err := io.CopyBuffer(io.MultiWriter(writers...), struct{ io.Reader }{r}, *buf); err != nil {
+		return nil, err
+	}
+	digest := DigestSet{}
+	for name, h := range hashes {
+		digest[name] = fmt.Sprintf("%x", h.Sum(nil))
+		if _, ok := gitoidAlgorithms[name]; ok {
+			digest[name] = "gitoid:blob:" + strings.TrimPrefix(name, "gitoid:") + ":" + digest[name]
+		}
+	}
+	return digest, nil
+}
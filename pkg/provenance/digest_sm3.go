@@ -0,0 +1,106 @@
+//go:build sm3
+// +build sm3
+
+package provenance
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// Build with `-tags sm3` to digest subjects with the Chinese SM3 algorithm
+// (GB/T 32905-2016).
+func init() {
+	RegisterDigestAlgorithm("sm3", newSM3)
+}
+
+const (
+	sm3BlockSize = 64
+	sm3Size      = 32
+)
+
+var sm3IV = [8]uint32{
+	0x7380166f, 0x4914b2b9, 0x172442d7, 0xda8a0600,
+	0xa96f30bc, 0x163138aa, 0xe38dee4d, 0xb0fb0e4e,
+}
+
+type sm3 struct {
+	v      [8]uint32
+	buf    [sm3BlockSize]byte
+	buffed int
+	length uint64
+}
+
+func newSM3() hash.Hash {
+	s := &sm3{}
+	s.Reset()
+	return s
+}
+
+func (s *sm3) Size() int      { return sm3Size }
+func (s *sm3) BlockSize() int { return sm3BlockSize }
+
+func (s *sm3) Reset() {
+	s.v = sm3IV
+	s.buffed = 0
+	s.length = 0
+}
+
+func (s *sm3) Write(p []byte) (int, error) {
+	n := len(p)
+	s.length += uint64(n)
+	for len(p) > 0 {
+		copied := copy(s.buf[s.buffed:], p)
+		s.buffed += copied
+		p = p[copied:]
+		if s.buffed == sm3BlockSize {
+			s.compress(s.buf[:])
+			s.buffed = 0
+		}
+	}
+	return n, nil
+}
+
+func (s *sm3) Sum(b []byte) []byte {
+	final := *s
+	// Padding as in SHA-256: a one bit, zeros and the bit length.
+	var pad [sm3BlockSize + 8]byte
+	pad[0] = 0x80
+	padding := sm3BlockSize - (final.buffed+8)%sm3BlockSize
+	binary.BigEndian.PutUint64(pad[padding:], s.length*8)
+	final.Write(pad[:padding+8])
+	var out [sm3Size]byte
+	for i, v := range final.v {
+		binary.BigEndian.PutUint32(out[i*4:], v)
+	}
+	return append(b, out[:]...)
+}
+
+func (s *sm3) compress(block []byte) {
+	p0 := func(x uint32) uint32 { return x ^ bits.RotateLeft32(x, 9) ^ bits.RotateLeft32(x, 17) }
+	p1 := func(x uint32) uint32 { return x ^ bits.RotateLeft32(x, 15) ^ bits.RotateLeft32(x, 23) }
+	var w [68]uint32
+	for j := 0; j < 16; j++ {
+		w[j] = binary.BigEndian.Uint32(block[j*4:])
+	}
+	for j := 16; j < 68; j++ {
+		w[j] = p1(w[j-16]^w[j-9]^bits.RotateLeft32(w[j-3], 15)) ^ bits.RotateLeft32(w[j-13], 7) ^ w[j-6]
+	}
+	a, b, c, d, e, f, g, h := s.v[0], s.v[1], s.v[2], s.v[3], s.v[4], s.v[5], s.v[6], s.v[7]
+	for j := 0; j < 64; j++ {
+		t, ff, gg := uint32(0x7a879d8a), (a&b)|(a&c)|(b&c), (e&f)|(^e&g)
+		if j < 16 {
+			t, ff, gg = 0x79cc4519, a^b^c, e^f^g
+		}
+		ss1 := bits.RotateLeft32(bits.RotateLeft32(a, 12)+e+bits.RotateLeft32(t, j%32), 7)
+		ss2 := ss1 ^ bits.RotateLeft32(a, 12)
+		tt1 := ff + d + ss2 + (w[j] ^ w[j+4])
+		tt2 := gg + h + ss1 + w[j]
+		a, b, c, d = tt1, a, bits.RotateLeft32(b, 9), c
+		e, f, g, h = p0(tt2), e, bits.RotateLeft32(f, 19), g
+	}
+	for i, x := range [8]uint32{a, b, c, d, e, f, g, h} {
+		s.v[i] ^= x
+	}
+}
@@ -0,0 +1,27 @@
+//go:build sm3
+// +build sm3
+
+package provenance
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// The examples of GB/T 32905-2016 appendix A.
+func TestSM3(t *testing.T) {
+	tests := []struct {
+		input, want string
+	}{
+		{"abc", "66c7f0f462eeedd9d1f2d46bdc10e4e24167c4875cf2f7a2297da02b8f4ba8e0"},
+		{strings.Repeat("abcd", 16), "debe9ff92275b8a138604889c18e5a4d6fdb70e5387e5765293dcba39c0c5732"},
+	}
+	for _, test := range tests {
+		h := newSM3()
+		h.Write([]byte(test.input))
+		if got := hex.EncodeToString(h.Sum(nil)); got != test.want {
+			t.Errorf("%q: got %s, want %s", test.input, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package provenance
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestDigestReader(t *testing.T) {
+	input := "abc"
+	got, err := DigestReader(strings.NewReader(input), int64(len(input)), []string{"sha256", "sha384", "blake2b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DigestSet{
+		"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		"sha384": "cb00753f45a35e8bb5a03d699ac65007272c32ab0eded1631a8b605a43ff5bed8086072ba1e7cc2358baeca134c825a7",
+		// RFC 7693 appendix A.
+		"blake2b": "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923",
+	}
+	for name, digest := range want {
+		if got[name] != digest {
+			t.Errorf("%s: got %s, want %s", name, got[name], digest)
+		}
+	}
+}
+
+// BLAKE2b only compresses a full buffer once more input follows it, so
+// inputs around the 128 byte block size take different paths.
+func TestBLAKE2bBlockBoundaries(t *testing.T) {
+	tests := []struct {
+		size int
+		want string
+	}{
+		{0, "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce"},
+		{128, "fc6c71f688f43ea7d60817478808f3cac753e61571865c95adbc2d9122c943a76b92c2cb1047ef3fe7bf6e436ec1d0a99a9e5b216780bf7fed9d7ca91d3a8f3b"},
+		{129, "55e6e0eb418149a8af92fd9ddc99254781b2f522a131b4f4d984404b71a00e1167b8124d5dcddd4c6977b299392335d6edd303da6d344d74bbef2d38101b232b"},
+		{256, "0eee13d0c73a2710c5015a8b4be0a16120bb88f826b662951ffe4b3b81441cfdce1f712c58e237dba72a0dad7f9c86b9745ea0b4b3b850ff3a260fb7df9d3e81"},
+	}
+	for _, test := range tests {
+		h := newBLAKE2b512()
+		h.Write([]byte(strings.Repeat("a", test.size)))
+		if got := hex.EncodeToString(h.Sum(nil)); got != test.want {
+			t.Errorf("%d bytes: got %s, want %s", test.size, got, test.want)
+		}
+	}
+}
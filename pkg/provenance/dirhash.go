@@ -0,0 +1,49 @@
+package provenance
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DirHashAlgorithm is the digest set key of directory digests, computed as
+// golang.org/x/mod/sumdb/dirhash computes its "h1:" hashes.
+const DirHashAlgorithm = "dirHash"
+
+// Policies of --subject_overflow, for more file subjects than
+// --max_subjects.
+const (
+	// SubjectOverflowFail fails the build.
+	SubjectOverflowFail = "fail"
+	// SubjectOverflowDirHash attests each artifact path as a single subject
+	// with the digest of its directory.
+	SubjectOverflowDirHash = "dirhash"
+)
+
+// DirHashSubject returns the subject "name" standing for the files
+// "subjects" as a whole. Its dirHash digest is the "h1:" hash of their
+// sha256sum listing, sorted by name, and its sha256 digest the hash of the
+// listing itself, so `sha256sum` of the listing reproduces it.
+func DirHashSubject(name string, subjects []Subject) (Subject, error) {
+	sorted := append([]Subject{}, subjects...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	h := sha256.New()
+	for _, s := range sorted {
+		if strings.Contains(s.Name, "\n") {
+			return Subject{}, fmt.Errorf("%q cannot be part of a directory digest", s.Name)
+		}
+		fmt.Fprintf(h, "%s  %s\n", s.Digest["sha256"], s.Name)
+	}
+	sum := h.Sum(nil)
+	return Subject{
+		Name: name,
+		Digest: DigestSet{
+			"sha256":         hex.EncodeToString(sum),
+			DirHashAlgorithm: "h1:" + base64.StdEncoding.EncodeToString(sum),
+		},
+		Annotations: map[string]string{"files": fmt.Sprint(len(subjects))},
+	}, nil
+}
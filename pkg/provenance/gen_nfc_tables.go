@@ -0,0 +1,151 @@
+//go:build ignore
+// +build ignore
+
+// gen_nfc_tables writes nfc_tables.go from the Unicode Character Database:
+//
+//	curl -O https://www.unicode.org/Public/14.0.0/ucd/UnicodeData.txt
+//	curl -O https://www.unicode.org/Public/14.0.0/ucd/CompositionExclusions.txt
+//	go run gen_nfc_tables.go -version 14.0.0 UnicodeData.txt CompositionExclusions.txt
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func parseRune(s string) rune {
+	r, err := strconv.ParseUint(strings.TrimSpace(s), 16, 32)
+	if err != nil {
+		panic(err)
+	}
+	return rune(r)
+}
+
+func main() {
+	version := flag.String("version", "", "The Unicode version of the input files.")
+	output := flag.String("output", "nfc_tables.go", "The path of the generated file.")
+	flag.Parse()
+	if flag.NArg() != 2 || *version == "" {
+		fmt.Println("usage: gen_nfc_tables -version V UnicodeData.txt CompositionExclusions.txt")
+		os.Exit(1)
+	}
+
+	decompositions := map[rune][]rune{}
+	classes := map[rune]uint8{}
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		panic(err)
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ";")
+		if len(fields) < 6 {
+			continue
+		}
+		r := parseRune(fields[0])
+		if ccc, _ := strconv.Atoi(fields[3]); ccc != 0 {
+			classes[r] = uint8(ccc)
+		}
+		// Compatibility decompositions start with a <tag> and are not
+		// used by NFC.
+		if fields[5] != "" && !strings.HasPrefix(fields[5], "<") {
+			for _, cp := range strings.Fields(fields[5]) {
+				decompositions[r] = append(decompositions[r], parseRune(cp))
+			}
+		}
+	}
+	f.Close()
+
+	excluded := map[rune]bool{}
+	contents, err := ioutil.ReadFile(flag.Arg(1))
+	if err != nil {
+		panic(err)
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) != "" {
+			excluded[parseRune(line)] = true
+		}
+	}
+
+	var full func(r rune) []rune
+	full = func(r rune) []rune {
+		d, ok := decompositions[r]
+		if !ok {
+			return []rune{r}
+		}
+		var out []rune
+		for _, c := range d {
+			out = append(out, full(c)...)
+		}
+		return out
+	}
+
+	var runes []rune
+	for r := range decompositions {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by gen_nfc_tables.go from Unicode %s; DO NOT EDIT.\n\n", *version)
+	fmt.Fprintf(&b, "package provenance\n\n")
+	fmt.Fprintf(&b, "const nfcUnicodeVersion = %q\n\n", *version)
+	fmt.Fprintf(&b, "// nfcDecompositions maps code points to their full canonical decomposition.\n")
+	fmt.Fprintf(&b, "var nfcDecompositions = map[rune]string{\n")
+	for _, r := range runes {
+		fmt.Fprintf(&b, "0x%04X: \"", r)
+		for _, c := range full(r) {
+			if c > 0xFFFF {
+				fmt.Fprintf(&b, "\\U%08X", c)
+			} else {
+				fmt.Fprintf(&b, "\\u%04X", c)
+			}
+		}
+		fmt.Fprintf(&b, "\",\n")
+	}
+	fmt.Fprintf(&b, "}\n\n")
+
+	var marks []rune
+	for r := range classes {
+		marks = append(marks, r)
+	}
+	sort.Slice(marks, func(i, j int) bool { return marks[i] < marks[j] })
+	fmt.Fprintf(&b, "// nfcCombiningClasses holds the non-zero canonical combining classes.\n")
+	fmt.Fprintf(&b, "var nfcCombiningClasses = map[rune]uint8{\n")
+	for _, r := range marks {
+		fmt.Fprintf(&b, "0x%04X: %d,\n", r, classes[r])
+	}
+	fmt.Fprintf(&b, "}\n\n")
+
+	// Primary composites are the canonical pair decompositions of starters
+	// into starters, minus the composition exclusions.
+	fmt.Fprintf(&b, "// nfcCompositions maps pairs of code points to their primary composite.\n")
+	fmt.Fprintf(&b, "var nfcCompositions = map[[2]rune]rune{\n")
+	for _, r := range runes {
+		d := decompositions[r]
+		if len(d) != 2 || excluded[r] || classes[r] != 0 || classes[d[0]] != 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "{0x%04X, 0x%04X}: 0x%04X,\n", d[0], d[1], r)
+	}
+	fmt.Fprintf(&b, "}\n")
+
+	source, err := format.Source(b.Bytes())
+	if err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(*output, source, 0644); err != nil {
+		panic(err)
+	}
+}
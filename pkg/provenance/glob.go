@@ -0,0 +1,122 @@
+package provenance
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// SplitGlob splits an artifact path into the literal directory it starts
+// with and the glob pattern below it, e.g. "dist/**/*.tar.gz" into "dist"
+// and "**/*.tar.gz". The pattern is empty for literal paths.
+func SplitGlob(root string) (base, pattern string) {
+	if !strings.ContainsAny(root, "*?[") {
+		return root, ""
+	}
+	segments := strings.Split(filepath.ToSlash(filepath.Clean(root)), "/")
+	for i, segment := range segments {
+		if strings.ContainsAny(segment, "*?[") {
+			base = strings.Join(segments[:i], "/")
+			if base == "" && i > 0 {
+				base = "/"
+			} else if base == "" {
+				base = "."
+			}
+			return filepath.FromSlash(base), strings.Join(segments[i:], "/")
+		}
+	}
+	return root, ""
+}
+
+// matchGlob reports whether the slash-separated "name" matches "pattern",
+// in which "**" matches any number of directories, including none, and
+// other segments match as in path.Match.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], name[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], name[1:])
+}
+
+// IgnoreFile lists, one per line, patterns of files below an artifact
+// directory that are not attested, like a .gitignore file.
+const IgnoreFile = ".provenanceignore"
+
+// excluder decides which files of an artifact directory are left out.
+type excluder struct {
+	patterns []string
+}
+
+// newExcluder returns the excluder for the artifact path "root" with the
+// "patterns" of --exclude and those of the IgnoreFile of "root".
+func newExcluder(root string, patterns []string) (*excluder, error) {
+	e := &excluder{patterns: append([]string{IgnoreFile}, patterns...)}
+	contents, err := ioutil.ReadFile(filepath.Join(root, IgnoreFile))
+	if os.IsNotExist(err) || isNotDir(err) {
+		return e, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "!") {
+			return nil, fmt.Errorf("%s: negated patterns are not supported: %s", filepath.Join(root, IgnoreFile), line)
+		}
+		e.patterns = append(e.patterns, line)
+	}
+	return e, nil
+}
+
+// excluded reports whether the file or directory at "relpath" is left out.
+// Patterns without a slash match names at any depth; others match the path
+// from the artifact directory. Patterns ending in a slash only match
+// directories, whose files are all left out.
+func (e *excluder) excluded(relpath string, dir bool) bool {
+	relpath = filepath.ToSlash(relpath)
+	for _, pattern := range e.patterns {
+		if strings.HasSuffix(pattern, "/") {
+			if !dir {
+				continue
+			}
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+		name := path.Base(relpath)
+		if strings.Contains(pattern, "/") {
+			pattern, name = strings.TrimPrefix(pattern, "/"), relpath
+		}
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNotDir(err error) bool {
+	return err != nil && errors.Is(err, syscall.ENOTDIR)
+}
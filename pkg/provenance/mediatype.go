@@ -0,0 +1,203 @@
+package provenance
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	OCIManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	OCIIndexMediaType    = "application/vnd.oci.image.index.v1+json"
+)
+
+// OCIDescriptor describes a blob referenced by an OCI manifest.
+type OCIDescriptor struct {
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Digest       string            `json:"digest"`
+	Size         int               `json:"size"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// sniffLength is how much of a file is read to detect its media type.
+const sniffLength = 4096
+
+// mediaTypesByName maps file name suffixes, most specific first, to the
+// media type of such files.
+var mediaTypesByName = []struct {
+	suffix    string
+	mediaType string
+}{
+	{".spdx.json", "application/spdx+json"},
+	{".spdx", "text/spdx"},
+	{".cdx.json", "application/vnd.cyclonedx+json"},
+	{".cdx.xml", "application/vnd.cyclonedx+xml"},
+	{".intoto.jsonl", "application/vnd.in-toto+json"},
+	{".intoto.json", "application/vnd.in-toto+json"},
+	{".sigstore.json", "application/vnd.dev.sigstore.bundle+json"},
+	{".tar.gz", "application/gzip"},
+	{".tgz", "application/gzip"},
+	{".tar.zst", "application/zstd"},
+	{".tar.xz", "application/x-xz"},
+	{".tar", "application/x-tar"},
+	{".zip", "application/zip"},
+	{".whl", "application/zip"},
+	{".jar", "application/java-archive"},
+	{".deb", "application/vnd.debian.binary-package"},
+	{".rpm", "application/x-rpm"},
+	{".apk", "application/vnd.android.package-archive"},
+	{".msi", "application/x-msi"},
+	{".exe", "application/vnd.microsoft.portable-executable"},
+	{".dll", "application/vnd.microsoft.portable-executable"},
+	{".wasm", "application/wasm"},
+	{".iso", "application/x-iso9660-image"},
+	{".pem", "application/x-pem-file"},
+	{".json", "application/json"},
+}
+
+// mediaTypesByMagic maps the leading bytes of executables and compressed
+// files, which http.DetectContentType does not know, to their media type.
+var mediaTypesByMagic = []struct {
+	magic     []byte
+	mediaType string
+}{
+	{[]byte("\x7fELF"), "application/x-elf"},
+	{[]byte("\xcf\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("\xce\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("\xca\xfe\xba\xbe"), "application/x-mach-binary"},
+	{[]byte("MZ"), "application/vnd.microsoft.portable-executable"},
+	{[]byte("\x28\xb5\x2f\xfd"), "application/zstd"},
+	{[]byte("\xfd7zXZ\x00"), "application/x-xz"},
+	{[]byte("\x00asm"), "application/wasm"},
+}
+
+// DescribeFile returns the media type of the file at "path" and its size.
+// Blobs of an OCI image layout take the media type their manifests give
+// them; other files are detected from their name, then their contents.
+func DescribeFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	if mediaType := ociBlobMediaType(path); mediaType != "" {
+		return mediaType, info.Size(), nil
+	}
+	head, err := ioutil.ReadAll(io.LimitReader(f, sniffLength))
+	if err != nil {
+		return "", 0, err
+	}
+	return detectMediaType(filepath.Base(path), head), info.Size(), nil
+}
+
+// detectMediaType detects the media type of the file "name" starting with
+// "head".
+func detectMediaType(name string, head []byte) string {
+	lower := strings.ToLower(name)
+	for _, m := range mediaTypesByName {
+		if strings.HasSuffix(lower, m.suffix) {
+			if m.mediaType == "application/json" {
+				return detectJSONMediaType(head)
+			}
+			return m.mediaType
+		}
+	}
+	for _, m := range mediaTypesByMagic {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.mediaType
+		}
+	}
+	if len(head) > 262 && string(head[257:262]) == "ustar" {
+		return "application/x-tar"
+	}
+	if trimmed := bytes.TrimSpace(head); len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+		return detectJSONMediaType(head)
+	}
+	return http.DetectContentType(head)
+}
+
+// detectJSONMediaType tells SBOMs and other documents with media types of
+// their own from plain JSON by the fields they start with.
+func detectJSONMediaType(head []byte) string {
+	switch {
+	case bytes.Contains(head, []byte(`"spdxVersion"`)):
+		return "application/spdx+json"
+	case bytes.Contains(head, []byte(`"bomFormat"`)) && bytes.Contains(head, []byte(`"CycloneDX"`)):
+		return "application/vnd.cyclonedx+json"
+	case bytes.Contains(head, []byte(`"_type"`)) && bytes.Contains(head, []byte(`https://in-toto.io/Statement/`)):
+		return "application/vnd.in-toto+json"
+	case bytes.Contains(head, []byte(`"payloadType"`)):
+		return "application/vnd.dsse.envelope.v1+json"
+	}
+	return "application/json"
+}
+
+// ociLayouts caches the blob media types of the OCI image layouts found,
+// by layout directory.
+var ociLayouts sync.Map
+
+// ociBlobMediaType returns the media type the manifests of an OCI image
+// layout give the blob at "path", or "" when it is not such a blob.
+func ociBlobMediaType(path string) string {
+	algorithmDir := filepath.Dir(path)
+	layout := filepath.Dir(filepath.Dir(algorithmDir))
+	if filepath.Base(filepath.Dir(algorithmDir)) != "blobs" {
+		return ""
+	}
+	if _, err := os.Stat(filepath.Join(layout, "oci-layout")); err != nil {
+		return ""
+	}
+	cached, ok := ociLayouts.Load(layout)
+	if !ok {
+		cached, _ = ociLayouts.LoadOrStore(layout, readOCILayout(layout))
+	}
+	return cached.(map[string]string)[filepath.Base(algorithmDir)+":"+filepath.Base(path)]
+}
+
+// readOCILayout maps the digests of the blobs the indexes and manifests of
+// the OCI image layout "layout" refer to, and of the manifests themselves,
+// to their media types.
+func readOCILayout(layout string) map[string]string {
+	mediaTypes := map[string]string{}
+	var visit func(contents []byte)
+	visit = func(contents []byte) {
+		var document struct {
+			Config    *OCIDescriptor  `json:"config"`
+			Layers    []OCIDescriptor `json:"layers"`
+			Manifests []OCIDescriptor `json:"manifests"`
+		}
+		if json.Unmarshal(contents, &document) != nil {
+			return
+		}
+		descriptors := append(document.Layers, document.Manifests...)
+		if document.Config != nil {
+			descriptors = append(descriptors, *document.Config)
+		}
+		for _, d := range descriptors {
+			if _, seen := mediaTypes[d.Digest]; seen || d.MediaType == "" {
+				continue
+			}
+			mediaTypes[d.Digest] = d.MediaType
+			if d.MediaType == OCIManifestMediaType || d.MediaType == OCIIndexMediaType || strings.Contains(d.MediaType, "docker.distribution.manifest") {
+				if nested, err := ioutil.ReadFile(filepath.Join(layout, "blobs", strings.Replace(d.Digest, ":", string(filepath.Separator), 1))); err == nil {
+					visit(nested)
+				}
+			}
+		}
+	}
+	if index, err := ioutil.ReadFile(filepath.Join(layout, "index.json")); err == nil {
+		visit(index)
+	}
+	return mediaTypes
+}
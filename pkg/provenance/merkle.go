@@ -0,0 +1,208 @@
+package provenance
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// MerkleDigestAlgorithm is the digest set key of a subject whose digest is
+// the root of a Merkle tree over many files.
+const MerkleDigestAlgorithm = "merkle-sha256"
+
+// MerkleManifest lists the leaves of a Merkle tree, sorted by name. It is
+// written next to the provenance so per-file inclusion proofs can be
+// produced later without keeping millions of subjects in the attestation.
+type MerkleManifest struct {
+	HashAlgorithm string    `json:"hashAlgorithm"`
+	Root          string    `json:"root"`
+	TreeSize      int       `json:"treeSize"`
+	Leaves        []Subject `json:"leaves"`
+}
+
+// MerkleProof proves that a single file is a leaf of the tree with the
+// given root.
+type MerkleProof struct {
+	Name      string    `json:"name"`
+	Digest    DigestSet `json:"digest"`
+	LeafIndex int       `json:"leafIndex"`
+	TreeSize  int       `json:"treeSize"`
+	Root      string    `json:"root"`
+	Hashes    []string  `json:"hashes"`
+}
+
+// merkleLeafHash hashes a leaf as in RFC 6962, binding the file name to
+// its sha256 digest.
+func merkleLeafHash(name, digest string) ([]byte, error) {
+	raw, err := hex.DecodeString(digest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sha256 digest for %s: %s", name, err)
+	}
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write(raw)
+	return h.Sum(nil), nil
+}
+
+func merkleNodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// MerkleTree returns every level of the tree built over "leaves", starting
+// with the leaves themselves and ending with the root. A node without a
+// sibling is promoted to the next level unchanged, which yields the same
+// tree as RFC 6962.
+func MerkleTree(leaves [][]byte) [][][]byte {
+	levels := [][][]byte{leaves}
+	for level := leaves; len(level) > 1; {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, merkleNodeHash(level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+// MerkleInclusionProof returns the audit path of the leaf at "index".
+func MerkleInclusionProof(levels [][][]byte, index int) [][]byte {
+	var proof [][]byte
+	for _, level := range levels[:len(levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof = append(proof, level[sibling])
+		}
+		index /= 2
+	}
+	return proof
+}
+
+// merkleRootFromProof computes the root of a tree of "size" leaves from the
+// leaf at "index" and its audit path, following RFC 9162 section 2.1.3.2.
+func merkleRootFromProof(leaf []byte, index, size int, proof [][]byte) ([]byte, error) {
+	if index < 0 || index >= size {
+		return nil, fmt.Errorf("leaf index %d out of range for tree size %d", index, size)
+	}
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return nil, fmt.Errorf("proof is too long for tree size %d", size)
+		}
+		if fn%2 == 1 || fn == sn {
+			r = merkleNodeHash(p, r)
+			for fn%2 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = merkleNodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return nil, fmt.Errorf("proof is too short for tree size %d", size)
+	}
+	return r, nil
+}
+
+// MerkleLeaves sorts "subjects" by name and returns their leaf hashes.
+func MerkleLeaves(subjects []Subject) ([][]byte, error) {
+	sort.Slice(subjects, func(i, j int) bool { return subjects[i].Name < subjects[j].Name })
+	leaves := make([][]byte, len(subjects))
+	for i, s := range subjects {
+		if i > 0 && subjects[i-1].Name == s.Name {
+			return nil, fmt.Errorf("duplicate subject name: %s", s.Name)
+		}
+		leaf, err := merkleLeafHash(s.Name, s.Digest["sha256"])
+		if err != nil {
+			return nil, err
+		}
+		leaves[i] = leaf
+	}
+	return leaves, nil
+}
+
+// NewMerkleManifest builds the Merkle tree over "subjects".
+func NewMerkleManifest(subjects []Subject) (*MerkleManifest, error) {
+	if len(subjects) == 0 {
+		return nil, fmt.Errorf("no subjects to build a merkle tree from")
+	}
+	leaves := make([]Subject, len(subjects))
+	for i, s := range subjects {
+		leaves[i] = Subject{Name: s.Name, Digest: DigestSet{"sha256": s.Digest["sha256"]}}
+	}
+	hashes, err := MerkleLeaves(leaves)
+	if err != nil {
+		return nil, err
+	}
+	levels := MerkleTree(hashes)
+	return &MerkleManifest{
+		HashAlgorithm: "sha256",
+		Root:          hex.EncodeToString(levels[len(levels)-1][0]),
+		TreeSize:      len(leaves),
+		Leaves:        leaves,
+	}, nil
+}
+
+// Subject returns the single subject standing in for every leaf of the
+// manifest stored at "path".
+func (m *MerkleManifest) Subject(path string) Subject {
+	return Subject{
+		Name:   filepath.Base(path),
+		Digest: DigestSet{MerkleDigestAlgorithm: m.Root},
+		Annotations: map[string]string{
+			"merkleManifest": filepath.Base(path),
+			"merkleTreeSize": strconv.Itoa(m.TreeSize),
+		},
+	}
+}
+
+// CheckProof verifies an inclusion proof against the Merkle root subjects
+// of a provenance, given as a map of root to tree size.
+func CheckProof(proof MerkleProof, roots map[string]string) error {
+	size, ok := roots[proof.Root]
+	if !ok {
+		return fmt.Errorf("root %s is not a subject of the provenance", proof.Root)
+	}
+	// The root does not commit to the tree size, so it must match the size
+	// attested alongside it.
+	if size != strconv.Itoa(proof.TreeSize) {
+		return fmt.Errorf("tree size %d does not match the attested size %s", proof.TreeSize, size)
+	}
+	leaf, err := merkleLeafHash(proof.Name, proof.Digest["sha256"])
+	if err != nil {
+		return err
+	}
+	var hashes [][]byte
+	for _, h := range proof.Hashes {
+		raw, err := hex.DecodeString(h)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, raw)
+	}
+	root, err := merkleRootFromProof(leaf, proof.LeafIndex, proof.TreeSize, hashes)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(root) != proof.Root {
+		return fmt.Errorf("proof does not lead to root %s", proof.Root)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package provenance
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+// The leaves and tree heads of the RFC 6962 test vectors used by
+// Certificate Transparency implementations.
+var rfc6962Leaves = []string{"", "00", "10", "2021", "3031", "40414243", "5051525354555657", "606162636465666768696a6b6c6d6e6f"}
+
+var rfc6962Roots = []string{
+	"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+	"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+	"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+	"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+	"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+	"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+func rfc6962LeafHashes(t *testing.T) [][]byte {
+	var hashes [][]byte
+	for _, leaf := range rfc6962Leaves {
+		data, err := hex.DecodeString(leaf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := sha256.Sum256(append([]byte{0}, data...))
+		hashes = append(hashes, h[:])
+	}
+	return hashes
+}
+
+func TestMerkleTree(t *testing.T) {
+	hashes := rfc6962LeafHashes(t)
+	for size := 1; size <= len(hashes); size++ {
+		levels := MerkleTree(hashes[:size])
+		root := levels[len(levels)-1][0]
+		if got := hex.EncodeToString(root); got != rfc6962Roots[size-1] {
+			t.Errorf("size %d: got root %s, want %s", size, got, rfc6962Roots[size-1])
+			continue
+		}
+		for index := 0; index < size; index++ {
+			proof := MerkleInclusionProof(levels, index)
+			got, err := merkleRootFromProof(hashes[index], index, size, proof)
+			if err != nil {
+				t.Errorf("size %d, leaf %d: %s", size, index, err)
+			} else if hex.EncodeToString(got) != rfc6962Roots[size-1] {
+				t.Errorf("size %d, leaf %d: proof leads to %x", size, index, got)
+			}
+		}
+	}
+}
+
+func TestMerkleRootFromProofRejectsWrongLength(t *testing.T) {
+	hashes := rfc6962LeafHashes(t)
+	levels := MerkleTree(hashes)
+	proof := MerkleInclusionProof(levels, 2)
+	if _, err := merkleRootFromProof(hashes[2], 2, len(hashes), proof[:len(proof)-1]); err == nil {
+		t.Errorf("got no error for a short proof")
+	}
+	if _, err := merkleRootFromProof(hashes[2], 2, len(hashes), append(proof, proof[0])); err == nil {
+		t.Errorf("got no error for a long proof")
+	}
+	if _, err := merkleRootFromProof(hashes[2], len(hashes), len(hashes), proof); err == nil {
+		t.Errorf("got no error for an index out of range")
+	}
+}
+
+func TestMerkleLeafHash(t *testing.T) {
+	got, err := merkleLeafHash("bin/tool", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "0aac9f489ffe65d871f73966723947b711f4dc3970ef60511dbfc7bf1d70b03c"; hex.EncodeToString(got) != want {
+		t.Errorf("got %x, want %s", got, want)
+	}
+}
+
+func TestCheckProof(t *testing.T) {
+	var subjects []Subject
+	for i := 0; i < 5; i++ {
+		digest := sha256.Sum256([]byte{byte(i)})
+		subjects = append(subjects, Subject{
+			Name:   fmt.Sprintf("dist/file-%d", i),
+			Digest: DigestSet{"sha256": hex.EncodeToString(digest[:])},
+		})
+	}
+	manifest, err := NewMerkleManifest(subjects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes, err := MerkleLeaves(manifest.Leaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	levels := MerkleTree(hashes)
+	roots := map[string]string{manifest.Root: strconv.Itoa(manifest.TreeSize)}
+	for index, leaf := range manifest.Leaves {
+		proof := MerkleProof{
+			Name:      leaf.Name,
+			Digest:    leaf.Digest,
+			LeafIndex: index,
+			TreeSize:  manifest.TreeSize,
+			Root:      manifest.Root,
+		}
+		for _, h := range MerkleInclusionProof(levels, index) {
+			proof.Hashes = append(proof.Hashes, hex.EncodeToString(h))
+		}
+		if err := CheckProof(proof, roots); err != nil {
+			t.Errorf("%s: %s", leaf.Name, err)
+		}
+
+		tampered := proof
+		tampered.Name = leaf.Name + ".bak"
+		if err := CheckProof(tampered, roots); err == nil {
+			t.Errorf("%s: got no error for a renamed leaf", leaf.Name)
+		}
+		resized := proof
+		resized.TreeSize++
+		if err := CheckProof(resized, roots); err == nil {
+			t.Errorf("%s: got no error for a tree size other than the attested one", leaf.Name)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package provenance
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:generate go run gen_nfc_tables.go -version 14.0.0 UnicodeData.txt CompositionExclusions.txt
+
+// Subject name policies.
+const (
+	// NamePolicyNFC normalizes names to Unicode NFC and rejects names that
+	// are not valid UTF-8 or contain control characters.
+	NamePolicyNFC = "nfc"
+	// NamePolicyEscape normalizes names to NFC and percent-encodes invalid
+	// UTF-8, whitespace other than a plain space, control characters and
+	// "%", so any file name maps to a distinct printable name.
+	NamePolicyEscape = "escape"
+	// NamePolicyPreserve keeps names byte for byte.
+	NamePolicyPreserve = "preserve"
+)
+
+// Hangul syllables are composed algorithmically (Unicode section 3.12).
+const (
+	hangulSBase  = 0xAC00
+	hangulLBase  = 0x1100
+	hangulVBase  = 0x1161
+	hangulTBase  = 0x11A7
+	hangulLCount = 19
+	hangulVCount = 21
+	hangulTCount = 28
+	hangulNCount = hangulVCount * hangulTCount
+	hangulSCount = hangulLCount * hangulNCount
+)
+
+// nfc returns "s" in Unicode Normalization Form C. "s" must be valid UTF-8.
+func nfc(s string) string {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return s
+	}
+
+	// Canonical decomposition.
+	var runes []rune
+	for _, r := range s {
+		if r >= hangulSBase && r < hangulSBase+hangulSCount {
+			i := r - hangulSBase
+			runes = append(runes, hangulLBase+i/hangulNCount, hangulVBase+(i%hangulNCount)/hangulTCount)
+			if t := i % hangulTCount; t != 0 {
+				runes = append(runes, hangulTBase+t)
+			}
+		} else if d, ok := nfcDecompositions[r]; ok {
+			runes = append(runes, []rune(d)...)
+		} else {
+			runes = append(runes, r)
+		}
+	}
+
+	// Canonical ordering of combining marks.
+	for i := 1; i < len(runes); i++ {
+		for j := i; j > 0; j-- {
+			a, b := nfcCombiningClasses[runes[j-1]], nfcCombiningClasses[runes[j]]
+			if b == 0 || a <= b {
+				break
+			}
+			runes[j-1], runes[j] = runes[j], runes[j-1]
+		}
+	}
+
+	// Canonical composition.
+	starter := -1
+	var last uint8
+	out := runes[:0]
+	for _, r := range runes {
+		ccc := nfcCombiningClasses[r]
+		if starter >= 0 && (len(out) == starter+1 || (last != 0 && last < ccc)) {
+			if c, ok := compose(out[starter], r); ok {
+				out[starter] = c
+				continue
+			}
+		}
+		if ccc == 0 {
+			starter = len(out)
+		}
+		last = ccc
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+// compose returns the primary composite of "a" and "b", if any.
+func compose(a, b rune) (rune, bool) {
+	if a >= hangulLBase && a < hangulLBase+hangulLCount && b >= hangulVBase && b < hangulVBase+hangulVCount {
+		return hangulSBase + ((a-hangulLBase)*hangulVCount+b-hangulVBase)*hangulTCount, true
+	}
+	if a >= hangulSBase && a < hangulSBase+hangulSCount && (a-hangulSBase)%hangulTCount == 0 && b > hangulTBase && b < hangulTBase+hangulTCount {
+		return a + b - hangulTBase, true
+	}
+	c, ok := nfcCompositions[[2]rune{a, b}]
+	return c, ok
+}
+
+// escapeName percent-encodes the bytes of "name" that would make it
+// ambiguous or unprintable.
+func escapeName(name string) string {
+	var b strings.Builder
+	for len(name) > 0 {
+		r, size := utf8.DecodeRuneInString(name)
+		if (r == utf8.RuneError && size <= 1) || r == '%' || unicode.IsControl(r) || (unicode.IsSpace(r) && r != ' ') {
+			for i := 0; i < size; i++ {
+				fmt.Fprintf(&b, "%%%02X", name[i])
+			}
+		} else {
+			b.WriteString(name[:size])
+		}
+		name = name[size:]
+	}
+	return b.String()
+}
+
+// normalizeName applies the naming "policy" to a single subject name.
+func normalizeName(name, policy string) (string, error) {
+	switch policy {
+	case NamePolicyPreserve:
+		return name, nil
+	case NamePolicyEscape:
+		if !utf8.ValidString(name) {
+			return escapeName(name), nil
+		}
+		return escapeName(nfc(name)), nil
+	case NamePolicyNFC, "":
+		if !utf8.ValidString(name) {
+			return "", fmt.Errorf("subject name is not valid UTF-8: %q", name)
+		}
+		for _, r := range name {
+			if unicode.IsControl(r) {
+				return "", fmt.Errorf("subject name contains a control character: %q", name)
+			}
+		}
+		return nfc(name), nil
+	}
+	return "", fmt.Errorf("unknown subject name policy %q", policy)
+}
+
+// NormalizeSubjectNames applies the naming "policy" to every subject and
+// fails when two subjects end up with the same name.
+func NormalizeSubjectNames(subjects []Subject, policy string) error {
+	names := map[string]string{}
+	var collisions []string
+	for i := range subjects {
+		name, err := normalizeName(subjects[i].Name, policy)
+		if err != nil {
+			return err
+		}
+		if original, ok := names[name]; ok && original != subjects[i].Name {
+			collisions = append(collisions, fmt.Sprintf("%q and %q", original, subjects[i].Name))
+		}
+		names[name] = subjects[i].Name
+		subjects[i].Name = name
+	}
+	if len(collisions) > 0 {
+		sort.Strings(collisions)
+		return fmt.Errorf("subject names are ambiguous after normalization: %s", strings.Join(collisions, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package provenance
+
+import "testing"
+
+func TestNFC(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{"ascii", "dist/app.tar.gz", "dist/app.tar.gz"},
+		{"combining acute", "cafe\u0301", "caf\u00e9"},
+		{"precomposed", "caf\u00e9", "caf\u00e9"},
+		{"angstrom singleton", "\u212b", "\u00c5"},
+		{"ohm singleton", "\u2126", "\u03a9"},
+		{"canonical order", "a\u0323\u0302", "\u1ead"},
+		{"reordered marks", "a\u0302\u0323", "\u1ead"},
+		{"composition exclusion", "\u0958", "\u0915\u093c"},
+		{"blocked mark", "\u1e9b\u0323", "\u1e9b\u0323"},
+		{"hangul jamo", "\u1100\u1161\u11a8", "\uac01"},
+		{"hangul lv and t", "\uac00\u11a8", "\uac01"},
+	}
+	for _, test := range tests {
+		if got := nfc(test.input); got != test.want {
+			t.Errorf("%s: nfc(%+q) = %+q, want %+q", test.name, test.input, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name, policy, want string
+		err                bool
+	}{
+		{"cafe\u0301.txt", NamePolicyNFC, "caf\u00e9.txt", false},
+		{"cafe\u0301.txt", "", "caf\u00e9.txt", false},
+		{"cafe\u0301.txt", NamePolicyPreserve, "cafe\u0301.txt", false},
+		{"a\tb", NamePolicyNFC, "", true},
+		{"a\xffb", NamePolicyNFC, "", true},
+		{"a\tb", NamePolicyEscape, "a%09b", false},
+		{"a\xffb", NamePolicyEscape, "a%FFb", false},
+		{"100%.txt", NamePolicyEscape, "100%25.txt", false},
+		{"cafe\u0301 menu", NamePolicyEscape, "caf\u00e9 menu", false},
+		{"a", "lowercase", "", true},
+	}
+	for _, test := range tests {
+		got, err := normalizeName(test.name, test.policy)
+		if test.err {
+			if err == nil {
+				t.Errorf("normalizeName(%+q, %q) = %+q, want an error", test.name, test.policy, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("normalizeName(%+q, %q): %s", test.name, test.policy, err)
+		} else if got != test.want {
+			t.Errorf("normalizeName(%+q, %q) = %+q, want %+q", test.name, test.policy, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeSubjectNamesCollision(t *testing.T) {
+	subjects := []Subject{{Name: "caf\u00e9"}, {Name: "cafe\u0301"}}
+	if err := NormalizeSubjectNames(subjects, NamePolicyNFC); err == nil {
+		t.Errorf("got no error for names that only differ in normalization")
+	}
+	subjects = []Subject{{Name: "caf\u00e9"}, {Name: "cafe\u0301"}}
+	if err := NormalizeSubjectNames(subjects, NamePolicyPreserve); err != nil {
+		t.Errorf("preserve: %s", err)
+	}
+}
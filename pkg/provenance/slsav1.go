@@ -0,0 +1,147 @@
+package provenance
+
+import (
+	"encoding/json"
+)
+
+const (
+	// ProvenanceV1PredicateType is the predicate type of SLSA v1 provenance.
+	ProvenanceV1PredicateType = "https://slsa.dev/provenance/v1"
+	// StatementV1Type is the type of in-toto v1 statements.
+	StatementV1Type = "https://in-toto.io/Statement/v1"
+	// BuildTypeV1 is the buildType of SLSA v1 provenance of Buildkite jobs,
+	// documented in the README.
+	BuildTypeV1 = "https://github.com/hi-artem/provenance-generator-buildkite-plugin#buildkite-job-build-type-v1"
+)
+
+// ProvenanceV1 is a SLSA v1 provenance predicate.
+type ProvenanceV1 struct {
+	BuildDefinition BuildDefinitionV1 `json:"buildDefinition"`
+	RunDetails      RunDetailsV1      `json:"runDetails"`
+}
+
+type BuildDefinitionV1 struct {
+	BuildType            string               `json:"buildType"`
+	ExternalParameters   ExternalParameters   `json:"externalParameters"`
+	InternalParameters   InternalParameters   `json:"internalParameters"`
+	ResolvedDependencies []ResourceDescriptor `json:"resolvedDependencies"`
+}
+
+// ExternalParameters are the parameters of the build under the control of
+// whoever triggered it or wrote the pipeline, which verifiers check
+// against their expectations.
+type ExternalParameters struct {
+	Source   SourceParameters `json:"source"`
+	Pipeline string           `json:"pipeline,omitempty"`
+	Step     StepParameters   `json:"step"`
+	// Environment are the variables captured with --capture_env.
+	Environment map[string]string `json:"environment,omitempty"`
+}
+
+type SourceParameters struct {
+	Repository string `json:"repository"`
+	Commit     string `json:"commit"`
+	Branch     string `json:"branch,omitempty"`
+	Tag        string `json:"tag,omitempty"`
+}
+
+type StepParameters struct {
+	ID      string `json:"id,omitempty"`
+	Command string `json:"command,omitempty"`
+	// Arguments are the recipe arguments, e.g. the step configuration with
+	// --buildkite_graphql.
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// InternalParameters are the parameters of the build set by the builder:
+// the agent the job ran on.
+type InternalParameters struct {
+	Organization string            `json:"organization"`
+	AgentID      string            `json:"agentId"`
+	AgentName    string            `json:"agentName,omitempty"`
+	AgentOS      string            `json:"agentOs,omitempty"`
+	AgentArch    string            `json:"agentArch,omitempty"`
+	AgentQueue   string            `json:"agentQueue,omitempty"`
+	AgentTags    map[string]string `json:"agentTags,omitempty"`
+}
+
+type RunDetailsV1 struct {
+	Builder    BuilderV1            `json:"builder"`
+	Metadata   BuildMetadataV1      `json:"metadata"`
+	Byproducts []ResourceDescriptor `json:"byproducts,omitempty"`
+}
+
+type BuilderV1 struct {
+	ID                  string               `json:"id"`
+	Version             map[string]string    `json:"version,omitempty"`
+	BuilderDependencies []ResourceDescriptor `json:"builderDependencies,omitempty"`
+}
+
+type BuildMetadataV1 struct {
+	InvocationID string `json:"invocationId"`
+	StartedOn    string `json:"startedOn,omitempty"`
+	FinishedOn   string `json:"finishedOn,omitempty"`
+}
+
+// NewProvenanceV1 returns the SLSA v1 provenance equivalent to "p", generated
+// for the job in "context" whose environment is "env". The invocation is
+// the job, and the branch and tag the build is of are taken from "env".
+func NewProvenanceV1(p Predicate, context AnyContext, env map[string]string) ProvenanceV1 {
+	build, agent := context.BuildContext, context.AgentContext
+	source := SourceParameters{Repository: build.Repository, Commit: build.Commit, Branch: env["BUILDKITE_BRANCH"], Tag: env["BUILDKITE_TAG"]}
+	var environment map[string]string
+	if p.Recipe.Environment != nil {
+		environment = p.Recipe.Environment.Variables
+	}
+	dependencies := []ResourceDescriptor{}
+	for _, material := range p.Materials {
+		dependencies = append(dependencies, ResourceDescriptor{URI: material.URI, Digest: material.Digest})
+	}
+	var builderDependencies []ResourceDescriptor
+	for _, dependency := range p.Builder.Dependencies {
+		builderDependencies = append(builderDependencies, ResourceDescriptor{URI: dependency.URI, Digest: dependency.Digest})
+	}
+	invocation := p.Metadata.BuildInvocationId
+	if env["BUILDKITE_JOB_ID"] != "" {
+		invocation += "#" + env["BUILDKITE_JOB_ID"]
+	}
+	return ProvenanceV1{
+		BuildDefinition: BuildDefinitionV1{
+			BuildType: BuildTypeV1,
+			ExternalParameters: ExternalParameters{
+				Source:      source,
+				Pipeline:    env["BUILDKITE_PIPELINE_SLUG"],
+				Step:        StepParameters{ID: build.StepID, Command: p.Recipe.EntryPoint, Arguments: p.Recipe.Arguments},
+				Environment: environment,
+			},
+			InternalParameters: InternalParameters{
+				Organization: agent.Organization,
+				AgentID:      agent.ID,
+				AgentName:    agent.Name,
+				AgentOS:      agent.OS,
+				AgentArch:    agent.Arch,
+				AgentQueue:   agent.Queue,
+				AgentTags:    agent.Tags,
+			},
+			ResolvedDependencies: dependencies,
+		},
+		RunDetails: RunDetailsV1{
+			Builder: BuilderV1{ID: p.Builder.Id, Version: p.Builder.Version, BuilderDependencies: builderDependencies},
+			Metadata: BuildMetadataV1{
+				InvocationID: invocation,
+				StartedOn:    p.Metadata.BuildStartedOn,
+				FinishedOn:   p.Metadata.BuildFinishedOn,
+			},
+			Byproducts: p.Byproducts,
+		},
+	}
+}
+
+// ResourceDescriptor describes an artifact, as in in-toto and SLSA v1:
+// the input verified by a VSA or a byproduct of a build.
+type ResourceDescriptor struct {
+	Name      string    `json:"name,omitempty"`
+	URI       string    `json:"uri,omitempty"`
+	Digest    DigestSet `json:"digest"`
+	MediaType string    `json:"mediaType,omitempty"`
+}
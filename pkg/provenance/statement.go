@@ -0,0 +1,218 @@
+// Package provenance builds in-toto statements with the Buildkite
+// provenance predicate: it walks and digests artifacts into subjects,
+// canonicalizes them with JCS, and computes the Merkle manifests and proofs
+// that let large subject lists be verified piecemeal.
+package provenance
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/giturl"
+)
+
+const (
+	BuildkiteIdSuffix = "/Attestations/Buildkite@v1"
+	TypeId            = "https://buildkite.com/Attestations/BuildkiteBuild@v1"
+)
+
+type Statement struct {
+	Type          string    `json:"_type"`
+	Subject       []Subject `json:"subject"`
+	PredicateType string    `json:"predicateType"`
+	Predicate     `json:"predicate"`
+}
+
+type Subject struct {
+	Name             string            `json:"name"`
+	Digest           DigestSet         `json:"digest"`
+	MediaType        string            `json:"mediaType,omitempty"`
+	DownloadLocation string            `json:"downloadLocation,omitempty"`
+	Annotations      map[string]string `json:"annotations,omitempty"`
+	// File is the local file the subject was digested from, if any, Image
+	// the reference of the image it is the manifest of and Archive the
+	// archive file it is an entry of. They are not part of the statement.
+	File    string `json:"-"`
+	Image   string `json:"-"`
+	Archive string `json:"-"`
+}
+
+type Predicate struct {
+	Builder   `json:"builder"`
+	Metadata  `json:"metadata"`
+	Recipe    `json:"recipe"`
+	Materials []Item `json:"materials"`
+	// BuildConfig is the buildConfig of merged BuildKit provenance, named
+	// as in SLSA v0.2.
+	BuildConfig json.RawMessage `json:"buildConfig,omitempty"`
+	// Byproducts are outputs of the build that are not subjects, such as
+	// its log, named as in SLSA v1.
+	Byproducts []ResourceDescriptor `json:"byproducts,omitempty"`
+}
+
+type Builder struct {
+	Id string `json:"id"`
+	// Version and Dependencies are the versions of the agent and the
+	// generator, named as in SLSA v1.
+	Version      map[string]string `json:"version,omitempty"`
+	Dependencies []Item            `json:"builderDependencies,omitempty"`
+}
+
+type Metadata struct {
+	BuildInvocationId string `json:"buildInvocationId"`
+	Completeness      `json:"completeness"`
+	Reproducible      bool `json:"reproducible"`
+	// BuildStartedOn is when the job started or, failing that, the build.
+	BuildStartedOn  string             `json:"buildStartedOn,omitempty"`
+	BuildFinishedOn string             `json:"buildFinishedOn"`
+	Buildkite       *BuildkiteMetadata `json:"buildkite,omitempty"`
+	// Hermetic is set when the build declared all its inputs and had no
+	// network access, and Isolated when no other build could influence it.
+	Hermetic bool `json:"hermetic,omitempty"`
+	Isolated bool `json:"isolated,omitempty"`
+}
+
+// BuildkiteMetadata describes the build as known to the Buildkite REST API.
+type BuildkiteMetadata struct {
+	Pipeline    string `json:"pipeline"`
+	BuildNumber int    `json:"buildNumber"`
+	Creator     string `json:"creator,omitempty"`
+	// JobState is the state of the job generating the provenance.
+	JobState string `json:"jobState,omitempty"`
+}
+
+type Recipe struct {
+	Type              string          `json:"type"`
+	DefinedInMaterial int             `json:"definedInMaterial"`
+	EntryPoint        string          `json:"entryPoint"`
+	Arguments         json.RawMessage `json:"arguments"`
+	Environment       *AnyContext     `json:"environment"`
+}
+
+type Completeness struct {
+	Arguments   bool `json:"arguments"`
+	Environment bool `json:"environment"`
+	Materials   bool `json:"materials"`
+}
+
+type DigestSet map[string]string
+
+type Item struct {
+	URI    string    `json:"uri"`
+	Digest DigestSet `json:"digest"`
+}
+
+type AnyContext struct {
+	BuildContext    `json:"build"`
+	AgentContext    `json:"agent"`
+	EnvironmentDiff *EnvironmentDiff `json:"environment_diff,omitempty"`
+	// Variables are the job environment variables captured with
+	// --capture_env.
+	Variables map[string]string `json:"variables,omitempty"`
+}
+
+type BuildContext struct {
+	Repository string `json:"repository"`
+	BuildURL   string `json:"build_url"`
+	Commit     string `json:"commit"`
+	StepID     string `json:"step_id"`
+	Command    string `json:"command"`
+	// Ref is the Git ref the build is of, e.g. "refs/tags/v1.2.3".
+	Ref string `json:"ref,omitempty"`
+}
+
+type AgentContext struct {
+	Name         string `json:"agent_name"`
+	ID           string `json:"agent_id"`
+	Organization string `json:"agent_organization"`
+	// OS and Arch describe the host of the agent, and Queue and Tags the
+	// pool it belongs to.
+	OS    string            `json:"agent_os,omitempty"`
+	Arch  string            `json:"agent_arch,omitempty"`
+	Queue string            `json:"agent_queue,omitempty"`
+	Tags  map[string]string `json:"agent_tags,omitempty"`
+}
+
+// EnvironmentDiff records how the job environment differs from the
+// baseline stored for the pipeline.
+type EnvironmentDiff struct {
+	Baseline   string   `json:"baseline"`
+	Unexpected []string `json:"unexpected"`
+	Missing    []string `json:"missing"`
+}
+
+// NewStatement returns the provenance statement for "subjects" built in the
+// given build and agent context and finished at "finishedOn".
+func NewStatement(subjects []Subject, context AnyContext, finishedOn time.Time) (Statement, error) {
+	stmt := Statement{PredicateType: "https://slsa.dev/provenance/v0.1", Type: "https://in-toto.io/Statement/v0.1"}
+	stmt.Subject = append(stmt.Subject, subjects...)
+	stmt.Predicate = Predicate{
+		Builder{},
+		Metadata{
+			Completeness: Completeness{
+				Arguments:   true,
+				Environment: false,
+				Materials:   false,
+			},
+			Reproducible:    false,
+			BuildFinishedOn: finishedOn.UTC().Format(time.RFC3339),
+		},
+		Recipe{
+			Type:              TypeId,
+			DefinedInMaterial: 0,
+		},
+		[]Item{},
+		nil,
+		nil,
+	}
+
+	build := context.BuildContext
+	agent := context.AgentContext
+
+	materialsURI, err := giturl.SourceURI(build.Repository)
+
+	if err != nil {
+		return stmt, err
+	}
+	if build.Ref != "" {
+		materialsURI += "@" + build.Ref
+	}
+
+	stmt.Predicate.Metadata.BuildInvocationId = build.BuildURL
+	stmt.Predicate.Recipe.EntryPoint = build.Command
+	stmt.Predicate.Materials = append(stmt.Predicate.Materials, Item{URI: materialsURI, Digest: DigestSet{"sha1": build.Commit}})
+	stmt.Predicate.Builder.Id = "https://buildkite.com/organizations/" + agent.Organization + "/agents/" + agent.ID
+	return stmt, nil
+}
+
+func EscapedMarshal(t interface{}) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(t)
+	return buffer.Bytes(), err
+}
+
+func EscapedMarshalIndent(v interface{}, prefix, indent string) ([]byte, error) {
+	b, err := EscapedMarshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = json.Indent(&buf, b, prefix, indent)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// CustomStatement is an in-toto Statement about the subjects found as for
+// provenance, wrapping a predicate given by --predicate_file instead, e.g.
+// an internal compliance attestation.
+type CustomStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []Subject       `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate"`
+}
@@ -0,0 +1,52 @@
+package provenance
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hi-artem/provenance-generator-buildkite-plugin/pkg/provtest"
+)
+
+func TestNewStatement(t *testing.T) {
+	dist := t.TempDir()
+	files := map[string]string{
+		"app.tar.gz":       "app",
+		"docs/README.md":   "# app\n",
+		"docs/CHANGES.txt": "v1.0.0\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(dist, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	walker := Walker{Workers: 2}
+	subjects, err := walker.Subjects(dist, "sha256", "sha512")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var context AnyContext
+	if err := json.Unmarshal([]byte(provtest.BuildContext), &context.BuildContext); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(provtest.AgentContext), &context.AgentContext); err != nil {
+		t.Fatal(err)
+	}
+	clock := provtest.NewClock(provtest.Epoch)
+	stmt, err := NewStatement(subjects, context, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := EscapedMarshalIndent(stmt, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provtest.AssertGolden(t, "testdata/statement.golden", got)
+}
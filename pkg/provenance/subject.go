@@ -0,0 +1,60 @@
+package provenance
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SameDigests reports whether "a" and "b" describe the same content: they
+// must share an algorithm and agree on every algorithm they share.
+func SameDigests(a, b DigestSet) bool {
+	shared := false
+	for algorithm, digest := range a {
+		if other, ok := b[algorithm]; ok {
+			if other != digest {
+				return false
+			}
+			shared = true
+		}
+	}
+	return shared
+}
+
+// SortSubjects sorts "subjects" by name, then by sha256 digest.
+func SortSubjects(subjects []Subject) {
+	sort.SliceStable(subjects, func(i, j int) bool {
+		if subjects[i].Name != subjects[j].Name {
+			return subjects[i].Name < subjects[j].Name
+		}
+		return subjects[i].Digest["sha256"] < subjects[j].Digest["sha256"]
+	})
+}
+
+// ParseSubject parses a precomputed subject given as
+// "name=algorithm:hex[,algorithm:hex...]", e.g. a digest reported by another
+// step for an artifact that is not on disk.
+func ParseSubject(value string) (Subject, error) {
+	i := strings.LastIndex(value, "=")
+	if i <= 0 {
+		return Subject{}, fmt.Errorf("%q is not name=algorithm:digest", value)
+	}
+	subject := Subject{Name: value[:i], Digest: DigestSet{}}
+	for _, digest := range strings.Split(value[i+1:], ",") {
+		parts := strings.SplitN(digest, ":", 2)
+		newHash, ok := digestAlgorithms[parts[0]]
+		if len(parts) != 2 || !ok {
+			return Subject{}, fmt.Errorf("%q of %s is not a supported algorithm:digest", digest, subject.Name)
+		}
+		hexDigest := strings.ToLower(parts[1])
+		if decoded, err := hex.DecodeString(hexDigest); err != nil || len(decoded) != newHash().Size() {
+			return Subject{}, fmt.Errorf("invalid %s digest %q of %s", parts[0], parts[1], subject.Name)
+		}
+		subject.Digest[parts[0]] = hexDigest
+	}
+	if subject.Digest["sha256"] == "" {
+		return Subject{}, fmt.Errorf("subject %s has no sha256 digest", subject.Name)
+	}
+	return subject, nil
+}
@@ -0,0 +1,324 @@
+package provenance
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// errWalkStopped stops a walk once hashing has failed.
+var errWalkStopped = errors.New("walk stopped")
+
+// ErrNoMatch is returned for artifact path patterns matching no files.
+var ErrNoMatch = errors.New("no files match")
+
+// Subjects walks the file or directory at "root" and hashes all files with
+// the given digest algorithms, or with sha256 when none are given. Files
+// are hashed by Workers workers but returned in walk (lexical) order.
+// A "root" with glob characters (e.g. "dist/**/*.tar.gz") selects the
+// matching files below its literal directory, which names are relative to.
+func (wk *Walker) Subjects(root string, algorithms ...string) ([]Subject, error) {
+	if len(algorithms) == 0 {
+		algorithms = []string{"sha256"}
+	}
+	type file struct {
+		index   int
+		abspath string
+		relpath string
+		link    bool
+	}
+	var (
+		files   = make(chan file)
+		stop    = make(chan struct{})
+		mu      sync.Mutex
+		hashed  = map[int]Subject{}
+		hashErr error
+		skipped int
+		wg      sync.WaitGroup
+	)
+	// skip reports a file or directory that cannot be read; mu must be held.
+	skip := func(relpath string, err error) {
+		wk.warn(fmt.Sprintf("Skipping unreadable %s: %s", relpath, err))
+		skipped++
+	}
+	workers := wk.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range files {
+				var digest DigestSet
+				var err error
+				if f.link {
+					digest, err = digestLink(f.abspath, algorithms)
+				} else {
+					digest, err = DigestFile(f.abspath, algorithms)
+				}
+				subject := Subject{Name: f.relpath, Digest: digest}
+				if !f.link {
+					subject.File = f.abspath
+				}
+				if err == nil && wk.Describe && !f.link {
+					var size int64
+					subject.MediaType, size, err = DescribeFile(f.abspath)
+					subject.Annotations = map[string]string{"size": strconv.FormatInt(size, 10)}
+				}
+				mu.Lock()
+				if err != nil && wk.SkipUnreadable {
+					skip(f.relpath, err)
+				} else if err != nil && hashErr == nil {
+					hashErr = err
+					close(stop)
+				} else if err == nil {
+					hashed[f.index] = subject
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	count := 0
+	walkErr := wk.Walk(root, func(abspath, relpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if !wk.SkipUnreadable {
+				return err
+			}
+			mu.Lock()
+			skip(relpath, err)
+			mu.Unlock()
+			return nil
+		}
+		select {
+		case files <- file{index: count, abspath: abspath, relpath: relpath, link: info.Mode()&os.ModeSymlink != 0}:
+			count++
+			return nil
+		case <-stop:
+			return errWalkStopped
+		}
+	})
+	close(files)
+	wg.Wait()
+	if hashErr != nil {
+		return nil, hashErr
+	}
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	if skipped > 0 {
+		wk.warn(fmt.Sprintf("Skipped %d unreadable files or directories under %s", skipped, root))
+	}
+	var s []Subject
+	for i := 0; i < count; i++ {
+		if subject, ok := hashed[i]; ok {
+			s = append(s, subject)
+		}
+	}
+	return s, nil
+}
+
+// Symlink policies of --symlinks.
+const (
+	// SymlinksFollow attests the files symlinks point to under the name of
+	// the link and descends into linked directories. Targets must be within
+	// the artifact directory.
+	SymlinksFollow = "follow"
+	// SymlinksSkip leaves symlinks out of the provenance.
+	SymlinksSkip = "skip"
+	// SymlinksHashTarget attests the link itself, digesting the path it
+	// points to as git does, without reading the target.
+	SymlinksHashTarget = "hash-target"
+)
+
+// Walker finds the files of artifact paths and digests them as subjects.
+// The zero Walker hashes one file at a time, follows symlinks and selects
+// every file.
+type Walker struct {
+	// Workers is the number of files hashed in parallel.
+	Workers int
+	// SkipUnreadable skips files that cannot be read, reporting them to
+	// Warn, instead of failing.
+	SkipUnreadable bool
+	// Symlinks is how symlinks are handled, SymlinksFollow when empty.
+	Symlinks string
+	// Exclude are patterns of files that are left out (see newExcluder).
+	Exclude []string
+	// Filter selects the files to attest by size and extension.
+	Filter FileFilter
+	// Describe records the media type and size of each file subject, as the
+	// mediaType and annotations of SLSA v1 resource descriptors, so
+	// policies can tell container layers from SBOMs from binaries.
+	Describe bool
+	// Warn, when set, receives a message for each file or directory
+	// SkipUnreadable skips and their count at the end of the walk.
+	Warn func(message string)
+}
+
+func (wk *Walker) warn(message string) {
+	if wk.Warn != nil {
+		wk.Warn(message)
+	}
+}
+
+func ValidSymlinkPolicy(policy string) bool {
+	return policy == SymlinksFollow || policy == SymlinksSkip || policy == SymlinksHashTarget
+}
+
+// artifactWalk walks an artifact path for Walker.Walk.
+type artifactWalk struct {
+	*Walker
+	realRoot  string
+	pattern   string
+	exclude   *excluder
+	ancestors []os.FileInfo
+	matched   int
+	fn        func(abspath, relpath string, info os.FileInfo, err error) error
+}
+
+// Walk calls "fn" in lexical order with every file of the artifact path
+// "root", which may end in a glob pattern, and its path relative to the
+// directory the pattern starts with. Excluded files, files the Filter does
+// not select, sockets, devices and named pipes are skipped, and symlinks are
+// handled as Symlinks says; with SymlinksHashTarget "fn" gets the links
+// themselves. As with
+// filepath.Walk, "fn" also gets the error of directories that cannot be read
+// and of symlinks that cannot be resolved, and the walk goes on when it
+// returns nil.
+func (wk *Walker) Walk(root string, fn func(abspath, relpath string, info os.FileInfo, err error) error) error {
+	root, pattern := SplitGlob(root)
+	exclude, err := newExcluder(root, wk.Exclude)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	w := &artifactWalk{Walker: wk, realRoot: realRoot, pattern: pattern, exclude: exclude, fn: fn}
+	if info.IsDir() {
+		err = w.dir(root, ".", info)
+	} else {
+		// A single file is named after itself.
+		err = w.entry(root, filepath.Base(root), info)
+	}
+	if err != nil {
+		return err
+	}
+	if pattern != "" && w.matched == 0 {
+		return fmt.Errorf("%w %s", ErrNoMatch, filepath.Join(root, pattern))
+	}
+	return nil
+}
+
+func (w *artifactWalk) dir(abspath, relpath string, info os.FileInfo) error {
+	for _, ancestor := range w.ancestors {
+		if os.SameFile(ancestor, info) {
+			return fmt.Errorf("symlink loop: %s is %s again", abspath, ancestor.Name())
+		}
+	}
+	w.ancestors = append(w.ancestors, info)
+	defer func() { w.ancestors = w.ancestors[:len(w.ancestors)-1] }()
+	entries, err := ioutil.ReadDir(abspath)
+	if err != nil {
+		return w.fn(abspath, relpath, info, err)
+	}
+	for _, entry := range entries {
+		if err := w.entry(filepath.Join(abspath, entry.Name()), filepath.Join(relpath, entry.Name()), entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *artifactWalk) entry(abspath, relpath string, info os.FileInfo) error {
+	if info.Mode()&os.ModeSymlink != 0 {
+		switch w.Symlinks {
+		case SymlinksSkip:
+			return nil
+		case SymlinksHashTarget:
+			return w.file(abspath, relpath, info)
+		}
+		target, err := filepath.EvalSymlinks(abspath)
+		if err != nil {
+			return w.fn(abspath, relpath, info, fmt.Errorf("failed to resolve symlink %s: %s", relpath, err))
+		}
+		if rel, err := filepath.Rel(w.realRoot, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("symlink %s points outside of the artifacts to %s; use --symlinks skip or hash-target", relpath, target)
+		}
+		if info, err = os.Stat(abspath); err != nil {
+			return w.fn(abspath, relpath, info, err)
+		}
+	}
+	switch {
+	case info.IsDir():
+		if w.exclude.excluded(relpath, true) {
+			return nil
+		}
+		return w.dir(abspath, relpath, info)
+	case info.Mode().IsRegular():
+		return w.file(abspath, relpath, info)
+	}
+	return nil
+}
+
+func (w *artifactWalk) file(abspath, relpath string, info os.FileInfo) error {
+	if w.exclude.excluded(relpath, false) {
+		return nil
+	}
+	if w.pattern != "" && !matchGlob(w.pattern, filepath.ToSlash(relpath)) {
+		return nil
+	}
+	if !w.Filter.selects(info.Name(), info.Size()) {
+		return nil
+	}
+	w.matched++
+	return w.fn(abspath, relpath, info, nil)
+}
+
+// FileFilter selects artifact files by size and extension, e.g. to attest
+// only the release binaries of a directory that also holds debug symbols and
+// logs. Extensions are given with or without their dot, and each entry may
+// be a comma-separated list.
+type FileFilter struct {
+	MinSize     int64
+	MaxSize     int64
+	IncludeExts []string
+	ExcludeExts []string
+}
+
+// selects reports whether the file "name" of "size" bytes passes the filter.
+func (f *FileFilter) selects(name string, size int64) bool {
+	if size < f.MinSize || (f.MaxSize > 0 && size > f.MaxSize) {
+		return false
+	}
+	if hasExtension(name, f.ExcludeExts) {
+		return false
+	}
+	return len(f.IncludeExts) == 0 || hasExtension(name, f.IncludeExts)
+}
+
+// hasExtension reports whether "name" ends, case-insensitively, in one of the
+// comma-separated extensions of "exts", given with or without their dot.
+func hasExtension(name string, exts []string) bool {
+	name = strings.ToLower(name)
+	for _, entry := range exts {
+		for _, ext := range strings.Split(entry, ",") {
+			ext = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(ext)), ".")
+			if ext != "" && strings.HasSuffix(name, "."+ext) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package provtest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var update = flag.Bool("provtest.update", false, "Rewrite golden files with the actual output.")
+
+// WriteEnvFile writes "env" NUL-separated, as `env -0` does, to a file in
+// the test's temporary directory and returns its path.
+func WriteEnvFile(t testing.TB, env map[string]string) string {
+	t.Helper()
+	var names []string
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		buf.WriteString(name + "=" + env[name])
+		buf.WriteByte(0)
+	}
+	path := filepath.Join(t.TempDir(), "job-env")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Statement returns the in-toto statement held by "document", which may be
+// a bare statement or a DSSE envelope, as a generic JSON value.
+func Statement(document []byte) (interface{}, error) {
+	var envelope struct {
+		PayloadType string `json:"payloadType"`
+		Payload     string `json:"payload"`
+	}
+	if err := json.Unmarshal(document, &envelope); err == nil && envelope.PayloadType != "" {
+		payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+		if err != nil {
+			return nil, err
+		}
+		document = payload
+	}
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Diff returns the JSON paths at which the statements held by "want" and
+// "got" differ. Key order, whitespace and DSSE wrapping are ignored, so
+// signatures do not make golden files unstable.
+func Diff(want, got []byte) ([]string, error) {
+	w, err := Statement(want)
+	if err != nil {
+		return nil, fmt.Errorf("invalid golden statement: %s", err)
+	}
+	g, err := Statement(got)
+	if err != nil {
+		return nil, fmt.Errorf("invalid statement: %s", err)
+	}
+	var diffs []string
+	diff("$", w, g, &diffs)
+	sort.Strings(diffs)
+	return diffs, nil
+}
+
+func diff(path string, want, got interface{}, diffs *[]string) {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			break
+		}
+		for key, value := range w {
+			if other, ok := g[key]; ok {
+				diff(path+"."+key, value, other, diffs)
+			} else {
+				*diffs = append(*diffs, fmt.Sprintf("%s.%s: missing", path, key))
+			}
+		}
+		for key := range g {
+			if _, ok := w[key]; !ok {
+				*diffs = append(*diffs, fmt.Sprintf("%s.%s: unexpected", path, key))
+			}
+		}
+		return
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok {
+			break
+		}
+		if len(w) != len(g) {
+			*diffs = append(*diffs, fmt.Sprintf("%s: want %d items, got %d", path, len(w), len(g)))
+			return
+		}
+		for i := range w {
+			diff(fmt.Sprintf("%s[%d]", path, i), w[i], g[i], diffs)
+		}
+		return
+	}
+	if !reflect.DeepEqual(want, got) {
+		*diffs = append(*diffs, fmt.Sprintf("%s: want %v, got %v", path, want, got))
+	}
+}
+
+// AssertGolden compares the statement in "got" with the golden file at
+// "path" and fails the test on any difference. Run the tests with
+// -provtest.update to write "got" to the golden file instead.
+func AssertGolden(t testing.TB, path string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file (run with -provtest.update to create it): %s", err)
+	}
+	diffs, err := Diff(want, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range diffs {
+		t.Errorf("%s: %s", path, d)
+	}
+}
@@ -0,0 +1,75 @@
+// Package provtest provides deterministic fixtures for testing extensions
+// of the provenance generator, such as custom resolvers and predicates: a
+// fake clock, fixed build and agent contexts, and golden file comparison
+// of statements. Nothing in it reads the ambient environment or makes
+// network calls.
+//
+// Code in the generator's package swaps its clock in tests with
+//
+//	clock := provtest.NewClock(provtest.Epoch)
+//	now = clock.Now
+//
+// and black-box tests of the generator binary get the same effect by
+// setting SOURCE_DATE_EPOCH (see Env).
+package provtest
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Epoch is the fixed time fixtures are built at.
+var Epoch = time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+// Clock is a fake clock that only moves when told to.
+type Clock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+// NewClock returns a clock stopped at "t".
+func NewClock(t time.Time) *Clock {
+	return &Clock{t: t}
+}
+
+// Now returns the clock's current time. It has the signature of time.Now.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+// Advance moves the clock forward by "d".
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// Fixed build and agent contexts, in the JSON form taken by the
+// generator's --build_context and --agent_context flags.
+const (
+	BuildContext = `{"build_url":"https://buildkite.com/acme/app/builds/42","command":"make dist","commit":"1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c","step_id":"0189c0a0-0000-4000-8000-000000000001","repository":"git@github.com:acme/app.git"}`
+	AgentContext = `{"agent_name":"provtest-agent-1","agent_id":"0189c0a0-0000-4000-8000-000000000002","agent_organization":"acme"}`
+)
+
+// Env returns a fixed job environment matching BuildContext and
+// AgentContext, with SOURCE_DATE_EPOCH set to Epoch. Write it with
+// WriteEnvFile to pass it as the generator's --job_env_file.
+func Env() map[string]string {
+	return map[string]string{
+		"BUILDKITE":                   "true",
+		"BUILDKITE_AGENT_ID":          "0189c0a0-0000-4000-8000-000000000002",
+		"BUILDKITE_AGENT_NAME":        "provtest-agent-1",
+		"BUILDKITE_BUILD_NUMBER":      "42",
+		"BUILDKITE_BUILD_URL":         "https://buildkite.com/acme/app/builds/42",
+		"BUILDKITE_COMMAND":           "make dist",
+		"BUILDKITE_COMMIT":            "1f2e3d4c5b6a79881f2e3d4c5b6a79881f2e3d4c",
+		"BUILDKITE_ORGANIZATION_SLUG": "acme",
+		"BUILDKITE_PIPELINE_SLUG":     "app",
+		"BUILDKITE_REPO":              "git@github.com:acme/app.git",
+		"BUILDKITE_STEP_ID":           "0189c0a0-0000-4000-8000-000000000001",
+		"SOURCE_DATE_EPOCH":           strconv.FormatInt(Epoch.Unix(), 10),
+	}
+}
@@ -0,0 +1,48 @@
+package provtest
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	clock := NewClock(Epoch)
+	if got := clock.Now(); !got.Equal(Epoch) {
+		t.Errorf("got %s, want %s", got, Epoch)
+	}
+	clock.Advance(90 * time.Second)
+	if got, want := clock.Now(), Epoch.Add(90*time.Second); !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	statement := `{"_type":"https://in-toto.io/Statement/v0.1","subject":[{"name":"a","digest":{"sha256":"01"}}],"predicate":{"n":1}}`
+	reordered := "{\n  \"predicate\": {\"n\": 1},\n  \"subject\": [{\"digest\": {\"sha256\": \"01\"}, \"name\": \"a\"}],\n  \"_type\": \"https://in-toto.io/Statement/v0.1\"\n}\n"
+	envelope := `{"payloadType":"application/vnd.in-toto+json","payload":"` + base64.StdEncoding.EncodeToString([]byte(statement)) + `","signatures":[{"keyid":"k","sig":"c2ln"}]}`
+	for _, got := range []string{reordered, envelope} {
+		diffs, err := Diff([]byte(statement), []byte(got))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(diffs) > 0 {
+			t.Errorf("got differences %v for an equivalent statement", diffs)
+		}
+	}
+
+	changed := `{"_type":"https://in-toto.io/Statement/v0.1","subject":[{"name":"b","digest":{"sha256":"01"}},{"name":"c","digest":{}}],"predicate":{"n":1.0,"m":2}}`
+	diffs, err := Diff([]byte(statement), []byte(changed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"$.predicate.m: unexpected",
+		"$.predicate.n: want 1, got 1.0",
+		"$.subject: want 1 items, got 2",
+	}
+	if !reflect.DeepEqual(diffs, want) {
+		t.Errorf("got %q, want %q", diffs, want)
+	}
+}
@@ -0,0 +1,97 @@
+package sign
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// pkcs11Signer signs with a key held on a PKCS#11 token. The module, slot
+// and PIN are read from PKCS11_MODULE_PATH, PKCS11_SLOT and PKCS11_PIN so
+// they never appear on the command line, and signing is delegated to
+// OpenSC's pkcs11-tool.
+type pkcs11Signer struct {
+	label     string
+	module    string
+	slot      string
+	mechanism string
+}
+
+func newPKCS11Signer(label string) (Signer, error) {
+	if label == "" {
+		return nil, fmt.Errorf("no key label given for pkcs11 signer")
+	}
+	s := &pkcs11Signer{
+		label:     label,
+		module:    os.Getenv("PKCS11_MODULE_PATH"),
+		slot:      os.Getenv("PKCS11_SLOT"),
+		mechanism: os.Getenv("PKCS11_MECHANISM"),
+	}
+	if s.module == "" {
+		return nil, fmt.Errorf("PKCS11_MODULE_PATH is not set")
+	}
+	if os.Getenv("PKCS11_PIN") == "" {
+		return nil, fmt.Errorf("PKCS11_PIN is not set")
+	}
+	if s.mechanism == "" {
+		s.mechanism = "ECDSA-SHA256"
+	}
+	return s, nil
+}
+
+func (s *pkcs11Signer) KeyID() string {
+	return "pkcs11:" + s.label
+}
+
+func (s *pkcs11Signer) PublicKey() ([]byte, error) {
+	dir, err := ioutil.TempDir("", "pkcs11")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "pubkey")
+	args := []string{
+		"--module", s.module,
+		"--read-object", "--type", "pubkey",
+		"--label", s.label,
+		"--output-file", output,
+	}
+	if s.slot != "" {
+		args = append(args, "--slot", s.slot)
+	}
+	if out, err := exec.Command("pkcs11-tool", args...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("pkcs11-tool failed: %s: %s", err, out)
+	}
+	return ioutil.ReadFile(output)
+}
+
+func (s *pkcs11Signer) Sign(data []byte) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "pkcs11")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "input")
+	output := filepath.Join(dir, "output")
+	if err := ioutil.WriteFile(input, data, 0600); err != nil {
+		return nil, err
+	}
+	args := []string{
+		"--module", s.module,
+		"--login", "--pin", "env:PKCS11_PIN",
+		"--sign", "--mechanism", s.mechanism,
+		"--label", s.label,
+		"--input-file", input,
+		"--output-file", output,
+		"--signature-format", "openssl",
+	}
+	if s.slot != "" {
+		args = append(args, "--slot", s.slot)
+	}
+	if out, err := exec.Command("pkcs11-tool", args...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("pkcs11-tool failed: %s: %s", err, out)
+	}
+	return ioutil.ReadFile(output)
+}
@@ -0,0 +1,98 @@
+// Package sign wraps statements in DSSE envelopes, signs them with file,
+// SSH agent or PKCS#11 keys, timestamps them with an RFC 3161 authority and
+// verifies the result.
+package sign
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+const (
+	PayloadContentType = "application/vnd.in-toto+json"
+)
+
+type Envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     string      `json:"payload"`
+	Signatures  []Signature `json:"signatures"`
+}
+
+// Signer produces DSSE signatures over the pre-authentication encoding of
+// an envelope payload.
+type Signer interface {
+	KeyID() string
+	Sign(data []byte) ([]byte, error)
+	// PublicKey returns the DER encoded PKIX public key of the signer.
+	PublicKey() ([]byte, error)
+}
+
+type Signature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+	// Timestamp is the base64 encoded RFC 3161 timestamp token over Sig.
+	Timestamp string `json:"rfc3161Timestamp,omitempty"`
+}
+
+// NewSigner returns the Signer for a "--sign_key" reference. The reference
+// is prefixed with the backend that holds the key, e.g. "pkcs11:<label>".
+func NewSigner(ref string) (Signer, error) {
+	i := strings.Index(ref, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("signing key %q has no backend prefix", ref)
+	}
+	backend, key := ref[:i], ref[i+1:]
+	switch backend {
+	case "pkcs11":
+		return newPKCS11Signer(key)
+	case "ssh":
+		return newSSHSigner(key)
+	}
+	return nil, fmt.Errorf("unknown signing backend %q", backend)
+}
+
+// NewSigners returns a Signer for each reference, so that an envelope can
+// carry the signatures of several independent keys.
+func NewSigners(refs []string) ([]Signer, error) {
+	var signers []Signer
+	seen := map[string]bool{}
+	for _, ref := range refs {
+		signer, err := NewSigner(ref)
+		if err != nil {
+			return nil, err
+		}
+		if seen[signer.KeyID()] {
+			return nil, fmt.Errorf("key %q is used more than once", signer.KeyID())
+		}
+		seen[signer.KeyID()] = true
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+// PAE returns the DSSE pre-authentication encoding of a payload.
+func PAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// SignEnvelope wraps the payload in an Envelope signed by each of
+// "signers". Without signers the envelope is left unsigned.
+func SignEnvelope(payload []byte, signers ...Signer) (*Envelope, error) {
+	envelope := &Envelope{
+		PayloadType: PayloadContentType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []Signature{},
+	}
+	for _, signer := range signers {
+		sig, err := signer.Sign(PAE(PayloadContentType, payload))
+		if err != nil {
+			return nil, err
+		}
+		envelope.Signatures = append(envelope.Signatures, Signature{
+			KeyID: signer.KeyID(),
+			Sig:   base64.StdEncoding.EncodeToString(sig),
+		})
+	}
+	return envelope, nil
+}
@@ -0,0 +1,76 @@
+package sign
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+)
+
+// ed25519Signer signs with an in-memory key.
+type ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+func (s ed25519Signer) KeyID() string { return "test-key" }
+
+func (s ed25519Signer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, data), nil
+}
+
+func (s ed25519Signer) PublicKey() ([]byte, error) {
+	return x509.MarshalPKIXPublicKey(s.key.Public())
+}
+
+// The example of the DSSE protocol specification.
+func TestPAE(t *testing.T) {
+	got := string(PAE("http://example.com/HelloWorld", []byte("hello world")))
+	if want := "DSSEv1 29 http://example.com/HelloWorld 11 hello world"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSignEnvelope(t *testing.T) {
+	seed := make([]byte, ed25519.SeedSize)
+	signer := ed25519Signer{ed25519.NewKeyFromSeed(seed)}
+	der, err := signer.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := LoadVerifier(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statement := []byte(`{"_type":"https://in-toto.io/Statement/v0.1","subject":[{"name":"app.tar.gz","digest":{"sha256":"a172cedcae47474b615c54d510a5d84a8dea3032e958587430b413538be3f333"}}],"predicateType":"https://slsa.dev/provenance/v0.1","predicate":{}}`)
+	envelope, err := SignEnvelope(statement, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID, err := VerifyEnvelope(envelope, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != "test-key" {
+		t.Errorf("got key ID %q, want test-key", keyID)
+	}
+	if payload, err := base64.StdEncoding.DecodeString(envelope.Payload); err != nil {
+		t.Error(err)
+	} else if string(payload) != string(statement) {
+		t.Errorf("got payload %s, want %s", payload, statement)
+	}
+
+	tampered := *envelope
+	tampered.Payload = base64.StdEncoding.EncodeToString([]byte(`{"_type":"https://in-toto.io/Statement/v0.1","subject":[]}`))
+	if _, err := VerifyEnvelope(&tampered, verifier); err == nil {
+		t.Errorf("got no error for a tampered payload")
+	}
+	unsigned, err := SignEnvelope(statement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyEnvelope(unsigned, verifier); err == nil {
+		t.Errorf("got no error for an unsigned envelope")
+	}
+}
@@ -0,0 +1,49 @@
+//go:build !wasm
+// +build !wasm
+
+package sign
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// sshVerifier verifies SSHSIG signatures with `ssh-keygen -Y verify`.
+type sshVerifier struct {
+	pub []byte
+}
+
+// newSSHVerifier returns a Verifier for an authorized_keys formatted SSH
+// public key.
+func newSSHVerifier(pub []byte) (Verifier, error) {
+	return sshVerifier{pub}, nil
+}
+
+func (v sshVerifier) Verify(data, sig []byte) error {
+	dir, err := ioutil.TempDir("", "sshverify")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	signers := filepath.Join(dir, "allowed_signers")
+	if err := ioutil.WriteFile(signers, append([]byte("provenance "), v.pub...), 0600); err != nil {
+		return err
+	}
+	armored := pem.EncodeToMemory(&pem.Block{Type: "SSH SIGNATURE", Bytes: sig})
+	sigPath := filepath.Join(dir, "signature")
+	if err := ioutil.WriteFile(sigPath, armored, 0600); err != nil {
+		return err
+	}
+	cmd := exec.Command("ssh-keygen", "-Y", "verify", "-f", signers, "-I", "provenance", "-n", SSHNamespace, "-s", sigPath)
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("signature does not match: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
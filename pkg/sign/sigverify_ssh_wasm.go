@@ -0,0 +1,9 @@
+package sign
+
+import "fmt"
+
+// newSSHVerifier rejects SSH keys: SSHSIG signatures are verified with
+// `ssh-keygen`, which WebAssembly builds cannot run.
+func newSSHVerifier(pub []byte) (Verifier, error) {
+	return nil, fmt.Errorf("unsupported key type: SSH signatures cannot be verified in WebAssembly builds")
+}
@@ -0,0 +1,96 @@
+package sign
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+)
+
+// SSHNamespace is the SSHSIG namespace used for provenance signatures.
+const SSHNamespace = "in-toto"
+
+// sshSigner signs with an SSH key using `ssh-keygen -Y sign`, producing
+// SSHSIG signatures. When "path" names a public key the private half is
+// taken from the ssh-agent listening on SSH_AUTH_SOCK.
+type sshSigner struct {
+	path        string
+	fingerprint string
+}
+
+func newSSHSigner(path string) (Signer, error) {
+	if path == "" {
+		return nil, fmt.Errorf("no key path given for ssh signer")
+	}
+	pub := path
+	if !strings.HasSuffix(pub, ".pub") {
+		pub += ".pub"
+	}
+	contents, err := ioutil.ReadFile(pub)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := sshFingerprint(contents)
+	if err != nil {
+		return nil, err
+	}
+	return &sshSigner{path: path, fingerprint: fingerprint}, nil
+}
+
+// sshFingerprint returns the OpenSSH SHA256 fingerprint of an
+// authorized_keys formatted public key.
+func sshFingerprint(pub []byte) (string, error) {
+	fields := strings.Fields(string(pub))
+	if len(fields) < 2 {
+		return "", fmt.Errorf("malformed ssh public key")
+	}
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]), nil
+}
+
+func (s *sshSigner) KeyID() string {
+	return s.fingerprint
+}
+
+func (s *sshSigner) PublicKey() ([]byte, error) {
+	return nil, fmt.Errorf("ssh keys have no PKIX public key")
+}
+
+func (s *sshSigner) Sign(data []byte) ([]byte, error) {
+	cmd := exec.Command("ssh-keygen", "-Y", "sign", "-n", SSHNamespace, "-f", s.path)
+	cmd.Stdin = bytes.NewReader(data)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ssh-keygen failed: %s: %s", err, stderr.String())
+	}
+	return decodeArmor(out, "SSH SIGNATURE")
+}
+
+// decodeArmor returns the base64 body of a PEM-like armored block.
+func decodeArmor(armored []byte, label string) ([]byte, error) {
+	var body strings.Builder
+	inside := false
+	for _, line := range strings.Split(string(armored), "\n") {
+		line = strings.TrimSpace(line)
+		switch line {
+		case "-----BEGIN " + label + "-----":
+			inside = true
+		case "-----END " + label + "-----":
+			return base64.StdEncoding.DecodeString(body.String())
+		default:
+			if inside {
+				body.WriteString(line)
+			}
+		}
+	}
+	return nil, fmt.Errorf("no %s block found", label)
+}
@@ -0,0 +1,376 @@
+package sign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+var (
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	// CMS content types and signed attributes of timestamp tokens.
+	oidSignedData        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidAttrContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttrMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+)
+
+// timeStampReq is the RFC 3161 TimeStampReq structure.
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional"`
+}
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+// timeStampResp is the RFC 3161 TimeStampResp structure. The status is
+// kept raw as only its leading PKIStatus is of interest.
+type timeStampResp struct {
+	Status         asn1.RawValue
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+// tstInfo is the RFC 3161 TSTInfo structure, up to the nonce. GenTime is
+// kept raw as encoding/asn1 rejects the fractional seconds many TSAs send.
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        asn1.RawValue
+	Accuracy       struct {
+		Seconds int `asn1:"optional"`
+		Millis  int `asn1:"optional,tag:0"`
+		Micros  int `asn1:"optional,tag:1"`
+	} `asn1:"optional"`
+	Ordering bool     `asn1:"optional"`
+	Nonce    *big.Int `asn1:"optional"`
+}
+
+// timestampToken is a parsed RFC 3161 timestamp token whose CMS signature
+// has been checked against the certificate of its signer.
+type timestampToken struct {
+	info    tstInfo
+	genTime time.Time
+	signer  *x509.Certificate
+	certs   []*x509.Certificate
+}
+
+// asn1Elements returns the DER elements concatenated in "der", e.g. the
+// contents of a SEQUENCE. Optional CMS fields are told apart by their tags,
+// which encoding/asn1 does not check for RawValue fields.
+func asn1Elements(der []byte) ([]asn1.RawValue, error) {
+	var elements []asn1.RawValue
+	for len(der) > 0 {
+		var element asn1.RawValue
+		rest, err := asn1.Unmarshal(der, &element)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+		der = rest
+	}
+	return elements, nil
+}
+
+// parseTimestampToken parses the DER encoded timestamp token "token", a CMS
+// SignedData over a TSTInfo, and checks the signature of the TSA over it.
+// The certificate of the TSA must be included in the token.
+func parseTimestampToken(token []byte) (*timestampToken, error) {
+	var contentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"explicit,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(token, &contentInfo); err != nil {
+		return nil, fmt.Errorf("malformed timestamp token: %s", err)
+	}
+	if !contentInfo.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("timestamp token is not CMS signed data")
+	}
+	var signedData asn1.RawValue
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+		return nil, fmt.Errorf("malformed timestamp token: %s", err)
+	}
+	fields, err := asn1Elements(signedData.Bytes)
+	if err != nil || len(fields) < 4 {
+		return nil, fmt.Errorf("malformed timestamp token signed data")
+	}
+	var encapsulated struct {
+		ContentType asn1.ObjectIdentifier
+		Content     []byte `asn1:"explicit,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(fields[2].FullBytes, &encapsulated); err != nil {
+		return nil, fmt.Errorf("malformed timestamp token content: %s", err)
+	}
+	if !encapsulated.ContentType.Equal(oidTSTInfo) {
+		return nil, fmt.Errorf("timestamp token does not hold a TSTInfo")
+	}
+	t := &timestampToken{}
+	var signerInfos []asn1.RawValue
+	for _, field := range fields[3:] {
+		switch {
+		case field.Class == asn1.ClassContextSpecific && field.Tag == 0:
+			if t.certs, err = x509.ParseCertificates(field.Bytes); err != nil {
+				return nil, fmt.Errorf("malformed timestamp token certificates: %s", err)
+			}
+		case field.Class == asn1.ClassUniversal && field.Tag == asn1.TagSet:
+			if signerInfos, err = asn1Elements(field.Bytes); err != nil {
+				return nil, fmt.Errorf("malformed timestamp token signers: %s", err)
+			}
+		}
+	}
+	if len(signerInfos) != 1 {
+		return nil, fmt.Errorf("timestamp token has %d signers, expected 1", len(signerInfos))
+	}
+	if err := t.verifySigner(signerInfos[0], encapsulated.Content); err != nil {
+		return nil, err
+	}
+	if _, err := asn1.Unmarshal(encapsulated.Content, &t.info); err != nil {
+		return nil, fmt.Errorf("malformed TSTInfo: %s", err)
+	}
+	if t.genTime, err = time.Parse("20060102150405Z0700", string(t.info.GenTime.Bytes)); err != nil {
+		return nil, fmt.Errorf("malformed TSTInfo time: %s", err)
+	}
+	return t, nil
+}
+
+// verifySigner checks the CMS SignerInfo "signerInfo" of the token: its
+// message digest attribute must be the digest of "content" and its
+// signature over the signed attributes must be made by an included
+// certificate, which becomes the signer of the token.
+func (t *timestampToken) verifySigner(signerInfo asn1.RawValue, content []byte) error {
+	fields, err := asn1Elements(signerInfo.Bytes)
+	if err != nil || len(fields) < 6 {
+		return fmt.Errorf("malformed timestamp token signer")
+	}
+	sid, digestAlgorithm, signedAttrs, signature := fields[1], fields[2], fields[3], fields[5]
+	if signedAttrs.Class != asn1.ClassContextSpecific || signedAttrs.Tag != 0 {
+		return fmt.Errorf("timestamp token signer has no signed attributes")
+	}
+	var algorithm pkix.AlgorithmIdentifier
+	if _, err := asn1.Unmarshal(digestAlgorithm.FullBytes, &algorithm); err != nil {
+		return fmt.Errorf("malformed timestamp token digest algorithm: %s", err)
+	}
+	var hash crypto.Hash
+	switch {
+	case algorithm.Algorithm.Equal(oidSHA256):
+		hash = crypto.SHA256
+	case algorithm.Algorithm.Equal(oidSHA384):
+		hash = crypto.SHA384
+	case algorithm.Algorithm.Equal(oidSHA512):
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported timestamp token digest algorithm %s", algorithm.Algorithm)
+	}
+
+	attributes, err := asn1Elements(signedAttrs.Bytes)
+	if err != nil {
+		return fmt.Errorf("malformed timestamp token signed attributes: %s", err)
+	}
+	var contentType asn1.ObjectIdentifier
+	var messageDigest []byte
+	for _, a := range attributes {
+		var attribute struct {
+			Type   asn1.ObjectIdentifier
+			Values asn1.RawValue
+		}
+		if _, err := asn1.Unmarshal(a.FullBytes, &attribute); err != nil {
+			return fmt.Errorf("malformed timestamp token signed attribute: %s", err)
+		}
+		switch {
+		case attribute.Type.Equal(oidAttrContentType):
+			asn1.Unmarshal(attribute.Values.Bytes, &contentType)
+		case attribute.Type.Equal(oidAttrMessageDigest):
+			asn1.Unmarshal(attribute.Values.Bytes, &messageDigest)
+		}
+	}
+	h := hash.New()
+	h.Write(content)
+	if !contentType.Equal(oidTSTInfo) || !bytes.Equal(messageDigest, h.Sum(nil)) {
+		return fmt.Errorf("timestamp token signed attributes do not match its TSTInfo")
+	}
+
+	for _, cert := range t.certs {
+		if !signerIdentifies(sid, cert) {
+			continue
+		}
+		// The signature is over the DER encoding of the attributes as a SET,
+		// not as the IMPLICIT [0] they are tagged with in the SignerInfo.
+		signed := append([]byte{0x31}, signedAttrs.FullBytes[1:]...)
+		h := hash.New()
+		h.Write(signed)
+		digest := h.Sum(nil)
+		var sig []byte
+		if _, err := asn1.Unmarshal(signature.FullBytes, &sig); err != nil {
+			return fmt.Errorf("malformed timestamp token signature: %s", err)
+		}
+		switch key := cert.PublicKey.(type) {
+		case *rsa.PublicKey:
+			err = rsa.VerifyPKCS1v15(key, hash, digest, sig)
+		case *ecdsa.PublicKey:
+			if !ecdsa.VerifyASN1(key, digest, sig) {
+				err = fmt.Errorf("signature does not match")
+			}
+		default:
+			err = fmt.Errorf("unsupported public key type %T", cert.PublicKey)
+		}
+		if err != nil {
+			return fmt.Errorf("timestamp token signature verification failed: %s", err)
+		}
+		t.signer = cert
+		return nil
+	}
+	return fmt.Errorf("timestamp token does not include the certificate of its signer")
+}
+
+// signerIdentifies reports whether the CMS SignerIdentifier "sid", an
+// IssuerAndSerialNumber or a [0] SubjectKeyIdentifier, names "cert".
+func signerIdentifies(sid asn1.RawValue, cert *x509.Certificate) bool {
+	if sid.Class == asn1.ClassContextSpecific && sid.Tag == 0 {
+		return len(cert.SubjectKeyId) > 0 && bytes.Equal(sid.Bytes, cert.SubjectKeyId)
+	}
+	var issuerAndSerial struct {
+		Issuer       asn1.RawValue
+		SerialNumber *big.Int
+	}
+	if _, err := asn1.Unmarshal(sid.FullBytes, &issuerAndSerial); err != nil {
+		return false
+	}
+	return bytes.Equal(issuerAndSerial.Issuer.FullBytes, cert.RawIssuer) && issuerAndSerial.SerialNumber.Cmp(cert.SerialNumber) == 0
+}
+
+// checkImprint checks that the token timestamps the SHA-256 digest of
+// "data".
+func (t *timestampToken) checkImprint(data []byte) error {
+	digest := sha256.Sum256(data)
+	if !t.info.MessageImprint.HashAlgorithm.Algorithm.Equal(oidSHA256) || !bytes.Equal(t.info.MessageImprint.HashedMessage, digest[:]) {
+		return fmt.Errorf("timestamp token is not over this signature")
+	}
+	return nil
+}
+
+// VerifyTimestamp checks that the base64 encoded timestamp token "token"
+// is over "data" and signed by a TSA certificate chaining to "roots", and
+// returns the time it attests.
+func VerifyTimestamp(token string, data []byte, roots *x509.CertPool) (time.Time, error) {
+	der, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := parseTimestampToken(der)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := t.checkImprint(data); err != nil {
+		return time.Time{}, err
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range t.certs {
+		intermediates.AddCert(cert)
+	}
+	if _, err := t.signer.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   t.genTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}); err != nil {
+		return time.Time{}, fmt.Errorf("timestamp authority certificate: %s", err)
+	}
+	return t.genTime, nil
+}
+
+// timestamp obtains an RFC 3161 timestamp token over "data" from the TSA at
+// "tsaURL" and returns the DER encoded token, once checked to be signed by
+// the TSA over "data" and the nonce of the request.
+func timestamp(tsaURL string, data []byte) ([]byte, error) {
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(data)
+	req, err := asn1.Marshal(timeStampReq{
+		Version: 1,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			HashedMessage: digest[:],
+		},
+		Nonce:   nonce,
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(tsaURL, "application/timestamp-query", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("timestamp authority returned %s", resp.Status)
+	}
+	var tsr timeStampResp
+	if _, err := asn1.Unmarshal(body, &tsr); err != nil {
+		return nil, fmt.Errorf("malformed timestamp response: %s", err)
+	}
+	var status int
+	if _, err := asn1.Unmarshal(tsr.Status.Bytes, &status); err != nil {
+		return nil, fmt.Errorf("malformed timestamp response status: %s", err)
+	}
+	// PKIStatus 0 is "granted" and 1 is "grantedWithMods".
+	if status > 1 || len(tsr.TimeStampToken.FullBytes) == 0 {
+		return nil, fmt.Errorf("timestamp request rejected with status %d", status)
+	}
+	token, err := parseTimestampToken(tsr.TimeStampToken.FullBytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := token.checkImprint(data); err != nil {
+		return nil, err
+	}
+	if token.info.Nonce == nil || token.info.Nonce.Cmp(nonce) != 0 {
+		return nil, fmt.Errorf("timestamp response does not echo the request nonce")
+	}
+	return tsr.TimeStampToken.FullBytes, nil
+}
+
+// TimestampEnvelope attaches an RFC 3161 timestamp token to every signature
+// of the envelope. The token is computed over the raw signature bytes.
+func TimestampEnvelope(envelope *Envelope, tsaURL string) error {
+	for i, signature := range envelope.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(signature.Sig)
+		if err != nil {
+			return err
+		}
+		token, err := timestamp(tsaURL, sig)
+		if err != nil {
+			return err
+		}
+		envelope.Signatures[i].Timestamp = base64.StdEncoding.EncodeToString(token)
+	}
+	return nil
+}
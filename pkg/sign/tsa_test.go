@@ -0,0 +1,42 @@
+package sign
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testdata/timestamp.b64 is a token issued by `openssl ts -reply` over the
+// SHA-256 digest of "test signature", by a TSA certified by
+// testdata/tsa-root.pem.
+func TestVerifyTimestamp(t *testing.T) {
+	contents, err := ioutil.ReadFile("testdata/timestamp.b64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := strings.TrimSpace(string(contents))
+	root, err := ioutil.ReadFile("testdata/tsa-root.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(root) {
+		t.Fatal("invalid root certificate")
+	}
+
+	got, err := VerifyTimestamp(token, []byte("test signature"), roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, time.October, 16, 17, 6, 52, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got time %s, want %s", got, want)
+	}
+	if _, err := VerifyTimestamp(token, []byte("other signature"), roots); err == nil {
+		t.Errorf("got no error for a token over other data")
+	}
+	if _, err := VerifyTimestamp(token, []byte("test signature"), x509.NewCertPool()); err == nil {
+		t.Errorf("got no error for a TSA that does not chain to the roots")
+	}
+}
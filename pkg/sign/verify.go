@@ -0,0 +1,148 @@
+package sign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Fulcio certificate extensions carrying the OIDC issuer of the identity.
+var (
+	oidFulcioIssuer   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidFulcioIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// Verifier checks a signature over DSSE pre-authentication encoded data.
+type Verifier interface {
+	Verify(data, sig []byte) error
+}
+
+// PublicKeyVerifier verifies signatures made by an ECDSA, RSA or Ed25519 key.
+type PublicKeyVerifier struct {
+	Key crypto.PublicKey
+}
+
+func (v PublicKeyVerifier) Verify(data, sig []byte) error {
+	digest := sha256.Sum256(data)
+	switch key := v.Key.(type) {
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(key, digest[:], sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil || rsa.VerifyPSS(key, crypto.SHA256, digest[:], sig, nil) == nil {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(key, data, sig) {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", v.Key)
+	}
+	return fmt.Errorf("signature does not match")
+}
+
+// LoadVerifier returns a Verifier for a PEM encoded public key or an
+// authorized_keys formatted SSH public key.
+func LoadVerifier(contents []byte) (Verifier, error) {
+	if block, _ := pem.Decode(contents); block != nil {
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			return PublicKeyVerifier{Key: key}, nil
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			return PublicKeyVerifier{Key: cert.PublicKey}, nil
+		}
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if strings.HasPrefix(string(contents), "ssh-") || strings.HasPrefix(string(contents), "ecdsa-") {
+		return newSSHVerifier(bytes.TrimSpace(contents))
+	}
+	return nil, fmt.Errorf("unrecognized public key format")
+}
+
+// VerifyFulcioCertificate checks that "cert" was valid at "signedAt",
+// chains to "roots" and was issued to "identity" by "issuer". Fulcio
+// certificates are short-lived, so "signedAt" must come from evidence of
+// when the signature was made: the integrated time of a Rekor entry or an
+// RFC 3161 timestamp.
+func VerifyFulcioCertificate(cert *x509.Certificate, roots *x509.CertPool, identity, issuer string, signedAt time.Time) error {
+	if identity == "" || issuer == "" {
+		return fmt.Errorf("the certificate identity and OIDC issuer are required")
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: signedAt,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return err
+	}
+	var names []string
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	found := false
+	for _, name := range names {
+		if name == identity {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("certificate identity %v does not match %q", names, identity)
+	}
+	got := ""
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidFulcioIssuer) {
+			got = string(ext.Value)
+		} else if ext.Id.Equal(oidFulcioIssuerV2) {
+			var value string
+			if _, err := asn1.Unmarshal(ext.Value, &value); err == nil {
+				got = value
+			}
+		}
+	}
+	if got != issuer {
+		return fmt.Errorf("certificate OIDC issuer %q does not match %q", got, issuer)
+	}
+	return nil
+}
+
+// VerifyEnvelope checks that at least one signature of "envelope" was made
+// by "verifier" and returns the key ID of the matching signature.
+func VerifyEnvelope(envelope *Envelope, verifier Verifier) (string, error) {
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return "", err
+	}
+	data := PAE(envelope.PayloadType, payload)
+	var last error = fmt.Errorf("envelope has no signatures")
+	for _, signature := range envelope.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(signature.Sig)
+		if err != nil {
+			return "", err
+		}
+		if last = verifier.Verify(data, sig); last == nil {
+			return signature.KeyID, nil
+		}
+	}
+	return "", last
+}